@@ -11,6 +11,7 @@ import (
 	goit "github.com/codecrafters-io/git-starter-go"
 	"github.com/codecrafters-io/git-starter-go/internal/object"
 	"github.com/codecrafters-io/git-starter-go/internal/store"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
 	"github.com/codecrafters-io/git-starter-go/plumbing"
 )
 
@@ -20,6 +21,7 @@ func main() {
 		fmt.Fprintf(os.Stderr, "usage: mygit <command> [<args>...]\n")
 		os.Exit(1)
 	}
+	trace.Command("built-in", os.Args)
 
 	switch command := os.Args[1]; command {
 	case "init":
@@ -60,7 +62,105 @@ func main() {
 		}
 		ExitWithMsg(msg)
 	case "clone":
-		goit.Clone(os.Args[2:])
+		if err := goit.Clone(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "fetch":
+		if err := goit.Fetch(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "push":
+		if err := goit.Push(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "ls-remote":
+		if err := goit.LsRemote(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "credential":
+		if err := goit.Credential(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "credential-store":
+		if err := goit.CredentialStore(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "credential-cache":
+		if err := goit.CredentialCache(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "credential-cache--daemon":
+		if err := goit.CredentialCacheDaemon(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "upload-pack":
+		if err := goit.UploadPack(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "receive-pack":
+		if err := goit.ReceivePack(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "serve":
+		if err := goit.Serve(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "bundle":
+		if err := goit.Bundle(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "daemon":
+		if err := goit.Daemon(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "pack-objects":
+		if err := goit.PackObjects(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "index-pack":
+		if err := goit.IndexPack(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "verify-pack":
+		if err := goit.VerifyPack(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "show-index":
+		if err := goit.ShowIndex(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "unpack-objects":
+		if err := goit.UnpackObjects(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "prune-packed":
+		if err := goit.PrunePacked(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "multi-pack-index":
+		if err := goit.MultiPackIndex(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "repack":
+		if err := goit.Repack(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "rev-list":
+		if err := goit.RevList(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "count-objects":
+		if err := goit.CountObjects(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "commit-graph":
+		if err := goit.CommitGraph(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
+	case "gc":
+		if err := goit.Gc(os.Args[2:]); err != nil {
+			ExitWithError(err)
+		}
 	default:
 		ExitWithFormatErrorMsg("Unknown command %s", command)
 	}
@@ -0,0 +1,369 @@
+package goit
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	BUNDLE_USAGE            = "mygit bundle (create|verify|list-heads|unbundle) ..."
+	BUNDLE_CREATE_USAGE     = "mygit bundle create [--version <2|3>] <file> <rev>..."
+	BUNDLE_VERIFY_USAGE     = "mygit bundle verify [-q] <file>"
+	BUNDLE_LIST_HEADS_USAGE = "mygit bundle list-heads <file> [<refname>...]"
+	BUNDLE_UNBUNDLE_USAGE   = "mygit bundle unbundle <file> [<refname>...]"
+)
+
+var (
+	ErrEmptyBundle          = errors.New("refusing to create empty bundle")
+	ErrMissingPrerequisites = errors.New("repository lacks the prerequisite commits of the bundle")
+)
+
+// Bundle creates and reads bundles, files holding refs and the pack of their objects that
+// move a repository without a network connection. A bundle file is also accepted as the
+// url of clone and fetch.
+func Bundle(args []string) error {
+	if len(args) == 0 {
+		return errors.New(BUNDLE_USAGE)
+	}
+	switch args[0] {
+	case "create":
+		return bundleCreate(args[1:])
+	case "verify":
+		return bundleVerify(args[1:])
+	case "list-heads":
+		return bundleListHeads(args[1:])
+	case "unbundle":
+		return bundleUnbundle(args[1:])
+	default:
+		return errors.New(BUNDLE_USAGE)
+	}
+}
+
+// bundleCreate writes the refs named by the rev arguments to a bundle along with the
+// objects reachable from them. ^<rev> and <rev>..<rev> leave out the history of a rev,
+// whose commits become prerequisites of the bundle.
+func bundleCreate(args []string) error {
+	flagSet := flag.NewFlagSet("bundle create", flag.ExitOnError)
+	version := flagSet.Int("version", 2, "version of the bundle format")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), BUNDLE_CREATE_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() < 2 {
+		return errors.New(BUNDLE_CREATE_USAGE)
+	}
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	refs, haves, err := parseBundleRevs(st, manager, flagSet.Args()[1:])
+	if err != nil {
+		return err
+	}
+	if len(refs) == 0 {
+		return ErrEmptyBundle
+	}
+
+	path := flagSet.Arg(0)
+	if path == "-" {
+		w := bufio.NewWriter(os.Stdout)
+		if err := githttp.WriteBundle(manager, w, *version, refs, haves); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	// The bundle is written next to its destination and only renamed once complete
+	lockPath := path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	err = githttp.WriteBundle(manager, w, *version, refs, haves)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(lockPath)
+		return err
+	}
+	return os.Rename(lockPath, path)
+}
+
+// parseBundleRevs resolves the rev arguments of bundle create into the refs to bundle and
+// the objects to leave out
+func parseBundleRevs(st *store.FSStore, db revlist.ObjectReader, args []string) ([]githttp.BundleRef, [][]byte, error) {
+	refs := []githttp.BundleRef{}
+	haves := [][]byte{}
+	added := map[string]bool{}
+	addRef := func(name string, id []byte) {
+		if !added[name] {
+			added[name] = true
+			refs = append(refs, githttp.BundleRef{Name: name, ID: id})
+		}
+	}
+	for _, arg := range args {
+		if arg == "--all" || arg == "--branches" || arg == "--tags" {
+			if arg == "--all" {
+				if head, err := st.ResolveRef(store.HEAD); err == nil {
+					addRef(store.HEAD, head.Checksum)
+				}
+			}
+			all, err := st.ListRefs()
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, ref := range all {
+				if arg == "--all" || (arg == "--branches" && strings.HasPrefix(ref.Name, "refs/heads/")) || (arg == "--tags" && strings.HasPrefix(ref.Name, "refs/tags/")) {
+					addRef(ref.Name, ref.Checksum)
+				}
+			}
+			continue
+		}
+		// The other revs are parsed as rev-list does, the commits walked have to be refs
+		wants, excluded, err := parseRevListRevs(st, db, []string{arg})
+		if err != nil {
+			return nil, nil, err
+		}
+		haves = append(haves, excluded...)
+		for _, want := range wants {
+			if want.name == "" {
+				return nil, nil, fmt.Errorf("%s is not a ref and cannot be bundled", arg)
+			}
+			addRef(want.name, want.id)
+		}
+	}
+	return refs, haves, nil
+}
+
+// resolveRev finds the ref a short name stands for, trying the same prefixes as git. A
+// full object id resolves to itself without a ref name. Suffixes select an ancestor, with
+// no ref name either: ~<n> the nth first parent and ^<n> the nth parent, n being 1 if left out.
+func resolveRev(st *store.FSStore, db revlist.ObjectReader, rev string) (string, []byte, error) {
+	i := strings.IndexAny(rev, "~^")
+	if i < 0 {
+		return resolveRefName(st, rev)
+	}
+	_, id, err := resolveRefName(st, rev[:i])
+	if err != nil {
+		return "", nil, err
+	}
+	for suffix := rev[i:]; suffix != ""; {
+		op := suffix[0]
+		digits := strings.TrimLeft(suffix[1:], "0123456789")
+		n := 1
+		if len(digits) < len(suffix)-1 {
+			n, err = strconv.Atoi(suffix[1 : len(suffix)-len(digits)])
+			if err != nil {
+				return "", nil, fmt.Errorf("unknown revision %s", rev)
+			}
+		}
+		suffix = digits
+		switch op {
+		case '^':
+			id, err = revlist.Parent(db, id, n)
+		case '~':
+			for ; n > 0 && err == nil; n-- {
+				id, err = revlist.Parent(db, id, 1)
+			}
+		default:
+			return "", nil, fmt.Errorf("unknown revision %s", rev)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("unknown revision %s: %w", rev, err)
+		}
+	}
+	return "", id, nil
+}
+
+// resolveRefName resolves a rev without suffixes to a ref, or to the object id it spells
+func resolveRefName(st *store.FSStore, rev string) (string, []byte, error) {
+	for _, name := range []string{rev, "refs/" + rev, "refs/tags/" + rev, "refs/heads/" + rev, "refs/remotes/" + rev, "refs/remotes/" + rev + "/HEAD"} {
+		ref, err := st.ResolveRef(name)
+		if err == nil {
+			return name, ref.Checksum, nil
+		}
+		if !errors.Is(err, store.ErrRefNotExist) {
+			return "", nil, err
+		}
+	}
+	if id, err := hash.ChecksumFromHex(rev); err == nil && len(id) == 20 {
+		return "", id, nil
+	}
+	return "", nil, fmt.Errorf("unknown revision %s", rev)
+}
+
+// bundleVerify checks that a bundle can be unbundled into the current repository, which
+// has to hold every prerequisite of the bundle
+func bundleVerify(args []string) error {
+	flagSet := flag.NewFlagSet("bundle verify", flag.ExitOnError)
+	quiet := flagSet.Bool("q", false, "only report errors")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), BUNDLE_VERIFY_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() != 1 {
+		return errors.New(BUNDLE_VERIFY_USAGE)
+	}
+	header, err := readBundleHeader(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	if err := verifyPrerequisites(manager, header); err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Println(countRefs("The bundle contains", len(header.Refs)))
+		for _, ref := range header.Refs {
+			fmt.Printf("%x %s\n", ref.ID, ref.Name)
+		}
+		if len(header.Prerequisites) == 0 {
+			fmt.Println("The bundle records a complete history.")
+		} else {
+			fmt.Println(countRefs("The bundle requires", len(header.Prerequisites)))
+			for _, prerequisite := range header.Prerequisites {
+				fmt.Printf("%x %s\n", prerequisite.ID, prerequisite.Comment)
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "%s is okay\n", flagSet.Arg(0))
+	return nil
+}
+
+func countRefs(prefix string, n int) string {
+	if n == 1 {
+		return prefix + " this ref:"
+	}
+	return fmt.Sprintf("%s these %d refs:", prefix, n)
+}
+
+// bundleListHeads prints the refs of a bundle, limited to the given ref names if any
+func bundleListHeads(args []string) error {
+	if len(args) == 0 {
+		return errors.New(BUNDLE_LIST_HEADS_USAGE)
+	}
+	header, err := readBundleHeader(args[0])
+	if err != nil {
+		return err
+	}
+	printBundleRefs(header, args[1:])
+	return nil
+}
+
+// bundleUnbundle stores the objects of a bundle in the current repository and prints its
+// refs, leaving the refs of the repository untouched as git does
+func bundleUnbundle(args []string) error {
+	if len(args) == 0 {
+		return errors.New(BUNDLE_UNBUNDLE_USAGE)
+	}
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	header, err := githttp.DecodeBundleHeader(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", args[0], err)
+	}
+	if err := verifyPrerequisites(manager, header); err != nil {
+		return err
+	}
+	if _, err := manager.From(r); err != nil {
+		return fmt.Errorf("failed to write pack: %w", err)
+	}
+	shallow, err := st.Shallow()
+	if err != nil {
+		return err
+	}
+	wants := [][]byte{}
+	for _, ref := range header.Refs {
+		wants = append(wants, ref.ID)
+	}
+	haves := [][]byte{}
+	for _, prerequisite := range header.Prerequisites {
+		haves = append(haves, prerequisite.ID)
+	}
+	if err := revlist.CheckConnected(manager, wants, haves, shallow); err != nil {
+		return fmt.Errorf("bundle is incomplete: %w", err)
+	}
+	printBundleRefs(header, args[1:])
+	return nil
+}
+
+func readBundleHeader(path string) (*githttp.BundleHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	header, err := githttp.DecodeBundleHeader(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return header, nil
+}
+
+// verifyPrerequisites checks that every prerequisite of a bundle is in the repository
+func verifyPrerequisites(manager pack.PackManageer, header *githttp.BundleHeader) error {
+	missing := []string{}
+	for _, prerequisite := range header.Prerequisites {
+		ok, err := manager.ObjectExist(prerequisite.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, fmt.Sprintf("%x %s", prerequisite.ID, prerequisite.Comment))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%w:\n%s", ErrMissingPrerequisites, strings.Join(missing, "\n"))
+	}
+	return nil
+}
+
+func printBundleRefs(header *githttp.BundleHeader, names []string) {
+	for _, ref := range header.Refs {
+		if len(names) > 0 && !slices.Contains(names, ref.Name) {
+			continue
+		}
+		fmt.Printf("%x %s\n", ref.ID, ref.Name)
+	}
+}
@@ -1,96 +1,215 @@
 package goit
 
 import (
-	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"path/filepath"
 
 	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
 	object "github.com/codecrafters-io/git-starter-go/internal/obj"
 	"github.com/codecrafters-io/git-starter-go/internal/pack"
-	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/transport"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
 	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
 )
 
-const CLONE_USAGE = "mygit clone <git_url>"
+const CLONE_USAGE = "mygit clone [--depth <n>] [--shallow-since <date>] [--shallow-exclude <ref>] [--filter <spec>] <git_url> [<directory>]"
 
-func Clone(args []string) {
+var (
+	ErrCloneDestination = errors.New("destination path already exists and is not an empty directory")
+)
+
+// Clone copies a remote repository into a new directory. A clone that fails or is
+// interrupted removes the files it created, so that no partial repository is left behind.
+func Clone(args []string) error {
 	flagSet := flag.NewFlagSet("clone", flag.ExitOnError)
+	options := &fetchOptions{}
+	shallowSince := addShallowFlags(flagSet, options)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), CLONE_USAGE)
+		flagSet.PrintDefaults()
+	}
 	flagSet.Parse(args)
 	if flagSet.Arg(0) == "" {
 		fmt.Fprintln(os.Stderr, CLONE_USAGE)
 		os.Exit(1)
 	}
+	if err := options.parseShallowSince(*shallowSince); err != nil {
+		return err
+	}
+	if options.filter != "" {
+		if _, err := revlist.ParseFilter(options.filter); err != nil {
+			return err
+		}
+	}
 
-	gitUrl := flagSet.Arg(0)
+	gitUrl, err := absRemoteUrl(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
 	outputDir := flagSet.Arg(1)
 	if outputDir == "" {
-		outputDir = path.Base(gitUrl)
+		outputDir = transport.RepoName(gitUrl)
 	}
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		ExitWithError(err)
+	outputDir, err = filepath.Abs(outputDir)
+	if err != nil {
+		return err
 	}
 
-	if err := os.Chdir(outputDir); err != nil {
-		ExitWithError(err)
+	// The transport is opened before changing directory so that relative paths resolve
+	cfg, err := loadConfig(nil)
+	if err != nil {
+		return err
 	}
-
-	client := githttp.NewGitHttpClient()
-	baseContext := context.Background()
-
-	store, err := store.Init()
+	remote, err := transport.New(gitUrl, cfg)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return err
 	}
+	defer remote.Close()
 
-	fmt.Print("fetching refs...")
-	refDiscReply, err := client.GetRefs(baseContext, gitUrl)
+	created, err := createCloneDir(outputDir)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return err
 	}
+	if err := clone(remote, gitUrl, outputDir, options); err != nil {
+		if cleanupErr := removeCloneDir(outputDir, created); cleanupErr != nil {
+			return errors.Join(err, cleanupErr)
+		}
+		return err
+	}
+	return nil
+}
 
-	packReq := githttp.BuildPacReqFromRefDisc(refDiscReply)
-
-	fmt.Print("\rfetching pack...")
-	resBody, err := client.FetchPack(packReq, gitUrl)
+// clone fetches remote into the empty directory outputDir and checks out its HEAD
+func clone(remote transport.Transport, gitUrl string, outputDir string, options *fetchOptions) error {
+	if err := os.Chdir(outputDir); err != nil {
+		return err
+	}
+	st, err := store.Init()
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return err
 	}
-	defer resBody.Close()
-
-	if _, err := githttp.ReadSktLine(resBody); err != nil {
+	if err := addRemote(st, DEFAULT_REMOTE, gitUrl); err != nil {
+		return err
+	}
+	if options.filter != "" {
+		if err := setPartialClone(st, DEFAULT_REMOTE, options.filter); err != nil {
+			return err
+		}
 	}
 
-	fmt.Print("\rwriting packfile...\n")
-	packManager := pack.New(store)
-	checksum, err := packManager.From(resBody)
+	fmt.Print("fetching pack...")
+	packManager, err := newPackManager(st)
 	if err != nil {
-		ExitWithError(err)
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+	refDiscReply, checksum, err := fetchRemote(ctx, st, packManager, remote, DEFAULT_REMOTE, options)
+	if err != nil {
+		fmt.Println()
+		return err
+	}
+	fmt.Print("\rwriting packfile...\n")
+
+	branch, ok := headBranch(refDiscReply)
+	if !ok {
+		// Without a HEAD, such as in a bundle of branches only, there is nothing to check out
+		if len(refDiscReply.Refs()) > 0 {
+			fmt.Println("warning: remote HEAD refers to nonexistent ref, unable to checkout")
+		} else {
+			fmt.Println("warning: You appear to have cloned an empty repository.")
+		}
+		return nil
+	}
+	if err := st.WriteRef(branch, refDiscReply.Head(), nil); err != nil {
+		return err
 	}
+	if err := st.WriteSymbolicRef(store.HEAD, branch); err != nil {
+		return err
+	}
+
 	encodedHead, err := packManager.Object(refDiscReply.Head())
 	if err != nil {
-		ExitWithError(err)
+		return err
 	}
 	head, err := object.DecodeCommit(encodedHead)
 	if err != nil {
-		ExitWithError(err)
+		return err
 	}
-	if err := Checkout(packManager, head.Tree()); err != nil {
-		ExitWithError(err)
+	checkedOut := trace.Measure("clone: checkout")
+	err = Checkout(packManager, head.Tree())
+	checkedOut()
+	if err != nil {
+		return err
+	}
+	// An interrupted checkout leaves an incomplete working tree
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 	fmt.Println("checkout")
 
-	if err := packManager.Unpack(checksum); err != nil {
-		ExitWithError(err)
+	if checksum != nil {
+		if err := explodePack(st, packManager, checksum); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	os.Exit(0)
+// explodePack writes the objects of the stored pack as loose objects, as cat-file only
+// reads loose objects
+func explodePack(st store.Store, manager *pack.DefaulPackManager, checksum []byte) error {
+	file, err := st.NewPackReader(hash.ChecksumToHex(checksum))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = manager.Unpack(file, pack.UnpackOptions{Explode: true})
+	return err
+}
+
+// createCloneDir creates the directory of a clone. An existing directory is only cloned
+// into if empty. created reports whether the directory was created.
+func createCloneDir(dir string) (created bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err == nil {
+		if len(entries) > 0 {
+			return false, fmt.Errorf("%w: %s", ErrCloneDestination, dir)
+		}
+		return false, nil
+	}
+	if !os.IsNotExist(err) {
+		return false, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// removeCloneDir removes what a failed clone wrote to dir. A directory that existed before
+// the clone is kept empty.
+func removeCloneDir(dir string, created bool) error {
+	if created {
+		return os.RemoveAll(dir)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func ExitWithError(err error) {
@@ -98,11 +217,22 @@ func ExitWithError(err error) {
 	os.Exit(1)
 }
 
-func Checkout(maanager pack.PackManageer, tree []byte) error {
+func Checkout(maanager *pack.DefaulPackManager, tree []byte) error {
 	cwd, err := os.Getwd()
 	if err != nil {
 		return err
 	}
+	// The blobs missing from a partial clone are fetched in a single request
+	blobs := [][]byte{}
+	if err := walkTree(maanager, tree, cwd, func(tn *TreeNode) error {
+		blobs = append(blobs, tn.checksum)
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := maanager.Prefetch(blobs); err != nil {
+		return err
+	}
 	return walkTree(maanager, tree, cwd, func(tn *TreeNode) error {
 
 		if err := os.MkdirAll(path.Dir(tn.path), 0755); err != nil {
@@ -163,7 +293,9 @@ func walkTree(manager pack.PackManageer, treeChecksum []byte, pathPrefix string,
 
 		} else {
 
-			walkTree(manager, entry.Checksum, path, f)
+			if err := walkTree(manager, entry.Checksum, path, f); err != nil {
+				return err
+			}
 
 		}
 
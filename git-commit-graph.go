@@ -0,0 +1,96 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const COMMIT_GRAPH_USAGE = "mygit commit-graph (write [--reachable] [--split[=no-merge|replace]] [--size-multiple=<n>] [--max-commits=<n>] | verify)"
+
+// splitFlag is the --split option, which may be given without a value
+type splitFlag struct {
+	split pack.CommitGraphSplit
+}
+
+func (f *splitFlag) String() string {
+	return ""
+}
+
+func (f *splitFlag) Set(value string) error {
+	switch value {
+	case "true":
+		f.split = pack.COMMIT_GRAPH_SPLIT
+	case "false":
+		f.split = pack.COMMIT_GRAPH_NO_SPLIT
+	case "no-merge":
+		f.split = pack.COMMIT_GRAPH_SPLIT_NO_MERGE
+	case "replace":
+		f.split = pack.COMMIT_GRAPH_SPLIT_REPLACE
+	default:
+		return fmt.Errorf("unknown split strategy %s", value)
+	}
+	return nil
+}
+
+// IsBoolFlag lets --split be given without a value
+func (f *splitFlag) IsBoolFlag() bool {
+	return true
+}
+
+// CommitGraph maintains the commit-graph, which lists the commits along with their
+// parents, trees and generations so that walks do not read the commits. write lists the
+// commits of every pack, or with --reachable the commits reachable from the refs. With
+// --split the commits missing from the split commit-graph go to a new layer, into which
+// the layers that are not size-multiple times larger are merged. verify checks the
+// commit-graph against the commits.
+func CommitGraph(args []string) error {
+	flagSet := flag.NewFlagSet("commit-graph", flag.ExitOnError)
+	reachable := flagSet.Bool("reachable", false, "list the commits reachable from the refs rather than the commits of the packs")
+	split := &splitFlag{}
+	flagSet.Var(split, "split", "add a layer to the split commit-graph, no-merge keeps every layer and replace merges them all")
+	defaults := pack.DefaultCommitGraphWriteOptions()
+	sizeMultiple := flagSet.Int("size-multiple", defaults.SizeMultiple, "merge the layers holding fewer than this many times the commits of the new layer")
+	maxCommits := flagSet.Int("max-commits", 0, "merge the layers below while the new layer holds more commits, 0 means no limit")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), COMMIT_GRAPH_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() == 0 {
+		return errors.New(COMMIT_GRAPH_USAGE)
+	}
+	subcommand := flagSet.Arg(0)
+	// The options may follow the subcommand as well
+	flagSet.Parse(flagSet.Args()[1:])
+	if flagSet.NArg() > 0 {
+		return errors.New(COMMIT_GRAPH_USAGE)
+	}
+	if *sizeMultiple < 1 || *maxCommits < 0 {
+		return errors.New(COMMIT_GRAPH_USAGE)
+	}
+
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	switch subcommand {
+	case "write":
+		return manager.WriteCommitGraph(pack.CommitGraphWriteOptions{
+			Reachable:    *reachable,
+			Split:        split.split,
+			SizeMultiple: *sizeMultiple,
+			MaxCommits:   *maxCommits,
+		})
+	case "verify":
+		return manager.VerifyCommitGraph()
+	}
+	return errors.New(COMMIT_GRAPH_USAGE)
+}
@@ -0,0 +1,206 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const COUNT_OBJECTS_USAGE = "mygit count-objects [-v] [-H] [--reachable]"
+
+// CountObjects reports the number of loose objects and the disk space they take. -v adds
+// the packed objects, the loose objects that are also packed and the packs missing their
+// index. --reachable counts the objects reachable from the refs by type, answered from the
+// reachability bitmaps when the repository has some.
+func CountObjects(args []string) error {
+	flagSet := flag.NewFlagSet("count-objects", flag.ExitOnError)
+	verbose := flagSet.Bool("v", false, "report the packed objects as well")
+	human := flagSet.Bool("H", false, "print sizes in human readable units")
+	reachable := flagSet.Bool("reachable", false, "count the objects reachable from the refs by type")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), COUNT_OBJECTS_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() > 0 {
+		return errors.New(COUNT_OBJECTS_USAGE)
+	}
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	formatSize := func(size int64) string {
+		if *human {
+			return humanSize(size)
+		}
+		return fmt.Sprint(size / 1024)
+	}
+
+	loose, err := st.ListObjects()
+	if err != nil {
+		return err
+	}
+	count, size := 0, int64(0)
+	for _, checksum := range loose {
+		info, err := st.StatObject(checksum)
+		if err != nil {
+			// The object was pruned since it was listed
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		count++
+		size += info.Size()
+	}
+	if !*verbose && !*reachable {
+		if *human {
+			fmt.Printf("%d %s, %s\n", count, plural(count, "object"), humanSize(size))
+		} else {
+			fmt.Printf("%d %s, %d kilobytes\n", count, plural(count, "object"), size/1024)
+		}
+		return nil
+	}
+
+	if *verbose {
+		fmt.Printf("count: %d\n", count)
+		fmt.Printf("size: %s\n", formatSize(size))
+		if err := printPackCounts(st, manager, formatSize); err != nil {
+			return err
+		}
+	}
+	if *reachable {
+		if err := printReachableCounts(st, manager); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printPackCounts prints the packed objects and the disk space taken by the packs, the
+// loose objects that prune-packed would remove and the packs without an index
+func printPackCounts(st *store.FSStore, manager *pack.DefaulPackManager, formatSize func(int64) string) error {
+	objects, err := manager.StoredObjects()
+	if err != nil {
+		return err
+	}
+	inPack := 0
+	for _, object := range objects {
+		if object.Pack != nil {
+			inPack++
+		}
+	}
+	indices, err := st.ListPackIndices()
+	if err != nil {
+		return err
+	}
+	isIndexed := map[string]bool{}
+	sizePack := int64(0)
+	for _, checksum := range indices {
+		isIndexed[checksum] = true
+		for _, stat := range []func(string) (os.FileInfo, error){st.StatPack, st.StatPackIndex} {
+			info, err := stat(checksum)
+			if err != nil {
+				return err
+			}
+			sizePack += info.Size()
+		}
+	}
+	packs, err := st.ListPacks()
+	if err != nil {
+		return err
+	}
+	garbage, sizeGarbage := 0, int64(0)
+	for _, checksum := range packs {
+		if isIndexed[checksum] {
+			continue
+		}
+		info, err := st.StatPack(checksum)
+		if err != nil {
+			return err
+		}
+		garbage++
+		sizeGarbage += info.Size()
+	}
+	prunable, err := manager.PrunePacked(true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("in-pack: %d\n", inPack)
+	fmt.Printf("packs: %d\n", len(indices))
+	fmt.Printf("size-pack: %s\n", formatSize(sizePack))
+	fmt.Printf("prune-packable: %d\n", len(prunable))
+	fmt.Printf("garbage: %d\n", garbage)
+	fmt.Printf("size-garbage: %s\n", formatSize(sizeGarbage))
+	return nil
+}
+
+// printReachableCounts prints the number of objects of each type reachable from HEAD and
+// the refs
+func printReachableCounts(st *store.FSStore, manager *pack.DefaulPackManager) error {
+	wants := [][]byte{}
+	if head, err := st.ResolveRef(store.HEAD); err == nil {
+		wants = append(wants, head.Checksum)
+	}
+	refs, err := st.ListRefs()
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		wants = append(wants, ref.Checksum)
+	}
+	shallow, err := st.Shallow()
+	if err != nil {
+		return err
+	}
+	options := revlist.Options{Shallow: shallow}
+	bitmaps, err := manager.Bitmaps()
+	if err != nil {
+		return err
+	}
+	if bitmaps != nil {
+		options.Bitmaps = bitmaps
+	}
+	entries, err := revlist.Objects(manager, wants, nil, options)
+	if err != nil {
+		return err
+	}
+	counts := map[common.ObjectType]int{}
+	for _, entry := range entries {
+		counts[entry.Type]++
+	}
+	fmt.Printf("reachable-commits: %d\n", counts[common.OBJ_COMMIT])
+	fmt.Printf("reachable-trees: %d\n", counts[common.OBJ_TREE])
+	fmt.Printf("reachable-blobs: %d\n", counts[common.OBJ_BLOB])
+	fmt.Printf("reachable-tags: %d\n", counts[common.OBJ_TAG])
+	return nil
+}
+
+// humanSize formats a size in bytes with the largest binary unit below it, rounded to
+// hundredths as git does
+func humanSize(size int64) string {
+	for _, unit := range []struct {
+		size     int64
+		rounding int64
+		name     string
+	}{{1 << 30, 5368709, "GiB"}, {1 << 20, 5243, "MiB"}, {1 << 10, 5, "KiB"}} {
+		if size > unit.size {
+			x := size + unit.rounding
+			return fmt.Sprintf("%d.%02d %s", x/unit.size, x%unit.size*100/unit.size, unit.name)
+		}
+	}
+	if size == 1 {
+		return "1 byte"
+	}
+	return fmt.Sprintf("%d bytes", size)
+}
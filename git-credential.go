@@ -0,0 +1,128 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/credential"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	CREDENTIAL_USAGE       = "mygit credential (fill|approve|reject)"
+	CREDENTIAL_STORE_USAGE = "mygit credential-store [--file <path>] (get|store|erase)"
+	CREDENTIAL_CACHE_USAGE = "mygit credential-cache [--timeout <seconds>] [--socket <path>] (get|store|erase|exit)"
+)
+
+// Credential runs the configured credential helpers for the credential read from stdin.
+// fill prints the completed credential, approve and reject pass it on to the helpers.
+func Credential(args []string) error {
+	flagSet := flag.NewFlagSet("credential", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), CREDENTIAL_USAGE)
+	}
+	flagSet.Parse(args)
+
+	c, err := credential.Decode(os.Stdin)
+	if err != nil {
+		return err
+	}
+	st, err := store.New()
+	if err != nil {
+		st = nil
+	}
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	manager, err := credential.NewManager(cfg, c.URL().String())
+	if err != nil {
+		return err
+	}
+
+	switch flagSet.Arg(0) {
+	case "fill":
+		// Keep the path asked for even when the helpers ignore it
+		path := c.Path
+		if err := manager.Fill(c); err != nil {
+			return err
+		}
+		if c.Path == "" {
+			c.Path = path
+		}
+		return c.Encode(os.Stdout)
+	case "approve":
+		return manager.Approve(c)
+	case "reject":
+		return manager.Reject(c)
+	default:
+		return errors.New(CREDENTIAL_USAGE)
+	}
+}
+
+// CredentialStore runs the built-in store helper, so that it can also be used by git with
+// credential.helper set to "!mygit credential-store"
+func CredentialStore(args []string) error {
+	flagSet := flag.NewFlagSet("credential-store", flag.ExitOnError)
+	file := flagSet.String("file", "", "file holding the credentials")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), CREDENTIAL_STORE_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	return runHelper(credential.NewStoreHelper(*file), flagSet.Arg(0), CREDENTIAL_STORE_USAGE)
+}
+
+// CredentialCache runs the built-in cache helper
+func CredentialCache(args []string) error {
+	flagSet := flag.NewFlagSet("credential-cache", flag.ExitOnError)
+	timeout := flagSet.Int("timeout", credential.DEFAULT_CACHE_TIMEOUT, "seconds a credential is kept")
+	socket := flagSet.String("socket", "", "socket of the cache daemon")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), CREDENTIAL_CACHE_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	helper := credential.NewCacheHelper(*socket, time.Duration(*timeout)*time.Second)
+	if flagSet.Arg(0) == "exit" {
+		return helper.Exit()
+	}
+	return runHelper(helper, flagSet.Arg(0), CREDENTIAL_CACHE_USAGE)
+}
+
+// CredentialCacheDaemon serves the cache of the cache helper on the socket given as argument
+func CredentialCacheDaemon(args []string) error {
+	if len(args) != 1 {
+		return errors.New("mygit " + credential.CACHE_DAEMON_COMMAND + " <socket>")
+	}
+	return credential.ServeCache(args[0], os.Stdout)
+}
+
+// runHelper performs a helper action on the credential read from stdin
+func runHelper(helper credential.Helper, action string, usage string) error {
+	c, err := credential.Decode(os.Stdin)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case "get":
+		reply, err := helper.Get(c)
+		if err != nil {
+			return err
+		}
+		return reply.Encode(os.Stdout)
+	case "store":
+		return helper.Store(c)
+	case "erase":
+		return helper.Erase(c)
+	default:
+		// Unknown actions are ignored, as git expects from helpers
+		if action == "" {
+			return errors.New(usage)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,65 @@
+package goit
+
+import (
+	"flag"
+	"fmt"
+	"net"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/daemon"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/transport"
+)
+
+const DAEMON_USAGE = "mygit daemon [--listen=<host>] [--port=<n>] [--base-path=<path>] [--export-all] [--enable=<service>] [--disable=<service>] [--allow-override=<service>] [--forbid-override=<service>] [<directory>...]"
+
+// Daemon serves repositories over the git:// protocol. Only the repositories holding a
+// git-daemon-export-ok file are served unless --export-all is given, and only below the
+// directories given as arguments if any.
+func Daemon(args []string) error {
+	flagSet := flag.NewFlagSet("daemon", flag.ExitOnError)
+	listen := flagSet.String("listen", "", "host or address to listen on, all interfaces by default")
+	port := flagSet.String("port", transport.DEFAULT_DAEMON_PORT, "port to listen on")
+	basePath := flagSet.String("base-path", "", "directory the paths of the requests are relative to")
+	exportAll := flagSet.Bool("export-all", false, "serve repositories without the git-daemon-export-ok file")
+	var enable, disable, allowOverride, forbidOverride stringList
+	flagSet.Var(&enable, "enable", "enable a service for every repository")
+	flagSet.Var(&disable, "disable", "disable a service for every repository")
+	flagSet.Var(&allowOverride, "allow-override", "let repositories enable or disable a service with daemon.<service>")
+	flagSet.Var(&forbidOverride, "forbid-override", "ignore the daemon.<service> setting of repositories")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), DAEMON_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	services := daemon.DefaultServices()
+	for _, change := range []struct {
+		names []string
+		apply func(*daemon.Service)
+	}{
+		{enable, func(s *daemon.Service) { s.Enabled = true }},
+		{disable, func(s *daemon.Service) { s.Enabled = false }},
+		{allowOverride, func(s *daemon.Service) { s.Overridable = true }},
+		{forbidOverride, func(s *daemon.Service) { s.Overridable = false }},
+	} {
+		for _, name := range change.names {
+			service, ok := services[name]
+			if !ok {
+				return fmt.Errorf("%w: %s", daemon.ErrUnknownService, name)
+			}
+			change.apply(service)
+		}
+	}
+
+	listener, err := net.Listen("tcp", net.JoinHostPort(*listen, *port))
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	fmt.Printf("serving on %s\n", listener.Addr())
+	return daemon.New(daemon.Options{
+		BasePath:    *basePath,
+		ExportAll:   *exportAll,
+		Directories: flagSet.Args(),
+		Services:    services,
+	}).Serve(listener)
+}
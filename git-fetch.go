@@ -0,0 +1,550 @@
+package goit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/transport"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
+)
+
+const (
+	FETCH_USAGE    = "mygit fetch [--depth <n>|--deepen <n>|--shallow-since <date>|--shallow-exclude <ref>|--unshallow] [<remote>|<url>]"
+	DEFAULT_REMOTE = "origin"
+	FETCH_HEAD     = "FETCH_HEAD"
+)
+
+var (
+	ErrNotShallow       = errors.New("--deepen and --unshallow need a shallow repository")
+	ErrServerCapability = errors.New("server does not support")
+)
+
+// fetchOptions shape the history that is fetched. The zero value fetches the complete history.
+type fetchOptions struct {
+	// depth limits the history to depth commits from the tips of the remote refs
+	depth int
+	// deepen adds deepen commits below the current shallow commits
+	deepen         int
+	shallowSince   time.Time
+	shallowExclude []string
+	// unshallow fetches the complete history of a shallow repository
+	unshallow bool
+	// filter is the spec of the objects left out of a partial clone
+	filter string
+	// promisor marks the fetched pack as coming from a promisor remote
+	promisor bool
+}
+
+// isDeepen reports whether the fetch changes the shallow boundary of the repository
+func (o *fetchOptions) isDeepen() bool {
+	return o.depth > 0 || o.deepen > 0 || !o.shallowSince.IsZero() || len(o.shallowExclude) > 0 || o.unshallow
+}
+
+// addShallowFlags defines the flags shaping the fetched history shared by clone and fetch
+func addShallowFlags(flagSet *flag.FlagSet, options *fetchOptions) *string {
+	flagSet.StringVar(&options.filter, "filter", "", "leave out objects: blob:none, blob:limit=<n> or tree:<depth>")
+	flagSet.IntVar(&options.depth, "depth", 0, "limit the history to the given number of commits")
+	flagSet.Var((*stringList)(&options.shallowExclude), "shallow-exclude", "exclude the history reachable from the given ref")
+	return flagSet.String("shallow-since", "", "limit the history to commits after the given date")
+}
+
+// Fetch downloads the objects and refs of a remote into the repository of the current directory
+func Fetch(args []string) error {
+	flagSet := flag.NewFlagSet("fetch", flag.ExitOnError)
+	options := &fetchOptions{}
+	shallowSince := addShallowFlags(flagSet, options)
+	flagSet.IntVar(&options.deepen, "deepen", 0, "deepen the history of a shallow repository by the given number of commits")
+	flagSet.BoolVar(&options.unshallow, "unshallow", false, "fetch the complete history of a shallow repository")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), FETCH_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if err := options.parseShallowSince(*shallowSince); err != nil {
+		return err
+	}
+	remoteName := flagSet.Arg(0)
+	if remoteName == "" {
+		remoteName = DEFAULT_REMOTE
+	}
+
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	gitUrl, err := remoteUrl(st, remoteName)
+	if err != nil {
+		return err
+	}
+	// A url given in place of a remote name has no remote-tracking refs
+	if gitUrl == "" {
+		gitUrl, remoteName = remoteName, ""
+	}
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	// Fetches from the promisor remote of a partial clone keep leaving out the same objects
+	promisor, err := cfg.GetBool("remote", remoteName, "promisor", false)
+	if err != nil {
+		return err
+	}
+	if promisor && remoteName != "" {
+		options.promisor = true
+		if options.filter == "" {
+			options.filter, _ = cfg.Get("remote", remoteName, "partialclonefilter")
+		}
+	}
+
+	remote, err := transport.New(gitUrl, cfg)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := commandContext()
+	defer cancel()
+	refDiscReply, _, err := fetchRemote(ctx, st, manager, remote, remoteName, options)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("From %s\n", transport.Anonymize(gitUrl))
+	for name, id := range refDiscReply.Refs() {
+		fmt.Printf("%x\t%s\n", id, name)
+	}
+	return nil
+}
+
+// fetchRemote downloads the objects of the refs of remote that are missing from the store
+// and updates the remote-tracking refs of remoteName. It returns the refs advertised by the
+// remote and the checksum of the fetched pack, which is nil if there was nothing to fetch.
+func fetchRemote(ctx context.Context, st *store.FSStore, manager *pack.DefaulPackManager, remote transport.Transport, remoteName string, options *fetchOptions) (*githttp.RefDiscReply, []byte, error) {
+	discovered := trace.Measure("fetch: ref discovery")
+	refDiscReply, err := remote.FetchRefs(ctx)
+	discovered()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch refs: %w", err)
+	}
+	shallow, err := st.Shallow()
+	if err != nil {
+		return nil, nil, err
+	}
+	if (options.deepen > 0 || options.unshallow) && len(shallow) == 0 {
+		return nil, nil, ErrNotShallow
+	}
+
+	packReq := githttp.NewPackReq()
+	for _, id := range refDiscReply.Refs() {
+		ok, err := manager.ObjectExist(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Refs that already exist are still wanted to deepen their history
+		if !ok || options.isDeepen() {
+			packReq.AddWant(id)
+		}
+	}
+	caps, err := setShallowReq(packReq, refDiscReply.Caps(), shallow, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if options.filter != "" {
+		if refDiscReply.Caps().Has("filter") {
+			packReq.SetFilter(options.filter)
+			caps = append(caps, "filter")
+		} else {
+			fmt.Fprintln(os.Stderr, "warning: filtering not recognized by server, ignoring")
+		}
+	}
+	// Bases missing from a thin pack are appended from the repository when it is stored
+	for _, c := range []string{"thin-pack", "ofs-delta"} {
+		if refDiscReply.Caps().Has(c) {
+			caps = append(caps, c)
+		}
+	}
+	if len(caps) > 0 {
+		packReq.SetCaps(githttp.NewCapList(caps...))
+	}
+
+	var checksum []byte
+	if len(packReq.Wants()) > 0 {
+		localRefs, err := st.ListRefs()
+		if err != nil {
+			return nil, nil, err
+		}
+		haves := [][]byte{}
+		for _, ref := range localRefs {
+			haves = append(haves, ref.Checksum)
+			packReq.AddHave(ref.Checksum)
+		}
+		packReq.Done()
+
+		// A dumb server cannot build a pack, the objects are downloaded as the server stores them
+		if fetcher, ok := remote.(transport.ObjectFetcher); ok && fetcher.Dumb() {
+			wants := [][]byte{}
+			for _, id := range packReq.Wants() {
+				wants = append(wants, id)
+			}
+			if err := fetcher.FetchObjects(ctx, st, manager, wants, haves); err != nil {
+				return nil, nil, fmt.Errorf("failed to fetch objects: %w", err)
+			}
+			if err := updateRemoteRefs(st, refDiscReply, remoteName); err != nil {
+				return nil, nil, err
+			}
+			return refDiscReply, nil, nil
+		}
+
+		received := trace.Measure("fetch: receive pack")
+		packReply, err := remote.FetchPack(ctx, packReq)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to fetch pack: %w", err)
+		}
+		defer packReply.Close()
+		if options.promisor || packReq.Filter() != "" {
+			checksum, err = manager.FromPromisor(packReply)
+		} else {
+			checksum, err = manager.From(packReply)
+		}
+		received()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to write pack: %w", err)
+		}
+		if err := updateShallow(st, shallow, packReply); err != nil {
+			return nil, nil, err
+		}
+		// The objects left out of a partial clone are missing on purpose
+		if !options.promisor && packReq.Filter() == "" {
+			if err := checkConnected(st, manager, packReq.Wants(), haves); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	if err := updateRemoteRefs(st, refDiscReply, remoteName); err != nil {
+		return nil, nil, err
+	}
+	return refDiscReply, checksum, nil
+}
+
+// checkConnected verifies that the history of the fetched refs is complete before the refs
+// are updated, so that a truncated or incomplete pack never leaves refs pointing to missing objects
+func checkConnected(st *store.FSStore, manager pack.PackManageer, wants []common.Checksum, haves [][]byte) error {
+	shallow, err := st.Shallow()
+	if err != nil {
+		return err
+	}
+	defer trace.Measure("fetch: check connectivity")()
+	ids := [][]byte{}
+	for _, id := range wants {
+		ids = append(ids, id)
+	}
+	if err := revlist.CheckConnected(manager, ids, haves, shallow); err != nil {
+		return fmt.Errorf("fetched pack is incomplete: %w", err)
+	}
+	return nil
+}
+
+// setShallowReq adds the shallow commits of the repository and the deepen options to a
+// pack request. It returns the capabilities they rely on.
+func setShallowReq(packReq *githttp.PackReq, serverCaps githttp.CapList, shallow [][]byte, options *fetchOptions) ([]string, error) {
+	if len(shallow) == 0 && !options.isDeepen() {
+		return nil, nil
+	}
+	caps := []string{"shallow"}
+	switch {
+	case options.unshallow:
+		packReq.SetDepth(githttp.INFINITE_DEPTH)
+	case options.deepen > 0:
+		packReq.SetDepth(options.deepen)
+		caps = append(caps, "deepen-relative")
+	case options.depth > 0:
+		packReq.SetDepth(options.depth)
+	}
+	if !options.shallowSince.IsZero() {
+		packReq.SetDeepenSince(options.shallowSince.Unix())
+		caps = append(caps, "deepen-since")
+	}
+	for _, ref := range options.shallowExclude {
+		packReq.AddDeepenNot(ref)
+	}
+	if len(options.shallowExclude) > 0 {
+		caps = append(caps, "deepen-not")
+	}
+	for _, c := range caps {
+		if !serverCaps.Has(c) {
+			return nil, fmt.Errorf("%w: %s", ErrServerCapability, c)
+		}
+	}
+	for _, id := range shallow {
+		packReq.AddShallow(id)
+	}
+	return caps, nil
+}
+
+// updateShallow applies the shallow update sent along with a pack to the shallow commits of the repository
+func updateShallow(st *store.FSStore, shallow [][]byte, packReply *githttp.PackReply) error {
+	if len(packReply.Shallows()) == 0 && len(packReply.Unshallows()) == 0 {
+		return nil
+	}
+	unshallowed := map[string]bool{}
+	for _, id := range packReply.Unshallows() {
+		unshallowed[string(id)] = true
+	}
+	updated := [][]byte{}
+	for _, id := range shallow {
+		if !unshallowed[string(id)] {
+			updated = append(updated, id)
+		}
+	}
+	for _, id := range packReply.Shallows() {
+		updated = append(updated, id)
+	}
+	return st.WriteShallow(updated)
+}
+
+// newPackManager returns the pack manager of the repository. The objects missing from a
+// partial clone are fetched from its promisor remote when they are read.
+func newPackManager(st *store.FSStore) (*pack.DefaulPackManager, error) {
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return nil, err
+	}
+	remoteName, ok := cfg.Get("extensions", "", "partialclone")
+	if !ok {
+		return pack.New(st), nil
+	}
+	gitUrl, ok := cfg.Get("remote", remoteName, "url")
+	if !ok {
+		return nil, fmt.Errorf("promisor remote %s has no url", remoteName)
+	}
+	return pack.NewWithPromisor(st, &remotePromisor{url: gitUrl, cfg: cfg}), nil
+}
+
+// setPartialClone records remoteName as the promisor remote of the repository
+func setPartialClone(st *store.FSStore, remoteName string, filter string) error {
+	cfg, err := st.Config()
+	if err != nil {
+		return err
+	}
+	cfg.Set("core", "", "repositoryformatversion", "1")
+	cfg.Set("extensions", "", "partialclone", remoteName)
+	cfg.Set("remote", remoteName, "promisor", "true")
+	cfg.Set("remote", remoteName, "partialclonefilter", filter)
+	return st.SaveConfig(cfg)
+}
+
+// remotePromisor fetches the objects missing from a partial clone from its promisor remote.
+// Like git, missing trees are fetched without their blobs, which are fetched when read.
+type remotePromisor struct {
+	url string
+	cfg *config.Config
+}
+
+func (p *remotePromisor) FetchObjects(ids [][]byte) (io.ReadCloser, error) {
+	remote, err := transport.New(p.url, p.cfg)
+	if err != nil {
+		return nil, err
+	}
+	ctx := context.Background()
+	refDiscReply, err := remote.FetchRefs(ctx)
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	packReq := githttp.NewPackReq()
+	for _, id := range ids {
+		packReq.AddWant(id)
+	}
+	caps := []string{}
+	if refDiscReply.Caps().Has("ofs-delta") {
+		caps = append(caps, "ofs-delta")
+	}
+	if refDiscReply.Caps().Has("filter") {
+		packReq.SetFilter("blob:none")
+		caps = append(caps, "filter")
+	}
+	if len(caps) > 0 {
+		packReq.SetCaps(githttp.NewCapList(caps...))
+	}
+	packReq.Done()
+	packReply, err := remote.FetchPack(ctx, packReq)
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return &promisorReply{PackReply: packReply, remote: remote}, nil
+}
+
+// promisorReply closes the transport along with the pack reply
+type promisorReply struct {
+	*githttp.PackReply
+	remote transport.Transport
+}
+
+func (r *promisorReply) Close() error {
+	err := r.PackReply.Close()
+	if closeErr := r.remote.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// parseShallowSince parses the date given to --shallow-since
+func (o *fetchOptions) parseShallowSince(date string) error {
+	if date == "" {
+		return nil
+	}
+	if seconds, err := strconv.ParseInt(date, 10, 64); err == nil {
+		o.shallowSince = time.Unix(seconds, 0)
+		return nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, date, time.Local); err == nil {
+			o.shallowSince = t
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid date %q", date)
+}
+
+// stringList is a flag that can be given several times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// updateRemoteRefs maps the branches of the remote to refs/remotes/<remote>/ and creates missing tags.
+// The advertised HEAD is recorded in FETCH_HEAD.
+func updateRemoteRefs(st *store.FSStore, refDiscReply *githttp.RefDiscReply, remoteName string) error {
+	if head := refDiscReply.Head(); head != nil {
+		if err := st.WriteRef(FETCH_HEAD, head, nil); err != nil {
+			return err
+		}
+	}
+	if remoteName == "" {
+		return nil
+	}
+	for name, id := range refDiscReply.Refs() {
+		if branch, ok := strings.CutPrefix(name, "refs/heads/"); ok {
+			if err := st.WriteRef(fmt.Sprintf("refs/remotes/%s/%s", remoteName, branch), id, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		if strings.HasPrefix(name, "refs/tags/") {
+			if _, err := st.ReadRef(name); err == nil {
+				continue
+			}
+			if err := st.WriteRef(name, id, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if target, ok := refDiscReply.HeadTarget(); ok {
+		if branch, ok := strings.CutPrefix(target, "refs/heads/"); ok {
+			return st.WriteSymbolicRef(fmt.Sprintf("refs/remotes/%s/HEAD", remoteName), fmt.Sprintf("refs/remotes/%s/%s", remoteName, branch))
+		}
+	}
+	return nil
+}
+
+// commandContext returns a context cancelled when the command is interrupted, so that
+// transfers in progress are aborted rather than the process being killed midway
+func commandContext() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// loadConfig returns the per-user config overridden by the config of the repository in st.
+// Without a repository only the per-user config is read.
+func loadConfig(st *store.FSStore) (*config.Config, error) {
+	global, err := config.LoadGlobal()
+	if err != nil {
+		return nil, err
+	}
+	if st == nil {
+		return global, nil
+	}
+	local, err := st.Config()
+	if err != nil {
+		return nil, err
+	}
+	return config.Merge(global, local), nil
+}
+
+// remoteUrl returns the url of a configured remote, or an empty string if no such remote exists
+func remoteUrl(st *store.FSStore, remoteName string) (string, error) {
+	cfg, err := st.Config()
+	if err != nil {
+		return "", err
+	}
+	gitUrl, _ := cfg.Get("remote", remoteName, "url")
+	return gitUrl, nil
+}
+
+// absRemoteUrl turns a local path into an absolute path so that the url
+// stored for a remote does not depend on the working directory
+func absRemoteUrl(gitUrl string) (string, error) {
+	endpoint, err := transport.ParseEndpoint(gitUrl)
+	if err != nil {
+		return "", err
+	}
+	if endpoint.Scheme != "file" || strings.Contains(gitUrl, "://") {
+		return gitUrl, nil
+	}
+	return filepath.Abs(gitUrl)
+}
+
+// addRemote records the url of a remote in the repository configuration
+func addRemote(st *store.FSStore, remoteName string, gitUrl string) error {
+	cfg, err := st.Config()
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Get("remote", remoteName, "url"); ok {
+		return errors.New("remote " + remoteName + " already exists")
+	}
+	cfg.Set("remote", remoteName, "url", gitUrl)
+	cfg.Set("remote", remoteName, "fetch", fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", remoteName))
+	return st.SaveConfig(cfg)
+}
+
+// headBranch returns the branch the remote HEAD points to. Servers that do not advertise the
+// symref capability are matched by finding a branch pointing to the same commit as HEAD.
+func headBranch(refDiscReply *githttp.RefDiscReply) (string, bool) {
+	if target, ok := refDiscReply.HeadTarget(); ok {
+		return target, true
+	}
+	for name, id := range refDiscReply.Refs() {
+		if strings.HasPrefix(name, "refs/heads/") && bytes.Equal(id, refDiscReply.Head()) {
+			return name, true
+		}
+	}
+	return "", false
+}
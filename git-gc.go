@@ -0,0 +1,261 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	GC_USAGE = "mygit gc [--auto] [--prune=<date>] [-q]"
+
+	// DEFAULT_GC_AUTO is the number of loose objects above which gc --auto runs
+	DEFAULT_GC_AUTO = 6700
+	// DEFAULT_GC_AUTO_PACK_LIMIT is the number of packs above which gc --auto runs
+	DEFAULT_GC_AUTO_PACK_LIMIT = 50
+	DEFAULT_GC_PRUNE_EXPIRE    = "2.weeks.ago"
+	DEFAULT_GC_REFLOG_EXPIRE   = "90.days.ago"
+)
+
+// Gc packs the refs, expires the old entries of the reflogs, repacks every object and,
+// unless gc.writeCommitGraph is false, writes the commit-graph of the reachable commits. The
+// unreachable objects are kept in a cruft pack until they have been unreachable longer
+// than --prune, after which they are removed along with the stale temporary files. With
+// --auto nothing is done unless the repository holds more loose objects than gc.auto or
+// more packs than gc.autoPackLimit.
+func Gc(args []string) error {
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	defaultPrune, ok := cfg.Get("gc", "", "pruneexpire")
+	if !ok {
+		defaultPrune = DEFAULT_GC_PRUNE_EXPIRE
+	}
+	flagSet := flag.NewFlagSet("gc", flag.ExitOnError)
+	auto := flagSet.Bool("auto", false, "only run when the repository needs it")
+	prune := flagSet.String("prune", defaultPrune, "remove the unreachable objects written before this date")
+	quiet := flagSet.Bool("q", false, "do not report what was done")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), GC_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() > 0 {
+		return errors.New(GC_USAGE)
+	}
+	now := time.Now()
+	pruneExpiry, err := parseExpiry(*prune, now)
+	if err != nil {
+		return fmt.Errorf("--prune: %w", err)
+	}
+	reflogExpire, ok := cfg.Get("gc", "", "reflogexpire")
+	if !ok {
+		reflogExpire = DEFAULT_GC_REFLOG_EXPIRE
+	}
+	reflogExpiry, err := parseExpiry(reflogExpire, now)
+	if err != nil {
+		return fmt.Errorf("gc.reflogExpire: %w", err)
+	}
+
+	if *auto {
+		needed, err := needsGc(st, cfg)
+		if err != nil || !needed {
+			return err
+		}
+		if !*quiet {
+			fmt.Fprintln(os.Stderr, "Auto packing the repository for optimum performance.")
+		}
+	}
+
+	packedRefs, err := st.PackRefs()
+	if err != nil {
+		return err
+	}
+	expiredEntries := 0
+	if !reflogExpiry.IsZero() {
+		names, err := st.ListReflogs()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			expired, err := st.ExpireReflog(name, reflogExpiry)
+			if err != nil {
+				return err
+			}
+			expiredEntries += expired
+		}
+	}
+
+	write, err := repackWriteOptions(cfg)
+	if err != nil {
+		return err
+	}
+	writeBitmaps, err := repackWriteBitmaps(cfg)
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	if err := repack(st, cfg, manager, repackOptions{
+		all:             true,
+		delete:          true,
+		cruft:           true,
+		cruftExpiration: pruneExpiry,
+		bitmap:          writeBitmaps,
+		write:           write,
+	}); err != nil {
+		return err
+	}
+	writeCommitGraph, err := cfg.GetBool("gc", "", "writecommitgraph", true)
+	if err != nil {
+		return err
+	}
+	if writeCommitGraph {
+		options := pack.DefaultCommitGraphWriteOptions()
+		options.Reachable = true
+		if err := manager.WriteCommitGraph(options); err != nil && !errors.Is(err, pack.ErrShallowCommitGraph) {
+			return err
+		}
+	}
+
+	// Every reachable object is now packed, and every unreachable object that has not
+	// expired is in the cruft pack: the loose objects left have expired
+	pruned, removedFiles := 0, 0
+	if !pruneExpiry.IsZero() {
+		pruned, err = pruneLooseObjects(st, pruneExpiry)
+		if err != nil {
+			return err
+		}
+		removedFiles, err = st.RemoveTemporaryFiles(pruneExpiry)
+		if err != nil {
+			return err
+		}
+	}
+	if !*quiet {
+		fmt.Printf("Packed %d %s, expired %d reflog %s, pruned %d %s and %d temporary %s\n",
+			packedRefs, plural(packedRefs, "ref"),
+			expiredEntries, plural(expiredEntries, "record"),
+			pruned, plural(pruned, "loose object"),
+			removedFiles, plural(removedFiles, "file"))
+	}
+	return nil
+}
+
+// needsGc reports whether the loose objects or the packs exceed the limits set by gc.auto
+// and gc.autoPackLimit. A limit of 0 disables it, and gc.auto=0 disables gc --auto.
+func needsGc(st *store.FSStore, cfg *config.Config) (bool, error) {
+	looseLimit, err := cfg.GetInt("gc", "", "auto", DEFAULT_GC_AUTO)
+	if err != nil {
+		return false, fmt.Errorf("gc.auto: %w", err)
+	}
+	if looseLimit <= 0 {
+		return false, nil
+	}
+	loose, err := st.ListObjects()
+	if err != nil {
+		return false, err
+	}
+	if int64(len(loose)) > looseLimit {
+		return true, nil
+	}
+	packLimit, err := cfg.GetInt("gc", "", "autopacklimit", DEFAULT_GC_AUTO_PACK_LIMIT)
+	if err != nil {
+		return false, fmt.Errorf("gc.autoPackLimit: %w", err)
+	}
+	if packLimit <= 0 {
+		return false, nil
+	}
+	packs, err := st.ListPacks()
+	if err != nil {
+		return false, err
+	}
+	return int64(len(packs)) > packLimit, nil
+}
+
+// pruneLooseObjects removes the loose objects written before expiry and returns how many
+// were removed
+func pruneLooseObjects(st *store.FSStore, expiry time.Time) (int, error) {
+	checksums, err := st.ListObjects()
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, checksum := range checksums {
+		info, err := st.StatObject(checksum)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return pruned, err
+		}
+		if !info.ModTime().Before(expiry) {
+			continue
+		}
+		if err := st.RemoveObject(checksum); err != nil && !os.IsNotExist(err) {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// parseExpiry parses an expiry date such as "2.weeks.ago", "now", an absolute date or a
+// unix timestamp, relative to now. "never" returns the zero time, for which nothing expires.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	switch strings.ToLower(value) {
+	case "never", "false":
+		return time.Time{}, nil
+	case "now", "all":
+		return now, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", time.DateOnly} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	fields := strings.FieldsFunc(strings.ToLower(value), func(r rune) bool {
+		return r == '.' || r == ' '
+	})
+	if len(fields) != 3 || fields[2] != "ago" {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	count, err := strconv.Atoi(fields[0])
+	if err != nil || count < 0 {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	switch strings.TrimSuffix(fields[1], "s") {
+	case "second":
+		return now.Add(-time.Duration(count) * time.Second), nil
+	case "minute":
+		return now.Add(-time.Duration(count) * time.Minute), nil
+	case "hour":
+		return now.Add(-time.Duration(count) * time.Hour), nil
+	case "day":
+		return now.AddDate(0, 0, -count), nil
+	case "week":
+		return now.AddDate(0, 0, -7*count), nil
+	case "month":
+		return now.AddDate(0, -count, 0), nil
+	case "year":
+		return now.AddDate(-count, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q", value)
+}
@@ -0,0 +1,118 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const INDEX_PACK_USAGE = "mygit index-pack [--threads=<n>] [-o <index-file>] (--stdin [--fix-thin] | <pack-file>)"
+
+// IndexPack writes the index of a pack. With --stdin the pack is read from stdin and
+// stored in the repository along with its index, otherwise the index of <pack-file> is
+// written next to it or to -o. With --fix-thin, the bases missing from a thin pack read
+// from stdin are appended to it. The checksum of the pack is printed.
+func IndexPack(args []string) error {
+	flagSet := flag.NewFlagSet("index-pack", flag.ExitOnError)
+	threads := flagSet.Int("threads", -1, "number of threads resolving deltas, 0 uses every CPU")
+	stdin := flagSet.Bool("stdin", false, "read the pack from stdin and store it in the repository")
+	output := flagSet.String("o", "", "write the index to this file")
+	fixThin := flagSet.Bool("fix-thin", false, "append the bases missing from a thin pack, read from the repository")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), INDEX_PACK_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if *stdin == (flagSet.NArg() == 1) || flagSet.NArg() > 1 {
+		return errors.New(INDEX_PACK_USAGE)
+	}
+	if *fixThin && !*stdin {
+		return errors.New("--fix-thin cannot be used without --stdin")
+	}
+
+	if *stdin {
+		if *output != "" {
+			return errors.New("-o is only supported along with <pack-file>")
+		}
+		st, err := store.New()
+		if err != nil {
+			return err
+		}
+		options := pack.IndexOptions{Threads: *threads}
+		if *threads < 0 {
+			cfg, err := loadConfig(st)
+			if err != nil {
+				return err
+			}
+			defaults, err := packWriteOptions(cfg)
+			if err != nil {
+				return err
+			}
+			options.Threads = defaults.Threads
+		}
+		manager, err := newPackManager(st)
+		if err != nil {
+			return err
+		}
+		if *fixThin {
+			options.Bases = manager
+		}
+		checksum, err := manager.Index(os.Stdin, options)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pack\t%s\n", hash.ChecksumToHex(checksum))
+		return nil
+	}
+
+	packPath := flagSet.Arg(0)
+	if !strings.HasSuffix(packPath, ".pack") {
+		return fmt.Errorf("pack file name %q does not end with .pack", packPath)
+	}
+	indexPath := *output
+	if indexPath == "" {
+		indexPath = strings.TrimSuffix(packPath, ".pack") + ".idx"
+	}
+	file, err := os.Open(packPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	indexed, err := pack.IndexPack(file, io.Discard, file, pack.IndexOptions{Threads: max(*threads, 0)})
+	if err != nil {
+		return fmt.Errorf("failed to index %s: %w", packPath, err)
+	}
+	if err := writeIndexFile(indexed, indexPath); err != nil {
+		return err
+	}
+	fmt.Println(hash.ChecksumToHex(indexed.Checksum))
+	return nil
+}
+
+// writeIndexFile writes the index to a temporary file renamed once complete
+func writeIndexFile(indexed *pack.IndexedPack, indexPath string) error {
+	idxFile, err := os.CreateTemp(filepath.Dir(indexPath), "tmp_idx_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(idxFile.Name())
+	err = indexed.WriteIndex(idxFile)
+	if closeErr := idxFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(idxFile.Name(), 0444); err != nil {
+		return err
+	}
+	return os.Rename(idxFile.Name(), indexPath)
+}
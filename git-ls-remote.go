@@ -0,0 +1,134 @@
+package goit
+
+import (
+	"flag"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/transport"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const LS_REMOTE_USAGE = "mygit ls-remote [--heads] [--tags] [--symref] [<remote>|<url> [<pattern>...]]"
+
+// lsRemoteOptions selects which advertised refs are listed
+type lsRemoteOptions struct {
+	heads    bool
+	tags     bool
+	symref   bool
+	patterns []string
+}
+
+// LsRemote lists the refs advertised by a remote along with the objects they point to
+func LsRemote(args []string) error {
+	flagSet := flag.NewFlagSet("ls-remote", flag.ExitOnError)
+	options := &lsRemoteOptions{}
+	flagSet.BoolVar(&options.heads, "heads", false, "limit to refs/heads")
+	flagSet.BoolVar(&options.tags, "tags", false, "limit to refs/tags")
+	flagSet.BoolVar(&options.symref, "symref", false, "show the ref HEAD points to")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), LS_REMOTE_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	gitUrl := flagSet.Arg(0)
+	if gitUrl == "" {
+		gitUrl = DEFAULT_REMOTE
+	}
+	if flagSet.NArg() > 1 {
+		options.patterns = flagSet.Args()[1:]
+	}
+
+	// A remote name is only resolved from inside a repository
+	st, err := store.New()
+	if err != nil {
+		st = nil
+	}
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	if configured, ok := cfg.Get("remote", gitUrl, "url"); ok {
+		gitUrl = configured
+	}
+
+	remote, err := transport.New(gitUrl, cfg)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+	ctx, cancel := commandContext()
+	defer cancel()
+	refDiscReply, err := remote.FetchRefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch refs: %w", err)
+	}
+	for _, line := range listRemoteRefs(refDiscReply, options) {
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// listRemoteRefs formats the advertised refs selected by options the way ls-remote prints
+// them: HEAD first, then refs sorted by name, each annotated tag followed by its peeled object
+func listRemoteRefs(refDiscReply *githttp.RefDiscReply, options *lsRemoteOptions) []string {
+	lines := []string{}
+	if head := refDiscReply.Head(); head != nil && !options.heads && !options.tags && options.match("HEAD") {
+		if target, ok := refDiscReply.HeadTarget(); ok && options.symref {
+			lines = append(lines, fmt.Sprintf("ref: %s\tHEAD", target))
+		}
+		lines = append(lines, fmt.Sprintf("%x\tHEAD", head))
+	}
+
+	names := make([]string, 0, len(refDiscReply.Refs()))
+	for name := range refDiscReply.Refs() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	peeledRefs := refDiscReply.PeeledRefs()
+	for _, name := range names {
+		if !options.selects(name) {
+			continue
+		}
+		if options.match(name) {
+			lines = append(lines, fmt.Sprintf("%x\t%s", refDiscReply.Refs()[name], name))
+		}
+		if peeled, ok := peeledRefs[name]; ok && options.match(name+"^{}") {
+			lines = append(lines, fmt.Sprintf("%x\t%s^{}", peeled, name))
+		}
+	}
+	return lines
+}
+
+// selects reports whether name is in the namespaces requested with --heads and --tags
+func (options *lsRemoteOptions) selects(name string) bool {
+	if !options.heads && !options.tags {
+		return true
+	}
+	return (options.heads && strings.HasPrefix(name, "refs/heads/")) ||
+		(options.tags && strings.HasPrefix(name, "refs/tags/"))
+}
+
+// match reports whether name matches one of the patterns. As with git, a pattern matches
+// the end of a ref name on a path component boundary, so "main" matches "refs/heads/main".
+func (options *lsRemoteOptions) match(name string) bool {
+	if len(options.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range options.patterns {
+		for tail := name; ; {
+			if ok, _ := path.Match(pattern, tail); ok {
+				return true
+			}
+			_, rest, found := strings.Cut(tail, "/")
+			if !found {
+				break
+			}
+			tail = rest
+		}
+	}
+	return false
+}
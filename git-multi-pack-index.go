@@ -0,0 +1,99 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const MULTI_PACK_INDEX_USAGE = "mygit multi-pack-index (write [--bitmap] [--preferred-pack=<pack>] | verify | expire | repack [--batch-size=<size>])"
+
+// MultiPackIndex maintains the multi-pack-index, which lists the objects of every pack so
+// that an object is found with a single lookup rather than one per pack. write indexes
+// every pack, verify checks the index against the packs, expire removes the packs whose
+// objects are all found in other packs and repack gathers the objects of small packs in a
+// new pack. write --bitmap also writes reachability bitmaps for the objects of the index.
+func MultiPackIndex(args []string) error {
+	flagSet := flag.NewFlagSet("multi-pack-index", flag.ExitOnError)
+	bitmap := flagSet.Bool("bitmap", false, "write reachability bitmaps")
+	preferredPack := flagSet.String("preferred-pack", "", "pack whose objects are preferred and come first in the bitmaps")
+	batchSize := flagSet.String("batch-size", "0", "repack the oldest packs until their objects take this size, 0 repacks every pack")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), MULTI_PACK_INDEX_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() == 0 {
+		return errors.New(MULTI_PACK_INDEX_USAGE)
+	}
+	subcommand := flagSet.Arg(0)
+	// The options may follow the subcommand as well
+	flagSet.Parse(flagSet.Args()[1:])
+	if flagSet.NArg() > 0 {
+		return errors.New(MULTI_PACK_INDEX_USAGE)
+	}
+
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	switch subcommand {
+	case "write":
+		options := pack.MidxWriteOptions{Bitmap: *bitmap}
+		if *preferredPack != "" {
+			name := strings.TrimSuffix(strings.TrimSuffix(path.Base(*preferredPack), ".idx"), ".pack")
+			checksum, err := hash.ChecksumFromHex(strings.TrimPrefix(name, "pack-"))
+			if err != nil {
+				return fmt.Errorf("--preferred-pack: bad pack name %s", *preferredPack)
+			}
+			options.PreferredPack = checksum
+		}
+		return manager.WriteMultiPackIndex(options)
+	case "verify":
+		return manager.VerifyMultiPackIndex()
+	case "expire":
+		expired, err := manager.ExpireMultiPackIndex()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Removed %d %s\n", len(expired), plural(len(expired), "pack"))
+		return nil
+	case "repack":
+		size, err := config.ParseInt(*batchSize)
+		if err != nil {
+			return fmt.Errorf("--batch-size: %w", err)
+		}
+		if size < 0 {
+			return fmt.Errorf("--batch-size: negative size %d", size)
+		}
+		cfg, err := loadConfig(st)
+		if err != nil {
+			return err
+		}
+		options, err := repackWriteOptions(cfg)
+		if err != nil {
+			return err
+		}
+		checksum, err := manager.RepackMultiPackIndex(size, options)
+		if err != nil {
+			return err
+		}
+		if checksum == nil {
+			fmt.Fprintln(os.Stderr, "Nothing to repack.")
+		}
+		return nil
+	}
+	return errors.New(MULTI_PACK_INDEX_USAGE)
+}
@@ -0,0 +1,153 @@
+package goit
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const PACK_OBJECTS_USAGE = "mygit pack-objects [--window=<n>] [--depth=<n>] [--threads=<n>] [--no-reuse-delta] [--delta-base-offset] (--stdout | <base-name>)"
+
+// PackObjects writes a pack of the objects whose ids are read from stdin, one per line and
+// optionally followed by the path of the object as printed by rev-list --objects. The pack
+// is written to stdout, or to <base-name>-<checksum>.pack along with its index, in which
+// case the checksum is printed.
+func PackObjects(args []string) error {
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	defaults, err := packWriteOptions(cfg)
+	if err != nil {
+		return err
+	}
+	flagSet := flag.NewFlagSet("pack-objects", flag.ExitOnError)
+	window := flagSet.Int("window", defaults.Window, "number of objects each object is compared to when looking for a delta base")
+	depth := flagSet.Int("depth", defaults.Depth, "longest chain of deltas")
+	threads := flagSet.Int("threads", defaults.Threads, "number of threads searching deltas, 0 uses every CPU")
+	noReuseDelta := flagSet.Bool("no-reuse-delta", false, "search new deltas rather than copying the deltas of existing packs")
+	ofsDelta := flagSet.Bool("delta-base-offset", false, "refer to delta bases by offset")
+	stdout := flagSet.Bool("stdout", false, "write the pack to stdout")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), PACK_OBJECTS_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if *stdout == (flagSet.NArg() == 1) || flagSet.NArg() > 1 {
+		return errors.New(PACK_OBJECTS_USAGE)
+	}
+	options := pack.WriteOptions{
+		Window:     *window,
+		Depth:      *depth,
+		Threads:    *threads,
+		OfsDelta:   *ofsDelta,
+		ReuseDelta: !*noReuseDelta,
+	}
+
+	objects, err := readObjectsToPack(bufio.NewScanner(os.Stdin))
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	if *stdout {
+		_, err := pack.WriteObjects(manager, os.Stdout, objects, options)
+		return err
+	}
+	return writePackFiles(manager, flagSet.Arg(0), objects, options)
+}
+
+// readObjectsToPack reads lines holding an object id optionally followed by its path
+func readObjectsToPack(scanner *bufio.Scanner) ([]pack.ObjectToPack, error) {
+	objects := []pack.ObjectToPack{}
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		hexID, name, _ := strings.Cut(line, " ")
+		id, err := hash.ChecksumFromHex(hexID)
+		if err != nil || len(id) != 20 {
+			return nil, fmt.Errorf("expected object id, got %q", line)
+		}
+		objects = append(objects, pack.ObjectToPack{ID: id, Name: name})
+	}
+	return objects, scanner.Err()
+}
+
+// writePackFiles writes the pack and its index next to baseName, renaming them once both
+// are complete, and prints the checksum of the pack
+func writePackFiles(manager pack.PackManageer, baseName string, objects []pack.ObjectToPack, options pack.WriteOptions) error {
+	packFile, err := os.CreateTemp(filepath.Dir(baseName), "tmp_pack_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(packFile.Name())
+	encoder, err := pack.WriteObjects(manager, packFile, objects, options)
+	if err == nil {
+		err = packFile.Close()
+	}
+	if err != nil {
+		packFile.Close()
+		return err
+	}
+	idxFile, err := os.CreateTemp(filepath.Dir(baseName), "tmp_idx_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(idxFile.Name())
+	err = encoder.WriteIndex(idxFile)
+	if closeErr := idxFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s", baseName, hash.ChecksumToHex(encoder.Checksum()))
+	// Packs are never modified once written
+	for _, rename := range [][2]string{{packFile.Name(), name + ".pack"}, {idxFile.Name(), name + ".idx"}} {
+		if err := os.Chmod(rename[0], 0444); err != nil {
+			return err
+		}
+		if err := os.Rename(rename[0], rename[1]); err != nil {
+			return err
+		}
+	}
+	fmt.Println(hash.ChecksumToHex(encoder.Checksum()))
+	return nil
+}
+
+// packWriteOptions reads the pack.window, pack.depth and pack.threads settings
+func packWriteOptions(cfg *config.Config) (pack.WriteOptions, error) {
+	options := pack.DefaultWriteOptions()
+	for _, setting := range []struct {
+		key   string
+		value *int
+	}{
+		{"window", &options.Window},
+		{"depth", &options.Depth},
+		{"threads", &options.Threads},
+	} {
+		value, err := cfg.GetInt("pack", "", setting.key, int64(*setting.value))
+		if err != nil {
+			return options, fmt.Errorf("pack.%s: %w", setting.key, err)
+		}
+		*setting.value = int(value)
+	}
+	return options, nil
+}
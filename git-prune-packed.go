@@ -0,0 +1,52 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const PRUNE_PACKED_USAGE = "mygit prune-packed [-n] [-q]"
+
+// PrunePacked removes the loose objects that are also stored in a pack. With -n the
+// objects are only listed as the commands that would remove them.
+func PrunePacked(args []string) error {
+	flagSet := flag.NewFlagSet("prune-packed", flag.ExitOnError)
+	dryRun := flagSet.Bool("n", false, "list the objects without removing them")
+	quiet := flagSet.Bool("q", false, "do not report the number of objects removed")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), PRUNE_PACKED_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() > 0 {
+		return errors.New(PRUNE_PACKED_USAGE)
+	}
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	pruned, err := manager.PrunePacked(*dryRun)
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		for _, id := range pruned {
+			hexID := hash.ChecksumToHex(id)
+			fmt.Printf("rm -f %s\n", filepath.Join(st.RootDir(), store.OBJECT_PREFIX, hexID[:2], hexID[2:]))
+		}
+		return nil
+	}
+	if !*quiet {
+		fmt.Printf("Removed %d %s\n", len(pruned), plural(len(pruned), "loose object"))
+	}
+	return nil
+}
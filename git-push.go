@@ -0,0 +1,175 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/transport"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
+)
+
+const PUSH_USAGE = "mygit push [-f | --force] [<remote>|<url>] [+<src>:<dst>|:<dst>|<branch>...]"
+
+var (
+	ErrDetachedHead = errors.New("not on a branch")
+)
+
+// Push updates the refs of a remote with local refs, sending the objects the remote is missing
+func Push(args []string) error {
+	flagSet := flag.NewFlagSet("push", flag.ExitOnError)
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), PUSH_USAGE)
+		flagSet.PrintDefaults()
+	}
+	force := flagSet.Bool("force", false, "update remote refs that are not ancestors of the local refs")
+	flagSet.BoolVar(force, "f", false, "short for --force")
+	flagSet.Parse(args)
+	remoteName := flagSet.Arg(0)
+	if remoteName == "" {
+		remoteName = DEFAULT_REMOTE
+	}
+
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	gitUrl, err := remoteUrl(st, remoteName)
+	if err != nil {
+		return err
+	}
+	if gitUrl == "" {
+		gitUrl, remoteName = remoteName, ""
+	}
+
+	refspecs := flagSet.Args()
+	if len(refspecs) > 0 {
+		refspecs = refspecs[1:]
+	}
+	if len(refspecs) == 0 {
+		head, err := st.ReadRef(store.HEAD)
+		if err != nil {
+			return err
+		}
+		if !head.IsSymbolic() {
+			return ErrDetachedHead
+		}
+		refspecs = []string{head.Target}
+	}
+
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	remote, err := transport.New(gitUrl, cfg)
+	if err != nil {
+		return err
+	}
+	defer remote.Close()
+
+	ctx, cancel := commandContext()
+	defer cancel()
+	refDiscReply, err := remote.PushRefs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch refs: %w", err)
+	}
+	manager := pack.New(st)
+	req, rejected, err := transport.PlanPush(st, manager, refDiscReply.Refs(), refspecs, *force)
+	if err != nil {
+		return err
+	}
+	if len(req.Updates) == 0 {
+		return pushRejected(gitUrl, rejected)
+	}
+
+	var packReader io.Reader
+	if req.HasPack() {
+		wants := [][]byte{}
+		for _, update := range req.Updates {
+			if !update.IsDelete() {
+				wants = append(wants, update.New)
+			}
+		}
+		// Objects reachable from remote refs we know about need not be sent
+		haves := [][]byte{}
+		for _, id := range refDiscReply.Refs() {
+			ok, err := manager.ObjectExist(id)
+			if err != nil {
+				return err
+			}
+			if ok {
+				haves = append(haves, id)
+			}
+		}
+		// The history of a shallow repository ends at its shallow commits
+		shallow, err := st.Shallow()
+		if err != nil {
+			return err
+		}
+		writeOptions := pack.DefaultWriteOptions()
+		writeOptions.OfsDelta = refDiscReply.Caps().Has("ofs-delta")
+		r, w := io.Pipe()
+		go func() {
+			w.CloseWithError(githttp.WritePack(manager, w, wants, haves, revlist.Options{Shallow: shallow}, writeOptions))
+		}()
+		defer r.Close()
+		packReader = r
+	}
+
+	sent := trace.Measure("push: send pack")
+	report, err := remote.Push(ctx, req, packReader)
+	sent()
+	if err != nil {
+		return err
+	}
+	if err := report.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("To %s\n", gitUrl)
+	printRejected(rejected)
+	for _, update := range req.Updates {
+		fmt.Printf("%x..%x\t%s\n", update.Old, update.New, update.Name)
+		if remoteName == "" {
+			continue
+		}
+		branch, ok := strings.CutPrefix(update.Name, "refs/heads/")
+		if !ok {
+			continue
+		}
+		trackingRef := fmt.Sprintf("refs/remotes/%s/%s", remoteName, branch)
+		if update.IsDelete() {
+			err = st.DeleteRef(trackingRef, nil)
+		} else {
+			err = st.WriteRef(trackingRef, update.New, nil)
+		}
+		if err != nil && !errors.Is(err, store.ErrRefNotExist) {
+			return err
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("failed to push some refs to '%s'", gitUrl)
+	}
+	return nil
+}
+
+// pushRejected reports a push whose updates were all rejected
+func pushRejected(gitUrl string, rejected []transport.RejectedUpdate) error {
+	fmt.Printf("To %s\n", gitUrl)
+	printRejected(rejected)
+	return fmt.Errorf("failed to push some refs to '%s'", gitUrl)
+}
+
+// printRejected prints the rejected updates the way git does
+func printRejected(rejected []transport.RejectedUpdate) {
+	for _, update := range rejected {
+		dst := strings.TrimPrefix(update.Dst, "refs/heads/")
+		fmt.Printf(" ! [rejected]        %s -> %s (%s)\n", update.Src, dst, update.Reason)
+	}
+}
@@ -0,0 +1,47 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
+)
+
+const RECEIVE_PACK_USAGE = "mygit receive-pack [--stateless-rpc] [--advertise-refs] <directory>"
+
+// ReceivePack stores the objects pushed over stdin into the repository in directory
+// and updates its refs, reporting the result over stdout.
+func ReceivePack(args []string) error {
+	flagSet := flag.NewFlagSet("receive-pack", flag.ExitOnError)
+	statelessRPC := flagSet.Bool("stateless-rpc", false, "read a single request and exit")
+	advertiseRefs := flagSet.Bool("advertise-refs", false, "only advertise the refs and exit")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), RECEIVE_PACK_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.Arg(0) == "" {
+		return errors.New(RECEIVE_PACK_USAGE)
+	}
+
+	trace.PacketIdentity = "receive-pack"
+	st, err := store.Open(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := protocolStdout()
+	session := githttp.NewReceivePackSession(st)
+	if *advertiseRefs || !*statelessRPC {
+		if err := session.AdvertiseRefs(out); err != nil {
+			return err
+		}
+	}
+	if *advertiseRefs {
+		return nil
+	}
+	return session.ReceivePack(os.Stdin, out)
+}
@@ -0,0 +1,319 @@
+package goit
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const REPACK_USAGE = "mygit repack [-a] [-d] [-f] [-b] [--cruft] [--cruft-expiration=<date>] [--window=<n>] [--depth=<n>]"
+
+// repackOptions tune repack
+type repackOptions struct {
+	// all packs every reachable object rather than only the loose ones
+	all bool
+	// delete removes the packs and the loose objects made redundant by the new pack
+	delete bool
+	// cruft keeps the unreachable objects in a cruft pack, which implies all
+	cruft bool
+	// cruftExpiration leaves the unreachable objects last written before it out of the
+	// cruft pack, the zero time keeps them all
+	cruftExpiration time.Time
+	// bitmap writes the reachability bitmaps of the new pack, which requires all
+	bitmap bool
+	write  pack.WriteOptions
+}
+
+// Repack writes the objects reachable from the refs, HEAD and the reflogs to a new pack.
+// Without -a only the reachable loose objects are packed. With -d the packs and the
+// loose objects the new pack makes redundant are removed; without --cruft, the
+// unreachable objects of the removed packs are lost. Packs with a .keep are left alone.
+// With -b, or repack.writeBitmaps which defaults to true in bare repositories, the new
+// pack gets reachability bitmaps.
+func Repack(args []string) error {
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(st)
+	if err != nil {
+		return err
+	}
+	defaults, err := repackWriteOptions(cfg)
+	if err != nil {
+		return err
+	}
+	flagSet := flag.NewFlagSet("repack", flag.ExitOnError)
+	all := flagSet.Bool("a", false, "pack every reachable object into a single pack")
+	remove := flagSet.Bool("d", false, "remove the redundant packs and loose objects")
+	noReuseDelta := flagSet.Bool("f", false, "search new deltas rather than copying the deltas of existing packs")
+	cruft := flagSet.Bool("cruft", false, "keep the unreachable objects in a cruft pack")
+	cruftExpiration := flagSet.String("cruft-expiration", "never", "leave the unreachable objects written before this date out of the cruft pack")
+	writeBitmaps, err := repackWriteBitmaps(cfg)
+	if err != nil {
+		return err
+	}
+	bitmap := flagSet.Bool("b", false, "write reachability bitmaps, which requires -a")
+	flagSet.BoolVar(bitmap, "write-bitmap-index", false, "same as -b")
+	window := flagSet.Int("window", defaults.Window, "number of objects each object is compared to when looking for a delta base")
+	depth := flagSet.Int("depth", defaults.Depth, "longest chain of deltas")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), REPACK_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() > 0 {
+		return errors.New(REPACK_USAGE)
+	}
+	expiration, err := parseExpiry(*cruftExpiration, time.Now())
+	if err != nil {
+		return err
+	}
+	options := repackOptions{
+		all:             *all || *cruft,
+		delete:          *remove,
+		cruft:           *cruft,
+		cruftExpiration: expiration,
+		write:           defaults,
+	}
+	if *bitmap && !options.all {
+		return errors.New("-b requires -a as the bitmaps of a pack cover every object it reaches")
+	}
+	options.bitmap = *bitmap || options.all && writeBitmaps
+	options.write.Window = *window
+	options.write.Depth = *depth
+	options.write.ReuseDelta = !*noReuseDelta
+
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	return repack(st, cfg, manager, options)
+}
+
+// repackWriteOptions reads the pack settings along with repack.useDeltaBaseOffset
+func repackWriteOptions(cfg *config.Config) (pack.WriteOptions, error) {
+	options, err := packWriteOptions(cfg)
+	if err != nil {
+		return options, err
+	}
+	// Packs of the repository are only read locally, which understands ofs deltas
+	options.OfsDelta, err = cfg.GetBool("repack", "", "usedeltabaseoffset", true)
+	return options, err
+}
+
+// repackWriteBitmaps reads repack.writeBitmaps, which defaults to true in bare
+// repositories as they usually serve clones
+func repackWriteBitmaps(cfg *config.Config) (bool, error) {
+	bare, err := cfg.GetBool("core", "", "bare", false)
+	if err != nil {
+		return false, fmt.Errorf("core.bare: %w", err)
+	}
+	writeBitmaps, err := cfg.GetBool("repack", "", "writebitmaps", bare)
+	if err != nil {
+		return false, fmt.Errorf("repack.writeBitmaps: %w", err)
+	}
+	return writeBitmaps, nil
+}
+
+func repack(st *store.FSStore, cfg *config.Config, manager *pack.DefaulPackManager, options repackOptions) error {
+	// The objects left out of a partial clone cannot be walked without fetching them
+	if _, ok := cfg.Get("extensions", "", "partialclone"); ok {
+		return errors.New("repack is not supported in a partial clone")
+	}
+	// Only the packs that existed before the walk are removed, not those written since.
+	// Packs with a .keep are neither rewritten nor removed.
+	indices, err := st.ListPackIndices()
+	if err != nil {
+		return err
+	}
+	existing := []string{}
+	kept := map[string]bool{}
+	for _, hexChecksum := range indices {
+		ok, err := st.IsPackKept(hexChecksum)
+		if err != nil {
+			return err
+		}
+		if ok {
+			kept[hexChecksum] = true
+		} else {
+			existing = append(existing, hexChecksum)
+		}
+	}
+	roots, err := reachableRoots(st, manager)
+	if err != nil {
+		return err
+	}
+	shallow, err := st.Shallow()
+	if err != nil {
+		return err
+	}
+	entries, err := revlist.Objects(manager, roots, nil, revlist.Options{Shallow: shallow})
+	if err != nil {
+		return err
+	}
+	stored, err := manager.StoredObjects()
+	if err != nil {
+		return err
+	}
+	packed := map[string]bool{}
+	inKept := map[string]bool{}
+	for _, object := range stored {
+		if object.Pack != nil {
+			packed[string(object.ID)] = true
+			if kept[hash.ChecksumToHex(object.Pack)] {
+				inKept[string(object.ID)] = true
+			}
+		}
+	}
+
+	reachable := make(map[string]bool, len(entries))
+	objects := []pack.ObjectToPack{}
+	for _, entry := range entries {
+		reachable[string(entry.Checksum)] = true
+		if options.all && !inKept[string(entry.Checksum)] || !packed[string(entry.Checksum)] {
+			objects = append(objects, pack.ObjectToPack{ID: entry.Checksum, Name: entry.Name})
+		}
+	}
+	// written holds the packs written, which are not removed even when an identical pack
+	// existed before
+	written := [][]byte{}
+	if len(objects) == 0 {
+		fmt.Println("Nothing new to pack.")
+	} else {
+		checksum, err := manager.Write(objects, options.write)
+		if err != nil {
+			return err
+		}
+		written = append(written, checksum)
+		// The parents of shallow commits are missing, so bitmaps cannot cover them, nor
+		// can they cover the objects left in kept packs
+		if options.bitmap && len(objects) < len(entries) {
+			fmt.Fprintln(os.Stderr, "warning: disabling bitmap writing, as some objects are not being packed")
+		} else if options.bitmap && len(shallow) == 0 {
+			if err := manager.WriteBitmap(checksum); err != nil {
+				return err
+			}
+		}
+	}
+	if options.cruft {
+		// The unreachable objects of kept packs stay in them
+		candidates := slices.DeleteFunc(slices.Clone(stored), func(object pack.StoredObject) bool {
+			return inKept[string(object.ID)]
+		})
+		cruft := unreachableObjects(candidates, reachable, options.cruftExpiration)
+		if len(cruft) > 0 {
+			checksum, err := manager.WriteCruft(cruft, options.write)
+			if err != nil {
+				return err
+			}
+			written = append(written, checksum)
+		}
+	}
+
+	if !options.delete {
+		return nil
+	}
+	if options.all {
+		for _, hexChecksum := range existing {
+			checksum, err := hash.ChecksumFromHex(hexChecksum)
+			if err != nil {
+				return err
+			}
+			if slices.ContainsFunc(written, func(c []byte) bool { return bytes.Equal(c, checksum) }) {
+				continue
+			}
+			if err := manager.RemovePack(checksum); err != nil {
+				return err
+			}
+		}
+	}
+	_, err = manager.PrunePacked(false)
+	return err
+}
+
+// reachableRoots returns the objects the refs, HEAD and the entries of the reflogs point
+// to. Reflog entries whose objects no longer exist are left out.
+func reachableRoots(st *store.FSStore, manager pack.PackManageer) ([][]byte, error) {
+	roots := [][]byte{}
+	seen := map[string]bool{}
+	add := func(id []byte) {
+		if !seen[string(id)] {
+			seen[string(id)] = true
+			roots = append(roots, id)
+		}
+	}
+	refs, err := st.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		add(ref.Checksum)
+	}
+	head, err := st.ResolveRef(store.HEAD)
+	if err != nil && !errors.Is(err, store.ErrRefNotExist) {
+		return nil, err
+	}
+	if head != nil {
+		add(head.Checksum)
+	}
+	names, err := st.ListReflogs()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		entries, err := st.ReadReflog(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			for _, id := range [][]byte{entry.Old, entry.New} {
+				// Entries creating or deleting the ref have a null id on one side
+				if seen[string(id)] || bytes.Equal(id, make([]byte, len(id))) {
+					continue
+				}
+				ok, err := manager.ObjectExist(id)
+				if err != nil {
+					return nil, err
+				}
+				if ok {
+					add(id)
+				}
+			}
+		}
+	}
+	return roots, nil
+}
+
+// unreachableObjects returns the stored objects that are not reachable, each dated by its
+// most recent copy. Objects last written before expiration are left out unless it is zero.
+func unreachableObjects(stored []pack.StoredObject, reachable map[string]bool, expiration time.Time) []pack.CruftObject {
+	modTimes := map[string]time.Time{}
+	for _, object := range stored {
+		key := string(object.ID)
+		if reachable[key] {
+			continue
+		}
+		if modTime, ok := modTimes[key]; !ok || object.ModTime.After(modTime) {
+			modTimes[key] = object.ModTime
+		}
+	}
+	cruft := []pack.CruftObject{}
+	for key, modTime := range modTimes {
+		if !expiration.IsZero() && modTime.Before(expiration) {
+			continue
+		}
+		cruft = append(cruft, pack.CruftObject{ID: []byte(key), ModTime: modTime})
+	}
+	return cruft
+}
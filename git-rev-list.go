@@ -0,0 +1,154 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const REV_LIST_USAGE = "mygit rev-list [--objects] [--use-bitmap-index] [--count] [--all] <commit>... [^<commit>...] [<commit>..<commit>]"
+
+// RevList lists the commits reachable from the given commits but not from the ones
+// prefixed with ^. With --objects the trees, blobs and tags are listed as well, along with
+// the path they were found at. --use-bitmap-index answers the walk from reachability
+// bitmaps when the repository has some.
+func RevList(args []string) error {
+	flagSet := flag.NewFlagSet("rev-list", flag.ExitOnError)
+	objects := flagSet.Bool("objects", false, "list the trees, blobs and tags as well")
+	useBitmaps := flagSet.Bool("use-bitmap-index", false, "use reachability bitmaps when available")
+	count := flagSet.Bool("count", false, "print the number of objects instead of listing them")
+	all := flagSet.Bool("all", false, "walk from every ref and HEAD")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), REV_LIST_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	revisions, haves, err := parseRevListRevs(st, manager, flagSet.Args())
+	if err != nil {
+		return err
+	}
+	wants := [][]byte{}
+	for _, rev := range revisions {
+		wants = append(wants, rev.id)
+	}
+	if *all {
+		if head, err := st.ResolveRef(store.HEAD); err == nil {
+			wants = append(wants, head.Checksum)
+		}
+		refs, err := st.ListRefs()
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			wants = append(wants, ref.Checksum)
+		}
+	}
+	if len(wants) == 0 {
+		return errors.New(REV_LIST_USAGE)
+	}
+
+	shallow, err := st.Shallow()
+	if err != nil {
+		return err
+	}
+	options := revlist.Options{Shallow: shallow}
+	if *useBitmaps {
+		bitmaps, err := manager.Bitmaps()
+		if err != nil {
+			return err
+		}
+		// A nil index has to stay a nil interface, which walks every object
+		if bitmaps != nil {
+			options.Bitmaps = bitmaps
+		}
+	}
+	entries, err := revlist.Objects(manager, wants, haves, options)
+	if err != nil {
+		return err
+	}
+
+	n := 0
+	for _, entry := range entries {
+		if !*objects && entry.Type != common.OBJ_COMMIT {
+			continue
+		}
+		n++
+		if *count {
+			continue
+		}
+		// Trees and blobs are followed by their path, which is empty for a root tree. The
+		// paths are unknown to bitmaps, so git prints none when they are used.
+		if options.Bitmaps == nil && (entry.Type == common.OBJ_TREE || entry.Type == common.OBJ_BLOB) {
+			fmt.Printf("%s %s\n", hash.ChecksumToHex(entry.Checksum), entry.Name)
+		} else {
+			fmt.Println(hash.ChecksumToHex(entry.Checksum))
+		}
+	}
+	if *count {
+		fmt.Println(n)
+	}
+	return nil
+}
+
+// revision is a commit named on the command line, along with the ref it stands for if any
+type revision struct {
+	name string
+	id   []byte
+}
+
+// parseRevListRevs splits the revisions into the commits walked and the ones excluded,
+// given as ^<commit> or as the left side of <commit>..<commit>
+func parseRevListRevs(st *store.FSStore, db revlist.ObjectReader, args []string) ([]revision, [][]byte, error) {
+	wants := []revision{}
+	haves := [][]byte{}
+	for _, arg := range args {
+		switch {
+		case strings.HasPrefix(arg, "^"):
+			_, id, err := resolveRev(st, db, strings.TrimPrefix(arg, "^"))
+			if err != nil {
+				return nil, nil, err
+			}
+			haves = append(haves, id)
+		case strings.Contains(arg, ".."):
+			from, to, _ := strings.Cut(arg, "..")
+			if from == "" {
+				from = store.HEAD
+			}
+			if to == "" {
+				to = store.HEAD
+			}
+			_, have, err := resolveRev(st, db, from)
+			if err != nil {
+				return nil, nil, err
+			}
+			name, want, err := resolveRev(st, db, to)
+			if err != nil {
+				return nil, nil, err
+			}
+			haves = append(haves, have)
+			wants = append(wants, revision{name: name, id: want})
+		default:
+			name, id, err := resolveRev(st, db, arg)
+			if err != nil {
+				return nil, nil, err
+			}
+			wants = append(wants, revision{name: name, id: id})
+		}
+	}
+	return wants, haves, nil
+}
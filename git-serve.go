@@ -0,0 +1,35 @@
+package goit
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+)
+
+const SERVE_USAGE = "mygit serve [--listen <addr>] [--receive-pack] [<base-dir>]"
+
+// Serve serves the repositories below the base directory, which defaults to the
+// current directory, over the smart HTTP protocol.
+func Serve(args []string) error {
+	flagSet := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := flagSet.String("listen", ":8080", "address to listen on")
+	receivePack := flagSet.Bool("receive-pack", false, "allow clients to push")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), SERVE_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+
+	baseDir := flagSet.Arg(0)
+	if baseDir == "" {
+		baseDir = "."
+	}
+
+	server := githttp.NewServer(githttp.NewFSResolver(baseDir), githttp.ServerOptions{
+		ReceivePack: *receivePack,
+	})
+	fmt.Printf("serving %s on %s\n", baseDir, *listen)
+	return http.ListenAndServe(*listen, server)
+}
@@ -0,0 +1,34 @@
+package goit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+)
+
+// ShowIndex prints the objects listed by the pack index read from stdin, sorted by id:
+// their offset, their id and, for version 2 indexes, the CRC of their entry
+func ShowIndex(args []string) error {
+	index, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	entries, _, err := pack.DecodeIndex(index)
+	if err != nil {
+		return err
+	}
+	hasCRC := bytes.HasPrefix(index, pack.INDEX_V2_MAGIC)
+	out := bufio.NewWriter(os.Stdout)
+	for _, entry := range entries {
+		if hasCRC {
+			fmt.Fprintf(out, "%d %x (%08x)\n", entry.Offset, entry.ID, entry.CRC)
+		} else {
+			fmt.Fprintf(out, "%d %x\n", entry.Offset, entry.ID)
+		}
+	}
+	return out.Flush()
+}
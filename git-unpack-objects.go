@@ -0,0 +1,48 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const UNPACK_OBJECTS_USAGE = "mygit unpack-objects [-n] [-q] [--strict] < <pack-file>"
+
+// UnpackObjects reads a pack from stdin and writes its objects as loose objects, skipping
+// the objects the repository already holds. With -n the pack is only verified. With
+// --strict nothing is written unless the objects are well formed and every object they
+// refer to exists.
+func UnpackObjects(args []string) error {
+	flagSet := flag.NewFlagSet("unpack-objects", flag.ExitOnError)
+	dryRun := flagSet.Bool("n", false, "verify the pack without writing any object")
+	quiet := flagSet.Bool("q", false, "do not report the number of objects unpacked")
+	strict := flagSet.Bool("strict", false, "do not write objects with broken content or links")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), UNPACK_OBJECTS_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() > 0 {
+		return errors.New(UNPACK_OBJECTS_USAGE)
+	}
+	st, err := store.New()
+	if err != nil {
+		return err
+	}
+	manager, err := newPackManager(st)
+	if err != nil {
+		return err
+	}
+	unpacked, err := manager.Unpack(os.Stdin, pack.UnpackOptions{DryRun: *dryRun, Strict: *strict})
+	if err != nil {
+		return err
+	}
+	if !*quiet {
+		fmt.Fprintf(os.Stderr, "Unpacking objects: %d %s, done.\n", len(unpacked), plural(len(unpacked), "object"))
+	}
+	return nil
+}
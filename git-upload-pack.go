@@ -0,0 +1,55 @@
+package goit
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
+)
+
+const UPLOAD_PACK_USAGE = "mygit upload-pack [--stateless-rpc] [--advertise-refs] <directory>"
+
+// UploadPack serves the objects of the repository in directory over stdin and stdout.
+// It is the command run on the remote end of ssh and ext:: transports.
+func UploadPack(args []string) error {
+	flagSet := flag.NewFlagSet("upload-pack", flag.ExitOnError)
+	statelessRPC := flagSet.Bool("stateless-rpc", false, "read a single request and exit")
+	advertiseRefs := flagSet.Bool("advertise-refs", false, "only advertise the refs and exit")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), UPLOAD_PACK_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.Arg(0) == "" {
+		return errors.New(UPLOAD_PACK_USAGE)
+	}
+
+	trace.PacketIdentity = "upload-pack"
+	st, err := store.Open(flagSet.Arg(0))
+	if err != nil {
+		return err
+	}
+	out := protocolStdout()
+	session := githttp.NewUploadPackSession(st, *statelessRPC)
+	if *advertiseRefs || !*statelessRPC {
+		if err := session.AdvertiseRefs(out); err != nil {
+			return err
+		}
+	}
+	if *advertiseRefs {
+		return nil
+	}
+	return session.UploadPack(os.Stdin, out)
+}
+
+// protocolStdout returns the stdout carrying the protocol stream and points os.Stdout
+// to stderr, so that anything else printed by the process cannot corrupt the stream.
+func protocolStdout() *os.File {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	return out
+}
@@ -0,0 +1,98 @@
+package goit
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+)
+
+const VERIFY_PACK_USAGE = "mygit verify-pack [-v] <pack>.idx..."
+
+// VerifyPack checks packs against their index. With -v every object is listed, followed
+// by a histogram of the delta chain lengths.
+func VerifyPack(args []string) error {
+	flagSet := flag.NewFlagSet("verify-pack", flag.ExitOnError)
+	verbose := flagSet.Bool("v", false, "list the objects of the packs")
+	flagSet.Usage = func() {
+		fmt.Fprintln(flagSet.Output(), VERIFY_PACK_USAGE)
+		flagSet.PrintDefaults()
+	}
+	flagSet.Parse(args)
+	if flagSet.NArg() == 0 {
+		return errors.New(VERIFY_PACK_USAGE)
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	var failed error
+	for _, name := range flagSet.Args() {
+		if err := verifyPack(out, name, *verbose); err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			failed = fmt.Errorf("%s: bad", name)
+		}
+	}
+	return failed
+}
+
+// verifyPack verifies the pack whose index or pack file is name
+func verifyPack(out io.Writer, name string, verbose bool) error {
+	base := strings.TrimSuffix(strings.TrimSuffix(name, ".idx"), ".pack")
+	index, err := os.ReadFile(base + ".idx")
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(base + ".pack")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	objects, err := pack.VerifyPack(file, info.Size(), index)
+	if err != nil {
+		return fmt.Errorf("%s.pack: %w", base, err)
+	}
+	if !verbose {
+		return nil
+	}
+
+	chains := []int{}
+	for _, object := range objects {
+		fmt.Fprintf(out, "%x %-6s %d %d %d", object.ID, object.Type, object.Size, object.PackedSize, object.Offset)
+		if object.Depth > 0 {
+			fmt.Fprintf(out, " %d %x", object.Depth, object.BaseID)
+		}
+		fmt.Fprintln(out)
+		for len(chains) <= object.Depth {
+			chains = append(chains, 0)
+		}
+		chains[object.Depth]++
+	}
+	for depth, count := range chains {
+		if count == 0 {
+			continue
+		}
+		if depth == 0 {
+			fmt.Fprintf(out, "non delta: %d %s\n", count, plural(count, "object"))
+		} else {
+			fmt.Fprintf(out, "chain length = %d: %d %s\n", depth, count, plural(count, "object"))
+		}
+	}
+	fmt.Fprintf(out, "%s.pack: ok\n", base)
+	return nil
+}
+
+func plural(count int, word string) string {
+	if count == 1 {
+		return word
+	}
+	return word + "s"
+}
@@ -0,0 +1,396 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Config is a git configuration file. Section, subsection and key lookups follow git's rules:
+// section and key names are case-insensitive while subsection names are case-sensitive.
+type Config struct {
+	sections []*Section
+}
+
+type Section struct {
+	Name       string
+	Subsection string
+	Options    []*Option
+}
+
+type Option struct {
+	Key   string
+	Value string
+}
+
+func New() *Config {
+	return &Config{}
+}
+
+// Load reads the config file at path. A missing file results in an empty config.
+func Load(path string) (*Config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return New(), nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	return Decode(file)
+}
+
+// Save writes the config to path, replacing the file atomically
+func (c *Config) Save(path string) error {
+	lockPath := path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lockPath)
+	if err := c.Encode(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(lockPath, path)
+}
+
+// GlobalPaths returns the per-user config files in the order git reads them. GIT_CONFIG_GLOBAL
+// replaces them altogether.
+func GlobalPaths() []string {
+	if global, ok := os.LookupEnv("GIT_CONFIG_GLOBAL"); ok {
+		return []string{global}
+	}
+	paths := []string{}
+	home, _ := os.UserHomeDir()
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(xdg, "git", "config"))
+	} else if home != "" {
+		paths = append(paths, filepath.Join(home, ".config", "git", "config"))
+	}
+	if home != "" {
+		paths = append(paths, filepath.Join(home, ".gitconfig"))
+	}
+	return paths
+}
+
+// LoadGlobal reads the per-user config files
+func LoadGlobal() (*Config, error) {
+	configs := []*Config{}
+	for _, path := range GlobalPaths() {
+		c, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, c)
+	}
+	return Merge(configs...), nil
+}
+
+// Merge returns a config holding the sections of configs in order, so that a value
+// set in a later config takes precedence over the same key in an earlier one. The
+// sections are shared with configs, so the merged config is only meant to be read.
+func Merge(configs ...*Config) *Config {
+	merged := New()
+	for _, c := range configs {
+		if c != nil {
+			merged.sections = append(merged.sections, c.sections...)
+		}
+	}
+	return merged
+}
+
+func Decode(r io.Reader) (*Config, error) {
+	c := New()
+	var section *Section
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("%w: line %d", ErrInvalidConfig, lineNum)
+			}
+			name, subsection, _ := strings.Cut(line[1:end], " ")
+			subsection = strings.Trim(strings.TrimSpace(subsection), `"`)
+			section = c.section(name, subsection, true)
+			line = strings.TrimSpace(line[end+1:])
+			if line == "" {
+				continue
+			}
+		}
+		if section == nil {
+			return nil, fmt.Errorf("%w: line %d: option outside of a section", ErrInvalidConfig, lineNum)
+		}
+		key, value, found := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !found {
+			// A key without a value is a boolean set to true
+			value = "true"
+		}
+		section.Options = append(section.Options, &Option{Key: key, Value: decodeValue(strings.TrimSpace(value))})
+	}
+	return c, scanner.Err()
+}
+
+func (c *Config) Encode(w io.Writer) error {
+	for _, section := range c.sections {
+		header := fmt.Sprintf("[%s]\n", section.Name)
+		if section.Subsection != "" {
+			header = fmt.Sprintf("[%s \"%s\"]\n", section.Name, section.Subsection)
+		}
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		for _, option := range section.Options {
+			if _, err := fmt.Fprintf(w, "\t%s = %s\n", option.Key, encodeValue(option.Value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get returns the last value of the key, as git does when a key is repeated
+func (c *Config) Get(section string, subsection string, key string) (string, bool) {
+	values := c.GetAll(section, subsection, key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value of a multi-valued key in the order they appear
+func (c *Config) GetAll(section string, subsection string, key string) []string {
+	values := []string{}
+	for _, s := range c.sections {
+		if !strings.EqualFold(s.Name, section) || s.Subsection != subsection {
+			continue
+		}
+		for _, option := range s.Options {
+			if strings.EqualFold(option.Key, key) {
+				values = append(values, option.Value)
+			}
+		}
+	}
+	return values
+}
+
+// GetForURL returns the last value of the key among the section and the subsections
+// matching rawUrl, as for http.<url>.sslVerify
+func (c *Config) GetForURL(section string, rawUrl string, key string) (string, bool) {
+	values := c.GetAllForURL(section, rawUrl, key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAllForURL returns every value of the key in the section and in the subsections whose
+// name is a url matching rawUrl, such as http.https://example.com/org.extraHeader. A url
+// matches when the scheme, host and user are the same and its path is a prefix of the
+// path of rawUrl on a path component boundary. The host may contain * wildcards.
+func (c *Config) GetAllForURL(section string, rawUrl string, key string) []string {
+	target, err := url.Parse(rawUrl)
+	if err != nil {
+		target = &url.URL{}
+	}
+	values := []string{}
+	for _, s := range c.sections {
+		if !strings.EqualFold(s.Name, section) {
+			continue
+		}
+		if s.Subsection != "" && !urlMatches(s.Subsection, target) {
+			continue
+		}
+		for _, option := range s.Options {
+			if strings.EqualFold(option.Key, key) {
+				values = append(values, option.Value)
+			}
+		}
+	}
+	return values
+}
+
+func urlMatches(pattern string, target *url.URL) bool {
+	u, err := url.Parse(pattern)
+	if err != nil || u.Scheme == "" || !strings.EqualFold(u.Scheme, target.Scheme) {
+		return false
+	}
+	if ok, _ := path.Match(strings.ToLower(u.Host), strings.ToLower(target.Host)); !ok {
+		return false
+	}
+	if u.User != nil && u.User.Username() != target.User.Username() {
+		return false
+	}
+	prefix := strings.TrimSuffix(u.Path, "/")
+	return prefix == "" || target.Path == prefix || strings.HasPrefix(target.Path, prefix+"/")
+}
+
+// GetBool parses the value of the key as a git boolean, returning def if the key is not set
+func (c *Config) GetBool(section string, subsection string, key string, def bool) (bool, error) {
+	value, ok := c.Get(section, subsection, key)
+	if !ok {
+		return def, nil
+	}
+	b, err := ParseBool(value)
+	if err != nil {
+		return def, fmt.Errorf("%w for %s.%s", err, section, key)
+	}
+	return b, nil
+}
+
+// ParseBool parses a git boolean such as true, yes, on or 1
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("%w: bad boolean value %q", ErrInvalidConfig, value)
+}
+
+// GetInt parses the value of the key as an integer with an optional k, m or g unit suffix
+func (c *Config) GetInt(section string, subsection string, key string, def int64) (int64, error) {
+	value, ok := c.Get(section, subsection, key)
+	if !ok {
+		return def, nil
+	}
+	return ParseInt(value)
+}
+
+// Set replaces every value of the key with value
+func (c *Config) Set(section string, subsection string, key string, value string) {
+	s := c.section(section, subsection, true)
+	options := s.Options[:0]
+	for _, option := range s.Options {
+		if !strings.EqualFold(option.Key, key) {
+			options = append(options, option)
+		}
+	}
+	s.Options = append(options, &Option{Key: key, Value: value})
+}
+
+// Add appends a value to a multi-valued key
+func (c *Config) Add(section string, subsection string, key string, value string) {
+	s := c.section(section, subsection, true)
+	s.Options = append(s.Options, &Option{Key: key, Value: value})
+}
+
+// Unset removes every value of the key
+func (c *Config) Unset(section string, subsection string, key string) {
+	s := c.section(section, subsection, false)
+	if s == nil {
+		return
+	}
+	options := s.Options[:0]
+	for _, option := range s.Options {
+		if !strings.EqualFold(option.Key, key) {
+			options = append(options, option)
+		}
+	}
+	s.Options = options
+}
+
+// Subsections returns the names of the subsections of a section, such as the names of all remotes
+func (c *Config) Subsections(section string) []string {
+	names := []string{}
+	for _, s := range c.sections {
+		if strings.EqualFold(s.Name, section) && s.Subsection != "" {
+			names = append(names, s.Subsection)
+		}
+	}
+	return names
+}
+
+func (c *Config) section(name string, subsection string, create bool) *Section {
+	for _, s := range c.sections {
+		if strings.EqualFold(s.Name, name) && s.Subsection == subsection {
+			return s
+		}
+	}
+	if !create {
+		return nil
+	}
+	s := &Section{Name: strings.ToLower(name), Subsection: subsection}
+	c.sections = append(c.sections, s)
+	return s
+}
+
+// ParseInt parses an integer with an optional k, m or g unit suffix
+func ParseInt(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	multiplier := int64(1)
+	if len(value) > 0 {
+		switch value[len(value)-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier != 1 {
+			value = value[:len(value)-1]
+		}
+	}
+	i, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad numeric value %q", ErrInvalidConfig, value)
+	}
+	return i * multiplier, nil
+}
+
+// decodeValue removes quotes and trailing comments and resolves escape sequences
+func decodeValue(value string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		ch := value[i]
+		switch {
+		case ch == '"':
+			quoted = !quoted
+		case ch == '\\' && i+1 < len(value):
+			i++
+			switch value[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			default:
+				b.WriteByte(value[i])
+			}
+		case (ch == '#' || ch == ';') && !quoted:
+			return strings.TrimSpace(b.String())
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String()
+}
+
+func encodeValue(value string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value)
+	if escaped != value || strings.ContainsAny(value, "#;") || strings.TrimSpace(value) != value {
+		return `"` + escaped + `"`
+	}
+	return value
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	input := `# comment
+[core]
+	bare = false
+[remote "origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/* ; trailing comment
+[http]
+	extraHeader = "X-Quoted: a;b"
+	postBuffer = 1m
+`
+	c, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testCase := range []struct {
+		section, subsection, key, expected string
+	}{
+		{"core", "", "bare", "false"},
+		{"remote", "origin", "URL", "https://example.com/repo.git"},
+		{"remote", "origin", "fetch", "+refs/tags/*:refs/tags/*"},
+		{"http", "", "extraheader", "X-Quoted: a;b"},
+	} {
+		actual, ok := c.Get(testCase.section, testCase.subsection, testCase.key)
+		if !ok || actual != testCase.expected {
+			t.Fatalf("expected(%s.%s): %q, actual: %q", testCase.section, testCase.key, testCase.expected, actual)
+		}
+	}
+	if fetch := c.GetAll("remote", "origin", "fetch"); len(fetch) != 2 {
+		t.Fatalf("expected 2 fetch refspecs but got %d", len(fetch))
+	}
+	if postBuffer, err := c.GetInt("http", "", "postbuffer", 0); err != nil || postBuffer != 1<<20 {
+		t.Fatalf("expected postBuffer to be %d but got %d (%v)", 1<<20, postBuffer, err)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	c := New()
+	c.Set("remote", "origin", "url", "/srv/repo.git")
+	c.Set("user", "", "name", "A \"quoted\" name")
+	var buffer bytes.Buffer
+	if err := c.Encode(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := decoded.Get("user", "", "name"); name != "A \"quoted\" name" {
+		t.Fatalf("unexpected name after round trip: %q", name)
+	}
+	if url, _ := decoded.Get("remote", "origin", "url"); url != "/srv/repo.git" {
+		t.Fatalf("unexpected url after round trip: %q", url)
+	}
+}
+
+func TestGetAllForURL(t *testing.T) {
+	input := `[http]
+	extraHeader = X-A: 1
+[http "https://example.com"]
+	extraHeader = X-B: 2
+[http "https://example.com/org"]
+	extraHeader = X-C: 3
+[http "https://*.example.org"]
+	extraHeader = X-D: 4
+[http "https://bob@example.com"]
+	extraHeader = X-E: 5
+`
+	c, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testCase := range []struct {
+		url      string
+		expected []string
+	}{
+		{"https://example.com/org/repo.git", []string{"X-A: 1", "X-B: 2", "X-C: 3"}},
+		{"https://example.com/organization/repo.git", []string{"X-A: 1", "X-B: 2"}},
+		{"https://bob@example.com/repo.git", []string{"X-A: 1", "X-B: 2", "X-E: 5"}},
+		{"https://git.example.org/repo.git", []string{"X-A: 1", "X-D: 4"}},
+		{"http://example.com/org/repo.git", []string{"X-A: 1"}},
+	} {
+		actual := c.GetAllForURL("http", testCase.url, "extraheader")
+		if strings.Join(actual, ",") != strings.Join(testCase.expected, ",") {
+			t.Fatalf("expected(%s): %q, actual: %q", testCase.url, testCase.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// DEFAULT_CACHE_TIMEOUT is the number of seconds a credential is cached, as in git
+	DEFAULT_CACHE_TIMEOUT = 900
+	// CACHE_DAEMON_COMMAND is the mygit command that runs the cache daemon
+	CACHE_DAEMON_COMMAND = "credential-cache--daemon"
+)
+
+// CacheHelper keeps credentials in the memory of a daemon listening on a unix socket, like
+// git credential-cache. The daemon is started on the first store and exits once its cache
+// is empty. It speaks the same protocol as the git daemon, so either can serve the other.
+type CacheHelper struct {
+	socket  string
+	timeout time.Duration
+}
+
+// NewCacheHelper returns a helper talking to the daemon at socket, or at the default
+// socket when empty, that keeps credentials for timeout
+func NewCacheHelper(socket string, timeout time.Duration) *CacheHelper {
+	if socket == "" {
+		socket = DefaultCacheSocket()
+	}
+	return &CacheHelper{socket: socket, timeout: timeout}
+}
+
+// DefaultCacheSocket returns ~/.git-credential-cache/socket when that directory exists and
+// $XDG_CACHE_HOME/git/credential/socket otherwise
+func DefaultCacheSocket() string {
+	home, _ := os.UserHomeDir()
+	legacy := filepath.Join(home, ".git-credential-cache")
+	if info, err := os.Stat(legacy); err == nil && info.IsDir() {
+		return filepath.Join(legacy, "socket")
+	}
+	if xdg := os.Getenv("XDG_CACHE_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "credential", "socket")
+	}
+	return filepath.Join(home, ".cache", "git", "credential", "socket")
+}
+
+// Get returns the cached credential matching c. Nothing is cached when the daemon is not running.
+func (h *CacheHelper) Get(c *Credential) (*Credential, error) {
+	reply, err := h.request("get", c)
+	if err != nil {
+		if isNotRunning(err) {
+			return &Credential{}, nil
+		}
+		return nil, err
+	}
+	return Decode(bytes.NewReader(reply))
+}
+
+// Store caches c, starting the daemon if needed
+func (h *CacheHelper) Store(c *Credential) error {
+	_, err := h.request("store", c)
+	if err == nil || !isNotRunning(err) {
+		return err
+	}
+	if err := h.spawn(); err != nil {
+		return err
+	}
+	_, err = h.request("store", c)
+	return err
+}
+
+// Erase removes the cached credentials matching c
+func (h *CacheHelper) Erase(c *Credential) error {
+	if _, err := h.request("erase", c); err != nil && !isNotRunning(err) {
+		return err
+	}
+	return nil
+}
+
+// Exit asks the daemon to forget every credential and exit
+func (h *CacheHelper) Exit() error {
+	if _, err := h.request("exit", &Credential{}); err != nil && !isNotRunning(err) {
+		return err
+	}
+	return nil
+}
+
+func (h *CacheHelper) request(action string, c *Credential) ([]byte, error) {
+	conn, err := net.Dial("unix", h.socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var req bytes.Buffer
+	fmt.Fprintf(&req, "action=%s\ntimeout=%d\n", action, int(h.timeout.Seconds()))
+	if err := c.Encode(&req); err != nil {
+		return nil, err
+	}
+	req.WriteString("\n")
+	if _, err := conn.Write(req.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := conn.(*net.UnixConn).CloseWrite(); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(conn)
+}
+
+// spawn starts the daemon in its own session and waits until it listens. The daemon gets
+// no stderr so that it does not keep the output of the spawning command open.
+func (h *CacheHelper) spawn() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, CACHE_DAEMON_COMMAND, h.socket)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil || line != "ok\n" {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return errors.New("credential-cache daemon did not start")
+	}
+	return cmd.Process.Release()
+}
+
+func isNotRunning(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ENOENT)
+}
+
+// cacheEntry is a credential along with the time it expires
+type cacheEntry struct {
+	credential *Credential
+	expiry     time.Time
+}
+
+// ServeCache runs the cache daemon on socket. It prints ok to ready once it listens and
+// returns when it is asked to exit or, after its first request, when its cache is empty.
+func ServeCache(socket string, ready io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	// A socket left behind by a daemon that died is replaced
+	os.Remove(socket)
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	if err := os.Chmod(socket, 0600); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(ready, "ok\n"); err != nil {
+		return err
+	}
+
+	entries := []*cacheEntry{}
+	served := false
+	for {
+		now := time.Now()
+		kept := entries[:0]
+		next := time.Time{}
+		for _, entry := range entries {
+			if entry.expiry.After(now) {
+				kept = append(kept, entry)
+				if next.IsZero() || entry.expiry.Before(next) {
+					next = entry.expiry
+				}
+			}
+		}
+		if served && len(kept) == 0 {
+			return nil
+		}
+		entries = kept
+		listener.SetDeadline(next)
+
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			return err
+		}
+		var exit bool
+		entries, exit = serveCacheRequest(conn, entries)
+		served = true
+		if exit {
+			return nil
+		}
+	}
+}
+
+func serveCacheRequest(conn net.Conn, entries []*cacheEntry) ([]*cacheEntry, bool) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Minute))
+	r := bufio.NewReader(conn)
+	action, timeout := "", DEFAULT_CACHE_TIMEOUT
+	for i := 0; i < 2; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return entries, false
+		}
+		key, value, _ := strings.Cut(strings.TrimSuffix(line, "\n"), "=")
+		switch key {
+		case "action":
+			action = value
+		case "timeout":
+			timeout, _ = strconv.Atoi(value)
+		}
+	}
+	c, err := Decode(r)
+	if err != nil {
+		return entries, false
+	}
+
+	switch action {
+	case "get":
+		for _, entry := range entries {
+			if entry.credential.Matches(c) {
+				reply := &Credential{Username: entry.credential.Username, Password: entry.credential.Password}
+				reply.Encode(conn)
+				break
+			}
+		}
+	case "store":
+		if c.IsComplete() {
+			entries = eraseEntries(entries, c)
+			entries = append(entries, &cacheEntry{
+				credential: c,
+				expiry:     time.Now().Add(time.Duration(timeout) * time.Second),
+			})
+		}
+	case "erase":
+		entries = eraseEntries(entries, c)
+	case "exit":
+		return nil, true
+	}
+	return entries, false
+}
+
+func eraseEntries(entries []*cacheEntry, c *Credential) []*cacheEntry {
+	pattern := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+	kept := []*cacheEntry{}
+	for _, entry := range entries {
+		if !entry.credential.Matches(pattern) {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
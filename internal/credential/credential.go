@@ -0,0 +1,156 @@
+package credential
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+var (
+	ErrInvalidCredential = errors.New("invalid credential")
+)
+
+// Credential holds the attributes exchanged with credential helpers, as described in
+// git-credential(1). Only Password is secret, String never includes it.
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+	// Quit is set by a helper to stop looking for credentials altogether
+	Quit bool
+}
+
+// FromURL returns the credential for u, taking the username and password from its userinfo
+func FromURL(u *url.URL) *Credential {
+	c := &Credential{
+		Protocol: u.Scheme,
+		Host:     u.Host,
+		Path:     strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+	}
+	return c
+}
+
+// Decode reads attribute lines of the form key=value up to an empty line or the end of r
+func Decode(r io.Reader) (*Credential, error) {
+	c := &Credential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("%w: expected key=value but got %q", ErrInvalidCredential, key)
+		}
+		switch key {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrInvalidCredential, err)
+			}
+			*c = *FromURL(u)
+		case "quit":
+			c.Quit = value == "1" || value == "true"
+		}
+	}
+	return c, scanner.Err()
+}
+
+// Encode writes the attributes that are set, one key=value line each
+func (c *Credential) Encode(w io.Writer) error {
+	for _, attribute := range []struct{ key, value string }{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	} {
+		if attribute.value == "" {
+			continue
+		}
+		if strings.ContainsAny(attribute.value, "\n\x00") {
+			return fmt.Errorf("%w: %s contains a newline", ErrInvalidCredential, attribute.key)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", attribute.key, attribute.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URL returns the url the credential is for, including its username and password
+func (c *Credential) URL() *url.URL {
+	u := &url.URL{Scheme: c.Protocol, Host: c.Host}
+	if c.Path != "" {
+		u.Path = "/" + c.Path
+	}
+	if c.Username != "" && c.Password != "" {
+		u.User = url.UserPassword(c.Username, c.Password)
+	} else if c.Username != "" {
+		u.User = url.User(c.Username)
+	}
+	return u
+}
+
+// String returns the url the credential is for with the username but never the password
+func (c *Credential) String() string {
+	u := c.URL()
+	if c.Username != "" {
+		u.User = url.User(c.Username)
+	}
+	return u.String()
+}
+
+// IsComplete reports whether both the username and the password are known
+func (c *Credential) IsComplete() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// Matches reports whether c can be used for pattern: each attribute set in pattern,
+// other than the password, has the same value in c
+func (c *Credential) Matches(pattern *Credential) bool {
+	return (pattern.Protocol == "" || pattern.Protocol == c.Protocol) &&
+		(pattern.Host == "" || pattern.Host == c.Host) &&
+		(pattern.Path == "" || pattern.Path == c.Path) &&
+		(pattern.Username == "" || pattern.Username == c.Username)
+}
+
+// merge overwrites the attributes of c with those set in reply
+func (c *Credential) merge(reply *Credential) {
+	if reply.Protocol != "" {
+		c.Protocol = reply.Protocol
+	}
+	if reply.Host != "" {
+		c.Host = reply.Host
+	}
+	if reply.Path != "" {
+		c.Path = reply.Path
+	}
+	if reply.Username != "" {
+		c.Username = reply.Username
+	}
+	if reply.Password != "" {
+		c.Password = reply.Password
+	}
+	c.Quit = c.Quit || reply.Quit
+}
@@ -0,0 +1,150 @@
+package credential
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+)
+
+func TestDecodeEncode(t *testing.T) {
+	input := "protocol=https\nhost=example.com\npath=org/repo.git\nusername=bob\npassword=secret\n\nignored=1\n"
+	c, err := Decode(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var output bytes.Buffer
+	if err := c.Encode(&output); err != nil {
+		t.Fatal(err)
+	}
+	if expected, _, _ := strings.Cut(input, "\n\n"); output.String() != expected+"\n" {
+		t.Fatalf("expected: %q\tactual: %q", expected+"\n", output.String())
+	}
+	if c.String() != "https://bob@example.com/org/repo.git" {
+		t.Fatalf("expected the password to be left out but got %s", c)
+	}
+
+	c, err = Decode(strings.NewReader("url=https://alice@example.com:8443/repo\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Protocol != "https" || c.Host != "example.com:8443" || c.Path != "repo" || c.Username != "alice" {
+		t.Fatalf("expected the url to be split into attributes but got %+v", c)
+	}
+}
+
+func TestStoreHelper(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials")
+	helper := NewStoreHelper(file)
+	stored := &Credential{Protocol: "https", Host: "example.com", Username: "bob", Password: "s3cr:t/"}
+	if err := helper.Store(stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := helper.Store(&Credential{Protocol: "https", Host: "example.org", Username: "eve", Password: "other"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(file); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the file to only be readable by its owner but got %v (%v)", info.Mode(), err)
+	}
+
+	reply, err := helper.Get(&Credential{Protocol: "https", Host: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Username != stored.Username || reply.Password != stored.Password {
+		t.Fatalf("expected: %s:%s\tactual: %s:%s", stored.Username, stored.Password, reply.Username, reply.Password)
+	}
+
+	if err := helper.Erase(&Credential{Protocol: "https", Host: "example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := helper.Get(&Credential{Protocol: "https", Host: "example.com"}); err != nil || reply.Password != "" {
+		t.Fatalf("expected the credential to be erased but got %s (%v)", reply, err)
+	}
+	if reply, err := helper.Get(&Credential{Protocol: "https", Host: "example.org"}); err != nil || reply.Password != "other" {
+		t.Fatalf("expected the credential of another host to be kept but got %s (%v)", reply, err)
+	}
+}
+
+func TestCacheHelper(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "cache", "socket")
+	ready, started := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeCache(socket, started)
+	}()
+	if _, err := io.ReadFull(ready, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	helper := NewCacheHelper(socket, time.Minute)
+	stored := &Credential{Protocol: "https", Host: "example.com", Username: "bob", Password: "secret"}
+	if err := helper.Store(stored); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := helper.Get(&Credential{Protocol: "https", Host: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Username != stored.Username || reply.Password != stored.Password {
+		t.Fatalf("expected: %s:%s\tactual: %s:%s", stored.Username, stored.Password, reply.Username, reply.Password)
+	}
+	if err := helper.Exit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// Without a daemon nothing is cached
+	if reply, err := helper.Get(stored); err != nil || reply.Password != "" {
+		t.Fatalf("expected nothing to be cached but got %s (%v)", reply, err)
+	}
+}
+
+func TestManagerFill(t *testing.T) {
+	t.Setenv("GIT_ASKPASS", "")
+	t.Setenv("SSH_ASKPASS", "")
+	file := filepath.Join(t.TempDir(), "credentials")
+	cfg := config.New()
+	cfg.Add("credential", "", "helper", "!f() { test $1 != get || echo username=helper; }; f")
+	cfg.Add("credential", "", "helper", "")
+	cfg.Add("credential", "", "helper", "store --file "+file)
+	cfg.Add("credential", "https://example.com", "helper", "!f() { test $1 != get || echo password=from-helper; }; f")
+	manager, err := NewManager(cfg, "https://example.com/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &Credential{Protocol: "https", Host: "example.com", Path: "repo.git", Username: "bob"}
+	if err := manager.Fill(c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Username != "bob" || c.Password != "from-helper" || c.Path != "" {
+		t.Fatalf("expected the password of the url helper but got %+v", c)
+	}
+	if err := manager.Approve(c); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := NewStoreHelper(file).Get(&Credential{Protocol: "https", Host: "example.com"}); err != nil || stored.Password != "from-helper" {
+		t.Fatalf("expected the approved credential to be stored but got %s (%v)", stored, err)
+	}
+	if err := manager.Reject(c); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := NewStoreHelper(file).Get(&Credential{Protocol: "https", Host: "example.com"}); err != nil || stored.Password != "" {
+		t.Fatalf("expected the rejected credential to be erased but got %s (%v)", stored, err)
+	}
+
+	manager, err = NewManager(config.New(), "https://example.com/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.Fill(&Credential{Protocol: "https", Host: "example.com"}); err == nil {
+		t.Fatalf("expected an error without helpers")
+	}
+}
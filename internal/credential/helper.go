@@ -0,0 +1,231 @@
+package credential
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+)
+
+var (
+	ErrNoCredential = errors.New("could not read credential")
+	ErrQuit         = errors.New("credential helper asked to stop")
+)
+
+// Helper stores and retrieves credentials, as the programs named by credential.helper do
+type Helper interface {
+	// Get returns the attributes the helper knows for c, which may be none
+	Get(c *Credential) (*Credential, error)
+	// Store records a credential that was accepted by the server
+	Store(c *Credential) error
+	// Erase forgets a credential that was rejected by the server
+	Erase(c *Credential) error
+}
+
+// Manager asks the configured helpers for credentials and tells them about the outcome
+type Manager struct {
+	helpers     []Helper
+	useHttpPath bool
+	askPass     string
+}
+
+// NewManager returns the manager for the credential.helper, credential.useHttpPath and
+// core.askPass settings of cfg that apply to rawUrl
+func NewManager(cfg *config.Config, rawUrl string) (*Manager, error) {
+	manager := &Manager{}
+	for _, spec := range cfg.GetAllForURL("credential", rawUrl, "helper") {
+		// An empty helper clears the helpers configured so far
+		if spec == "" {
+			manager.helpers = nil
+			continue
+		}
+		helper, err := ParseHelper(spec)
+		if err != nil {
+			return nil, err
+		}
+		manager.helpers = append(manager.helpers, helper)
+	}
+	if value, ok := cfg.GetForURL("credential", rawUrl, "useHttpPath"); ok {
+		useHttpPath, err := config.ParseBool(value)
+		if err != nil {
+			return nil, err
+		}
+		manager.useHttpPath = useHttpPath
+	}
+	manager.askPass = os.Getenv("GIT_ASKPASS")
+	if manager.askPass == "" {
+		manager.askPass, _ = cfg.Get("core", "", "askPass")
+	}
+	if manager.askPass == "" {
+		manager.askPass = os.Getenv("SSH_ASKPASS")
+	}
+	return manager, nil
+}
+
+// ParseHelper returns the helper described by a credential.helper value. The built-in store
+// and cache helpers run in process, a value starting with ! is a shell snippet, an absolute
+// path is run as is and any other name is run as git credential-<name>.
+func ParseHelper(spec string) (Helper, error) {
+	name, args, _ := strings.Cut(spec, " ")
+	switch {
+	case name == "store":
+		return parseStoreHelper(strings.Fields(args))
+	case name == "cache":
+		return parseCacheHelper(strings.Fields(args))
+	case strings.HasPrefix(spec, "!"):
+		return &externalHelper{command: spec[1:]}, nil
+	case filepath.IsAbs(name):
+		return &externalHelper{command: spec}, nil
+	default:
+		return &externalHelper{command: "git credential-" + spec}, nil
+	}
+}
+
+func parseStoreHelper(args []string) (Helper, error) {
+	flagSet := flag.NewFlagSet("credential-store", flag.ContinueOnError)
+	file := flagSet.String("file", "", "file holding the credentials")
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+	return NewStoreHelper(*file), nil
+}
+
+func parseCacheHelper(args []string) (Helper, error) {
+	flagSet := flag.NewFlagSet("credential-cache", flag.ContinueOnError)
+	timeout := flagSet.Int("timeout", DEFAULT_CACHE_TIMEOUT, "seconds a credential is kept")
+	socket := flagSet.String("socket", "", "socket of the cache daemon")
+	if err := flagSet.Parse(args); err != nil {
+		return nil, err
+	}
+	return NewCacheHelper(*socket, time.Duration(*timeout)*time.Second), nil
+}
+
+// Fill completes c with the username and password from the helpers, falling back to asking
+// through the askpass program. The helpers are tried in order until one supplies both.
+func (m *Manager) Fill(c *Credential) error {
+	m.normalize(c)
+	for _, helper := range m.helpers {
+		if c.IsComplete() {
+			break
+		}
+		reply, err := helper.Get(c)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: credential helper failed: %v\n", err)
+			continue
+		}
+		c.merge(reply)
+		if c.Quit {
+			return fmt.Errorf("%w for '%s'", ErrQuit, c)
+		}
+	}
+	if c.IsComplete() {
+		return nil
+	}
+	if m.askPass == "" {
+		return fmt.Errorf("%w for '%s': no credential helper or askpass program is configured", ErrNoCredential, c)
+	}
+	var err error
+	if c.Username == "" {
+		if c.Username, err = m.ask(fmt.Sprintf("Username for '%s': ", c)); err != nil {
+			return err
+		}
+	}
+	if c.Password, err = m.ask(fmt.Sprintf("Password for '%s': ", c)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Approve tells every helper that c was accepted so that they can store it. Like git, a
+// failing helper is reported without failing the operation.
+func (m *Manager) Approve(c *Credential) error {
+	m.normalize(c)
+	if !c.IsComplete() {
+		return nil
+	}
+	for _, helper := range m.helpers {
+		if err := helper.Store(c); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: credential helper failed: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Reject tells every helper that c was refused so that they can forget it
+func (m *Manager) Reject(c *Credential) error {
+	m.normalize(c)
+	for _, helper := range m.helpers {
+		if err := helper.Erase(c); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: credential helper failed: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// normalize drops the path of http urls unless credential.useHttpPath is set, so that a
+// single credential is used for every repository of a host
+func (m *Manager) normalize(c *Credential) {
+	if !m.useHttpPath && (c.Protocol == "http" || c.Protocol == "https") {
+		c.Path = ""
+	}
+}
+
+// ask runs the askpass program with prompt and returns the first line it prints
+func (m *Manager) ask(prompt string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(m.askPass, prompt)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%w: askpass failed: %w", ErrNoCredential, err)
+	}
+	answer, _, _ := strings.Cut(stdout.String(), "\n")
+	return strings.TrimSuffix(answer, "\r"), nil
+}
+
+// externalHelper runs a helper program with the action as its last argument, passing
+// the credential on its stdin and reading the reply from its stdout
+type externalHelper struct {
+	command string
+}
+
+func (h *externalHelper) Get(c *Credential) (*Credential, error) {
+	var stdout bytes.Buffer
+	if err := h.run("get", c, &stdout); err != nil {
+		return nil, err
+	}
+	return Decode(&stdout)
+}
+
+func (h *externalHelper) Store(c *Credential) error {
+	return h.run("store", c, nil)
+}
+
+func (h *externalHelper) Erase(c *Credential) error {
+	return h.run("erase", c, nil)
+}
+
+func (h *externalHelper) run(action string, c *Credential, stdout *bytes.Buffer) error {
+	var stdin bytes.Buffer
+	if err := c.Encode(&stdin); err != nil {
+		return err
+	}
+	cmd := exec.Command("sh", "-c", h.command+" "+action)
+	cmd.Stdin = &stdin
+	cmd.Stderr = os.Stderr
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	if err := cmd.Run(); err != nil {
+		// The command itself is reported but never the credential it was given
+		return fmt.Errorf("%s %s: %w", h.command, action, err)
+	}
+	return nil
+}
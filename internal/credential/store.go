@@ -0,0 +1,148 @@
+package credential
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StoreHelper keeps credentials unencrypted in a file, one url with the username and
+// password as userinfo per line, like git credential-store
+type StoreHelper struct {
+	// files are searched in order, credentials are stored in the first one
+	files []string
+}
+
+// NewStoreHelper returns a helper using file or, if file is empty, ~/.git-credentials
+// along with $XDG_CONFIG_HOME/git/credentials
+func NewStoreHelper(file string) *StoreHelper {
+	if file != "" {
+		return &StoreHelper{files: []string{file}}
+	}
+	return &StoreHelper{files: defaultStoreFiles()}
+}
+
+func defaultStoreFiles() []string {
+	files := []string{}
+	home, _ := os.UserHomeDir()
+	if home != "" {
+		files = append(files, filepath.Join(home, ".git-credentials"))
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		files = append(files, filepath.Join(xdg, "git", "credentials"))
+	} else if home != "" {
+		files = append(files, filepath.Join(home, ".config", "git", "credentials"))
+	}
+	return files
+}
+
+// Get returns the first stored credential matching c
+func (h *StoreHelper) Get(c *Credential) (*Credential, error) {
+	for _, file := range h.files {
+		stored, err := readStoreFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, candidate := range stored {
+			if candidate.Matches(c) {
+				return candidate, nil
+			}
+		}
+	}
+	return &Credential{}, nil
+}
+
+// Store records c in the first file, replacing the credential stored for the same url
+func (h *StoreHelper) Store(c *Credential) error {
+	if len(h.files) == 0 || !c.IsComplete() {
+		return nil
+	}
+	file := h.files[0]
+	// Like git, a credential goes to the XDG file when only that one exists
+	if len(h.files) > 1 {
+		if _, err := os.Stat(file); os.IsNotExist(err) {
+			if _, err := os.Stat(h.files[1]); err == nil {
+				file = h.files[1]
+			}
+		}
+	}
+	return rewriteStoreFile(file, c, true)
+}
+
+// Erase removes the credentials matching c from every file
+func (h *StoreHelper) Erase(c *Credential) error {
+	for _, file := range h.files {
+		if err := rewriteStoreFile(file, c, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewriteStoreFile drops the credentials of file matching c, with the password ignored,
+// and when store is set puts c first
+func rewriteStoreFile(file string, c *Credential, store bool) error {
+	stored, err := readStoreFile(file)
+	if err != nil {
+		return err
+	}
+	pattern := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+	kept := []*Credential{}
+	if store {
+		kept = append(kept, c)
+	}
+	for _, candidate := range stored {
+		if !candidate.Matches(pattern) || (store && candidate.Path != c.Path) {
+			kept = append(kept, candidate)
+		}
+	}
+	if !store && len(kept) == len(stored) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	lockPath := file + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lockPath)
+	w := bufio.NewWriter(lock)
+	for _, credential := range kept {
+		w.WriteString(credential.URL().String() + "\n")
+	}
+	if err := w.Flush(); err != nil {
+		lock.Close()
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+	return os.Rename(lockPath, file)
+}
+
+// readStoreFile returns the credentials of file, skipping lines that are not complete urls
+func readStoreFile(file string) ([]*Credential, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	credentials := []*Credential{}
+	for _, line := range strings.Split(string(content), "\n") {
+		u, err := url.Parse(strings.TrimSpace(line))
+		if err != nil || u.Scheme == "" || u.User == nil {
+			continue
+		}
+		if c := FromURL(u); c.IsComplete() {
+			credentials = append(credentials, c)
+		}
+	}
+	return credentials, nil
+}
@@ -14,6 +14,7 @@ const (
 	Regular    FileMode = 0100644
 	Executable FileMode = 0100755
 	Symlink    FileMode = 0120000
+	Submodule  FileMode = 0160000
 )
 
 var UnsupportedFileModeErr = errors.New("Unsupported file mode")
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -42,10 +41,31 @@ type Commit struct {
 	message   string
 }
 
+// Date returns the time the actor acted
+func (a *Actor) Date() time.Time {
+	return a.date
+}
+
+func (c *Commit) Author() Actor {
+	return c.author
+}
+
+func (c *Commit) Committer() Actor {
+	return c.committer
+}
+
 func (c *Commit) Tree() []byte {
 	return c.tree
 }
 
+func (c *Commit) Parents() [][]byte {
+	return c.parents
+}
+
+func (c *Commit) Message() string {
+	return c.message
+}
+
 func (c *Commit) SetAuthor(author Actor) {
 	c.author = author
 }
@@ -168,7 +188,7 @@ func DecodeCommit(encodedObject common.Object) (*Commit, error) {
 			}
 			commit.SetAuthor(*author)
 
-		case "commiter":
+		case "committer":
 
 			commiter, err := decodeActor(data)
 			if err != nil {
@@ -189,16 +209,20 @@ func DecodeCommit(encodedObject common.Object) (*Commit, error) {
 	return commit, nil
 }
 
+// decodeActor decodes an actor of the form "name <email> timestamp timezone"
 func decodeActor(b []byte) (*Actor, error) {
-	re := regexp.MustCompile(`([\w\s]+) <([\w\._%+-]+@[\w\.-]+\.[a-zA-Z]{2,})> (\d+) ([\+\-]\d+)`)
-
-	// Search for matches
-	matches := re.FindSubmatch(b)
-	if len(matches) != 5 {
+	emailStart := bytes.IndexByte(b, '<')
+	emailEnd := bytes.LastIndexByte(b, '>')
+	if emailStart < 0 || emailEnd < emailStart {
 		return nil, errors.New("invalid actor")
 	}
-
-	name, email, date, timezone := matches[1], matches[2], matches[3], matches[4]
+	name := bytes.TrimSpace(b[:emailStart])
+	email := b[emailStart+1 : emailEnd]
+	fields := bytes.Fields(b[emailEnd+1:])
+	if len(fields) != 2 {
+		return nil, errors.New("invalid actor")
+	}
+	date, timezone := fields[0], fields[1]
 	dateUnix, err := strconv.ParseInt(string(date), 10, 64)
 	if err != nil {
 		return nil, err
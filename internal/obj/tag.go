@@ -0,0 +1,80 @@
+package object
+
+import (
+	"bytes"
+	"errors"
+	"io"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/util"
+)
+
+// Tag represents an annotated tag object
+type Tag struct {
+	object     []byte
+	objectType common.ObjectType
+	name       string
+	message    string
+}
+
+// Object returns the checksum of the tagged object
+func (t *Tag) Object() []byte {
+	return t.object
+}
+
+// ObjectType returns the type of the tagged object
+func (t *Tag) ObjectType() common.ObjectType {
+	return t.objectType
+}
+
+func (t *Tag) Name() string {
+	return t.name
+}
+
+func (t *Tag) Type() common.ObjectType {
+	return common.OBJ_TAG
+}
+
+func DecodeTag(encodedObject common.Object) (*Tag, error) {
+	if encodedObject.Type() != common.OBJ_TAG {
+		return nil, errors.New("invalid object type")
+	}
+	tag := &Tag{}
+	for {
+		line, err := util.ReadBefore(encodedObject, []byte("\n"))
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(line) < 1 {
+			break
+		}
+
+		prefix, data, found := bytes.Cut(line, []byte(" "))
+		if !found {
+			return nil, errors.New("invalid line encoding")
+		}
+		switch string(prefix) {
+		case "object":
+			object, err := hash.ChecksumFromHex(string(data))
+			if err != nil {
+				return nil, err
+			}
+			tag.object = object
+		case "type":
+			tag.objectType = common.ParseObjectType(string(data))
+		case "tag":
+			tag.name = string(data)
+		}
+		if err == io.EOF {
+			return tag, nil
+		}
+	}
+
+	message, err := io.ReadAll(encodedObject)
+	if err != nil {
+		return nil, err
+	}
+	tag.message = string(message)
+	return tag, nil
+}
@@ -0,0 +1,769 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/filemode"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+)
+
+const (
+	BITMAP_VERSION = 1
+	// BITMAP_OPT_FULL_DAG is set for bitmaps holding every object reachable from their
+	// commits, which git requires
+	BITMAP_OPT_FULL_DAG = 0x1
+	// BITMAP_OPT_HASH_CACHE is set when the hash of the name of each object follows the
+	// bitmaps of the commits
+	BITMAP_OPT_HASH_CACHE = 0x4
+	BITMAP_HEADER_SIZE    = 12 + CHECKSUM_LEN
+	// BITMAP_ENTRY_HEADER_SIZE is the size of the header of the bitmap of a commit: the
+	// position of the commit in the index, the offset of the bitmap it is xored with and
+	// flags
+	BITMAP_ENTRY_HEADER_SIZE = 6
+	BITMAP_MAX_XOR_OFFSET    = 160
+	NAME_HASH_SIZE           = 4
+
+	// Below BITMAP_MIN_COMMITS commits, every commit gets a bitmap. Otherwise the
+	// BITMAP_MUST_REGION most recent commits do, then one commit in up to
+	// BITMAP_MIN_SPACING until BITMAP_MIN_REGION, then the spacing grows up to
+	// BITMAP_MAX_SPACING, as in git.
+	BITMAP_MIN_COMMITS  = 100
+	BITMAP_MUST_REGION  = 100
+	BITMAP_MIN_REGION   = 20000
+	BITMAP_MIN_SPACING  = 100
+	BITMAP_MAX_SPACING  = 5000
+	BITMAP_TYPES_COUNT  = 4
+	BITMAP_COMMITS_TYPE = 0
+)
+
+var (
+	// BITMAP_MAGIC starts the bitmap files written by git
+	BITMAP_MAGIC = []byte("BITM")
+	// BITMAP_TYPES are the types of the objects of the type bitmaps, in the order of the file
+	BITMAP_TYPES = [BITMAP_TYPES_COUNT]common.ObjectType{common.OBJ_COMMIT, common.OBJ_TREE, common.OBJ_BLOB, common.OBJ_TAG}
+
+	ErrInvalidBitmap = errors.New("invalid bitmap file")
+)
+
+// BitmapFile is a decoded bitmap file. The bits of the bitmaps stand for the objects of a
+// pack in the order of the pack, or for the objects of a multi-pack-index in the order of
+// its reverse index.
+type BitmapFile struct {
+	// Checksum is the checksum of the pack or of the multi-pack-index
+	Checksum []byte
+	// Types hold the objects of each type, in the order of BITMAP_TYPES
+	Types [BITMAP_TYPES_COUNT]*Bitmap
+	// Commits are the bitmaps of the objects reachable from the commits selected
+	Commits []BitmapCommit
+	// NameHashes are the hashes of the names of the objects by bit, nil when the file has
+	// none
+	NameHashes []uint32
+}
+
+// BitmapCommit is the bitmap of a commit selected for bitmaps
+type BitmapCommit struct {
+	// Index is the position of the commit among the objects sorted by id, as in the index
+	Index  int
+	Bitmap *Bitmap
+}
+
+// encodeBitmapFile writes the bitmaps as git does: a header, the type bitmaps, the bitmap
+// of each selected commit, the name hashes and the checksum of the file. Bitmaps are
+// written whole rather than xored with one another.
+func encodeBitmapFile(file *BitmapFile, w io.Writer) error {
+	hashWriter := hash.NewHashWriter(w, hash.SHA1)
+	bw := bufio.NewWriter(hashWriter)
+	options := uint16(BITMAP_OPT_FULL_DAG)
+	if file.NameHashes != nil {
+		options |= BITMAP_OPT_HASH_CACHE
+	}
+	bw.Write(BITMAP_MAGIC)
+	binary.Write(bw, binary.BigEndian, uint16(BITMAP_VERSION))
+	binary.Write(bw, binary.BigEndian, options)
+	binary.Write(bw, binary.BigEndian, uint32(len(file.Commits)))
+	bw.Write(file.Checksum)
+	for _, bitmap := range file.Types {
+		if err := encodeEWAH(bitmap, bw); err != nil {
+			return err
+		}
+	}
+	for _, commit := range file.Commits {
+		binary.Write(bw, binary.BigEndian, uint32(commit.Index))
+		// Neither xored nor flagged
+		bw.Write([]byte{0, 0})
+		if err := encodeEWAH(commit.Bitmap, bw); err != nil {
+			return err
+		}
+	}
+	if file.NameHashes != nil {
+		if err := binary.Write(bw, binary.BigEndian, file.NameHashes); err != nil {
+			return err
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(hashWriter.Sum(nil))
+	return err
+}
+
+// DecodeBitmapFile decodes the bitmaps of count objects, verifying the checksum of the
+// file. Bitmaps xored with a previous bitmap are resolved.
+func DecodeBitmapFile(data []byte, count int) (*BitmapFile, error) {
+	if len(data) < BITMAP_HEADER_SIZE+CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: too small", ErrInvalidBitmap)
+	}
+	trailer := len(data) - CHECKSUM_LEN
+	sum := hash.New(hash.SHA1)
+	sum.Write(data[:trailer])
+	if !bytes.Equal(sum.Sum(nil), data[trailer:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidBitmap)
+	}
+	if !bytes.HasPrefix(data, BITMAP_MAGIC) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidBitmap)
+	}
+	if version := binary.BigEndian.Uint16(data[4:]); version != BITMAP_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidBitmap, version)
+	}
+	options := binary.BigEndian.Uint16(data[6:])
+	if options&BITMAP_OPT_FULL_DAG == 0 {
+		return nil, fmt.Errorf("%w: not a full DAG", ErrInvalidBitmap)
+	}
+	file := &BitmapFile{
+		Checksum: data[12:BITMAP_HEADER_SIZE],
+		Commits:  make([]BitmapCommit, binary.BigEndian.Uint32(data[8:])),
+	}
+	data = data[BITMAP_HEADER_SIZE:trailer]
+	decode := func() (*Bitmap, error) {
+		bitmap, n, err := decodeEWAH(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidBitmap, err)
+		}
+		if bitmap.size() > count {
+			return nil, fmt.Errorf("%w: bits past the %d objects", ErrInvalidBitmap, count)
+		}
+		data = data[n:]
+		return bitmap, nil
+	}
+	for i := range file.Types {
+		bitmap, err := decode()
+		if err != nil {
+			return nil, err
+		}
+		file.Types[i] = bitmap
+	}
+	for i := range file.Commits {
+		if len(data) < BITMAP_ENTRY_HEADER_SIZE {
+			return nil, fmt.Errorf("%w: truncated commit bitmaps", ErrInvalidBitmap)
+		}
+		index, xorOffset := int(binary.BigEndian.Uint32(data)), int(data[4])
+		if index >= count {
+			return nil, fmt.Errorf("%w: commit %d of %d objects", ErrInvalidBitmap, index, count)
+		}
+		if xorOffset > i || xorOffset > BITMAP_MAX_XOR_OFFSET {
+			return nil, fmt.Errorf("%w: bad xor offset %d", ErrInvalidBitmap, xorOffset)
+		}
+		data = data[BITMAP_ENTRY_HEADER_SIZE:]
+		bitmap, err := decode()
+		if err != nil {
+			return nil, err
+		}
+		if xorOffset > 0 {
+			bitmap.Xor(file.Commits[i-xorOffset].Bitmap)
+		}
+		file.Commits[i] = BitmapCommit{Index: index, Bitmap: bitmap}
+	}
+	// A lookup table may follow the name hashes, it is not needed as every bitmap is read
+	if options&BITMAP_OPT_HASH_CACHE != 0 {
+		if len(data) < count*NAME_HASH_SIZE {
+			return nil, fmt.Errorf("%w: truncated name hashes", ErrInvalidBitmap)
+		}
+		file.NameHashes = make([]uint32, count)
+		for i := range file.NameHashes {
+			file.NameHashes[i] = binary.BigEndian.Uint32(data[i*NAME_HASH_SIZE:])
+		}
+	}
+	return file, nil
+}
+
+// bitmapWalker sets the bits of the objects reachable from commits, trees and blobs
+type bitmapWalker struct {
+	db ObjectSource
+	// positions are the bits of the objects
+	positions map[string]int
+	// commits are the known bitmaps of commits, which are not walked further
+	commits map[string]*Bitmap
+	// nameHashes, when not nil, receive the hash of the name each object is first found at
+	nameHashes []uint32
+	named      *Bitmap
+}
+
+// reach sets the bits of the objects reachable from id, stopping at the bits already set.
+// ok is false when one of the objects has no bit.
+func (w *bitmapWalker) reach(bitmap *Bitmap, id []byte) (ok bool, err error) {
+	trees := [][]byte{}
+	stack := [][]byte{id}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		position, ok := w.positions[string(cur)]
+		if !ok {
+			return false, nil
+		}
+		if bitmap.Has(position) {
+			continue
+		}
+		if known := w.commits[string(cur)]; known != nil {
+			bitmap.Or(known)
+			continue
+		}
+		// Commits of the commit-graph are walked without reading them
+		if graph, ok := w.db.(revlist.CommitGraph); ok {
+			commit, ok, err := graph.LookupCommit(cur)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				bitmap.Set(position)
+				trees = append(trees, commit.Tree)
+				stack = append(stack, commit.Parents...)
+				continue
+			}
+		}
+		encodedObject, err := w.db.Object(cur)
+		if err != nil {
+			return false, fmt.Errorf("failed to read %x: %w", cur, err)
+		}
+		switch encodedObject.Type() {
+		case common.OBJ_COMMIT:
+			bitmap.Set(position)
+			commit, err := object.DecodeCommit(encodedObject)
+			if err != nil {
+				return false, err
+			}
+			trees = append(trees, commit.Tree())
+			stack = append(stack, commit.Parents()...)
+		case common.OBJ_TAG:
+			bitmap.Set(position)
+			tag, err := object.DecodeTag(encodedObject)
+			if err != nil {
+				return false, err
+			}
+			stack = append(stack, tag.Object())
+		case common.OBJ_TREE:
+			trees = append(trees, cur)
+		default:
+			bitmap.Set(position)
+		}
+	}
+	for _, tree := range trees {
+		if ok, err := w.walkTree(bitmap, tree, ""); !ok || err != nil {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+// walkTree sets the bits of the tree found at name and of everything below it
+func (w *bitmapWalker) walkTree(bitmap *Bitmap, id []byte, name string) (bool, error) {
+	position, ok := w.positions[string(id)]
+	if !ok {
+		return false, nil
+	}
+	w.setName(position, name)
+	if bitmap.Has(position) {
+		return true, nil
+	}
+	bitmap.Set(position)
+	encodedObject, err := w.db.Object(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to read tree %x: %w", id, err)
+	}
+	tree, err := object.DecodeTree(encodedObject)
+	if err != nil {
+		return false, err
+	}
+	entryIter := tree.TreeIter()
+	for {
+		entry, more := entryIter.Next()
+		if !more {
+			return true, nil
+		}
+		// Submodule commits live in another repository
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+		if entry.Type() == common.OBJ_TREE {
+			if ok, err := w.walkTree(bitmap, entry.Checksum, path.Join(name, entry.Name)); !ok || err != nil {
+				return ok, err
+			}
+			continue
+		}
+		position, ok := w.positions[string(entry.Checksum)]
+		if !ok {
+			return false, nil
+		}
+		w.setName(position, path.Join(name, entry.Name))
+		bitmap.Set(position)
+	}
+}
+
+// setName records the hash of the name an object is first found at
+func (w *bitmapWalker) setName(position int, name string) {
+	if w.nameHashes == nil || w.named.Has(position) {
+		return
+	}
+	w.named.Set(position)
+	w.nameHashes[position] = nameHash(name)
+}
+
+// BitmapIndex answers walks from the bitmaps of a pack or of the multi-pack-index. The
+// objects missing from the bitmaps of commits are walked until commits with bitmaps are
+// reached.
+type BitmapIndex struct {
+	db      revlist.ObjectReader
+	file    *BitmapFile
+	walker  *bitmapWalker
+	objects [][]byte
+}
+
+// newBitmapIndex indexes the bitmaps of the objects given in the order of their bits
+func newBitmapIndex(db revlist.ObjectReader, file *BitmapFile, objects [][]byte) *BitmapIndex {
+	positions := make(map[string]int, len(objects))
+	for i, id := range objects {
+		positions[string(id)] = i
+	}
+	sorted := slices.Clone(objects)
+	slices.SortFunc(sorted, bytes.Compare)
+	commits := make(map[string]*Bitmap, len(file.Commits))
+	for _, commit := range file.Commits {
+		commits[string(sorted[commit.Index])] = commit.Bitmap
+	}
+	return &BitmapIndex{
+		db:      db,
+		file:    file,
+		walker:  &bitmapWalker{db: db, positions: positions, commits: commits},
+		objects: objects,
+	}
+}
+
+// Commits returns the number of commits that have a bitmap
+func (index *BitmapIndex) Commits() int {
+	return len(index.file.Commits)
+}
+
+// reach returns the bitmap of the objects reachable from ids. ok is false when some of
+// them are not covered by the bitmaps.
+func (index *BitmapIndex) reach(ids [][]byte) (*Bitmap, bool, error) {
+	bitmap := NewBitmap()
+	for _, id := range ids {
+		if ok, err := index.walker.reach(bitmap, id); !ok || err != nil {
+			return nil, ok, err
+		}
+	}
+	return bitmap, true, nil
+}
+
+// Reachable returns the objects reachable from wants but not from haves, commits and tags
+// first. haves that do not exist are ignored.
+func (index *BitmapIndex) Reachable(wants [][]byte, haves [][]byte) ([]revlist.Entry, bool, error) {
+	existing := [][]byte{}
+	for _, have := range haves {
+		ok, err := index.db.ObjectExist(have)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			existing = append(existing, have)
+		}
+	}
+	excluded, ok, err := index.reach(existing)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	bitmap, ok, err := index.reach(wants)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	bitmap.AndNot(excluded)
+
+	entries := make([]revlist.Entry, 0, bitmap.Count())
+	for _, types := range [][]int{{0, 3}, {1, 2}} {
+		for _, t := range types {
+			selected := bitmap.Clone()
+			selected.And(index.file.Types[t])
+			for _, position := range selected.Positions() {
+				entry := revlist.Entry{Checksum: index.objects[position], Type: BITMAP_TYPES[t]}
+				if index.file.NameHashes != nil {
+					entry.NameHash = index.file.NameHashes[position]
+				}
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries, true, nil
+}
+
+// Unreachable returns the ids that are not reachable from tips. ok is false when some of
+// tips are not covered by the bitmaps.
+func (index *BitmapIndex) Unreachable(tips [][]byte, ids [][]byte) ([][]byte, bool, error) {
+	bitmap, ok, err := index.reach(tips)
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	unreachable := [][]byte{}
+	for _, id := range ids {
+		// The objects reachable from covered tips all have a bit
+		if position, ok := index.walker.positions[string(id)]; !ok || !bitmap.Has(position) {
+			unreachable = append(unreachable, id)
+		}
+	}
+	return unreachable, true, nil
+}
+
+// bitmapCommit is a commit that may be selected for a bitmap
+type bitmapCommit struct {
+	id   []byte
+	date int64
+	// tip is set for the commits the refs point to, which are preferred
+	tip   bool
+	merge bool
+}
+
+// selectBitmapCommits picks the commits that get a bitmap, following the heuristics of
+// git: every recent commit has one, then the commits get sparser with age. Within each
+// span the commits refs point to are preferred, then merges.
+func selectBitmapCommits(commits []bitmapCommit) [][]byte {
+	sorted := slices.Clone(commits)
+	slices.SortStableFunc(sorted, func(a, b bitmapCommit) int {
+		return -cmp.Compare(a.date, b.date)
+	})
+	selected := [][]byte{}
+	if len(sorted) < BITMAP_MIN_COMMITS {
+		for _, commit := range sorted {
+			selected = append(selected, commit.id)
+		}
+		return selected
+	}
+	for i := 0; ; {
+		next := nextBitmapCommit(i)
+		if i+next >= len(sorted) {
+			return selected
+		}
+		chosen := sorted[i+next]
+		for _, commit := range sorted[i : i+next+1] {
+			if commit.tip {
+				chosen = commit
+				break
+			}
+			if commit.merge {
+				chosen = commit
+			}
+		}
+		selected = append(selected, chosen.id)
+		i += next + 1
+	}
+}
+
+// nextBitmapCommit returns how many commits are skipped after the commit at i, as in git
+func nextBitmapCommit(i int) int {
+	if i <= BITMAP_MUST_REGION {
+		return 0
+	}
+	if i <= BITMAP_MIN_REGION {
+		return min(i-BITMAP_MUST_REGION, BITMAP_MIN_SPACING)
+	}
+	return max(min(i-BITMAP_MIN_REGION, BITMAP_MAX_SPACING), BITMAP_MIN_SPACING)
+}
+
+// packTypes adds the types of the objects of a pack to types, read from the headers of its
+// entries rather than by inflating the objects. A delta has the type of its base.
+func (manager *DefaulPackManager) packTypes(checksum []byte, types map[string]common.ObjectType) error {
+	packfile, err := manager.packfile(checksum)
+	if err != nil {
+		return err
+	}
+	entries, err := manager.indexEntries(hash.ChecksumToHex(checksum))
+	if err != nil {
+		return err
+	}
+	headers := make(map[int]entryHeader, len(entries))
+	offsets := make(map[string]int, len(entries))
+	for _, entry := range entries {
+		header, err := packfile.readEntryHeaderAt(int64(entry.Offset))
+		if err != nil {
+			return fmt.Errorf("failed to read object at %d: %w", entry.Offset, err)
+		}
+		headers[entry.Offset] = header
+		offsets[string(entry.ID)] = entry.Offset
+	}
+	resolved := make(map[int]common.ObjectType, len(entries))
+	for _, entry := range entries {
+		chain := []int{}
+		objectType, offset := common.ObjectType(0), entry.Offset
+		for objectType == 0 {
+			if t, ok := resolved[offset]; ok {
+				objectType = t
+				break
+			}
+			if len(chain) > MAX_DELTA_CHAIN {
+				return fmt.Errorf("%w: object at %d", ErrDeltaChainTooLong, entry.Offset)
+			}
+			header, ok := headers[offset]
+			if !ok {
+				return fmt.Errorf("invalid base offset %d of object at %d", offset, chain[len(chain)-1])
+			}
+			chain = append(chain, offset)
+			switch header.objectType {
+			case common.OBJ_OFS_DELTA:
+				if header.baseOffset <= 0 || header.baseOffset > offset {
+					return fmt.Errorf("invalid base offset of object at %d", offset)
+				}
+				offset -= header.baseOffset
+			case common.OBJ_REF_DELTA:
+				if offset, ok = offsets[string(header.baseID)]; !ok {
+					return fmt.Errorf("%w: %x is not in the pack", ErrBaseNotFound, header.baseID)
+				}
+			default:
+				objectType = header.objectType
+			}
+		}
+		for _, offset := range chain {
+			resolved[offset] = objectType
+		}
+		types[string(entry.ID)] = objectType
+	}
+	return nil
+}
+
+// buildBitmaps computes the bitmaps of the objects, which are given in the order of
+// their bits and must hold every object reachable from their commits. types are the
+// types of the objects by id. tips are the objects the refs point to. checksum is the
+// checksum of the pack or multi-pack-index.
+func buildBitmaps(db ObjectSource, objects [][]byte, types map[string]common.ObjectType, tips [][]byte, checksum []byte) (*BitmapFile, error) {
+	file := &BitmapFile{Checksum: checksum, NameHashes: make([]uint32, len(objects))}
+	for i := range file.Types {
+		file.Types[i] = NewBitmap()
+	}
+	walker := &bitmapWalker{
+		db:         db,
+		positions:  make(map[string]int, len(objects)),
+		commits:    map[string]*Bitmap{},
+		nameHashes: file.NameHashes,
+		named:      NewBitmap(),
+	}
+	tipSet := map[string]bool{}
+	for _, tip := range tips {
+		tipSet[string(tip)] = true
+	}
+	candidates := []bitmapCommit{}
+	for i, id := range objects {
+		walker.positions[string(id)] = i
+		t := slices.Index(BITMAP_TYPES[:], types[string(id)])
+		if t < 0 {
+			return nil, fmt.Errorf("object %x has type %v", id, types[string(id)])
+		}
+		file.Types[t].Set(i)
+		if t != BITMAP_COMMITS_TYPE {
+			continue
+		}
+		encodedObject, err := db.Object(id)
+		if err != nil {
+			return nil, err
+		}
+		commit, err := object.DecodeCommit(encodedObject)
+		if err != nil {
+			return nil, err
+		}
+		committer := commit.Committer()
+		candidates = append(candidates, bitmapCommit{
+			id:    id,
+			date:  committer.Date().Unix(),
+			tip:   tipSet[string(id)],
+			merge: len(commit.Parents()) > 1,
+		})
+	}
+
+	sorted := slices.Clone(objects)
+	slices.SortFunc(sorted, bytes.Compare)
+	selected := selectBitmapCommits(candidates)
+	// The oldest commits come first so that the bitmaps of their descendants reuse theirs
+	slices.Reverse(selected)
+	for _, id := range selected {
+		bitmap := NewBitmap()
+		ok, err := walker.reach(bitmap, id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("objects reachable from commit %x are missing", id)
+		}
+		walker.commits[string(id)] = bitmap
+		index, _ := slices.BinarySearchFunc(sorted, id, bytes.Compare)
+		file.Commits = append(file.Commits, BitmapCommit{Index: index, Bitmap: bitmap})
+	}
+	return file, nil
+}
+
+// refTips returns the objects the refs and HEAD point to
+func (manager *DefaulPackManager) refTips() ([][]byte, error) {
+	refs, err := manager.store.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	tips := [][]byte{}
+	for _, ref := range refs {
+		tips = append(tips, ref.Checksum)
+	}
+	return tips, nil
+}
+
+// WriteBitmap writes the bitmaps of a pack, which must hold every object reachable from
+// its commits, such as a pack written by repack -a
+func (manager *DefaulPackManager) WriteBitmap(checksum []byte) error {
+	hexChecksum := hash.ChecksumToHex(checksum)
+	entries, err := manager.indexEntries(hexChecksum)
+	if err != nil {
+		return err
+	}
+	tips, err := manager.refTips()
+	if err != nil {
+		return err
+	}
+	types := make(map[string]common.ObjectType, len(entries))
+	if err := manager.packTypes(checksum, types); err != nil {
+		return err
+	}
+	bitmaps, err := buildBitmaps(manager, packOrder(entries), types, tips, checksum)
+	if err != nil {
+		return err
+	}
+	file, err := manager.store.NewPackBitmapWriter("")
+	if err != nil {
+		return err
+	}
+	if err := encodeBitmapFile(bitmaps, file); err != nil {
+		file.Remove()
+		return err
+	}
+	if err := publish(checksum, file); err != nil {
+		file.Remove()
+		return err
+	}
+	manager.bitmaps, manager.bitmapsLoaded = nil, false
+	return nil
+}
+
+// packOrder returns the ids of the objects of a pack in the order of the pack
+func packOrder(entries []IndexEntry) [][]byte {
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b IndexEntry) int {
+		return cmp.Compare(a.Offset, b.Offset)
+	})
+	objects := make([][]byte, len(sorted))
+	for i, entry := range sorted {
+		objects[i] = entry.ID
+	}
+	return objects
+}
+
+// Bitmaps returns the bitmaps of the multi-pack-index or else of the first pack that has
+// some, nil when there are none. They are decoded once, until the packs change.
+func (manager *DefaulPackManager) Bitmaps() (*BitmapIndex, error) {
+	if !manager.bitmapsLoaded {
+		bitmaps, err := manager.loadBitmaps()
+		if err != nil {
+			return nil, err
+		}
+		manager.bitmaps, manager.bitmapsLoaded = bitmaps, true
+	}
+	return manager.bitmaps, nil
+}
+
+// loadBitmaps decodes the bitmaps Bitmaps returns
+func (manager *DefaulPackManager) loadBitmaps() (*BitmapIndex, error) {
+	indexfileIter, err := manager.IndexfilesIter()
+	if err != nil {
+		return nil, err
+	}
+	if manager.midx != nil {
+		index, err := manager.midxBitmaps()
+		if err != nil || index != nil {
+			return index, err
+		}
+	}
+	for {
+		indexfile, more := indexfileIter.Next()
+		if !more {
+			return nil, nil
+		}
+		hexChecksum := hash.ChecksumToHex(indexfile.packfile)
+		data, err := readAll(manager.store.NewPackBitmapReader(hexChecksum))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		entries, err := manager.indexEntries(hexChecksum)
+		if err != nil {
+			return nil, err
+		}
+		file, err := DecodeBitmapFile(data, len(entries))
+		if err != nil {
+			return nil, fmt.Errorf("pack %s: %w", hexChecksum, err)
+		}
+		if !bytes.Equal(file.Checksum, indexfile.packfile) {
+			return nil, fmt.Errorf("%w: bitmaps of pack %x found for pack %s", ErrInvalidBitmap, file.Checksum, hexChecksum)
+		}
+		return newBitmapIndex(manager, file, packOrder(entries)), nil
+	}
+}
+
+// midxBitmaps returns the bitmaps of the multi-pack-index, nil when it has none
+func (manager *DefaulPackManager) midxBitmaps() (*BitmapIndex, error) {
+	midx, err := manager.readMultiPackIndex()
+	if err != nil {
+		return nil, err
+	}
+	hexChecksum := hash.ChecksumToHex(midx.Checksum)
+	data, err := readAll(manager.store.NewMultiPackIndexBitmapReader(hexChecksum))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	file, err := DecodeBitmapFile(data, len(midx.Entries))
+	if err != nil {
+		return nil, fmt.Errorf("multi-pack-index: %w", err)
+	}
+	if !bytes.Equal(file.Checksum, midx.Checksum) {
+		return nil, fmt.Errorf("%w: bitmaps of multi-pack-index %x found for %s", ErrInvalidBitmap, file.Checksum, hexChecksum)
+	}
+	rev, err := readAll(manager.store.NewMultiPackIndexRevReader(hexChecksum))
+	if err != nil {
+		return nil, err
+	}
+	order, err := DecodeReverseIndex(rev, len(midx.Entries), midx.Checksum)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([][]byte, len(order))
+	for i, index := range order {
+		objects[i] = midx.Entries[index].ID
+	}
+	return newBitmapIndex(manager, file, objects), nil
+}
@@ -0,0 +1,162 @@
+package pack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// storeHistory stores a pack with a linear history of n commits, each one adding a file,
+// and returns the commits from the oldest along with the checksum of the pack
+func storeHistory(t *testing.T, st store.Store, n int) ([][]byte, []byte) {
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 3*n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encode := func(objectType common.ObjectType, content []byte) []byte {
+		if err := encoder.Encode(objectType, content); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := common.NewObjectBuffer(objectType, content).Hash()
+		return id
+	}
+	commits := [][]byte{}
+	var tree bytes.Buffer
+	for i := 0; i < n; i++ {
+		blob := encode(common.OBJ_BLOB, []byte(fmt.Sprintf("file %d\n", i)))
+		fmt.Fprintf(&tree, "100644 f%03d\x00%s", i, blob)
+		treeID := encode(common.OBJ_TREE, tree.Bytes())
+		var commit bytes.Buffer
+		fmt.Fprintf(&commit, "tree %x\n", treeID)
+		if i > 0 {
+			fmt.Fprintf(&commit, "parent %x\n", commits[i-1])
+		}
+		fmt.Fprintf(&commit, "author A <a@example.com> %d +0000\ncommitter A <a@example.com> %d +0000\n\ncommit %d\n", 1000+i, 1000+i, i)
+		commits = append(commits, encode(common.OBJ_COMMIT, commit.Bytes()))
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := New(st).From(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return commits, checksum
+}
+
+func TestBitmapReachable(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits, checksum := storeHistory(t, st, 30)
+	manager := New(st)
+	if index, err := manager.Bitmaps(); err != nil || index != nil {
+		t.Fatalf("expected: no bitmaps\tactual: %v %v", index, err)
+	}
+	if err := manager.WriteBitmap(checksum); err != nil {
+		t.Fatal(err)
+	}
+	index, err := manager.Bitmaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index == nil || index.Commits() != len(commits) {
+		t.Fatalf("expected: bitmaps for %d commits\tactual: %v", len(commits), index)
+	}
+	// The bitmaps are decoded once
+	if cached, err := manager.Bitmaps(); err != nil || cached != index {
+		t.Fatalf("expected: the decoded bitmaps\tactual: %v %v", cached, err)
+	}
+
+	for _, haves := range [][][]byte{nil, {commits[10]}, {commits[29]}} {
+		wants := [][]byte{commits[29]}
+		entries, ok, err := index.Reachable(wants, haves)
+		if err != nil || !ok {
+			t.Fatalf("expected: the walk answered by the bitmaps\tactual: %v %v", ok, err)
+		}
+		walked, err := revlist.Objects(manager, wants, haves, revlist.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, expected := entryIDs(entries), entryIDs(walked)
+		if !slices.Equal(actual, expected) {
+			t.Fatalf("expected: %d objects\tactual: %d objects", len(expected), len(actual))
+		}
+	}
+
+	unreachable, ok, err := index.Unreachable([][]byte{commits[20]}, [][]byte{commits[10], commits[29]})
+	if err != nil || !ok {
+		t.Fatalf("expected: the reachability answered by the bitmaps\tactual: %v %v", ok, err)
+	}
+	if len(unreachable) != 1 || !bytes.Equal(unreachable[0], commits[29]) {
+		t.Fatalf("expected: %x unreachable\tactual: %x", commits[29], unreachable)
+	}
+
+	// Objects missing from the pack are not covered by the bitmaps
+	if _, ok, err := index.Reachable([][]byte{bytes.Repeat([]byte{1}, 20)}, nil); err != nil || ok {
+		t.Fatalf("expected: the walk not answered\tactual: %v %v", ok, err)
+	}
+}
+
+// entryIDs returns the sorted ids of entries in hex
+func entryIDs(entries []revlist.Entry) []string {
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = hash.ChecksumToHex(entry.Checksum)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestEWAH(t *testing.T) {
+	b := NewBitmap()
+	// A literal word, a run of ones, a run of zeros and a last literal word
+	for _, i := range []int{3, 60} {
+		b.Set(i)
+	}
+	for i := 64; i < 64*5; i++ {
+		b.Set(i)
+	}
+	b.Set(64*40 + 7)
+
+	var buf bytes.Buffer
+	if err := encodeEWAH(b, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// The words are 3 run length words and 2 literals
+	if expected := EWAH_HEADER_SIZE + 5*EWAH_WORD_SIZE + 4; buf.Len() != expected {
+		t.Fatalf("expected: %d bytes\tactual: %d bytes", expected, buf.Len())
+	}
+	decoded, n, err := decodeEWAH(append(buf.Bytes(), 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != buf.Len() || !slices.Equal(decoded.Positions(), b.Positions()) {
+		t.Fatalf("expected: %v in %d bytes\tactual: %v in %d bytes", b.Positions(), buf.Len(), decoded.Positions(), n)
+	}
+
+	empty := bytes.Buffer{}
+	if err := encodeEWAH(NewBitmap(), &empty); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _, err := decodeEWAH(empty.Bytes()); err != nil || decoded.Count() != 0 {
+		t.Fatalf("expected: an empty bitmap\tactual: %v %v", decoded, err)
+	}
+
+	// Bits set past the size of the bitmap
+	corrupted := bytes.Clone(buf.Bytes())
+	binary.BigEndian.PutUint32(corrupted, 64)
+	if _, _, err := decodeEWAH(corrupted); !errors.Is(err, ErrInvalidEWAH) {
+		t.Fatalf("expected: %v\tactual: %v", ErrInvalidEWAH, err)
+	}
+}
@@ -0,0 +1,871 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"time"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+)
+
+const (
+	COMMIT_GRAPH_VERSION = 1
+	// COMMIT_GRAPH_SHA1 identifies the hash function of the ids of the commit-graph
+	COMMIT_GRAPH_SHA1        = 1
+	COMMIT_GRAPH_HEADER_SIZE = 8
+	// COMMIT_GRAPH_CHUNK_ENTRY_SIZE is the size of an entry of the table of chunks: the id
+	// of the chunk followed by its offset in the file
+	COMMIT_GRAPH_CHUNK_ENTRY_SIZE = 12
+	// COMMIT_GRAPH_DATA_SIZE is the size of an entry of the CDAT chunk: the tree of the
+	// commit, the positions of its first two parents, then its topological level in 30 bits
+	// followed by its commit date in 34 bits
+	COMMIT_GRAPH_DATA_SIZE = CHECKSUM_LEN + 16
+	COMMIT_GRAPH_DATE_BITS = 34
+	// GRAPH_PARENT_NONE is the position of a missing parent
+	GRAPH_PARENT_NONE = 0x70000000
+	// GRAPH_EXTRA_EDGES_NEEDED flags the second parent of an octopus merge, which is then
+	// the position in the EDGE chunk of its parents after the first one
+	GRAPH_EXTRA_EDGES_NEEDED = 0x80000000
+	// GRAPH_LAST_EDGE flags the last parent of an octopus merge in the EDGE chunk
+	GRAPH_LAST_EDGE = 0x80000000
+	// GENERATION_NUMBER_V1_MAX caps the topological levels, which take 30 bits
+	GENERATION_NUMBER_V1_MAX = 0x3FFFFFFF
+	// GENERATION_NUMBER_V2_OFFSET_MAX is the largest offset of a corrected commit date from
+	// the commit date held by the GDA2 chunk, larger offsets go to the GDO2 chunk
+	GENERATION_NUMBER_V2_OFFSET_MAX = 1<<31 - 1
+	// CORRECTED_COMMIT_DATE_OFFSET_OVERFLOW flags the entries of the GDA2 chunk that are
+	// the position of the offset in the GDO2 chunk
+	CORRECTED_COMMIT_DATE_OFFSET_OVERFLOW = 0x80000000
+	// COMMIT_GRAPH_SIZE_MULTIPLE is how many times more commits than the new layer of a
+	// split commit-graph a layer must hold not to be merged into it
+	COMMIT_GRAPH_SIZE_MULTIPLE = 2
+)
+
+var (
+	// COMMIT_GRAPH_MAGIC starts the commit-graph files written by git
+	COMMIT_GRAPH_MAGIC = []byte("CGPH")
+
+	GRAPH_CHUNK_OID_FANOUT               = "OIDF"
+	GRAPH_CHUNK_OID_LOOKUP               = "OIDL"
+	GRAPH_CHUNK_DATA                     = "CDAT"
+	GRAPH_CHUNK_GENERATION_DATA          = "GDA2"
+	GRAPH_CHUNK_GENERATION_DATA_OVERFLOW = "GDO2"
+	GRAPH_CHUNK_EXTRA_EDGES              = "EDGE"
+	GRAPH_CHUNK_BASE                     = "BASE"
+
+	ErrInvalidCommitGraph = errors.New("invalid commit-graph")
+	ErrShallowCommitGraph = errors.New("commit-graph cannot be written in a shallow repository")
+)
+
+// GraphCommit is a commit listed by a commit-graph
+type GraphCommit struct {
+	ID      []byte
+	Tree    []byte
+	Parents [][]byte
+	// Date is the commit date in seconds since the epoch
+	Date int64
+	// Level is the topological level of the commit: 1 for root commits and otherwise one
+	// more than the highest level of its parents
+	Level uint32
+	// Generation is the corrected commit date: the commit date, raised to be later than
+	// the generation of every parent. It is zero when the commit-graph has none.
+	Generation int64
+}
+
+// CommitGraphSplit tells how a commit-graph is written
+type CommitGraphSplit int
+
+const (
+	// COMMIT_GRAPH_NO_SPLIT writes every commit to a single commit-graph file
+	COMMIT_GRAPH_NO_SPLIT CommitGraphSplit = iota
+	// COMMIT_GRAPH_SPLIT adds a layer with the new commits to the split commit-graph,
+	// merging into it the layers on top that are not much larger
+	COMMIT_GRAPH_SPLIT
+	// COMMIT_GRAPH_SPLIT_NO_MERGE adds a layer without merging any layer into it
+	COMMIT_GRAPH_SPLIT_NO_MERGE
+	// COMMIT_GRAPH_SPLIT_REPLACE merges every layer into a single new one
+	COMMIT_GRAPH_SPLIT_REPLACE
+)
+
+// CommitGraphWriteOptions tune the writing of a commit-graph
+type CommitGraphWriteOptions struct {
+	// Reachable lists the commits reachable from the refs rather than the commits of
+	// every pack
+	Reachable bool
+	Split     CommitGraphSplit
+	// SizeMultiple is how many times more commits than the new layer a layer must hold
+	// not to be merged into it
+	SizeMultiple int
+	// MaxCommits merges the layers below into the new layer as long as it holds more
+	// commits, 0 means no limit
+	MaxCommits int
+}
+
+func DefaultCommitGraphWriteOptions() CommitGraphWriteOptions {
+	return CommitGraphWriteOptions{SizeMultiple: COMMIT_GRAPH_SIZE_MULTIPLE}
+}
+
+// commitGraphLayer is a commit-graph file, alone or as a layer of a split commit-graph
+type commitGraphLayer struct {
+	checksum []byte
+	// base is the number of commits of the layers below, which come first in the
+	// positions of the parents
+	base  int
+	count int
+	// bases are the checksums of the layers below as listed by the BASE chunk
+	bases [][]byte
+	// generations and overflows are nil when the layer has no corrected commit dates
+	fanout, lookup, data, generations, overflows, edges []byte
+}
+
+// commitGraph is the commit-graph of a repository: a single layer, or the layers of a
+// split commit-graph from the bottom one
+type commitGraph struct {
+	layers []*commitGraphLayer
+	split  bool
+}
+
+// decodeCommitGraphLayer decodes a commit-graph file whose layers below hold base commits,
+// verifying its checksum and the sizes of its chunks
+func decodeCommitGraphLayer(data []byte, base int) (*commitGraphLayer, error) {
+	if len(data) < COMMIT_GRAPH_HEADER_SIZE+CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: too small", ErrInvalidCommitGraph)
+	}
+	trailer := len(data) - CHECKSUM_LEN
+	sum := hash.New(hash.SHA1)
+	sum.Write(data[:trailer])
+	if !bytes.Equal(sum.Sum(nil), data[trailer:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidCommitGraph)
+	}
+	if !bytes.HasPrefix(data, COMMIT_GRAPH_MAGIC) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidCommitGraph)
+	}
+	if data[4] != COMMIT_GRAPH_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidCommitGraph, data[4])
+	}
+	if data[5] != COMMIT_GRAPH_SHA1 {
+		return nil, fmt.Errorf("%w: unsupported hash %d", ErrInvalidCommitGraph, data[5])
+	}
+	count, baseCount := int(data[6]), int(data[7])
+	tableEnd := COMMIT_GRAPH_HEADER_SIZE + (count+1)*COMMIT_GRAPH_CHUNK_ENTRY_SIZE
+	if tableEnd > trailer {
+		return nil, fmt.Errorf("%w: truncated table of chunks", ErrInvalidCommitGraph)
+	}
+	chunks := map[string][]byte{}
+	for i := 0; i < count; i++ {
+		entry := data[COMMIT_GRAPH_HEADER_SIZE+i*COMMIT_GRAPH_CHUNK_ENTRY_SIZE:]
+		start := binary.BigEndian.Uint64(entry[4:12])
+		end := binary.BigEndian.Uint64(entry[COMMIT_GRAPH_CHUNK_ENTRY_SIZE+4 : 2*COMMIT_GRAPH_CHUNK_ENTRY_SIZE])
+		if start < uint64(tableEnd) || start > end || end > uint64(trailer) {
+			return nil, fmt.Errorf("%w: chunk %s out of bounds", ErrInvalidCommitGraph, entry[:4])
+		}
+		chunks[string(entry[:4])] = data[start:end]
+	}
+	for _, id := range []string{GRAPH_CHUNK_OID_FANOUT, GRAPH_CHUNK_OID_LOOKUP, GRAPH_CHUNK_DATA} {
+		if _, ok := chunks[id]; !ok {
+			return nil, fmt.Errorf("%w: missing chunk %s", ErrInvalidCommitGraph, id)
+		}
+	}
+
+	layer := &commitGraphLayer{
+		checksum:    data[trailer:],
+		base:        base,
+		fanout:      chunks[GRAPH_CHUNK_OID_FANOUT],
+		lookup:      chunks[GRAPH_CHUNK_OID_LOOKUP],
+		data:        chunks[GRAPH_CHUNK_DATA],
+		generations: chunks[GRAPH_CHUNK_GENERATION_DATA],
+		overflows:   chunks[GRAPH_CHUNK_GENERATION_DATA_OVERFLOW],
+		edges:       chunks[GRAPH_CHUNK_EXTRA_EDGES],
+	}
+	if len(layer.fanout) != HEADER_SIZE {
+		return nil, fmt.Errorf("%w: bad fan-out size", ErrInvalidCommitGraph)
+	}
+	layer.count = int(binary.BigEndian.Uint32(layer.fanout[HEADER_SIZE-HEADER_ENTRY_SIZE:]))
+	previous := uint32(0)
+	for i := 0; i < HEADER_ENTRIES; i++ {
+		n := binary.BigEndian.Uint32(layer.fanout[i*HEADER_ENTRY_SIZE:])
+		if n < previous {
+			return nil, fmt.Errorf("%w: fan-out out of order", ErrInvalidCommitGraph)
+		}
+		previous = n
+	}
+	if len(layer.lookup) != layer.count*CHECKSUM_LEN || len(layer.data) != layer.count*COMMIT_GRAPH_DATA_SIZE {
+		return nil, fmt.Errorf("%w: truncated commit tables", ErrInvalidCommitGraph)
+	}
+	if layer.generations != nil && len(layer.generations) != layer.count*4 {
+		return nil, fmt.Errorf("%w: truncated generation data", ErrInvalidCommitGraph)
+	}
+	if len(layer.overflows)%8 != 0 || len(layer.edges)%4 != 0 {
+		return nil, fmt.Errorf("%w: truncated chunks", ErrInvalidCommitGraph)
+	}
+	if baseCount > 0 {
+		bases, ok := chunks[GRAPH_CHUNK_BASE]
+		if !ok || len(bases) != baseCount*CHECKSUM_LEN {
+			return nil, fmt.Errorf("%w: %d base graphs without their checksums", ErrInvalidCommitGraph, baseCount)
+		}
+		for i := 0; i < baseCount; i++ {
+			layer.bases = append(layer.bases, bases[i*CHECKSUM_LEN:(i+1)*CHECKSUM_LEN])
+		}
+	}
+	return layer, nil
+}
+
+func (l *commitGraphLayer) id(i int) []byte {
+	return l.lookup[i*CHECKSUM_LEN : (i+1)*CHECKSUM_LEN]
+}
+
+// search returns the position of the commit id in the layer
+func (l *commitGraphLayer) search(id []byte) (int, bool) {
+	first := 0
+	if id[0] > 0 {
+		first = int(binary.BigEndian.Uint32(l.fanout[(int(id[0])-1)*HEADER_ENTRY_SIZE:]))
+	}
+	last := int(binary.BigEndian.Uint32(l.fanout[int(id[0])*HEADER_ENTRY_SIZE:]))
+	i := first + sort.Search(last-first, func(i int) bool {
+		return bytes.Compare(l.id(first+i), id) >= 0
+	})
+	return i, i < last && bytes.Equal(l.id(i), id)
+}
+
+// count is the number of commits of every layer
+func (g *commitGraph) count() int {
+	if len(g.layers) == 0 {
+		return 0
+	}
+	top := g.layers[len(g.layers)-1]
+	return top.base + top.count
+}
+
+// hasGenerations reports whether every layer holds corrected commit dates
+func (g *commitGraph) hasGenerations() bool {
+	for _, layer := range g.layers {
+		if layer.generations == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// search returns the layer holding the commit id along with its position in the layer
+func (g *commitGraph) search(id []byte) (*commitGraphLayer, int, bool) {
+	for _, layer := range g.layers {
+		if i, ok := layer.search(id); ok {
+			return layer, i, true
+		}
+	}
+	return nil, 0, false
+}
+
+// id returns the commit at a position counted across every layer
+func (g *commitGraph) id(position uint32) ([]byte, error) {
+	for _, layer := range g.layers {
+		if int(position) < layer.base+layer.count {
+			return layer.id(int(position) - layer.base), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: parent position %d out of bounds", ErrInvalidCommitGraph, position)
+}
+
+// commit decodes the commit at position i of layer
+func (g *commitGraph) commit(layer *commitGraphLayer, i int) (*GraphCommit, error) {
+	entry := layer.data[i*COMMIT_GRAPH_DATA_SIZE : (i+1)*COMMIT_GRAPH_DATA_SIZE]
+	levelAndDate := binary.BigEndian.Uint64(entry[CHECKSUM_LEN+8:])
+	commit := &GraphCommit{
+		ID:      layer.id(i),
+		Tree:    entry[:CHECKSUM_LEN],
+		Parents: [][]byte{},
+		Level:   uint32(levelAndDate >> COMMIT_GRAPH_DATE_BITS),
+		Date:    int64(levelAndDate & (1<<COMMIT_GRAPH_DATE_BITS - 1)),
+	}
+	first := binary.BigEndian.Uint32(entry[CHECKSUM_LEN:])
+	second := binary.BigEndian.Uint32(entry[CHECKSUM_LEN+4:])
+	if first != GRAPH_PARENT_NONE {
+		parent, err := g.id(first)
+		if err != nil {
+			return nil, err
+		}
+		commit.Parents = append(commit.Parents, parent)
+	}
+	switch {
+	case second == GRAPH_PARENT_NONE:
+	case second&GRAPH_EXTRA_EDGES_NEEDED != 0:
+		for edge := int(second &^ GRAPH_EXTRA_EDGES_NEEDED); ; edge++ {
+			if (edge+1)*4 > len(layer.edges) {
+				return nil, fmt.Errorf("%w: extra edges of commit %x out of bounds", ErrInvalidCommitGraph, commit.ID)
+			}
+			position := binary.BigEndian.Uint32(layer.edges[edge*4:])
+			parent, err := g.id(position &^ GRAPH_LAST_EDGE)
+			if err != nil {
+				return nil, err
+			}
+			commit.Parents = append(commit.Parents, parent)
+			if position&GRAPH_LAST_EDGE != 0 {
+				break
+			}
+		}
+	default:
+		parent, err := g.id(second)
+		if err != nil {
+			return nil, err
+		}
+		commit.Parents = append(commit.Parents, parent)
+	}
+	if g.hasGenerations() {
+		offset := uint64(binary.BigEndian.Uint32(layer.generations[i*4:]))
+		if offset&CORRECTED_COMMIT_DATE_OFFSET_OVERFLOW != 0 {
+			overflow := int(offset &^ CORRECTED_COMMIT_DATE_OFFSET_OVERFLOW)
+			if (overflow+1)*8 > len(layer.overflows) {
+				return nil, fmt.Errorf("%w: generation of commit %x out of bounds", ErrInvalidCommitGraph, commit.ID)
+			}
+			offset = binary.BigEndian.Uint64(layer.overflows[overflow*8:])
+		}
+		commit.Generation = commit.Date + int64(offset)
+	}
+	return commit, nil
+}
+
+// lookup returns the commit id, ok is false when the graph does not hold it
+func (g *commitGraph) lookup(id []byte) (*GraphCommit, bool, error) {
+	layer, i, ok := g.search(id)
+	if !ok {
+		return nil, false, nil
+	}
+	commit, err := g.commit(layer, i)
+	return commit, err == nil, err
+}
+
+// setGenerations computes the topological levels and corrected commit dates of commits,
+// whose parents are either among commits or in base
+func setGenerations(commits []GraphCommit, base *commitGraph) error {
+	index := make(map[string]int, len(commits))
+	for i, commit := range commits {
+		index[string(commit.ID)] = i
+	}
+	done := make([]bool, len(commits))
+	for start := range commits {
+		stack := []int{start}
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			if done[i] {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			level, generation := uint32(0), int64(0)
+			pending := false
+			for _, parent := range commits[i].Parents {
+				if j, ok := index[string(parent)]; ok {
+					if !done[j] {
+						stack = append(stack, j)
+						pending = true
+					}
+					level, generation = max(level, commits[j].Level), max(generation, commits[j].Generation)
+					continue
+				}
+				baseCommit, ok, err := base.lookup(parent)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("parent %x of commit %x is missing from the commit-graph", parent, commits[i].ID)
+				}
+				level, generation = max(level, baseCommit.Level), max(generation, baseCommit.Generation)
+			}
+			if pending {
+				continue
+			}
+			commits[i].Level = min(level+1, GENERATION_NUMBER_V1_MAX)
+			commits[i].Generation = max(commits[i].Date, generation+1)
+			done[i] = true
+			stack = stack[:len(stack)-1]
+		}
+	}
+	return nil
+}
+
+// encodeCommitGraph writes a commit-graph file as git does, holding commits sorted by id
+// on top of the layers of base: a header, the table of chunks, the OIDF, OIDL, CDAT and
+// GDA2 chunks, the GDO2 and EDGE chunks when needed and the BASE chunk when there are
+// layers below, followed by the checksum of the file, which is returned
+func encodeCommitGraph(commits []GraphCommit, base *commitGraph, w io.Writer) ([]byte, error) {
+	positions := make(map[string]int, len(commits))
+	for i, commit := range commits {
+		positions[string(commit.ID)] = base.count() + i
+	}
+	position := func(commit GraphCommit, parent []byte) (uint32, error) {
+		if i, ok := positions[string(parent)]; ok {
+			return uint32(i), nil
+		}
+		if layer, i, ok := base.search(parent); ok {
+			return uint32(layer.base + i), nil
+		}
+		return 0, fmt.Errorf("parent %x of commit %x is missing from the commit-graph", parent, commit.ID)
+	}
+
+	fanout := [HEADER_ENTRIES]uint32{}
+	for _, commit := range commits {
+		fanout[commit.ID[0]]++
+	}
+	for i := 1; i < HEADER_ENTRIES; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	var fanoutChunk, lookup, data, generations, overflows, edges, bases bytes.Buffer
+	binary.Write(&fanoutChunk, binary.BigEndian, fanout)
+	for _, commit := range commits {
+		lookup.Write(commit.ID)
+		data.Write(commit.Tree)
+		parents := [2]uint32{GRAPH_PARENT_NONE, GRAPH_PARENT_NONE}
+		for i, parent := range commit.Parents {
+			p, err := position(commit, parent)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case i == 0:
+				parents[0] = p
+			case len(commit.Parents) == 2:
+				parents[1] = p
+			default:
+				if i == 1 {
+					parents[1] = GRAPH_EXTRA_EDGES_NEEDED | uint32(edges.Len()/4)
+				}
+				if i == len(commit.Parents)-1 {
+					p |= GRAPH_LAST_EDGE
+				}
+				binary.Write(&edges, binary.BigEndian, p)
+			}
+		}
+		binary.Write(&data, binary.BigEndian, parents)
+		binary.Write(&data, binary.BigEndian, uint64(commit.Level)<<COMMIT_GRAPH_DATE_BITS|uint64(commit.Date)&(1<<COMMIT_GRAPH_DATE_BITS-1))
+		offset := uint64(commit.Generation - commit.Date)
+		if offset > GENERATION_NUMBER_V2_OFFSET_MAX {
+			binary.Write(&generations, binary.BigEndian, uint32(CORRECTED_COMMIT_DATE_OFFSET_OVERFLOW|overflows.Len()/8))
+			binary.Write(&overflows, binary.BigEndian, offset)
+		} else {
+			binary.Write(&generations, binary.BigEndian, uint32(offset))
+		}
+	}
+	for _, layer := range base.layers {
+		bases.Write(layer.checksum)
+	}
+
+	type chunk struct {
+		id   string
+		data []byte
+	}
+	chunks := []chunk{
+		{GRAPH_CHUNK_OID_FANOUT, fanoutChunk.Bytes()},
+		{GRAPH_CHUNK_OID_LOOKUP, lookup.Bytes()},
+		{GRAPH_CHUNK_DATA, data.Bytes()},
+		{GRAPH_CHUNK_GENERATION_DATA, generations.Bytes()},
+	}
+	if overflows.Len() > 0 {
+		chunks = append(chunks, chunk{GRAPH_CHUNK_GENERATION_DATA_OVERFLOW, overflows.Bytes()})
+	}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{GRAPH_CHUNK_EXTRA_EDGES, edges.Bytes()})
+	}
+	if bases.Len() > 0 {
+		chunks = append(chunks, chunk{GRAPH_CHUNK_BASE, bases.Bytes()})
+	}
+
+	hashWriter := hash.NewHashWriter(w, hash.SHA1)
+	bw := bufio.NewWriter(hashWriter)
+	bw.Write(COMMIT_GRAPH_MAGIC)
+	bw.Write([]byte{COMMIT_GRAPH_VERSION, COMMIT_GRAPH_SHA1, byte(len(chunks)), byte(len(base.layers))})
+	// The table of chunks ends with a zero id giving the offset of the end of the last chunk
+	offset := uint64(COMMIT_GRAPH_HEADER_SIZE + (len(chunks)+1)*COMMIT_GRAPH_CHUNK_ENTRY_SIZE)
+	for _, chunk := range chunks {
+		bw.WriteString(chunk.id)
+		binary.Write(bw, binary.BigEndian, offset)
+		offset += uint64(len(chunk.data))
+	}
+	bw.Write(make([]byte, 4))
+	binary.Write(bw, binary.BigEndian, offset)
+	for _, chunk := range chunks {
+		bw.Write(chunk.data)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	checksum := hashWriter.Sum(nil)
+	if _, err := w.Write(checksum); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// readCommitGraph reads the commit-graph file, or else the layers of the split
+// commit-graph. It returns nil when the repository has neither.
+func (manager *DefaulPackManager) readCommitGraph() (*commitGraph, error) {
+	data, err := readAll(manager.store.NewCommitGraphReader())
+	if err == nil {
+		layer, err := decodeCommitGraphLayer(data, 0)
+		if err != nil {
+			return nil, err
+		}
+		if len(layer.bases) > 0 {
+			return nil, fmt.Errorf("%w: base graphs without a chain", ErrInvalidCommitGraph)
+		}
+		return &commitGraph{layers: []*commitGraphLayer{layer}}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	chain, err := manager.store.ReadCommitGraphChain()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	graph := &commitGraph{split: true}
+	for _, hexChecksum := range chain {
+		data, err := readAll(manager.store.NewCommitGraphLayerReader(hexChecksum))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: missing layer %s", ErrInvalidCommitGraph, hexChecksum)
+			}
+			return nil, err
+		}
+		layer, err := decodeCommitGraphLayer(data, graph.count())
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", hexChecksum, err)
+		}
+		if hash.ChecksumToHex(layer.checksum) != hexChecksum {
+			return nil, fmt.Errorf("%w: layer %s has checksum %x", ErrInvalidCommitGraph, hexChecksum, layer.checksum)
+		}
+		if len(layer.bases) != len(graph.layers) {
+			return nil, fmt.Errorf("%w: layer %s has %d base graphs rather than %d", ErrInvalidCommitGraph, hexChecksum, len(layer.bases), len(graph.layers))
+		}
+		for i, base := range layer.bases {
+			if !bytes.Equal(base, graph.layers[i].checksum) {
+				return nil, fmt.Errorf("%w: layer %s is not based on layer %x", ErrInvalidCommitGraph, hexChecksum, graph.layers[i].checksum)
+			}
+		}
+		graph.layers = append(graph.layers, layer)
+	}
+	return graph, nil
+}
+
+// loadCommitGraph returns the commit-graph, read once. An invalid commit-graph is ignored
+// as the commits can still be read.
+func (manager *DefaulPackManager) loadCommitGraph() (*commitGraph, error) {
+	if manager.graphLoaded {
+		return manager.graph, nil
+	}
+	graph, err := manager.readCommitGraph()
+	if err != nil && !errors.Is(err, ErrInvalidCommitGraph) {
+		return nil, err
+	}
+	manager.graph, manager.graphLoaded = graph, true
+	return manager.graph, nil
+}
+
+// LookupCommit returns the parents, tree and dates of the commit id from the commit-graph.
+// ok is false when the repository has no commit-graph, when the commit-graph does not hold
+// the commit or when the commit is no longer stored.
+func (manager *DefaulPackManager) LookupCommit(id []byte) (*revlist.Commit, bool, error) {
+	graph, err := manager.loadCommitGraph()
+	if err != nil || graph == nil {
+		return nil, false, err
+	}
+	commit, ok, err := graph.lookup(id)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if ok, err := manager.ObjectExist(id); err != nil || !ok {
+		return nil, false, err
+	}
+	return &revlist.Commit{
+		Tree:       commit.Tree,
+		Parents:    commit.Parents,
+		Date:       time.Unix(commit.Date, 0),
+		Generation: commit.Generation,
+	}, true, nil
+}
+
+// readGraphCommit reads the commit id from its object, leaving the commit-graph aside
+func (manager *DefaulPackManager) readGraphCommit(id []byte) (*GraphCommit, error) {
+	encodedObject, err := manager.Object(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %x: %w", id, err)
+	}
+	commit, err := object.DecodeCommit(encodedObject)
+	if err != nil {
+		return nil, fmt.Errorf("commit %x: %w", id, err)
+	}
+	committer := commit.Committer()
+	return &GraphCommit{ID: id, Tree: commit.Tree(), Parents: commit.Parents(), Date: committer.Date().Unix()}, nil
+}
+
+// graphStarts returns the commits the commit-graph is written from: the commits the refs
+// point to, or every commit of the packs
+func (manager *DefaulPackManager) graphStarts(reachable bool) ([][]byte, error) {
+	candidates := [][]byte{}
+	if reachable {
+		tips, err := manager.refTips()
+		if err != nil {
+			return nil, err
+		}
+		candidates = tips
+	} else {
+		objects, err := manager.StoredObjects()
+		if err != nil {
+			return nil, err
+		}
+		for _, stored := range objects {
+			if stored.Pack != nil {
+				candidates = append(candidates, stored.ID)
+			}
+		}
+	}
+	starts := [][]byte{}
+	seen := map[string]bool{}
+	for _, id := range candidates {
+		// Tags are peeled to the commits they point to
+		for !seen[string(id)] {
+			seen[string(id)] = true
+			encodedObject, err := manager.Object(id)
+			if err != nil {
+				return nil, err
+			}
+			if encodedObject.Type() == common.OBJ_COMMIT {
+				starts = append(starts, id)
+			}
+			if encodedObject.Type() != common.OBJ_TAG || !reachable {
+				break
+			}
+			tag, err := object.DecodeTag(encodedObject)
+			if err != nil {
+				return nil, err
+			}
+			id = tag.Object()
+		}
+	}
+	return starts, nil
+}
+
+// graphCommits returns the commits reachable from starts that are not in existing, which
+// may be nil, sorted by id
+func (manager *DefaulPackManager) graphCommits(starts [][]byte, existing *commitGraph) ([]GraphCommit, error) {
+	commits := []GraphCommit{}
+	seen := map[string]bool{}
+	stack := slices.Clone(starts)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[string(id)] {
+			continue
+		}
+		seen[string(id)] = true
+		if existing != nil {
+			if _, _, ok := existing.search(id); ok {
+				continue
+			}
+		}
+		commit, err := manager.readGraphCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, *commit)
+		stack = append(stack, commit.Parents...)
+	}
+	slices.SortFunc(commits, func(a, b GraphCommit) int {
+		return bytes.Compare(a.ID, b.ID)
+	})
+	return commits, nil
+}
+
+// WriteCommitGraph writes the commit-graph of the commits of the packs, or of the commits
+// reachable from the refs. Without splitting every commit goes to a single file, which
+// replaces the split commit-graph. Otherwise a layer with the commits missing from the
+// split commit-graph is added, into which the layers on top are merged unless they hold
+// SizeMultiple times more commits.
+func (manager *DefaulPackManager) WriteCommitGraph(options CommitGraphWriteOptions) error {
+	shallow, err := manager.store.Shallow()
+	if err != nil {
+		return err
+	}
+	// The parents of shallow commits are missing
+	if len(shallow) > 0 {
+		return ErrShallowCommitGraph
+	}
+	starts, err := manager.graphStarts(options.Reachable)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		manager.graph, manager.graphLoaded = nil, false
+	}()
+	if options.Split == COMMIT_GRAPH_NO_SPLIT {
+		commits, err := manager.graphCommits(starts, nil)
+		if err != nil {
+			return err
+		}
+		if err := setGenerations(commits, &commitGraph{}); err != nil {
+			return err
+		}
+		file, err := manager.store.NewCommitGraphWriter()
+		if err != nil {
+			return err
+		}
+		checksum, err := encodeCommitGraph(commits, &commitGraph{}, file)
+		if err != nil {
+			file.Remove()
+			return err
+		}
+		if err := publish(checksum, file); err != nil {
+			file.Remove()
+			return err
+		}
+		return manager.store.PruneCommitGraphLayers(nil)
+	}
+
+	existing, err := manager.readCommitGraph()
+	if err != nil && !errors.Is(err, ErrInvalidCommitGraph) {
+		return err
+	}
+	if existing == nil {
+		existing = &commitGraph{split: true}
+	}
+	commits, err := manager.graphCommits(starts, existing)
+	if err != nil {
+		return err
+	}
+	// A commit-graph file is merged into the new layer as it cannot be part of the chain,
+	// and so are layers without corrected commit dates as the new layer could not have
+	// any otherwise
+	kept := existing.layers
+	if !existing.split || !existing.hasGenerations() || options.Split == COMMIT_GRAPH_SPLIT_REPLACE {
+		kept = nil
+	}
+	n := len(commits)
+	for options.Split == COMMIT_GRAPH_SPLIT && len(kept) > 0 {
+		top := kept[len(kept)-1]
+		if top.count > options.SizeMultiple*n && (options.MaxCommits == 0 || n <= options.MaxCommits) {
+			break
+		}
+		n += top.count
+		kept = kept[:len(kept)-1]
+	}
+	for _, layer := range existing.layers[len(kept):] {
+		for i := 0; i < layer.count; i++ {
+			commit, err := existing.commit(layer, i)
+			if err != nil {
+				return err
+			}
+			commits = append(commits, *commit)
+		}
+	}
+	if len(commits) == 0 {
+		return nil
+	}
+	slices.SortFunc(commits, func(a, b GraphCommit) int {
+		return bytes.Compare(a.ID, b.ID)
+	})
+	base := &commitGraph{layers: kept, split: true}
+	if err := setGenerations(commits, base); err != nil {
+		return err
+	}
+	file, err := manager.store.NewCommitGraphLayerWriter()
+	if err != nil {
+		return err
+	}
+	checksum, err := encodeCommitGraph(commits, base, file)
+	if err != nil {
+		file.Remove()
+		return err
+	}
+	if err := publish(checksum, file); err != nil {
+		file.Remove()
+		return err
+	}
+	chain := []string{}
+	for _, layer := range kept {
+		chain = append(chain, hash.ChecksumToHex(layer.checksum))
+	}
+	chain = append(chain, hash.ChecksumToHex(checksum))
+	if err := manager.store.WriteCommitGraphChain(chain); err != nil {
+		return err
+	}
+	// The commit-graph file would be read rather than the chain
+	if err := manager.store.RemoveCommitGraph(); err != nil {
+		return err
+	}
+	return manager.store.PruneCommitGraphLayers(chain)
+}
+
+// VerifyCommitGraph checks the commit-graph against the commits: the order of the commits,
+// their trees, parents and dates and that each generation is above those of the parents
+func (manager *DefaulPackManager) VerifyCommitGraph() error {
+	graph, err := manager.readCommitGraph()
+	if err != nil || graph == nil {
+		return err
+	}
+	for _, layer := range graph.layers {
+		fanout := [HEADER_ENTRIES]uint32{}
+		for i := 0; i < layer.count; i++ {
+			fanout[layer.id(i)[0]]++
+			if i > 0 && bytes.Compare(layer.id(i-1), layer.id(i)) >= 0 {
+				return fmt.Errorf("%w: commits %x and %x out of order", ErrInvalidCommitGraph, layer.id(i-1), layer.id(i))
+			}
+		}
+		for i := 1; i < HEADER_ENTRIES; i++ {
+			fanout[i] += fanout[i-1]
+		}
+		for i, n := range fanout {
+			if binary.BigEndian.Uint32(layer.fanout[i*HEADER_ENTRY_SIZE:]) != n {
+				return fmt.Errorf("%w: fan-out entry %d is not %d", ErrInvalidCommitGraph, i, n)
+			}
+		}
+
+		for i := 0; i < layer.count; i++ {
+			commit, err := graph.commit(layer, i)
+			if err != nil {
+				return err
+			}
+			actual, err := manager.readGraphCommit(commit.ID)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(commit.Tree, actual.Tree) {
+				return fmt.Errorf("%w: tree %x of commit %x is not %x", ErrInvalidCommitGraph, commit.Tree, commit.ID, actual.Tree)
+			}
+			if !slices.EqualFunc(commit.Parents, actual.Parents, bytes.Equal) {
+				return fmt.Errorf("%w: parents of commit %x do not match", ErrInvalidCommitGraph, commit.ID)
+			}
+			if commit.Date != actual.Date&(1<<COMMIT_GRAPH_DATE_BITS-1) {
+				return fmt.Errorf("%w: date %d of commit %x is not %d", ErrInvalidCommitGraph, commit.Date, commit.ID, actual.Date)
+			}
+			level, generation := uint32(0), commit.Date-1
+			for _, id := range commit.Parents {
+				parent, ok, err := graph.lookup(id)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return fmt.Errorf("%w: parent %x of commit %x is missing", ErrInvalidCommitGraph, id, commit.ID)
+				}
+				level, generation = max(level, parent.Level), max(generation, parent.Generation)
+			}
+			// Topological levels are left at zero by old versions of git
+			if commit.Level != 0 && commit.Level != min(level+1, GENERATION_NUMBER_V1_MAX) {
+				return fmt.Errorf("%w: level %d of commit %x is not %d", ErrInvalidCommitGraph, commit.Level, commit.ID, min(level+1, GENERATION_NUMBER_V1_MAX))
+			}
+			if graph.hasGenerations() && commit.Generation <= generation {
+				return fmt.Errorf("%w: generation %d of commit %x is not above %d", ErrInvalidCommitGraph, commit.Generation, commit.ID, generation)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+func TestCommitGraph(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	storeHistory(t, st, 30)
+	manager := New(st)
+	options := DefaultCommitGraphWriteOptions()
+	options.Split = COMMIT_GRAPH_SPLIT
+	if err := manager.WriteCommitGraph(options); err != nil {
+		t.Fatal(err)
+	}
+	// The history grows by 10 commits, which go to a new layer
+	commits, _ := storeHistory(t, st, 40)
+	manager = New(st)
+	options.Split = COMMIT_GRAPH_SPLIT_NO_MERGE
+	if err := manager.WriteCommitGraph(options); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := st.ReadCommitGraphChain()
+	if err != nil || len(chain) != 2 {
+		t.Fatalf("expected: 2 layers\tactual: %v %v", chain, err)
+	}
+	if err := manager.VerifyCommitGraph(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, id := range commits {
+		commit, ok, err := manager.LookupCommit(id)
+		if err != nil || !ok {
+			t.Fatalf("expected: commit %x in the commit-graph\tactual: %v %v", id, ok, err)
+		}
+		expected, err := manager.readGraphCommit(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(commit.Tree, expected.Tree) || !slices.EqualFunc(commit.Parents, expected.Parents, bytes.Equal) || commit.Date.Unix() != expected.Date {
+			t.Fatalf("expected: %v\tactual: %v", expected, commit)
+		}
+		// The dates of the linear history increase, so the generations are the dates
+		if commit.Generation != expected.Date {
+			t.Fatalf("expected: generation %d of commit %d\tactual: %d", expected.Date, i, commit.Generation)
+		}
+	}
+	walked, err := revlist.Objects(manager, [][]byte{commits[39]}, [][]byte{commits[20]}, revlist.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walked) != 19*3 {
+		t.Fatalf("expected: %d objects\tactual: %d", 19*3, len(walked))
+	}
+
+	// Replacing the chain merges the layers, writing without splitting drops the chain
+	options.Split = COMMIT_GRAPH_SPLIT_REPLACE
+	if err := manager.WriteCommitGraph(options); err != nil {
+		t.Fatal(err)
+	}
+	if chain, err := st.ReadCommitGraphChain(); err != nil || len(chain) != 1 {
+		t.Fatalf("expected: 1 layer\tactual: %v %v", chain, err)
+	}
+	options.Split = COMMIT_GRAPH_NO_SPLIT
+	if err := manager.WriteCommitGraph(options); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.ReadCommitGraphChain(); err == nil {
+		t.Fatalf("expected: no chain")
+	}
+	if err := manager.VerifyCommitGraph(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEncodeCommitGraph(t *testing.T) {
+	id := func(b byte) []byte {
+		return bytes.Repeat([]byte{b}, 20)
+	}
+	// An octopus merge of three roots, one of them committed long after the merge
+	commits := []GraphCommit{
+		{ID: id(1), Tree: id(0xa1), Date: 100},
+		{ID: id(2), Tree: id(0xa2), Date: 200},
+		{ID: id(3), Tree: id(0xa3), Date: 1 << 33},
+		{ID: id(4), Tree: id(0xa4), Parents: [][]byte{id(2), id(1), id(3)}, Date: 300},
+	}
+	if err := setGenerations(commits, &commitGraph{}); err != nil {
+		t.Fatal(err)
+	}
+	if commits[3].Level != 2 || commits[3].Generation != 1<<33+1 {
+		t.Fatalf("expected: level 2 and generation %d\tactual: level %d and generation %d", int64(1<<33+1), commits[3].Level, commits[3].Generation)
+	}
+	var buf bytes.Buffer
+	checksum, err := encodeCommitGraph(commits, &commitGraph{}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range []string{GRAPH_CHUNK_EXTRA_EDGES, GRAPH_CHUNK_GENERATION_DATA_OVERFLOW} {
+		if !bytes.Contains(buf.Bytes(), []byte(chunk)) {
+			t.Fatalf("expected: a %s chunk", chunk)
+		}
+	}
+	layer, err := decodeCommitGraphLayer(buf.Bytes(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(layer.checksum, checksum) || layer.count != len(commits) {
+		t.Fatalf("expected: %d commits with checksum %x\tactual: %d commits with checksum %x", len(commits), checksum, layer.count, layer.checksum)
+	}
+	graph := &commitGraph{layers: []*commitGraphLayer{layer}}
+	for _, expected := range commits {
+		actual, ok, err := graph.lookup(expected.ID)
+		if err != nil || !ok {
+			t.Fatalf("expected: commit %x\tactual: %v %v", expected.ID, ok, err)
+		}
+		if !bytes.Equal(actual.Tree, expected.Tree) || !slices.EqualFunc(actual.Parents, expected.Parents, bytes.Equal) ||
+			actual.Date != expected.Date || actual.Level != expected.Level || actual.Generation != expected.Generation {
+			t.Fatalf("expected: %v\tactual: %v", expected, actual)
+		}
+	}
+	if _, ok, _ := graph.lookup(id(5)); ok {
+		t.Fatalf("expected: commit %x not found", id(5))
+	}
+
+	corrupted := bytes.Clone(buf.Bytes())
+	corrupted[COMMIT_GRAPH_HEADER_SIZE] ^= 0xff
+	if _, err := decodeCommitGraphLayer(corrupted, 0); !errors.Is(err, ErrInvalidCommitGraph) {
+		t.Fatalf("expected: %v\tactual: %v", ErrInvalidCommitGraph, err)
+	}
+}
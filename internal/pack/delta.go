@@ -18,7 +18,6 @@ func applyDelta(instruction []byte, base []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	fmt.Printf("expected base %d base %d target %d\n", len(base), baseObjectSize, targetObjectSize)
 
 	if int(baseObjectSize) != len(base) {
 		return nil, errors.New("invalid base object size")
@@ -34,35 +33,47 @@ func applyDelta(instruction []byte, base []byte) ([]byte, error) {
 		}
 	}
 
+	if targetBuffer.Len() != int(targetObjectSize) {
+		return nil, errors.New("invalid target object size")
+	}
 	return targetBuffer.Bytes(), nil
 }
 
+// applyInstruction applies a single instruction of a delta. Copies hold the offset and the
+// size of the data copied from the base, a size of 0 standing for 0x10000 as in git.
+// Inserts hold the data added, their size is never 0.
 func applyInstruction(instructionReader ByteReader, base []byte, target io.Writer) error {
 	header, err := instructionReader.ReadByte()
 	if err != nil {
 		return err
 	}
 	isCopy := (header & (1 << 7)) != 0
-	fmt.Printf("copy %v \n", isCopy)
 	if isCopy {
-		// Readingg offset
 		offset, err := readSparseInt(header, 4, instructionReader)
 		if err != nil {
 			return err
 		}
 		size, err := readSparseInt(header>>4, 3, instructionReader)
+		if err != nil {
+			return err
+		}
+		if size == 0 {
+			size = DELTA_MAX_COPY
+		}
 
 		// Check if the offset and size are valid given the base object
 		if offset < 0 || (offset+size) > len(base) {
-			return errors.New("valid offset and size")
+			return errors.New("invalid copy offset and size")
 		}
-		copyiedData := base[offset : offset+size]
-		target.Write(copyiedData)
+		target.Write(base[offset : offset+size])
 	} else {
 		size := header & ((1 << 7) - 1)
+		if size == 0 {
+			return errors.New("invalid delta instruction")
+		}
 		dataToAppend := make([]byte, size)
-		if _, err := instructionReader.Read(dataToAppend); err != nil {
-			return err
+		if _, err := io.ReadFull(instructionReader, dataToAppend); err != nil {
+			return fmt.Errorf("truncated delta insert: %w", err)
 		}
 
 		target.Write(dataToAppend)
@@ -89,3 +100,171 @@ func readSparseInt(presentIndex byte, presentIndexBits int, data io.ByteReader)
 	}
 	return value, nil
 }
+
+const (
+	// DELTA_BLOCK_SIZE is the length of the blocks of the base indexed to find copies
+	DELTA_BLOCK_SIZE = 16
+	// DELTA_MAX_BUCKET bounds the offsets kept per block hash, so that a base made of the
+	// same block over and over does not make every lookup scan all of them
+	DELTA_MAX_BUCKET = 64
+	// DELTA_MAX_COPY is the largest copy of a single instruction, as in git
+	DELTA_MAX_COPY = 0x10000
+	// DELTA_MAX_INSERT is the largest insert of a single instruction
+	DELTA_MAX_INSERT = 0x7f
+
+	rollingHashBase = 257
+)
+
+// deltaIndex maps the hash of the blocks of a base to their offsets, the blocks start at
+// every multiple of DELTA_BLOCK_SIZE
+type deltaIndex struct {
+	base   []byte
+	blocks map[uint32][]int
+}
+
+func newDeltaIndex(base []byte) *deltaIndex {
+	index := &deltaIndex{
+		base:   base,
+		blocks: map[uint32][]int{},
+	}
+	for offset := 0; offset+DELTA_BLOCK_SIZE <= len(base); offset += DELTA_BLOCK_SIZE {
+		h := blockHash(base[offset : offset+DELTA_BLOCK_SIZE])
+		if len(index.blocks[h]) < DELTA_MAX_BUCKET {
+			index.blocks[h] = append(index.blocks[h], offset)
+		}
+	}
+	return index
+}
+
+// blockHash is a polynomial hash that rollingHash updates one byte at a time
+func blockHash(block []byte) uint32 {
+	var h uint32
+	for _, b := range block {
+		h = h*rollingHashBase + uint32(b)
+	}
+	return h
+}
+
+// rollingHashOut is the weight of the byte leaving the block
+var rollingHashOut = func() uint32 {
+	w := uint32(1)
+	for i := 1; i < DELTA_BLOCK_SIZE; i++ {
+		w *= rollingHashBase
+	}
+	return w
+}()
+
+func rollingHash(h uint32, out byte, in byte) uint32 {
+	return (h-uint32(out)*rollingHashOut)*rollingHashBase + uint32(in)
+}
+
+// createDelta returns the delta that turns base into target in the format read by
+// applyDelta. No delta is returned when it would be larger than maxSize.
+func createDelta(base []byte, target []byte, maxSize int) ([]byte, bool) {
+	return newDeltaIndex(base).delta(target, maxSize)
+}
+
+func (index *deltaIndex) delta(target []byte, maxSize int) ([]byte, bool) {
+	delta := appendVarint(nil, uint(len(index.base)))
+	delta = appendVarint(delta, uint(len(target)))
+
+	insertStart := 0
+	i := 0
+	var h uint32
+	if len(target) >= DELTA_BLOCK_SIZE {
+		h = blockHash(target[:DELTA_BLOCK_SIZE])
+	}
+	for i+DELTA_BLOCK_SIZE <= len(target) {
+		offset, start, length := index.match(target, i, h, insertStart)
+		if length < DELTA_BLOCK_SIZE {
+			if i+DELTA_BLOCK_SIZE < len(target) {
+				h = rollingHash(h, target[i], target[i+DELTA_BLOCK_SIZE])
+			}
+			i++
+			continue
+		}
+		delta = appendInserts(delta, target[insertStart:start])
+		delta = appendCopies(delta, offset, length)
+		if len(delta) > maxSize {
+			return nil, false
+		}
+		i = start + length
+		insertStart = i
+		if i+DELTA_BLOCK_SIZE <= len(target) {
+			h = blockHash(target[i : i+DELTA_BLOCK_SIZE])
+		}
+	}
+	delta = appendInserts(delta, target[insertStart:])
+	if len(delta) > maxSize {
+		return nil, false
+	}
+	return delta, true
+}
+
+// match returns the longest copy from the base for the block of target at i, whose hash
+// is h. The copy is extended backwards over the bytes not yet copied, down to insertStart.
+func (index *deltaIndex) match(target []byte, i int, h uint32, insertStart int) (offset int, start int, length int) {
+	for _, candidate := range index.blocks[h] {
+		n := 0
+		for candidate+n < len(index.base) && i+n < len(target) && index.base[candidate+n] == target[i+n] {
+			n++
+		}
+		if n < DELTA_BLOCK_SIZE {
+			continue
+		}
+		back := 0
+		for candidate-back > 0 && i-back > insertStart && index.base[candidate-back-1] == target[i-back-1] {
+			back++
+		}
+		if n+back > length {
+			offset, start, length = candidate-back, i-back, n+back
+		}
+	}
+	return offset, start, length
+}
+
+// appendInserts appends instructions inserting data, at most DELTA_MAX_INSERT bytes each
+func appendInserts(delta []byte, data []byte) []byte {
+	for len(data) > 0 {
+		n := min(len(data), DELTA_MAX_INSERT)
+		delta = append(delta, byte(n))
+		delta = append(delta, data[:n]...)
+		data = data[n:]
+	}
+	return delta
+}
+
+// appendCopies appends instructions copying length bytes of the base from offset, at most
+// DELTA_MAX_COPY bytes each. Only the bytes of the offset and size that are not zero are
+// written, flagged in the first byte.
+func appendCopies(delta []byte, offset int, length int) []byte {
+	for length > 0 {
+		size := min(length, DELTA_MAX_COPY)
+		header := len(delta)
+		delta = append(delta, 0x80)
+		for i := 0; i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				delta[header] |= 1 << i
+				delta = append(delta, b)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if b := byte(size >> (8 * i)); b != 0 {
+				delta[header] |= 0x10 << i
+				delta = append(delta, b)
+			}
+		}
+		offset += size
+		length -= size
+	}
+	return delta
+}
+
+// appendVarint appends the sizes of the delta header, 7 bits at a time from the lowest ones
+func appendVarint(b []byte, value uint) []byte {
+	for value >= 0x80 {
+		b = append(b, byte(value)|0x80)
+		value >>= 7
+	}
+	return append(b, byte(value))
+}
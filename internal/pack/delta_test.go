@@ -2,6 +2,8 @@ package pack
 
 import (
 	"bytes"
+	"math/rand"
+	"slices"
 	"testing"
 )
 
@@ -18,3 +20,62 @@ func TestDecodeSparseInt(t *testing.T) {
 	}
 
 }
+
+func TestCreateDelta(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	base := make([]byte, 3*DELTA_MAX_COPY)
+	random.Read(base)
+	target := slices.Concat(base[:1000], []byte("inserted"), base[5000:5000+2*DELTA_MAX_COPY], base[100:200])
+	for _, test := range []struct {
+		base   []byte
+		target []byte
+	}{
+		{base, target},
+		{base, []byte("short")},
+		{[]byte("short"), target[:100]},
+		{nil, []byte("hello world\n")},
+	} {
+		delta, ok := createDelta(test.base, test.target, len(test.target)+1000)
+		if !ok {
+			t.Fatalf("expected a delta of %d bytes", len(test.target))
+		}
+		result, err := applyDelta(delta, test.base)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(result, test.target) {
+			t.Fatalf("expected: %d bytes\tactual: %d bytes", len(test.target), len(result))
+		}
+	}
+
+	// Copies make the delta of similar objects small
+	delta, _ := createDelta(base, target, len(target))
+	if len(delta) > 100 {
+		t.Fatalf("expected: delta under 100 bytes\tactual: %d bytes", len(delta))
+	}
+	if _, ok := createDelta(base, target, 10); ok {
+		t.Fatalf("expected no delta under 10 bytes")
+	}
+}
+
+func TestApplyDeltaFullCopy(t *testing.T) {
+	base := make([]byte, DELTA_MAX_COPY+10)
+	rand.New(rand.NewSource(1)).Read(base)
+	// A copy without size bytes copies 0x10000 bytes, followed by a copy of the rest
+	delta := appendVarint(nil, uint(len(base)))
+	delta = appendVarint(delta, uint(len(base)))
+	delta = append(delta, 0x80, 0x94, 0x01, 10)
+	target, err := applyDelta(delta, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(target, base) {
+		t.Fatalf("expected: %d bytes of the base\tactual: %d bytes", len(base), len(target))
+	}
+
+	// The size of the target is checked
+	delta[len(appendVarint(nil, uint(len(base))))]++
+	if _, err := applyDelta(delta, base); err == nil {
+		t.Fatalf("expected a target size mismatch to fail")
+	}
+}
@@ -0,0 +1,188 @@
+package pack
+
+import (
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+const (
+	PACK_VERSION = 2
+)
+
+var (
+	ErrBaseNotWritten = errors.New("delta base is not written in the pack")
+)
+
+// Encoder writes a pack entry by entry, so that a pack never has to fit in memory. The
+// offset of every entry is recorded so that the index of the pack can be written once it
+// is closed.
+type Encoder struct {
+	hashWriter *hash.HashWriter
+	w          *countingWriter
+	numObjects int
+	written    int
+	// entries holds the written objects, in the order of the pack
+	entries  []IndexEntry
+	offsets  map[string]int
+	checksum []byte
+}
+
+// NewEncoder writes the pack header to w. Exactly numObjects objects must be encoded before closing the encoder.
+func NewEncoder(w io.Writer, numObjects int) (*Encoder, error) {
+	hashWriter := hash.NewHashWriter(w, hash.SHA1)
+	e := &Encoder{
+		hashWriter: hashWriter,
+		w:          &countingWriter{w: hashWriter},
+		numObjects: numObjects,
+		offsets:    map[string]int{},
+	}
+	header := make([]byte, HEADER_LEN)
+	copy(header, "PACK")
+	binary.BigEndian.PutUint32(header[4:8], PACK_VERSION)
+	binary.BigEndian.PutUint32(header[8:], uint32(numObjects))
+	if err := e.write(header); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Encode writes an undeltified entry holding content
+func (e *Encoder) Encode(objectType common.ObjectType, content []byte) error {
+	id, err := common.NewObjectBuffer(objectType, content).Hash()
+	if err != nil {
+		return err
+	}
+	return e.encode(id, encodeEntryHeader(objectType, uint(len(content))), content)
+}
+
+// EncodeOfsDelta writes the object id as a delta against baseID, which must already be
+// written in the pack. The base is referred to by its offset.
+func (e *Encoder) EncodeOfsDelta(id []byte, baseID []byte, delta []byte) error {
+	baseOffset, ok := e.offsets[hash.ChecksumToHex(baseID)]
+	if !ok {
+		return fmt.Errorf("%w: %x", ErrBaseNotWritten, baseID)
+	}
+	header := encodeEntryHeader(common.OBJ_OFS_DELTA, uint(len(delta)))
+	header = append(header, encodeOffset(uint(e.w.n-baseOffset))...)
+	return e.encode(id, header, delta)
+}
+
+// EncodeRefDelta writes the object id as a delta against baseID, which is referred to by
+// its id. The base does not have to be in the pack, which is then a thin pack.
+func (e *Encoder) EncodeRefDelta(id []byte, baseID []byte, delta []byte) error {
+	header := encodeEntryHeader(common.OBJ_REF_DELTA, uint(len(delta)))
+	header = append(header, baseID...)
+	return e.encode(id, header, delta)
+}
+
+// Contains reports whether the object id is already written in the pack
+func (e *Encoder) Contains(id []byte) bool {
+	_, ok := e.offsets[hash.ChecksumToHex(id)]
+	return ok
+}
+
+func (e *Encoder) encode(id []byte, header []byte, content []byte) error {
+	if e.written >= e.numObjects {
+		return errors.New("number of objects exceeds the pack header")
+	}
+	offset := e.w.n
+	e.w.crc = 0
+	if err := writeEntry(e.w, header, content); err != nil {
+		return err
+	}
+	e.entries = append(e.entries, IndexEntry{ID: id, Offset: offset, CRC: e.w.crc})
+	e.offsets[hash.ChecksumToHex(id)] = offset
+	e.written++
+	return nil
+}
+
+// writeEntry writes the header of an entry followed by its compressed content
+func writeEntry(w io.Writer, header []byte, content []byte) error {
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	comp := zlib.NewWriter(w)
+	if _, err := comp.Write(content); err != nil {
+		return err
+	}
+	return comp.Close()
+}
+
+func (e *Encoder) write(p []byte) error {
+	_, err := e.w.Write(p)
+	return err
+}
+
+// countingWriter counts the bytes written through it, which is the offset of the next
+// entry, and computes the CRC of the entry being written
+type countingWriter struct {
+	w   io.Writer
+	n   int
+	crc uint32
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	c.crc = crc32.Update(c.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+// Close writes the trailing checksum and returns it
+func (e *Encoder) Close() ([]byte, error) {
+	if e.written != e.numObjects {
+		return nil, errors.New("number of objects does not match the pack header")
+	}
+	checksum := e.hashWriter.Sum(nil)
+	if _, err := e.hashWriter.Write(checksum); err != nil {
+		return nil, err
+	}
+	e.checksum = checksum
+	return checksum, nil
+}
+
+// Checksum returns the trailing checksum of the pack once it is closed
+func (e *Encoder) Checksum() []byte {
+	return e.checksum
+}
+
+// WriteIndex writes the index of the pack to w. The encoder must be closed first.
+func (e *Encoder) WriteIndex(w io.Writer) error {
+	if e.checksum == nil {
+		return errors.New("pack is not closed")
+	}
+	return encodeIndex(e.entries, e.checksum, w)
+}
+
+// encodeEntryHeader encodes the type and the size of an entry. The first byte holds the type and
+// the lowest 4 bits of the size, each following byte holds the next 7 bits of the size.
+func encodeEntryHeader(objectType common.ObjectType, size uint) []byte {
+	b := byte(objectType&0x7)<<4 | byte(size&0xf)
+	size >>= 4
+	header := []byte{}
+	for size > 0 {
+		header = append(header, b|byte(SIZE_ENCODING_FLAG_MASK))
+		b = byte(size & 0x7f)
+		size >>= SIZE_ENCODING_DATA_BITS
+	}
+	return append(header, b)
+}
+
+// encodeOffset encodes the distance to the base of an ofs delta, the reverse of
+// readOffsetEncoding. The most significant bits come first and every byte but the last
+// one adds one to the value, so that no two encodings stand for the same offset.
+func encodeOffset(offset uint) []byte {
+	encoded := []byte{byte(offset & 0x7f)}
+	for offset >>= 7; offset > 0; offset >>= 7 {
+		offset--
+		encoded = append([]byte{byte(SIZE_ENCODING_FLAG_MASK) | byte(offset&0x7f)}, encoded...)
+	}
+	return encoded
+}
@@ -0,0 +1,167 @@
+package pack
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+func TestEncodeOffset(t *testing.T) {
+	for _, offset := range []uint{0, 1, 127, 128, 129, 16511, 16512, 1 << 20, 1<<32 + 5} {
+		decoded, err := readOffsetEncoding(bytes.NewReader(encodeOffset(offset)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded != offset {
+			t.Fatalf("expected: %d\tactual: %d", offset, decoded)
+		}
+	}
+}
+
+func TestEncoderDeltas(t *testing.T) {
+	base := []byte("hello world\n")
+	target := []byte("hello there\n")
+	baseID, _ := common.NewObjectBuffer(common.OBJ_BLOB, base).Hash()
+	targetID, _ := common.NewObjectBuffer(common.OBJ_BLOB, target).Hash()
+	// Copy "hello " from the base and insert "there\n"
+	delta := []byte{12, 12, 0x90, 6, 6, 't', 'h', 'e', 'r', 'e', '\n'}
+
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.EncodeOfsDelta(targetID, baseID, delta); err == nil {
+		t.Fatalf("expected an ofs delta before its base to be refused")
+	}
+	if err := encoder.Encode(common.OBJ_BLOB, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.EncodeOfsDelta(targetID, baseID, delta); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.EncodeRefDelta(targetID, baseID, delta); err != nil {
+		t.Fatal(err)
+	}
+	checksum, err := encoder.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index bytes.Buffer
+	if err := encoder.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+
+	packfile, err := newPackfileFromBytes(checksum, buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []common.ObjectType{common.OBJ_BLOB, common.OBJ_OFS_DELTA, common.OBJ_REF_DELTA}
+	offsets := []int{}
+	for i, objectType := range expected {
+		object := &PackObject{}
+		offset, err := packfile.ReadObject(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+		if object.objectType != objectType {
+			t.Fatalf("entry %d\texpected: %s\tactual: %s", i, objectType, object.objectType)
+		}
+		if objectType == common.OBJ_OFS_DELTA && offset-object.baseOffset != offsets[0] {
+			t.Fatalf("expected: base at %d\tactual: base at %d", offsets[0], offset-object.baseOffset)
+		}
+		if objectType == common.OBJ_REF_DELTA && !bytes.Equal(object.baseChecksum, baseID) {
+			t.Fatalf("expected: base %x\tactual: base %x", baseID, object.baseChecksum)
+		}
+		if isDeltaObject(objectType) {
+			content, err := applyDelta(object.content, base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, target) {
+				t.Fatalf("expected: %q\tactual: %q", target, content)
+			}
+		}
+	}
+
+	ids, packChecksum, err := IndexObjects(index.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packChecksum, checksum) || len(ids) != 3 {
+		t.Fatalf("expected: 3 objects of pack %x\tactual: %d objects of pack %x", checksum, len(ids), packChecksum)
+	}
+	offset, found, err := search(baseID, bytes.NewReader(index.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || offset != offsets[0] {
+		t.Fatalf("expected: %x at %d\tactual: found=%v at %d", baseID, offsets[0], found, offset)
+	}
+}
+
+func TestManagerWrite(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := [][]byte{[]byte("first\n"), []byte("second\n")}
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, len(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := [][]byte{}
+	for _, content := range contents {
+		if err := encoder.Encode(common.OBJ_BLOB, content); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := common.NewObjectBuffer(common.OBJ_BLOB, content).Hash()
+		ids = append(ids, id)
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	manager := New(st)
+	if _, err := manager.From(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the second blob is written to the new pack
+	checksum, err := manager.Write([]ObjectToPack{{ID: ids[1]}}, DefaultWriteOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := st.NewPackIndexReader(hash.ChecksumToHex(checksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	index, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexed, _, err := IndexObjects(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexed) != 1 || !bytes.Equal(indexed[0], ids[1]) {
+		t.Fatalf("expected: [%x]\tactual: %x", ids[1], indexed)
+	}
+	object, err := New(st).Object(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, contents[1]) {
+		t.Fatalf("expected: %q\tactual: %q", contents[1], content)
+	}
+}
@@ -0,0 +1,208 @@
+package pack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+const (
+	// EWAH_RUNNING_LENGTH_BITS is the width of the count of fill words of a run length word,
+	// which follows its running bit
+	EWAH_RUNNING_LENGTH_BITS = 32
+	// EWAH_LITERAL_BITS is the width of the count of literal words of a run length word
+	EWAH_LITERAL_BITS = 31
+	EWAH_HEADER_SIZE  = 8
+	EWAH_WORD_SIZE    = 8
+)
+
+var (
+	ErrInvalidEWAH = errors.New("invalid EWAH bitmap")
+)
+
+// Bitmap is a set of bit positions, held uncompressed in memory. Files store bitmaps
+// compressed with EWAH.
+type Bitmap struct {
+	words []uint64
+}
+
+func NewBitmap() *Bitmap {
+	return &Bitmap{}
+}
+
+// Set adds the position i
+func (b *Bitmap) Set(i int) {
+	for len(b.words) <= i/64 {
+		b.words = append(b.words, 0)
+	}
+	b.words[i/64] |= 1 << (i % 64)
+}
+
+// Has reports whether the position i is set
+func (b *Bitmap) Has(i int) bool {
+	return i/64 < len(b.words) && b.words[i/64]&(1<<(i%64)) != 0
+}
+
+// Or adds the positions of other
+func (b *Bitmap) Or(other *Bitmap) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, word := range other.words {
+		b.words[i] |= word
+	}
+}
+
+// And keeps the positions also set in other
+func (b *Bitmap) And(other *Bitmap) {
+	for i := range b.words {
+		if i < len(other.words) {
+			b.words[i] &= other.words[i]
+		} else {
+			b.words[i] = 0
+		}
+	}
+}
+
+// AndNot removes the positions of other
+func (b *Bitmap) AndNot(other *Bitmap) {
+	for i := range min(len(b.words), len(other.words)) {
+		b.words[i] &^= other.words[i]
+	}
+}
+
+// Xor flips the positions set in other
+func (b *Bitmap) Xor(other *Bitmap) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, word := range other.words {
+		b.words[i] ^= word
+	}
+}
+
+// Count returns the number of positions set
+func (b *Bitmap) Count() int {
+	count := 0
+	for _, word := range b.words {
+		count += bits.OnesCount64(word)
+	}
+	return count
+}
+
+// Positions returns the positions set in increasing order
+func (b *Bitmap) Positions() []int {
+	positions := make([]int, 0, b.Count())
+	for i, word := range b.words {
+		for word != 0 {
+			positions = append(positions, i*64+bits.TrailingZeros64(word))
+			word &= word - 1
+		}
+	}
+	return positions
+}
+
+func (b *Bitmap) Clone() *Bitmap {
+	return &Bitmap{words: slices.Clone(b.words)}
+}
+
+// size is the number of bits up to the last position set
+func (b *Bitmap) size() int {
+	for i := len(b.words) - 1; i >= 0; i-- {
+		if b.words[i] != 0 {
+			return i*64 + 64 - bits.LeadingZeros64(b.words[i])
+		}
+	}
+	return 0
+}
+
+// encodeEWAH writes the bitmap compressed with EWAH, as git does: the number of bits, the
+// number of words, the words and the position of the last run length word. Each run length
+// word gives a running bit and how many words are filled with it, followed by the number
+// of literal words that come next.
+func encodeEWAH(b *Bitmap, w io.Writer) error {
+	words := b.words[:(b.size()+63)/64]
+	buffer := []uint64{}
+	lastRLW := 0
+	for i := 0; i == 0 || i < len(words); {
+		running, fill := uint64(0), uint64(0)
+		if i < len(words) && words[i] == ^uint64(0) {
+			running, fill = 1, ^uint64(0)
+		}
+		run := 0
+		for i < len(words) && words[i] == fill && run < 1<<EWAH_RUNNING_LENGTH_BITS-1 {
+			run++
+			i++
+		}
+		start := i
+		for i < len(words) && words[i] != 0 && words[i] != ^uint64(0) && i-start < 1<<EWAH_LITERAL_BITS-1 {
+			i++
+		}
+		lastRLW = len(buffer)
+		buffer = append(buffer, running|uint64(run)<<1|uint64(i-start)<<(1+EWAH_RUNNING_LENGTH_BITS))
+		buffer = append(buffer, words[start:i]...)
+		if len(words) == 0 {
+			break
+		}
+	}
+	header := make([]byte, EWAH_HEADER_SIZE)
+	binary.BigEndian.PutUint32(header, uint32(b.size()))
+	binary.BigEndian.PutUint32(header[4:], uint32(len(buffer)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, buffer); err != nil {
+		return err
+	}
+	return binary.Write(w, binary.BigEndian, uint32(lastRLW))
+}
+
+// decodeEWAH decodes a bitmap compressed with EWAH and returns it along with the number
+// of bytes it took
+func decodeEWAH(data []byte) (*Bitmap, int, error) {
+	if len(data) < EWAH_HEADER_SIZE {
+		return nil, 0, fmt.Errorf("%w: truncated header", ErrInvalidEWAH)
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	count := int(binary.BigEndian.Uint32(data[4:]))
+	end := EWAH_HEADER_SIZE + count*EWAH_WORD_SIZE + 4
+	if count > len(data)/EWAH_WORD_SIZE || len(data) < end {
+		return nil, 0, fmt.Errorf("%w: truncated words", ErrInvalidEWAH)
+	}
+	// Bits past the size are never set, which bounds the words of the bitmap
+	maxWords := (size + 63) / 64
+	b := &Bitmap{words: make([]uint64, 0, maxWords)}
+	position := 0
+	for i := 0; i < count; {
+		rlw := binary.BigEndian.Uint64(data[EWAH_HEADER_SIZE+i*EWAH_WORD_SIZE:])
+		i++
+		run := int(rlw >> 1 & (1<<EWAH_RUNNING_LENGTH_BITS - 1))
+		literals := int(rlw >> (1 + EWAH_RUNNING_LENGTH_BITS))
+		if i+literals > count {
+			return nil, 0, fmt.Errorf("%w: truncated words", ErrInvalidEWAH)
+		}
+		// Runs of zeros may go past the size, they are only counted
+		if rlw&1 == 0 {
+			position += run
+			run = 0
+		}
+		if run+literals > 0 && position+run+literals > maxWords {
+			return nil, 0, fmt.Errorf("%w: bits set past the size of %d bits", ErrInvalidEWAH, size)
+		}
+		for len(b.words) < position {
+			b.words = append(b.words, 0)
+		}
+		for range run {
+			b.words = append(b.words, ^uint64(0))
+		}
+		for range literals {
+			b.words = append(b.words, binary.BigEndian.Uint64(data[EWAH_HEADER_SIZE+i*EWAH_WORD_SIZE:]))
+			i++
+		}
+		position = len(b.words)
+	}
+	return b, end, nil
+}
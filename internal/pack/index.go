@@ -1,14 +1,16 @@
 package pack
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"slices"
+	"sort"
 
-	common "github.com/codecrafters-io/git-starter-go/internal"
 	"github.com/codecrafters-io/git-starter-go/internal/hash"
 	store "github.com/codecrafters-io/git-starter-go/internal/stor"
 )
@@ -24,17 +26,23 @@ const (
 
 	OFFSET_SIZE      = 4
 	TABLE_ENTRY_SIZE = OFFSET_SIZE + CHECKSUM_LEN
-)
 
-// LevelEntry represents an entry in the second layer of the fan-out table
-type LevelEntry struct {
-	checksum []byte
-	offset   uint32
-}
+	// A version 2 index starts with its magic and version, and holds offsets above
+	// INDEX_MAX_SMALL_OFFSET in a table of their own, flagged in the table of offsets
+	INDEX_V2_HEADER_SIZE    = 8
+	INDEX_V2_FANOUT         = INDEX_V2_HEADER_SIZE
+	INDEX_V2_NAMES          = INDEX_V2_FANOUT + HEADER_SIZE
+	INDEX_MAX_SMALL_OFFSET  = 0x7fffffff
+	INDEX_LARGE_OFFSET_FLAG = 0x80000000
+	LARGE_OFFSET_SIZE       = 8
+	CRC_SIZE                = 4
+)
 
 type Indexfile struct {
 	file     store.ReadOnlyFile
 	packfile []byte
+	// ids maps the offsets of the objects to their ids, it is read on first use
+	ids map[int][]byte
 }
 
 func NewIndexfile(packChecksum []byte, file store.ReadOnlyFile) *Indexfile {
@@ -44,26 +52,149 @@ func NewIndexfile(packChecksum []byte, file store.ReadOnlyFile) *Indexfile {
 	}
 }
 
-func writeIndex(packfile *Packfile, w io.Writer) error {
+// IndexEntry is an object of a pack as listed by the index of the pack
+type IndexEntry struct {
+	ID     []byte
+	Offset int
+	// CRC is the CRC-32 of the entry as stored in the pack, compressed
+	CRC uint32
+}
+
+// encodeIndex writes a version 2 index of the pack whose objects are entries, as git does
+// by default. The fan-out table is followed by the tables of the ids, the CRCs and the
+// offsets of the objects sorted by id, then the offsets too large for 31 bits, the
+// checksum of the pack and the checksum of the index.
+func encodeIndex(entries []IndexEntry, packChecksum []byte, w io.Writer) error {
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b IndexEntry) int {
+		return bytes.Compare(a.ID, b.ID)
+	})
 	hashWriter := hash.NewHashWriter(w, hash.SHA1)
+	bw := bufio.NewWriter(hashWriter)
 
-	if err := writeFanoutTable(packfile, hashWriter); err != nil {
-		return err
+	bw.Write(INDEX_V2_MAGIC)
+	binary.Write(bw, binary.BigEndian, uint32(2))
+	fanout := [HEADER_ENTRIES]uint32{}
+	for _, entry := range sorted {
+		fanout[entry.ID[0]]++
 	}
-
-	if _, err := hashWriter.Write(packfile.checksum); err != nil {
+	for i := 1; i < HEADER_ENTRIES; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(bw, binary.BigEndian, fanout)
+	for _, entry := range sorted {
+		bw.Write(entry.ID)
+	}
+	for _, entry := range sorted {
+		binary.Write(bw, binary.BigEndian, entry.CRC)
+	}
+	largeOffsets := []uint64{}
+	for _, entry := range sorted {
+		offset := uint32(entry.Offset)
+		if entry.Offset > INDEX_MAX_SMALL_OFFSET {
+			offset = INDEX_LARGE_OFFSET_FLAG | uint32(len(largeOffsets))
+			largeOffsets = append(largeOffsets, uint64(entry.Offset))
+		}
+		binary.Write(bw, binary.BigEndian, offset)
+	}
+	binary.Write(bw, binary.BigEndian, largeOffsets)
+	bw.Write(packChecksum)
+	if err := bw.Flush(); err != nil {
 		return err
 	}
-	indexChecksum := hashWriter.Sum(nil)
-	w.Write(indexChecksum)
-	return nil
+	_, err := w.Write(hashWriter.Sum(nil))
+	return err
 }
 
 func (index *Indexfile) search(checksum []byte) (offset int, found bool, err error) {
 	return search(checksum, index.file)
 }
 
+// objectAt returns the id of the object at offset in the packfile
+func (index *Indexfile) objectAt(offset int) ([]byte, bool, error) {
+	if index.ids == nil {
+		data, err := io.ReadAll(io.NewSectionReader(index.file, 0, math.MaxInt64))
+		if err != nil {
+			return nil, false, err
+		}
+		entries, _, err := DecodeIndex(data)
+		if err != nil {
+			return nil, false, err
+		}
+		index.ids = make(map[int][]byte, len(entries))
+		for _, entry := range entries {
+			index.ids[entry.Offset] = entry.ID
+		}
+	}
+	id, ok := index.ids[offset]
+	return id, ok, nil
+}
+
+// search returns the offset of an object in the pack of a version 1 or 2 index
 func search(targetChecksum []byte, index io.ReaderAt) (offset int, found bool, err error) {
+	header := make([]byte, INDEX_V2_HEADER_SIZE)
+	if _, err := index.ReadAt(header, 0); err != nil {
+		return offset, found, err
+	}
+	if bytes.HasPrefix(header, INDEX_V2_MAGIC) {
+		return searchV2(targetChecksum, index)
+	}
+	return searchV1(targetChecksum, index)
+}
+
+// searchV2 bisects the ids of the fan-out bucket of the first byte of the object
+func searchV2(targetChecksum []byte, index io.ReaderAt) (offset int, found bool, err error) {
+	bounds := make([]byte, 2*HEADER_ENTRY_SIZE)
+	readOffset := INDEX_V2_FANOUT + (int(targetChecksum[0])-1)*HEADER_ENTRY_SIZE
+	if targetChecksum[0] == 0 {
+		readOffset += HEADER_ENTRY_SIZE
+		bounds = bounds[HEADER_ENTRY_SIZE:]
+	}
+	if _, err := index.ReadAt(bounds, int64(readOffset)); err != nil {
+		return offset, found, err
+	}
+	start, end := uint32(0), binary.BigEndian.Uint32(bounds[len(bounds)-HEADER_ENTRY_SIZE:])
+	if targetChecksum[0] > 0 {
+		start = binary.BigEndian.Uint32(bounds[:HEADER_ENTRY_SIZE])
+	}
+	if end <= start {
+		return offset, found, nil
+	}
+	names := make([]byte, int(end-start)*CHECKSUM_LEN)
+	if _, err := index.ReadAt(names, int64(INDEX_V2_NAMES+int(start)*CHECKSUM_LEN)); err != nil {
+		return offset, found, err
+	}
+	position := sort.Search(int(end-start), func(i int) bool {
+		return bytes.Compare(names[i*CHECKSUM_LEN:(i+1)*CHECKSUM_LEN], targetChecksum) >= 0
+	})
+	if position == int(end-start) || !bytes.Equal(names[position*CHECKSUM_LEN:(position+1)*CHECKSUM_LEN], targetChecksum) {
+		return offset, found, nil
+	}
+	position += int(start)
+
+	last := make([]byte, HEADER_ENTRY_SIZE)
+	if _, err := index.ReadAt(last, INDEX_V2_FANOUT+HEADER_SIZE-HEADER_ENTRY_SIZE); err != nil {
+		return offset, found, err
+	}
+	count := int(binary.BigEndian.Uint32(last))
+	offsets := INDEX_V2_NAMES + count*(CHECKSUM_LEN+CRC_SIZE)
+	small := make([]byte, OFFSET_SIZE)
+	if _, err := index.ReadAt(small, int64(offsets+position*OFFSET_SIZE)); err != nil {
+		return offset, found, err
+	}
+	value := binary.BigEndian.Uint32(small)
+	if value&INDEX_LARGE_OFFSET_FLAG == 0 {
+		return int(value), true, nil
+	}
+	large := make([]byte, LARGE_OFFSET_SIZE)
+	largeOffset := offsets + count*OFFSET_SIZE + int(value&^INDEX_LARGE_OFFSET_FLAG)*LARGE_OFFSET_SIZE
+	if _, err := index.ReadAt(large, int64(largeOffset)); err != nil {
+		return offset, found, err
+	}
+	return int(binary.BigEndian.Uint64(large)), true, nil
+}
+
+func searchV1(targetChecksum []byte, index io.ReaderAt) (offset int, found bool, err error) {
 
 	var readOffset int
 	readSize := 4
@@ -110,225 +241,84 @@ func search(targetChecksum []byte, index io.ReaderAt) (offset int, found bool, e
 	return offset, found, nil
 }
 
-func writeFanoutTable(r *Packfile, w io.Writer) error {
-	firstLevel := [256]uint32{}
-	secondLevel := make([]LevelEntry, r.TotalObjects())
+var (
+	// INDEX_V2_MAGIC starts the version 2 indexes written by git. Version 1 indexes start
+	// directly with the fan-out table.
+	INDEX_V2_MAGIC = []byte{0xff, 't', 'O', 'c'}
 
-	checksumToOffset := map[string]int{}
-
-	for i := 0; i < r.TotalObjects(); i++ {
-		objectEntry := &PackObject{}
-		offset, err := r.ReadObject(objectEntry)
-		if err != nil {
-
-			return err
-		}
-
-		fmt.Printf("type: %s\n", objectEntry.objectType)
-
-		var targetObject common.Object
-
-		// Entry is an undeltified object
-		if !isDeltaObject(objectEntry.objectType) {
-			targetObject = common.NewObjectBuffer(objectEntry.objectType, objectEntry.content)
-		} else {
-
-			// Etry is a deltified object
-			var baseObjectBuffer []byte
-			var objectType common.ObjectType
-
-			stack := &Stack[*PackObject]{}
-			// push object into stack
-			cur := objectEntry
-			for {
-				ancestor := &PackObject{}
-				fmt.Println("adding base")
-				fmt.Printf("%x %d\n", cur.baseChecksum, cur.baseOffset)
-				stack.Push(cur)
-				if cur.objectType == common.OBJ_OFS_DELTA {
-					err := r.ReadObjectAt(int64(offset-objectEntry.baseOffset), ancestor)
-					if err != nil {
-						return err
-					}
-				} else {
-					baseOffset, ok := checksumToOffset[hash.ChecksumToHex(cur.baseChecksum)]
-					if !ok {
-						return errors.New("base object is not in current file")
-					}
-					err := r.ReadObjectAt(int64(baseOffset), ancestor)
-					if err != nil {
-						return err
-					}
-
-					if !isDeltaObject(ancestor.objectType) {
-						baseObjectBuffer = ancestor.content
-						objectType = ancestor.objectType
-						break
-					} else {
-						cur = ancestor
-					}
-				}
-			}
-
-			// while the stock in not empty
-			for !stack.Empty() {
-				deltaObject, _ := stack.Pop()
-				baseObjectBuffer, err = applyDelta(deltaObject.content, baseObjectBuffer)
-				if err != nil {
-					return err
-				}
-			}
-
-			targetObject = common.NewObjectBuffer(objectType, baseObjectBuffer)
-		}
-		checksum, err := targetObject.Hash()
-		if err != nil {
-			return err
-		}
-		checksumToOffset[hash.ChecksumToHex(checksum)] = offset
-
-		// Add checksum and offset to the map
-
-		secondLevel[i] = LevelEntry{offset: uint32(offset), checksum: checksum}
-		fmt.Printf("%x %d %d\n", checksum, offset, objectEntry.size)
-		firstLevel[int(checksum[0])]++
+	ErrInvalidIndex = errors.New("invalid pack index")
+)
 
+// IndexObjects returns the ids of the objects listed by a version 1 or 2 pack index, as
+// downloaded from a dumb server, along with the checksum of the pack it describes. The
+// checksum of the index is verified.
+func IndexObjects(index []byte) (ids [][]byte, packChecksum []byte, err error) {
+	entries, packChecksum, err := DecodeIndex(index)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	// Sort the second level entry by the checksum
-	slices.SortFunc(secondLevel, func(i, j LevelEntry) int {
-		return bytes.Compare(i.checksum, j.checksum)
-	})
-
-	for i := 1; i < len(firstLevel); i++ {
-		firstLevel[i] = firstLevel[i] + firstLevel[i-1]
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
 	}
+	return ids, packChecksum, nil
+}
 
-	for _, accCount := range firstLevel {
-		if err := writeUnit32BigEndian(accCount, w); err != nil {
-			return err
-		}
-
+// DecodeIndex returns the objects listed by a version 1 or 2 pack index sorted by id,
+// along with the checksum of the pack it describes. The checksum of the index is
+// verified. Version 1 indexes hold no CRC.
+func DecodeIndex(index []byte) (entries []IndexEntry, packChecksum []byte, err error) {
+	if len(index) < HEADER_SIZE+2*CHECKSUM_LEN {
+		return nil, nil, fmt.Errorf("%w: too small", ErrInvalidIndex)
+	}
+	trailer := len(index) - CHECKSUM_LEN
+	sum := hash.New(hash.SHA1)
+	sum.Write(index[:trailer])
+	if !bytes.Equal(sum.Sum(nil), index[trailer:]) {
+		return nil, nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidIndex)
 	}
+	packChecksum = index[trailer-CHECKSUM_LEN : trailer]
+	tables := index[:trailer-CHECKSUM_LEN]
 
-	for _, entry := range secondLevel {
-		if err := writeUnit32BigEndian(entry.offset, w); err != nil {
-			return err
+	if !bytes.HasPrefix(index, INDEX_V2_MAGIC) {
+		count := int(binary.BigEndian.Uint32(index[HEADER_SIZE-HEADER_ENTRY_SIZE:]))
+		if len(tables) != HEADER_SIZE+count*TABLE_ENTRY_SIZE {
+			return nil, nil, fmt.Errorf("%w: truncated object table", ErrInvalidIndex)
 		}
-		if _, err := w.Write(entry.checksum); err != nil {
-			return err
+		for i := 0; i < count; i++ {
+			entry := index[HEADER_SIZE+i*TABLE_ENTRY_SIZE : HEADER_SIZE+(i+1)*TABLE_ENTRY_SIZE]
+			entries = append(entries, IndexEntry{
+				ID:     entry[OFFSET_SIZE:],
+				Offset: int(binary.BigEndian.Uint32(entry[:OFFSET_SIZE])),
+			})
 		}
+		return entries, packChecksum, nil
 	}
 
-	return nil
-}
-
-func writeUnit32BigEndian(i uint32, w io.Writer) error {
-	chunk := make([]byte, 4)
-	binary.BigEndian.PutUint32(chunk, i)
-	if _, err := w.Write(chunk); err != nil {
-		return err
+	if version := binary.BigEndian.Uint32(index[4:8]); version != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidIndex, version)
 	}
-	return nil
-}
-
-// readSecondLevelEntry reads a secondary entry from r
-func readSecondLevelEntry(r io.Reader) (entry LevelEntry, err error) {
-	offsetBytes := make([]byte, 4)
-	if _, err := r.Read(offsetBytes); err != nil {
-		return entry, err
-	}
-	entry.offset = binary.BigEndian.Uint32(offsetBytes)
-	checksumBytes := make([]byte, common.CHECKSUM_LEN)
-	if _, err := r.Read(checksumBytes); err != nil {
-		return entry, err
-	}
-	return entry, err
-}
-
-func readUint32BigEndian(r io.Reader) (uint32, error) {
-	chunk := make([]byte, 4)
-	if _, err := r.Read(chunk); err != nil {
-		return 0, err
+	count := int(binary.BigEndian.Uint32(index[INDEX_V2_NAMES-HEADER_ENTRY_SIZE:]))
+	crcs := INDEX_V2_NAMES + count*CHECKSUM_LEN
+	offsets := crcs + count*CRC_SIZE
+	largeOffsets := offsets + count*OFFSET_SIZE
+	if len(tables) < largeOffsets {
+		return nil, nil, fmt.Errorf("%w: truncated object table", ErrInvalidIndex)
 	}
-	return binary.BigEndian.Uint32(chunk), nil
-}
-
-type Stack[T any] struct {
-	list []T
-}
-
-func (stack *Stack[T]) Push(v T) {
-	stack.list = append(stack.list, v)
-}
-
-func (stack *Stack[T]) Len() int {
-	return len(stack.list)
-}
-
-func (stack *Stack[T]) Empty() bool {
-	return stack.Len() < 1
-}
-
-func (stack *Stack[T]) Pop() (v T, ok bool) {
-	if stack.Empty() {
-		return v, ok
+	for i := 0; i < count; i++ {
+		entry := IndexEntry{
+			ID:  index[INDEX_V2_NAMES+i*CHECKSUM_LEN : INDEX_V2_NAMES+(i+1)*CHECKSUM_LEN],
+			CRC: binary.BigEndian.Uint32(index[crcs+i*CRC_SIZE:]),
+		}
+		offset := binary.BigEndian.Uint32(index[offsets+i*OFFSET_SIZE:])
+		entry.Offset = int(offset)
+		if offset&INDEX_LARGE_OFFSET_FLAG != 0 {
+			large := largeOffsets + int(offset&^INDEX_LARGE_OFFSET_FLAG)*LARGE_OFFSET_SIZE
+			if large+LARGE_OFFSET_SIZE > len(tables) {
+				return nil, nil, fmt.Errorf("%w: truncated large offset table", ErrInvalidIndex)
+			}
+			entry.Offset = int(binary.BigEndian.Uint64(index[large:]))
+		}
+		entries = append(entries, entry)
 	}
-
-	v = stack.list[stack.Len()-1]
-	stack.list = stack.list[:stack.Len()-1]
-	return v, true
+	return entries, packChecksum, nil
 }
-
-// switch objectEntry.objectType {
-// case common.OBJ_OFS_DELTA:
-// 	fmt.Println("ofs")
-// 	baseObjectEntry := &PackObject{}
-// 	err := r.ReadObjectAt(int64(offset-objectEntry.baseOffset), baseObjectEntry)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	targetObject, err := applyDelta(objectEntry.content, baseObjectEntry.content)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	checksum, err = common.HashObject(baseObjectEntry.objectType, targetObject)
-// 	if err != nil {
-// 		return err
-// 	}
-// case common.OBJ_REF_DELTA:
-// 	fmt.Println("refs")
-// 	var found bool
-// 	var baseOffset int64
-// 	for _, entry := range secondLevel {
-// 		if bytes.Equal(entry.checksum, objectEntry.baseChecksum) {
-// 			baseOffset = int64(entry.offset)
-// 			found = true
-// 			break
-// 		}
-// 	}
-// 	fmt.Printf("lookg up %x %d\n", objectEntry.baseChecksum, baseOffset)
-// 	if !found {
-// 		return errors.New("base object not found in current pack file")
-// 	}
-//
-// 	baseObjectEntry := &PackObject{}
-// 	err := r.ReadObjectAt(int64(baseOffset), baseObjectEntry)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	fmt.Printf("length %d", len(baseObjectEntry.content))
-// 	targetObject, err := applyDelta(objectEntry.content, baseObjectEntry.content)
-// 	if err != nil {
-// 		return err
-// 	}
-// 	checksum, err = common.HashObject(baseObjectEntry.objectType, targetObject)
-// 	if err != nil {
-// 		return err
-// 	}
-// default:
-// 	checksum, err = common.HashObject(objectEntry.objectType, objectEntry.content)
-// 	if err != nil {
-// 		return err
-// 	}
-// }
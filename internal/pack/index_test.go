@@ -2,14 +2,18 @@ package pack
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"io"
 	"math/rand"
+	"slices"
 	"testing"
 
 	packfileFixture "github.com/codecrafters-io/git-starter-go/internal/pack/fixture"
 )
 
-func TestWriteFanoutTable(t *testing.T) {
-
+func TestEncodeIndex(t *testing.T) {
 	packIter := packfileFixture.Packs()
 	for {
 		pack, ok := packIter.Next()
@@ -20,49 +24,51 @@ func TestWriteFanoutTable(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		packfileReader, err := NewPackfile(nil, packfile)
+		data, err := io.ReadAll(packfile)
 		if err != nil {
 			t.Fatal(err)
 		}
-		var table bytes.Buffer
-		if err := writeFanoutTable(packfileReader, &table); err != nil {
+		indexed, err := IndexPack(bytes.NewReader(data), io.Discard, bytes.NewReader(data), IndexOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index bytes.Buffer
+		if err := encodeIndex(indexed.Entries, indexed.Checksum, &index); err != nil {
 			t.Fatal(err)
 		}
 
-		// Compare table entries
+		// The fan-out table matches the one of the version 1 index of the fixture
 		indexfile, err := pack.Indexfile()
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		expectedTable := make([]byte, packfileReader.TotalObjects()*TABLE_ENTRY_SIZE+HEADER_SIZE)
-		if _, err := indexfile.ReadAt(expectedTable, 0); err != nil {
+		expectedFanout := make([]byte, HEADER_SIZE)
+		if _, err := indexfile.ReadAt(expectedFanout, 0); err != nil {
 			t.Fatal(err)
 		}
-
-		// Compare header
-		if table.Len() < (HEADER_SIZE) {
-			t.Fatalf("expecte header length to be %d bytes", 256*4)
+		if !bytes.Equal(index.Bytes()[INDEX_V2_FANOUT:INDEX_V2_NAMES], expectedFanout) {
+			t.Fatalf("expected: fan-out table of the fixture\tactual: different fan-out table")
 		}
 
-		for i := 0; i < HEADER_SIZE; i += HEADER_ENTRY_SIZE {
-			if !bytes.Equal(table.Bytes()[i:i+4], expectedTable[i:i+4]) {
-				t.Fatalf("invalid header entry at byte %d", i)
-			}
+		entries, packChecksum, err := DecodeIndex(index.Bytes())
+		if err != nil {
+			t.Fatal(err)
 		}
-
-		// Compare table
-		for i := TABLE_OFFSET; i < packfileReader.TotalObjects(); i += TABLE_ENTRY_SIZE {
-			if !bytes.Equal(table.Bytes()[i:i+TABLE_ENTRY_SIZE], expectedTable[i:i+TABLE_ENTRY_SIZE]) {
-				t.Fatalf("invalid table entry at byte %d", i)
-			}
+		if !bytes.Equal(packChecksum, data[len(data)-CHECKSUM_LEN:]) {
+			t.Fatalf("expected: %x\tactual: %x", data[len(data)-CHECKSUM_LEN:], packChecksum)
 		}
-
-		// Check if there are no more bytes
-		if len(table.Bytes()) != (packfileReader.TotalObjects()*TABLE_ENTRY_SIZE + HEADER_SIZE) {
-			t.Fatalf("table too large")
+		if len(entries) != len(pack.IndexTableEntries) {
+			t.Fatalf("expected: %d objects\tactual: %d", len(pack.IndexTableEntries), len(entries))
+		}
+		for _, expected := range pack.IndexTableEntries {
+			offset, found, err := search(expected.Checksum, bytes.NewReader(index.Bytes()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !found || offset != expected.Offset {
+				t.Fatalf("expected: object %x at %d\tactual: found %v at %d", expected.Checksum, expected.Offset, found, offset)
+			}
 		}
-
 	}
 }
 
@@ -122,3 +128,70 @@ func TestSearch(t *testing.T) {
 
 	}
 }
+
+func TestIndexObjects(t *testing.T) {
+	pack := packfileFixture.Pack()
+	file, err := pack.Indexfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packfile, err := pack.Packfile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(packfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trailer := content[len(content)-CHECKSUM_LEN:]
+
+	// Indexes list the objects sorted by id
+	entries := slices.Clone(pack.IndexTableEntries)
+	slices.SortFunc(entries, func(a, b packfileFixture.IndexTableEntry) int {
+		return bytes.Compare(a.Checksum, b.Checksum)
+	})
+
+	// Build the version 2 index git writes for the same pack
+	var v2 bytes.Buffer
+	v2.Write(INDEX_V2_MAGIC)
+	binary.Write(&v2, binary.BigEndian, uint32(2))
+	v2.Write(v1[:HEADER_SIZE])
+	for _, entry := range entries {
+		v2.Write(entry.Checksum)
+	}
+	v2.Write(make([]byte, 4*len(entries)))
+	for _, entry := range entries {
+		binary.Write(&v2, binary.BigEndian, uint32(entry.Offset))
+	}
+	v2.Write(v1[len(v1)-2*CHECKSUM_LEN : len(v1)-CHECKSUM_LEN])
+	sum := sha1.Sum(v2.Bytes())
+	v2.Write(sum[:])
+
+	for name, index := range map[string][]byte{"v1": v1, "v2": v2.Bytes()} {
+		ids, packChecksum, err := IndexObjects(index)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(packChecksum, trailer) {
+			t.Fatalf("%s: expected: %x\tactual: %x", name, trailer, packChecksum)
+		}
+		if len(ids) != len(entries) {
+			t.Fatalf("%s: expected: %d objects\tactual: %d", name, len(entries), len(ids))
+		}
+		for i, entry := range entries {
+			if !bytes.Equal(ids[i], entry.Checksum) {
+				t.Fatalf("%s: expected: %x\tactual: %x", name, entry.Checksum, ids[i])
+			}
+		}
+	}
+
+	v1[HEADER_SIZE] ^= 0xff
+	if _, _, err := IndexObjects(v1); !errors.Is(err, ErrInvalidIndex) {
+		t.Fatalf("expected a corrupt index to be rejected but got %v", err)
+	}
+}
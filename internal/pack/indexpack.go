@@ -0,0 +1,482 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+var (
+	ErrUnresolvedDeltas = errors.New("pack has unresolved deltas")
+	ErrPackChecksum     = errors.New("pack checksum mismatch")
+)
+
+// IndexOptions tune IndexPack
+type IndexOptions struct {
+	// Threads is the number of goroutines resolving deltas, 0 uses every CPU
+	Threads int
+	// Bases is where the bases of the deltas of a thin pack are read from. Without it,
+	// deltas whose base is not in the pack are unresolved.
+	Bases BaseSource
+	// Keep, when not empty, is written to the .keep of the pack before the pack is
+	// published, so that repack leaves the pack alone until the .keep is removed
+	Keep string
+}
+
+// BaseSource holds the objects a thin pack refers to
+type BaseSource interface {
+	ObjectExist([]byte) (bool, error)
+	Object([]byte) (common.Object, error)
+}
+
+// IndexedPack is a pack whose objects are identified, ready to be indexed
+type IndexedPack struct {
+	Checksum []byte
+	// Entries are the objects of the pack in the order of the pack
+	Entries []IndexEntry
+	// size is the size of the pack, trailer included
+	size int64
+	// external holds the bases of a thin pack, read from outside of the pack
+	external []*externalBase
+}
+
+// externalBase is a delta base missing from a thin pack
+type externalBase struct {
+	id         []byte
+	objectType common.ObjectType
+	content    []byte
+}
+
+// Thin reports whether deltas of the pack have their base outside of it
+func (p *IndexedPack) Thin() bool {
+	return len(p.external) > 0
+}
+
+// AppendBases appends the bases missing from a thin pack to the pack held by file, as
+// git index-pack --fix-thin does, so that the pack is self-contained. The header and the
+// trailer of the pack are rewritten, the checksum and the entries are updated.
+func (p *IndexedPack) AppendBases(file interface {
+	io.ReaderAt
+	io.WriterAt
+}) error {
+	if !p.Thin() {
+		return nil
+	}
+	end := p.size - CHECKSUM_LEN
+	w := &countingWriter{w: io.NewOffsetWriter(file, end), n: int(end)}
+	for _, base := range p.external {
+		offset := w.n
+		w.crc = 0
+		if err := writeEntry(w, encodeEntryHeader(base.objectType, uint(len(base.content))), base.content); err != nil {
+			return err
+		}
+		p.Entries = append(p.Entries, IndexEntry{ID: base.id, Offset: offset, CRC: w.crc})
+	}
+	count := make([]byte, 4)
+	binary.BigEndian.PutUint32(count, uint32(len(p.Entries)))
+	if _, err := file.WriteAt(count, 8); err != nil {
+		return err
+	}
+	sum := hash.New(hash.SHA1)
+	if _, err := io.Copy(sum, io.NewSectionReader(file, 0, int64(w.n))); err != nil {
+		return err
+	}
+	checksum := sum.Sum(nil)
+	if _, err := file.WriteAt(checksum, int64(w.n)); err != nil {
+		return err
+	}
+	p.Checksum = checksum
+	p.size = int64(w.n + CHECKSUM_LEN)
+	p.external = nil
+	return nil
+}
+
+// WriteIndex writes the index of the pack to w
+func (p *IndexedPack) WriteIndex(w io.Writer) error {
+	return encodeIndex(p.Entries, p.Checksum, w)
+}
+
+// indexingEntry is an entry of the pack being indexed. The id of a delta is only known
+// once its base is resolved.
+type indexingEntry struct {
+	objectType common.ObjectType
+	size       int
+	// dataOffset is where the compressed data of the entry starts
+	dataOffset int64
+	// baseOffset and baseID locate the base of ofs and ref deltas
+	baseOffset int
+	baseID     []byte
+	resolved   atomic.Bool
+}
+
+// packIndexer resolves the deltas of a pack once every entry is scanned. The children
+// of every base are resolved from the content of the base, which is inflated once, so
+// that no chain is walked twice.
+type packIndexer struct {
+	pack    io.ReaderAt
+	bases   BaseSource
+	size    int64
+	entries []IndexEntry
+	scanned []*indexingEntry
+	// ofsChildren and refChildren list the deltas of each base by offset and by id
+	ofsChildren map[int][]int
+	refChildren map[string][]int
+}
+
+// IndexPack reads a pack from r and identifies its objects, verifying the trailing
+// checksum of the pack. The bytes of the pack are copied to w as they are read, and pack
+// reads them back to resolve the deltas: pack is usually the file w writes to, or the
+// file r reads from with w discarding the bytes. When r is a bufio.Reader, nothing past
+// the trailer is read from it.
+func IndexPack(r io.Reader, w io.Writer, pack io.ReaderAt, options IndexOptions) (*IndexedPack, error) {
+	indexer := &packIndexer{
+		pack:        pack,
+		bases:       options.Bases,
+		ofsChildren: map[int][]int{},
+		refChildren: map[string][]int{},
+	}
+	checksum, err := indexer.scan(r, w)
+	if err != nil {
+		return nil, err
+	}
+	external, err := indexer.resolve(options.Threads)
+	if err != nil {
+		return nil, err
+	}
+	return &IndexedPack{Checksum: checksum, Entries: indexer.entries, size: indexer.size, external: external}, nil
+}
+
+// scan reads every entry of the pack, computing its CRC and the id of the objects that
+// are not deltas. The content of the objects is not kept.
+func (indexer *packIndexer) scan(r io.Reader, w io.Writer) ([]byte, error) {
+	sum := hash.New(hash.SHA1)
+	buffered, ok := r.(*bufio.Reader)
+	if !ok {
+		buffered = bufio.NewReader(r)
+	}
+	scanner := &scanReader{r: buffered, w: io.MultiWriter(w, sum)}
+	header := make([]byte, HEADER_LEN)
+	if _, err := io.ReadFull(scanner, header); err != nil {
+		return nil, fmt.Errorf("failed to read pack header: %w", err)
+	}
+	numObjects, err := verifyHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+
+	for i := 0; i < numObjects; i++ {
+		offset := int(scanner.n)
+		scanner.crc = 0
+		entry, id, err := scanEntry(scanner)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object at %d: %w", offset, err)
+		}
+		indexer.entries = append(indexer.entries, IndexEntry{ID: id, Offset: offset, CRC: scanner.crc})
+		indexer.scanned = append(indexer.scanned, entry)
+		switch entry.objectType {
+		case common.OBJ_OFS_DELTA:
+			if entry.baseOffset <= 0 || entry.baseOffset > offset {
+				return nil, fmt.Errorf("invalid base offset of object at %d", offset)
+			}
+			base := offset - entry.baseOffset
+			indexer.ofsChildren[base] = append(indexer.ofsChildren[base], i)
+		case common.OBJ_REF_DELTA:
+			key := hash.ChecksumToHex(entry.baseID)
+			indexer.refChildren[key] = append(indexer.refChildren[key], i)
+		}
+	}
+
+	checksum := sum.Sum(nil)
+	trailer := make([]byte, CHECKSUM_LEN)
+	if _, err := io.ReadFull(scanner.r, trailer); err != nil {
+		return nil, fmt.Errorf("failed to read pack checksum: %w", err)
+	}
+	if !bytes.Equal(trailer, checksum) {
+		return nil, ErrPackChecksum
+	}
+	if _, err := w.Write(trailer); err != nil {
+		return nil, err
+	}
+	indexer.size = scanner.n + CHECKSUM_LEN
+	return checksum, nil
+}
+
+// scanEntry reads an entry and returns the id of the object unless it is a delta
+func scanEntry(scanner *scanReader) (*indexingEntry, []byte, error) {
+	objectType, size, err := readEntryHeader(scanner)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry := &indexingEntry{objectType: objectType, size: int(size)}
+	switch objectType {
+	case common.OBJ_OFS_DELTA:
+		baseOffset, err := readOffsetEncoding(scanner)
+		if err != nil {
+			return nil, nil, err
+		}
+		entry.baseOffset = int(baseOffset)
+	case common.OBJ_REF_DELTA:
+		entry.baseID = make([]byte, CHECKSUM_LEN)
+		if _, err := io.ReadFull(scanner, entry.baseID); err != nil {
+			return nil, nil, err
+		}
+	case common.OBJ_COMMIT, common.OBJ_TREE, common.OBJ_BLOB, common.OBJ_TAG:
+	default:
+		return nil, nil, fmt.Errorf("invalid object type %d", objectType)
+	}
+	entry.dataOffset = scanner.n
+
+	objectHash := hash.New(hash.SHA1)
+	var content io.Writer = io.Discard
+	if !isDeltaObject(objectType) {
+		fmt.Fprintf(objectHash, "%s %d\x00", objectType, size)
+		content = objectHash
+	}
+	inflated, err := inflate(scanner, content)
+	if err != nil {
+		return nil, nil, err
+	}
+	if inflated != int64(size) {
+		return nil, nil, fmt.Errorf("expected %d bytes of content, got %d", size, inflated)
+	}
+	if isDeltaObject(objectType) {
+		return entry, nil, nil
+	}
+	entry.resolved.Store(true)
+	return entry, objectHash.Sum(nil), nil
+}
+
+// inflate decompresses the data read from r to w and returns its size
+func inflate(r io.Reader, w io.Writer) (int64, error) {
+	decompressor, err := zlib.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer decompressor.Close()
+	return io.Copy(w, decompressor)
+}
+
+// resolve identifies the deltas, starting from the objects that are not deltas and
+// going down the children of each base. The bases are split among the goroutines. The
+// bases of a thin pack are then read from the base source and returned.
+func (indexer *packIndexer) resolve(threads int) ([]*externalBase, error) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	roots := []int{}
+	for i, entry := range indexer.scanned {
+		if !isDeltaObject(entry.objectType) {
+			roots = append(roots, i)
+		}
+	}
+	if err := parallelize(threads, len(roots), func(i int) error {
+		return indexer.resolveRoot(roots[i])
+	}); err != nil {
+		return nil, err
+	}
+
+	var external []*externalBase
+	if indexer.bases != nil {
+		var err error
+		if external, err = indexer.resolveExternal(threads); err != nil {
+			return nil, err
+		}
+	}
+
+	unresolved := 0
+	for _, entry := range indexer.scanned {
+		if !entry.resolved.Load() {
+			unresolved++
+		}
+	}
+	if unresolved > 0 {
+		return nil, fmt.Errorf("%w: %d deltas have their base outside of the pack", ErrUnresolvedDeltas, unresolved)
+	}
+	return external, nil
+}
+
+// resolveExternal reads the missing bases of the unresolved ref deltas from the base
+// source and resolves their children
+func (indexer *packIndexer) resolveExternal(threads int) ([]*externalBase, error) {
+	missing := []string{}
+	seen := map[string]bool{}
+	for _, entry := range indexer.scanned {
+		if entry.objectType != common.OBJ_REF_DELTA || entry.resolved.Load() {
+			continue
+		}
+		key := hash.ChecksumToHex(entry.baseID)
+		if !seen[key] {
+			seen[key] = true
+			missing = append(missing, key)
+		}
+	}
+	bases := make([]*externalBase, len(missing))
+	if err := parallelize(threads, len(missing), func(i int) error {
+		id, err := hash.ChecksumFromHex(missing[i])
+		if err != nil {
+			return err
+		}
+		// The base may be a delta of the pack whose own base is missing
+		ok, err := indexer.bases.ObjectExist(id)
+		if err != nil || !ok {
+			return err
+		}
+		object, err := indexer.bases.Object(id)
+		if err != nil {
+			return err
+		}
+		content, err := io.ReadAll(object)
+		if err != nil {
+			return err
+		}
+		bases[i] = &externalBase{id: id, objectType: object.Type(), content: content}
+		return indexer.resolveChildren(-1, id, object.Type(), content)
+	}); err != nil {
+		return nil, err
+	}
+
+	// Bases found in the pack once their own base was resolved are not appended
+	inPack := map[string]bool{}
+	for _, entry := range indexer.entries {
+		inPack[hash.ChecksumToHex(entry.ID)] = true
+	}
+	external := []*externalBase{}
+	for _, base := range bases {
+		if base != nil && !inPack[hash.ChecksumToHex(base.id)] {
+			external = append(external, base)
+		}
+	}
+	return external, nil
+}
+
+// parallelize calls fn for every index below n from threads goroutines and returns the
+// first error
+func parallelize(threads int, n int, fn func(int) error) error {
+	indexes := make(chan int)
+	errs := make(chan error, threads)
+	var wg sync.WaitGroup
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := fn(i); err != nil {
+					errs <- err
+					// Drain the remaining indexes so that the producer is not blocked
+					for range indexes {
+					}
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// resolveRoot resolves the deltas based on the non-delta object i. Its content is only
+// read back when it has deltas, as its id was computed during the scan.
+func (indexer *packIndexer) resolveRoot(i int) error {
+	if len(indexer.ofsChildren[indexer.entries[i].Offset]) == 0 && len(indexer.refChildren[hash.ChecksumToHex(indexer.entries[i].ID)]) == 0 {
+		return nil
+	}
+	entry := indexer.scanned[i]
+	content, err := indexer.content(entry)
+	if err != nil {
+		return err
+	}
+	return indexer.resolveChildren(indexer.entries[i].Offset, indexer.entries[i].ID, entry.objectType, content)
+}
+
+// resolveChildren identifies the deltas whose base is the object id found at offset,
+// which is negative for a base outside of the pack, then their own deltas
+func (indexer *packIndexer) resolveChildren(offset int, id []byte, objectType common.ObjectType, content []byte) error {
+	children := indexer.ofsChildren[offset]
+	children = append(children[:len(children):len(children)], indexer.refChildren[hash.ChecksumToHex(id)]...)
+	for _, child := range children {
+		entry := indexer.scanned[child]
+		// A ref delta is claimed by the first copy of its base to be resolved
+		if !entry.resolved.CompareAndSwap(false, true) {
+			continue
+		}
+		delta, err := indexer.content(entry)
+		if err != nil {
+			return err
+		}
+		childContent, err := applyDelta(delta, content)
+		if err != nil {
+			return fmt.Errorf("failed to apply delta at %d: %w", indexer.entries[child].Offset, err)
+		}
+		childID, err := common.NewObjectBuffer(objectType, childContent).Hash()
+		if err != nil {
+			return err
+		}
+		indexer.entries[child].ID = childID
+		if err := indexer.resolveChildren(indexer.entries[child].Offset, childID, objectType, childContent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// content inflates the data of an entry, read back from the pack
+func (indexer *packIndexer) content(entry *indexingEntry) ([]byte, error) {
+	r := bufio.NewReader(io.NewSectionReader(indexer.pack, entry.dataOffset, math.MaxInt64-entry.dataOffset))
+	var content bytes.Buffer
+	content.Grow(entry.size)
+	if _, err := inflate(r, &content); err != nil {
+		return nil, err
+	}
+	return content.Bytes(), nil
+}
+
+// scanReader copies the bytes read to w, counts them and computes their CRC. Being a
+// byte reader, the decompression of an entry does not read past its end.
+type scanReader struct {
+	r   *bufio.Reader
+	w   io.Writer
+	n   int64
+	crc uint32
+}
+
+func (s *scanReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		if err := s.consume(p[:n]); err != nil {
+			return n, err
+		}
+	}
+	return n, err
+}
+
+func (s *scanReader) ReadByte() (byte, error) {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	return b, s.consume([]byte{b})
+}
+
+func (s *scanReader) consume(p []byte) error {
+	s.n += int64(len(p))
+	s.crc = crc32.Update(s.crc, crc32.IEEETable, p)
+	_, err := s.w.Write(p)
+	return err
+}
@@ -0,0 +1,208 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// encodeChain writes a pack of the versions, each one a delta of the previous version.
+// Deltas alternate between ofs and ref deltas.
+func encodeChain(t *testing.T, contents [][]byte) ([]byte, *Encoder) {
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, len(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(common.OBJ_BLOB, contents[0]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(contents); i++ {
+		baseID, _ := common.NewObjectBuffer(common.OBJ_BLOB, contents[i-1]).Hash()
+		id, _ := common.NewObjectBuffer(common.OBJ_BLOB, contents[i]).Hash()
+		delta, ok := createDelta(contents[i-1], contents[i], len(contents[i]))
+		if !ok {
+			t.Fatalf("expected a delta between versions %d and %d", i-1, i)
+		}
+		encode := encoder.EncodeOfsDelta
+		if i%2 == 0 {
+			encode = encoder.EncodeRefDelta
+		}
+		if err := encode(id, baseID, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), encoder
+}
+
+func TestIndexPack(t *testing.T) {
+	data, encoder := encodeChain(t, versions(6))
+	var expected bytes.Buffer
+	if err := encoder.WriteIndex(&expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, threads := range []int{1, 4} {
+		var copied bytes.Buffer
+		indexed, err := IndexPack(bytes.NewReader(data), &copied, bytes.NewReader(data), IndexOptions{Threads: threads})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(copied.Bytes(), data) {
+			t.Fatalf("expected: %d bytes copied\tactual: %d bytes copied", len(data), copied.Len())
+		}
+		var actual bytes.Buffer
+		if err := indexed.WriteIndex(&actual); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual.Bytes(), expected.Bytes()) {
+			t.Fatalf("threads %d\texpected: index of the encoder\tactual: different index", threads)
+		}
+	}
+
+	entries, checksum, err := DecodeIndex(expected.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(checksum, encoder.Checksum()) || len(entries) != 6 {
+		t.Fatalf("expected: 6 entries of pack %x\tactual: %d entries of pack %x", encoder.Checksum(), len(entries), checksum)
+	}
+	for _, entry := range entries {
+		offset, found, err := search(entry.ID, bytes.NewReader(expected.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !found || offset != entry.Offset {
+			t.Fatalf("expected: %x at %d\tactual: found=%v at %d", entry.ID, entry.Offset, found, offset)
+		}
+	}
+}
+
+func TestIndexPackStopsAfterTrailer(t *testing.T) {
+	data, _ := encodeChain(t, versions(3))
+	r := bufio.NewReader(bytes.NewReader(append(bytes.Clone(data), "rest"...)))
+	if _, err := IndexPack(r, io.Discard, bytes.NewReader(data), IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(rest) != "rest" {
+		t.Fatalf("expected: %q\tactual: %q", "rest", rest)
+	}
+}
+
+func TestIndexPackErrors(t *testing.T) {
+	data, _ := encodeChain(t, versions(3))
+	corrupted := bytes.Clone(data)
+	corrupted[len(corrupted)-1] ^= 0xff
+	if _, err := IndexPack(bytes.NewReader(corrupted), io.Discard, bytes.NewReader(corrupted), IndexOptions{}); !errors.Is(err, ErrPackChecksum) {
+		t.Fatalf("expected: %v\tactual: %v", ErrPackChecksum, err)
+	}
+
+	// The base of the ref delta is not in the pack
+	contents := versions(2)
+	baseID, _ := common.NewObjectBuffer(common.OBJ_BLOB, contents[0]).Hash()
+	id, _ := common.NewObjectBuffer(common.OBJ_BLOB, contents[1]).Hash()
+	delta, _ := createDelta(contents[0], contents[1], len(contents[1]))
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.EncodeRefDelta(id, baseID, delta); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	thin := buf.Bytes()
+	if _, err := IndexPack(bytes.NewReader(thin), io.Discard, bytes.NewReader(thin), IndexOptions{}); !errors.Is(err, ErrUnresolvedDeltas) {
+		t.Fatalf("expected: %v\tactual: %v", ErrUnresolvedDeltas, err)
+	}
+}
+
+func TestFromThinPack(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := versions(3)
+	storeBlobs(t, st, contents[:1])
+
+	// The pack only holds deltas, the base of the first one is already stored
+	data, encoder := encodeChain(t, contents)
+	thin := thinPack(t, data, encoder)
+	checksum, err := New(st).From(bytes.NewReader(thin))
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := st.NewPackIndexReader(hash.ChecksumToHex(checksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	index, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids, _, err := IndexObjects(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != len(contents) {
+		t.Fatalf("expected: %d objects with the appended base\tactual: %d objects", len(contents), len(ids))
+	}
+	packFile, err := st.NewPackReader(hash.ChecksumToHex(checksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer packFile.Close()
+	stored, err := io.ReadAll(packFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The completed pack is self-contained
+	if _, err := IndexPack(bytes.NewReader(stored), io.Discard, bytes.NewReader(stored), IndexOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// thinPack rewrites the chain written by encodeChain without its first object, every delta
+// referring to its base by id
+func thinPack(t *testing.T, data []byte, encoder *Encoder) []byte {
+	entries := encoder.entries
+	var buf bytes.Buffer
+	thin, err := NewEncoder(&buf, len(entries)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packfile, err := newPackfileFromBytes(nil, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, entry := range entries[1:] {
+		object := &PackObject{}
+		if err := packfile.ReadObjectAt(int64(entry.Offset), object); err != nil {
+			t.Fatal(err)
+		}
+		// Every object is a delta of the object before it
+		if err := thin.EncodeRefDelta(entry.ID, entries[i].ID, object.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := thin.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -2,10 +2,10 @@ package pack
 
 import (
 	"bytes"
-	"compress/zlib"
 	"errors"
 	"fmt"
 	"io"
+	"os"
 
 	common "github.com/codecrafters-io/git-starter-go/internal"
 	"github.com/codecrafters-io/git-starter-go/internal/hash"
@@ -13,26 +13,44 @@ import (
 	"github.com/codecrafters-io/git-starter-go/internal/util"
 )
 
+var (
+	ErrObjectNotFound = errors.New("object not found")
+)
+
+// PackManageer looks up the objects of a repository, loose or packed. Writing and
+// maintaining packs is left to DefaulPackManager.
 type PackManageer interface {
-	From(io.Reader) ([]byte, error)
 	Object([]byte) (common.Object, error)
 	ObjectExist([]byte) (bool, error)
-	Unpack([]byte) error
 }
 
 type DefaulPackManager struct {
 	store      store.Store
 	indexfiles []*Indexfile
-	packfiles  []*Packfile
+	// midx is the multi-pack-index, loaded along with the index files. It is nil when
+	// the repository has none.
+	midx      *multiPackIndex
+	packfiles []*Packfile
+	// promisor fetches the objects missing from a partial clone, it is nil for complete repositories
+	promisor Promisor
+	// cache holds the delta bases resolved by any lookup
+	cache *deltaBaseCache
+	// graph is the commit-graph once graphLoaded is set, nil when the repository has none
+	graph       *commitGraph
+	graphLoaded bool
+	// bitmaps are the bitmaps once bitmapsLoaded is set, nil when the repository has none
+	bitmaps       *BitmapIndex
+	bitmapsLoaded bool
 }
 
 func (manager *DefaulPackManager) getStore() store.Store {
 	return manager.store
 }
 
-func New(store *store.FSStore) PackManageer {
+func New(store store.Store) *DefaulPackManager {
 	return &DefaulPackManager{
 		store: store,
+		cache: newDeltaBaseCache(DEFAULT_DELTA_BASE_CACHE_LIMIT),
 	}
 }
 
@@ -48,136 +66,148 @@ func NewPackEntry(checksum common.Checksum, offset int) PackEntry {
 	}
 }
 
-func (manager *DefaulPackManager) Unpack(packfileChecksum []byte) error {
-	file, err := manager.store.NewPackReader(hash.ChecksumToHex(packfileChecksum))
+// resolver resolves the objects of a pack whose index is indexfile. The bases of ref
+// deltas missing from the pack are read from the rest of the repository.
+func (manager *DefaulPackManager) resolver(packfile *Packfile, indexfile *Indexfile) *packResolver {
+	return &packResolver{
+		packfile: packfile,
+		findBase: indexfile.search,
+		external: manager.resolve,
+		cache:    manager.cache,
+	}
+}
+
+// From stores the pack read from r along with its index and returns its checksum. Both are
+// written to temporary files that are only renamed once the pack is verified and indexed,
+// so that a pack that fails or is interrupted leaves nothing behind. The bases missing
+// from a thin pack are read from the repository and appended to the pack.
+func (manager *DefaulPackManager) From(r io.Reader) ([]byte, error) {
+	return manager.Index(r, IndexOptions{Bases: manager})
+}
+
+// Index stores the pack read from r as From does, resolving its deltas as options say.
+// The bases of a thin pack are appended to it when options have a base source, and the
+// pack is published with a .keep when options have a keep message.
+func (manager *DefaulPackManager) Index(r io.Reader, options IndexOptions) ([]byte, error) {
+	file, err := manager.store.NewPackWriter("")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	packfile, err := NewPackfile(packfileChecksum, file)
+	indexed, err := IndexPack(r, file, file, options)
 	if err != nil {
-		return err
+		file.Remove()
+		return nil, fmt.Errorf("failed to index pack: %w", err)
 	}
-
-	checksumToOffset := map[string]int{}
-
-	for i := 0; i < packfile.TotalObjects(); i++ {
-		objectEntry := &PackObject{}
-		offset, err := packfile.ReadObject(objectEntry)
-		if err != nil {
-			return err
-		}
-
-		var targetObject common.Object
-
-		// Entry is an undeltified object
-		if !isDeltaObject(objectEntry.objectType) {
-			targetObject = common.NewObjectBuffer(objectEntry.objectType, objectEntry.content)
-		} else {
-
-			// Etry is a deltified object
-			var baseObjectBuffer []byte
-			var objectType common.ObjectType
-
-			stack := &Stack[*PackObject]{}
-			// push object into stack
-			cur := objectEntry
-			for {
-				ancestor := &PackObject{}
-				fmt.Println("adding base")
-				fmt.Printf("%x %d\n", cur.baseChecksum, cur.baseOffset)
-				stack.Push(cur)
-				if cur.objectType == common.OBJ_OFS_DELTA {
-					err := packfile.ReadObjectAt(int64(offset-objectEntry.baseOffset), ancestor)
-					if err != nil {
-						return err
-					}
-				} else {
-					baseOffset, ok := checksumToOffset[hash.ChecksumToHex(cur.baseChecksum)]
-					if !ok {
-						return errors.New("base object is not in current file")
-					}
-					err := packfile.ReadObjectAt(int64(baseOffset), ancestor)
-					if err != nil {
-						return err
-					}
-
-					if !isDeltaObject(ancestor.objectType) {
-						baseObjectBuffer = ancestor.content
-						objectType = ancestor.objectType
-						break
-					} else {
-						cur = ancestor
-					}
-				}
-			}
-
-			// while the stock in not empty
-			for !stack.Empty() {
-				deltaObject, _ := stack.Pop()
-				baseObjectBuffer, err = applyDelta(deltaObject.content, baseObjectBuffer)
-				if err != nil {
-					return err
-				}
-			}
-
-			targetObject = common.NewObjectBuffer(objectType, baseObjectBuffer)
-		}
-		checksum, err := targetObject.Hash()
-		if err != nil {
-			return err
+	if err := indexed.AppendBases(file); err != nil {
+		file.Remove()
+		return nil, fmt.Errorf("failed to complete thin pack: %w", err)
+	}
+	checksum := indexed.Checksum
+	indexfile, err := manager.store.NewPackIndexWriter("")
+	if err != nil {
+		file.Remove()
+		return nil, err
+	}
+	if err := indexed.WriteIndex(indexfile); err != nil {
+		file.Remove()
+		indexfile.Remove()
+		return nil, err
+	}
+	if options.Keep != "" {
+		if err := manager.store.WritePackKeep(hash.ChecksumToHex(checksum), options.Keep); err != nil {
+			file.Remove()
+			indexfile.Remove()
+			return nil, err
 		}
-		objectfile, err := manager.store.ObjectWriter(hash.ChecksumToHex(checksum))
-		if err != nil {
-			return err
+	}
+	if err := publish(checksum, file, indexfile); err != nil {
+		file.Remove()
+		indexfile.Remove()
+		if options.Keep != "" {
+			manager.store.RemovePackKeep(hash.ChecksumToHex(checksum))
 		}
-		comp := zlib.NewWriter(objectfile)
-		targetObject.Encode(comp)
-		comp.Close()
-		objectfile.Close()
-		checksumToOffset[hash.ChecksumToHex(checksum)] = offset
-
+		return nil, err
 	}
-	return nil
 
+	// Force the index files to be listed again so that the new pack is searched
+	manager.resetIndexfiles()
+	return checksum, nil
 }
 
-func (packagaeManager *DefaulPackManager) From(r io.Reader) ([]byte, error) {
-	file, err := packagaeManager.store.NewPackWriter("")
-	checksum, err := WritePackfile(r, file)
+// Write stores a pack of the objects along with its index and returns its checksum. The
+// pack is streamed to a temporary file as the objects are read.
+func (manager *DefaulPackManager) Write(objects []ObjectToPack, options WriteOptions) ([]byte, error) {
+	return manager.write(objects, options, nil)
+}
+
+// write stores a pack of the objects. The pack is a cruft pack when cruft holds the
+// modification times of its objects, which are written along with the index.
+func (manager *DefaulPackManager) write(objects []ObjectToPack, options WriteOptions, cruft []CruftObject) ([]byte, error) {
+	file, err := manager.store.NewPackWriter("")
 	if err != nil {
 		return nil, err
 	}
-
-	file.Rename(hash.ChecksumToHex(checksum))
-
-	file.Close()
-
-	_file, err := packagaeManager.store.NewPackReader(hash.ChecksumToHex(checksum))
+	encoder, err := WriteObjects(manager, file, objects, options)
 	if err != nil {
-		return nil, err
+		file.Remove()
+		return nil, fmt.Errorf("failed to write pack: %w", err)
 	}
-	defer _file.Close()
-
-	packfile, err := NewPackfile(checksum, _file)
-
+	checksum := encoder.checksum
+	files := []store.WriteReadFile{file}
+	discard := func() {
+		for _, file := range files {
+			file.Remove()
+		}
+	}
+	if cruft != nil {
+		mtimesfile, err := manager.store.NewPackMtimesWriter("")
+		if err != nil {
+			discard()
+			return nil, err
+		}
+		files = append(files, mtimesfile)
+		if err := encodeMtimes(cruft, checksum, mtimesfile); err != nil {
+			discard()
+			return nil, err
+		}
+	}
+	indexfile, err := manager.store.NewPackIndexWriter("")
 	if err != nil {
+		discard()
 		return nil, err
 	}
-
-	indexfile, err := packagaeManager.store.NewPackIndexWriter(hash.ChecksumToHex(checksum))
-	if err != nil {
+	files = append(files, indexfile)
+	if err := encoder.WriteIndex(indexfile); err != nil {
+		discard()
 		return nil, err
 	}
-
-	defer indexfile.Close()
-
-	if err := writeIndex(packfile, indexfile); err != nil {
+	if err := publish(checksum, files...); err != nil {
+		discard()
 		return nil, err
 	}
-
+	manager.resetIndexfiles()
 	return checksum, nil
 }
 
+// publish renames the temporary files of a pack in order. The pack comes first and its
+// index last, as the pack is only searched once its index exists.
+func publish(checksum []byte, files ...store.WriteReadFile) error {
+	for _, file := range files {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		if err := file.Rename(hash.ChecksumToHex(checksum)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // newPackfile returns a complete packfile from store
 func (manager *DefaulPackManager) newPackfile(checksum []byte) (*Packfile, error) {
 	file, err := manager.store.NewPackReader(hash.ChecksumToHex(checksum))
@@ -198,9 +228,22 @@ func (manager *DefaulPackManager) newIndexfile(packfile *Packfile) (*Indexfile,
 	}, nil
 }
 
+// resetIndexfiles closes the index files, which are listed again on the next lookup. The
+// bitmaps are decoded again as well, as they may belong to another pack.
+func (manager *DefaulPackManager) resetIndexfiles() {
+	for _, indexfile := range manager.indexfiles {
+		indexfile.file.Close()
+	}
+	manager.indexfiles = nil
+	manager.bitmaps, manager.bitmapsLoaded = nil, false
+}
+
 func (manager *DefaulPackManager) IndexfilesIter() (iter *util.CollectionIter[*Indexfile], err error) {
 
 	if manager.indexfiles == nil {
+		if err := manager.loadMultiPackIndex(); err != nil {
+			return nil, err
+		}
 		hexChecksums, err := manager.store.ListPackIndices()
 		if err != nil {
 			return nil, err
@@ -224,105 +267,187 @@ func (manager *DefaulPackManager) IndexfilesIter() (iter *util.CollectionIter[*I
 }
 
 func (manager *DefaulPackManager) searchIndex(checksum []byte) (offset int, packfile []byte, ok bool, err error) {
+	offset, indexfile, ok, err := manager.searchIndexfile(checksum)
+	if !ok || err != nil {
+		return offset, nil, ok, err
+	}
+	return offset, indexfile.packfile, true, nil
+}
 
+// loadMultiPackIndex opens the multi-pack-index in place of the previous one. As git
+// does, a multi-pack-index that cannot be parsed is ignored and every pack is searched.
+func (manager *DefaulPackManager) loadMultiPackIndex() error {
+	if manager.midx != nil {
+		manager.midx.file.Close()
+		manager.midx = nil
+	}
+	file, err := manager.store.NewMultiPackIndexReader()
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	midx, err := openMultiPackIndex(file)
+	if err != nil {
+		file.Close()
+		if errors.Is(err, ErrInvalidMultiPackIndex) {
+			return nil
+		}
+		return err
+	}
+	manager.midx = midx
+	return nil
+}
+
+// searchIndexfile returns the index of the pack holding the object along with its offset.
+// The multi-pack-index is searched first, then the index of each pack it does not cover.
+// When it points to a pack that no longer exists, every pack is searched.
+func (manager *DefaulPackManager) searchIndexfile(checksum []byte) (int, *Indexfile, bool, error) {
 	indexfileIter, err := manager.IndexfilesIter()
 	if err != nil {
-		return offset, packfile, ok, err
+		return 0, nil, false, err
+	}
+	covered := func([]byte) bool { return false }
+	if manager.midx != nil {
+		pack, offset, found, err := manager.midx.search(checksum)
+		if err != nil {
+			return 0, nil, false, err
+		}
+		if !found {
+			covered = manager.midx.covers
+		} else if indexfile, err := manager.indexfile(pack); err == nil {
+			return offset, indexfile, true, nil
+		}
+	}
+	for {
+		indexfile, more := indexfileIter.Next()
+		if !more {
+			return 0, nil, false, nil
+		}
+		if covered(indexfile.packfile) {
+			continue
+		}
+		offset, found, err := indexfile.search(checksum)
+		if err != nil || found {
+			return offset, indexfile, found, err
+		}
 	}
+}
 
+// indexfile returns the index of the pack
+func (manager *DefaulPackManager) indexfile(packChecksum []byte) (*Indexfile, error) {
+	indexfileIter, err := manager.IndexfilesIter()
+	if err != nil {
+		return nil, err
+	}
 	for {
-		indexfile, _ok := indexfileIter.Next()
-		if !_ok {
-			break
+		indexfile, more := indexfileIter.Next()
+		if !more {
+			return nil, fmt.Errorf("pack %x has no index", packChecksum)
 		}
-		offset, ok, err = indexfile.search(checksum)
-		if ok {
-			packfile = indexfile.packfile
-			break
+		if bytes.Equal(indexfile.packfile, packChecksum) {
+			return indexfile, nil
 		}
 	}
-	return offset, packfile, ok, err
+}
+
+// storedDelta returns the delta an object is stored as in its pack along with the id of
+// its base, so that the delta can be copied to a new pack. ok is false for objects that
+// are not stored as deltas.
+func (manager *DefaulPackManager) storedDelta(checksum []byte) (baseID []byte, delta []byte, ok bool, err error) {
+	offset, indexfile, found, err := manager.searchIndexfile(checksum)
+	if err != nil || !found {
+		return nil, nil, false, err
+	}
+	packfile, err := manager.packfile(indexfile.packfile)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	packObject := &PackObject{}
+	if err := packfile.ReadObjectAt(int64(offset), packObject); err != nil {
+		return nil, nil, false, err
+	}
+	switch packObject.objectType {
+	case common.OBJ_REF_DELTA:
+		return packObject.baseChecksum, packObject.content, true, nil
+	case common.OBJ_OFS_DELTA:
+		baseID, found, err := indexfile.objectAt(offset - packObject.baseOffset)
+		if err != nil || !found {
+			return nil, nil, false, err
+		}
+		return baseID, packObject.content, true, nil
+	default:
+		return nil, nil, false, nil
+	}
 }
 
 func (manager *DefaulPackManager) ObjectExist(checksum []byte) (ok bool, err error) {
 	_, _, ok, err = manager.searchIndex(checksum)
-	return ok, err
+	if err != nil || ok {
+		return ok, err
+	}
+	return manager.looseObjectExist(checksum)
 }
 
-func (manager *DefaulPackManager) Object(checksum []byte) (object common.Object, err error) {
-	offset, packfileChecksum, ok, err := manager.searchIndex(checksum)
+// looseObject reads an object stored outside of any packfile
+func (manager *DefaulPackManager) looseObject(checksum []byte) (common.Object, error) {
+	file, err := manager.store.ObjectReader(hash.ChecksumToHex(checksum))
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %x", ErrObjectNotFound, checksum)
+		}
 		return nil, err
 	}
+	defer file.Close()
+	return common.DecodeMemoryObject(file)
+}
 
-	if !ok {
+// Object returns the object identified by checksum from the packfiles, falling back to loose objects.
+// Objects missing from a partial clone are fetched from its promisor remote.
+// [ErrObjectNotFound] is returned if the object does not exist in the store.
+func (manager *DefaulPackManager) Object(checksum []byte) (common.Object, error) {
+	object, err := manager.object(checksum)
+	if manager.promisor == nil || !errors.Is(err, ErrObjectNotFound) {
 		return object, err
 	}
-
-	// read corresponding packfile and retrun the object
-	packfile, err := manager.packfile(packfileChecksum)
-
-	packObject := &PackObject{}
-	if err := packfile.ReadObjectAt(int64(offset), packObject); err != nil {
+	if err := manager.Prefetch([][]byte{checksum}); err != nil {
 		return nil, err
 	}
+	return manager.object(checksum)
+}
 
-	if !isDeltaObject(packObject.objectType) {
-		return common.NewObjectBuffer(packObject.objectType, packObject.content), nil
+func (manager *DefaulPackManager) object(checksum []byte) (common.Object, error) {
+	objectType, content, err := manager.resolve(checksum, 0)
+	if err != nil {
+		return nil, err
 	}
-	// Etry is a deltified object
-	var baseObjectBuffer []byte
-	var objectType common.ObjectType
-
-	stack := &Stack[*PackObject]{}
-	// push object into stack
-	cur := packObject
-	for {
-		ancestor := &PackObject{}
-		fmt.Println("adding base")
-		fmt.Printf("%x %d\n", cur.baseChecksum, cur.baseOffset)
-		stack.Push(cur)
-		if cur.objectType == common.OBJ_OFS_DELTA {
-			// TODO: change from obejctEntry.baseOffset to cur.baseOffset in other spot
-			err := packfile.ReadObjectAt(int64(offset-cur.baseOffset), ancestor)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			baseOffset, _, ok, err := manager.searchIndex(cur.baseChecksum)
-			if err != nil {
-				return nil, err
-			}
-			if !ok {
-				return nil, errors.New("base object is not in current file")
-			}
-			err = packfile.ReadObjectAt(int64(baseOffset), ancestor)
-			if err != nil {
-				return nil, err
-			}
+	return common.NewObjectBuffer(objectType, content), nil
+}
 
-			if !isDeltaObject(ancestor.objectType) {
-				baseObjectBuffer = ancestor.content
-				objectType = ancestor.objectType
-				break
-			} else {
-				cur = ancestor
-			}
-		}
+// resolve reads the object identified by checksum from the packfiles or the loose
+// objects. depth is the length of the delta chain that led to the object.
+func (manager *DefaulPackManager) resolve(checksum []byte, depth int) (common.ObjectType, []byte, error) {
+	offset, indexfile, ok, err := manager.searchIndexfile(checksum)
+	if err != nil {
+		return 0, nil, err
 	}
-
-	// while the stock in not empty
-	for !stack.Empty() {
-		deltaObject, _ := stack.Pop()
-		baseObjectBuffer, err = applyDelta(deltaObject.content, baseObjectBuffer)
+	if !ok {
+		object, err := manager.looseObject(checksum)
 		if err != nil {
-			return nil, err
+			return 0, nil, err
+		}
+		content, err := io.ReadAll(object)
+		if err != nil {
+			return 0, nil, err
 		}
+		return object.Type(), content, nil
 	}
-
-	object = common.NewObjectBuffer(objectType, baseObjectBuffer)
-
-	return object, nil
+	packfile, err := manager.packfile(indexfile.packfile)
+	if err != nil {
+		return 0, nil, err
+	}
+	return manager.resolver(packfile, indexfile).resolve(offset, depth)
 }
 
 func (manager *DefaulPackManager) packfile(checksum []byte) (packfile *Packfile, err error) {
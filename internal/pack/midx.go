@@ -0,0 +1,832 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	MIDX_VERSION = 1
+	// MIDX_SHA1 identifies the hash function of the ids of the multi-pack-index
+	MIDX_SHA1        = 1
+	MIDX_HEADER_SIZE = 12
+	// MIDX_CHUNK_ENTRY_SIZE is the size of an entry of the table of chunks: the id of the
+	// chunk followed by its offset in the file
+	MIDX_CHUNK_ENTRY_SIZE = 12
+	// MIDX_OFFSET_ENTRY_SIZE is the size of an entry of the OOFF chunk: the position of
+	// the pack of the object among the packs and the offset of the object in the pack
+	MIDX_OFFSET_ENTRY_SIZE = 8
+
+	RIDX_VERSION = 1
+	// RIDX_SHA1 identifies the hash function of the ids of the reverse index
+	RIDX_SHA1        = 1
+	RIDX_HEADER_SIZE = 12
+	RIDX_ENTRY_SIZE  = 4
+)
+
+var (
+	// MIDX_MAGIC starts the multi-pack-index files written by git
+	MIDX_MAGIC = []byte("MIDX")
+
+	MIDX_CHUNK_PACK_NAMES    = "PNAM"
+	MIDX_CHUNK_OID_FANOUT    = "OIDF"
+	MIDX_CHUNK_OID_LOOKUP    = "OIDL"
+	MIDX_CHUNK_OFFSETS       = "OOFF"
+	MIDX_CHUNK_LARGE_OFFSETS = "LOFF"
+
+	// RIDX_MAGIC starts the reverse indexes, which order the objects of an index as in
+	// their pack
+	RIDX_MAGIC = []byte("RIDX")
+
+	ErrInvalidMultiPackIndex = errors.New("invalid multi-pack-index")
+	ErrInvalidReverseIndex   = errors.New("invalid reverse index")
+)
+
+// MidxPack is a pack indexed by a multi-pack-index
+type MidxPack struct {
+	Checksum []byte
+	Entries  []IndexEntry
+	// ModTime is when the pack was written. Among the copies of an object, the one of
+	// the preferred pack is indexed, else the one of the most recent pack.
+	ModTime   time.Time
+	Preferred bool
+}
+
+// MidxEntry is an object listed by a multi-pack-index
+type MidxEntry struct {
+	ID []byte
+	// Pack is the position of the pack of the object among the packs of the index
+	Pack   int
+	Offset int
+}
+
+// MultiPackIndex is a decoded multi-pack-index
+type MultiPackIndex struct {
+	// Packs are the checksums of the packs indexed, sorted by name
+	Packs [][]byte
+	// Entries are the objects sorted by id, each listed once
+	Entries []MidxEntry
+	// Checksum is the checksum of the file, set when decoded
+	Checksum []byte
+}
+
+// midxPackName is the name of the index of a pack as listed by the PNAM chunk
+func midxPackName(checksum []byte) string {
+	return fmt.Sprintf("pack-%s.idx", hash.ChecksumToHex(checksum))
+}
+
+// newMultiPackIndex indexes the objects of the packs. Objects stored in several packs are
+// listed once, preferring the preferred pack and then the most recent pack.
+func newMultiPackIndex(packs []MidxPack) *MultiPackIndex {
+	sorted := slices.Clone(packs)
+	slices.SortFunc(sorted, func(a, b MidxPack) int {
+		return bytes.Compare(a.Checksum, b.Checksum)
+	})
+	candidates := []MidxEntry{}
+	for i, pack := range sorted {
+		for _, entry := range pack.Entries {
+			candidates = append(candidates, MidxEntry{ID: entry.ID, Pack: i, Offset: entry.Offset})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b MidxEntry) int {
+		if c := bytes.Compare(a.ID, b.ID); c != 0 {
+			return c
+		}
+		if sorted[a.Pack].Preferred != sorted[b.Pack].Preferred {
+			if sorted[a.Pack].Preferred {
+				return -1
+			}
+			return 1
+		}
+		if c := sorted[b.Pack].ModTime.Compare(sorted[a.Pack].ModTime); c != 0 {
+			return c
+		}
+		return a.Pack - b.Pack
+	})
+	midx := &MultiPackIndex{
+		Packs: make([][]byte, len(sorted)),
+		Entries: slices.CompactFunc(candidates, func(a, b MidxEntry) bool {
+			return bytes.Equal(a.ID, b.ID)
+		}),
+	}
+	for i, pack := range sorted {
+		midx.Packs[i] = pack.Checksum
+	}
+	return midx
+}
+
+// encodeMultiPackIndex writes a multi-pack-index as git does: a header, the table of
+// chunks and the PNAM, OIDF, OIDL, OOFF and, when some offsets need more than 31 bits,
+// LOFF chunks, followed by the checksum of the file, which is returned
+func encodeMultiPackIndex(midx *MultiPackIndex, w io.Writer) ([]byte, error) {
+	entries := midx.Entries
+	var names bytes.Buffer
+	for _, pack := range midx.Packs {
+		names.WriteString(midxPackName(pack))
+		names.WriteByte(0)
+	}
+	for names.Len()%4 != 0 {
+		names.WriteByte(0)
+	}
+	fanout := [HEADER_ENTRIES]uint32{}
+	for _, entry := range entries {
+		fanout[entry.ID[0]]++
+	}
+	for i := 1; i < HEADER_ENTRIES; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	var lookup, offsets, largeOffsets bytes.Buffer
+	for _, entry := range entries {
+		lookup.Write(entry.ID)
+		offset := uint32(entry.Offset)
+		if entry.Offset > INDEX_MAX_SMALL_OFFSET {
+			offset = INDEX_LARGE_OFFSET_FLAG | uint32(largeOffsets.Len()/LARGE_OFFSET_SIZE)
+			binary.Write(&largeOffsets, binary.BigEndian, uint64(entry.Offset))
+		}
+		binary.Write(&offsets, binary.BigEndian, uint32(entry.Pack))
+		binary.Write(&offsets, binary.BigEndian, offset)
+	}
+	var fanoutChunk bytes.Buffer
+	binary.Write(&fanoutChunk, binary.BigEndian, fanout)
+	chunks := []struct {
+		id   string
+		data []byte
+	}{
+		{MIDX_CHUNK_PACK_NAMES, names.Bytes()},
+		{MIDX_CHUNK_OID_FANOUT, fanoutChunk.Bytes()},
+		{MIDX_CHUNK_OID_LOOKUP, lookup.Bytes()},
+		{MIDX_CHUNK_OFFSETS, offsets.Bytes()},
+	}
+	if largeOffsets.Len() > 0 {
+		chunks = append(chunks, struct {
+			id   string
+			data []byte
+		}{MIDX_CHUNK_LARGE_OFFSETS, largeOffsets.Bytes()})
+	}
+
+	hashWriter := hash.NewHashWriter(w, hash.SHA1)
+	bw := bufio.NewWriter(hashWriter)
+	bw.Write(MIDX_MAGIC)
+	bw.Write([]byte{MIDX_VERSION, MIDX_SHA1, byte(len(chunks)), 0})
+	binary.Write(bw, binary.BigEndian, uint32(len(midx.Packs)))
+	// The table of chunks ends with a zero id giving the offset of the end of the last chunk
+	offset := uint64(MIDX_HEADER_SIZE + (len(chunks)+1)*MIDX_CHUNK_ENTRY_SIZE)
+	for _, chunk := range chunks {
+		bw.WriteString(chunk.id)
+		binary.Write(bw, binary.BigEndian, offset)
+		offset += uint64(len(chunk.data))
+	}
+	bw.Write(make([]byte, 4))
+	binary.Write(bw, binary.BigEndian, offset)
+	for _, chunk := range chunks {
+		bw.Write(chunk.data)
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	checksum := hashWriter.Sum(nil)
+	if _, err := w.Write(checksum); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// midxChunks locates the chunks of a multi-pack-index
+type midxChunks struct {
+	packCount int
+	// chunks maps the ids of the chunks to their start and end in the file
+	chunks map[string][2]int64
+	// end is where the last chunk ends and the checksum of the file starts
+	end int64
+}
+
+// readMidxChunks reads the header and the table of chunks of a multi-pack-index. The
+// chunks required to look objects up must be present, others are ignored.
+func readMidxChunks(r io.ReaderAt) (*midxChunks, error) {
+	header := make([]byte, MIDX_HEADER_SIZE)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMultiPackIndex, err)
+	}
+	if !bytes.HasPrefix(header, MIDX_MAGIC) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidMultiPackIndex)
+	}
+	if header[4] != MIDX_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidMultiPackIndex, header[4])
+	}
+	if header[5] != MIDX_SHA1 {
+		return nil, fmt.Errorf("%w: unsupported hash %d", ErrInvalidMultiPackIndex, header[5])
+	}
+	if header[7] != 0 {
+		return nil, fmt.Errorf("%w: incremental indexes are not supported", ErrInvalidMultiPackIndex)
+	}
+	count := int(header[6])
+	table := make([]byte, (count+1)*MIDX_CHUNK_ENTRY_SIZE)
+	if _, err := r.ReadAt(table, MIDX_HEADER_SIZE); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMultiPackIndex, err)
+	}
+	chunks := &midxChunks{
+		packCount: int(binary.BigEndian.Uint32(header[8:])),
+		chunks:    map[string][2]int64{},
+	}
+	for i := 0; i < count; i++ {
+		entry := table[i*MIDX_CHUNK_ENTRY_SIZE:]
+		start := int64(binary.BigEndian.Uint64(entry[4:12]))
+		end := int64(binary.BigEndian.Uint64(entry[MIDX_CHUNK_ENTRY_SIZE+4 : 2*MIDX_CHUNK_ENTRY_SIZE]))
+		if start < int64(len(table))+MIDX_HEADER_SIZE || start > end {
+			return nil, fmt.Errorf("%w: chunk %s out of bounds", ErrInvalidMultiPackIndex, entry[:4])
+		}
+		chunks.chunks[string(entry[:4])] = [2]int64{start, end}
+		chunks.end = end
+	}
+	for _, id := range []string{MIDX_CHUNK_PACK_NAMES, MIDX_CHUNK_OID_FANOUT, MIDX_CHUNK_OID_LOOKUP, MIDX_CHUNK_OFFSETS} {
+		if _, ok := chunks.chunks[id]; !ok {
+			return nil, fmt.Errorf("%w: missing chunk %s", ErrInvalidMultiPackIndex, id)
+		}
+	}
+	if bounds := chunks.chunks[MIDX_CHUNK_OID_FANOUT]; bounds[1]-bounds[0] != HEADER_SIZE {
+		return nil, fmt.Errorf("%w: bad fan-out size", ErrInvalidMultiPackIndex)
+	}
+	return chunks, nil
+}
+
+// packs decodes the PNAM chunk into the checksums of the packs
+func (c *midxChunks) packs(r io.ReaderAt) ([][]byte, error) {
+	bounds := c.chunks[MIDX_CHUNK_PACK_NAMES]
+	names := make([]byte, bounds[1]-bounds[0])
+	if _, err := r.ReadAt(names, bounds[0]); err != nil {
+		return nil, err
+	}
+	packs := [][]byte{}
+	for _, name := range strings.Split(string(bytes.TrimRight(names, "\x00")), "\x00") {
+		hexChecksum, ok := strings.CutPrefix(name, "pack-")
+		if ok {
+			hexChecksum, ok = strings.CutSuffix(hexChecksum, ".idx")
+		}
+		checksum, err := hash.ChecksumFromHex(hexChecksum)
+		if !ok || err != nil || len(checksum) != CHECKSUM_LEN {
+			return nil, fmt.Errorf("%w: bad pack name %q", ErrInvalidMultiPackIndex, name)
+		}
+		packs = append(packs, checksum)
+	}
+	if len(packs) != c.packCount {
+		return nil, fmt.Errorf("%w: %d pack names for %d packs", ErrInvalidMultiPackIndex, len(packs), c.packCount)
+	}
+	return packs, nil
+}
+
+// DecodeMultiPackIndex decodes a multi-pack-index, verifying its checksum, the order of
+// its objects and the positions of their packs
+func DecodeMultiPackIndex(data []byte) (*MultiPackIndex, error) {
+	if len(data) < MIDX_HEADER_SIZE+CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: too small", ErrInvalidMultiPackIndex)
+	}
+	trailer := len(data) - CHECKSUM_LEN
+	sum := hash.New(hash.SHA1)
+	sum.Write(data[:trailer])
+	if !bytes.Equal(sum.Sum(nil), data[trailer:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidMultiPackIndex)
+	}
+	r := bytes.NewReader(data)
+	chunks, err := readMidxChunks(r)
+	if err != nil {
+		return nil, err
+	}
+	if chunks.end != int64(trailer) {
+		return nil, fmt.Errorf("%w: chunks end at %d rather than %d", ErrInvalidMultiPackIndex, chunks.end, trailer)
+	}
+	packs, err := chunks.packs(r)
+	if err != nil {
+		return nil, err
+	}
+	chunk := func(id string) []byte {
+		bounds := chunks.chunks[id]
+		return data[bounds[0]:bounds[1]]
+	}
+	fanout := chunk(MIDX_CHUNK_OID_FANOUT)
+	count := int(binary.BigEndian.Uint32(fanout[HEADER_SIZE-HEADER_ENTRY_SIZE:]))
+	lookup, offsets, largeOffsets := chunk(MIDX_CHUNK_OID_LOOKUP), chunk(MIDX_CHUNK_OFFSETS), []byte(nil)
+	if _, ok := chunks.chunks[MIDX_CHUNK_LARGE_OFFSETS]; ok {
+		largeOffsets = chunk(MIDX_CHUNK_LARGE_OFFSETS)
+	}
+	if len(lookup) != count*CHECKSUM_LEN || len(offsets) != count*MIDX_OFFSET_ENTRY_SIZE {
+		return nil, fmt.Errorf("%w: truncated object tables", ErrInvalidMultiPackIndex)
+	}
+
+	midx := &MultiPackIndex{Packs: packs, Entries: make([]MidxEntry, count), Checksum: data[trailer:]}
+	for i := range midx.Entries {
+		id := lookup[i*CHECKSUM_LEN : (i+1)*CHECKSUM_LEN]
+		if i > 0 && bytes.Compare(lookup[(i-1)*CHECKSUM_LEN:i*CHECKSUM_LEN], id) >= 0 {
+			return nil, fmt.Errorf("%w: objects out of order at %d", ErrInvalidMultiPackIndex, i)
+		}
+		if int(binary.BigEndian.Uint32(fanout[int(id[0])*HEADER_ENTRY_SIZE:])) <= i {
+			return nil, fmt.Errorf("%w: fan-out does not match object %x", ErrInvalidMultiPackIndex, id)
+		}
+		entry := offsets[i*MIDX_OFFSET_ENTRY_SIZE:]
+		pack := int(binary.BigEndian.Uint32(entry))
+		if pack >= len(packs) {
+			return nil, fmt.Errorf("%w: object %x in pack %d of %d", ErrInvalidMultiPackIndex, id, pack, len(packs))
+		}
+		offset, err := midxOffset(binary.BigEndian.Uint32(entry[4:]), largeOffsets)
+		if err != nil {
+			return nil, err
+		}
+		midx.Entries[i] = MidxEntry{ID: id, Pack: pack, Offset: offset}
+	}
+	return midx, nil
+}
+
+// midxOffset decodes an offset of the OOFF chunk, which refers to the LOFF chunk when
+// flagged
+func midxOffset(value uint32, largeOffsets []byte) (int, error) {
+	if value&INDEX_LARGE_OFFSET_FLAG == 0 {
+		return int(value), nil
+	}
+	position := int(value&^INDEX_LARGE_OFFSET_FLAG) * LARGE_OFFSET_SIZE
+	if position+LARGE_OFFSET_SIZE > len(largeOffsets) {
+		return 0, fmt.Errorf("%w: large offset %d out of bounds", ErrInvalidMultiPackIndex, value&^INDEX_LARGE_OFFSET_FLAG)
+	}
+	return int(binary.BigEndian.Uint64(largeOffsets[position:])), nil
+}
+
+// multiPackIndex looks objects up in a multi-pack-index file without loading it
+type multiPackIndex struct {
+	file   store.ReadOnlyFile
+	chunks *midxChunks
+	packs  [][]byte
+}
+
+func openMultiPackIndex(file store.ReadOnlyFile) (*multiPackIndex, error) {
+	chunks, err := readMidxChunks(file)
+	if err != nil {
+		return nil, err
+	}
+	packs, err := chunks.packs(file)
+	if err != nil {
+		return nil, err
+	}
+	return &multiPackIndex{file: file, chunks: chunks, packs: packs}, nil
+}
+
+// covers reports whether the pack is indexed
+func (m *multiPackIndex) covers(packChecksum []byte) bool {
+	return slices.ContainsFunc(m.packs, func(pack []byte) bool {
+		return bytes.Equal(pack, packChecksum)
+	})
+}
+
+// search returns the pack holding the object and the offset of the object in the pack.
+// Only the ids of the fan-out bucket of the object are read, and searched by bisection.
+func (m *multiPackIndex) search(id []byte) (pack []byte, offset int, found bool, err error) {
+	fanout := m.chunks.chunks[MIDX_CHUNK_OID_FANOUT][0]
+	bucket := make([]byte, 2*HEADER_ENTRY_SIZE)
+	if id[0] == 0 {
+		_, err = m.file.ReadAt(bucket[HEADER_ENTRY_SIZE:], fanout)
+	} else {
+		_, err = m.file.ReadAt(bucket, fanout+int64(id[0]-1)*HEADER_ENTRY_SIZE)
+	}
+	if err != nil {
+		return nil, 0, false, err
+	}
+	start, end := int64(binary.BigEndian.Uint32(bucket)), int64(binary.BigEndian.Uint32(bucket[HEADER_ENTRY_SIZE:]))
+	if end <= start {
+		return nil, 0, false, nil
+	}
+	ids := make([]byte, (end-start)*CHECKSUM_LEN)
+	lookup := m.chunks.chunks[MIDX_CHUNK_OID_LOOKUP][0]
+	if _, err := m.file.ReadAt(ids, lookup+start*CHECKSUM_LEN); err != nil {
+		return nil, 0, false, err
+	}
+	position := sort.Search(int(end-start), func(i int) bool {
+		return bytes.Compare(ids[i*CHECKSUM_LEN:(i+1)*CHECKSUM_LEN], id) >= 0
+	})
+	if position == int(end-start) || !bytes.Equal(ids[position*CHECKSUM_LEN:(position+1)*CHECKSUM_LEN], id) {
+		return nil, 0, false, nil
+	}
+
+	entry := make([]byte, MIDX_OFFSET_ENTRY_SIZE)
+	offsets := m.chunks.chunks[MIDX_CHUNK_OFFSETS][0]
+	if _, err := m.file.ReadAt(entry, offsets+(start+int64(position))*MIDX_OFFSET_ENTRY_SIZE); err != nil {
+		return nil, 0, false, err
+	}
+	packPosition := int(binary.BigEndian.Uint32(entry))
+	if packPosition >= len(m.packs) {
+		return nil, 0, false, fmt.Errorf("%w: object %x in pack %d of %d", ErrInvalidMultiPackIndex, id, packPosition, len(m.packs))
+	}
+	value := binary.BigEndian.Uint32(entry[4:])
+	if value&INDEX_LARGE_OFFSET_FLAG == 0 {
+		return m.packs[packPosition], int(value), true, nil
+	}
+	bounds, ok := m.chunks.chunks[MIDX_CHUNK_LARGE_OFFSETS]
+	large := int64(value&^INDEX_LARGE_OFFSET_FLAG) * LARGE_OFFSET_SIZE
+	if !ok || bounds[0]+large+LARGE_OFFSET_SIZE > bounds[1] {
+		return nil, 0, false, fmt.Errorf("%w: large offset %d out of bounds", ErrInvalidMultiPackIndex, value&^INDEX_LARGE_OFFSET_FLAG)
+	}
+	largeOffset := make([]byte, LARGE_OFFSET_SIZE)
+	if _, err := m.file.ReadAt(largeOffset, bounds[0]+large); err != nil {
+		return nil, 0, false, err
+	}
+	return m.packs[packPosition], int(binary.BigEndian.Uint64(largeOffset)), true, nil
+}
+
+// MidxWriteOptions tune the writing of a multi-pack-index
+type MidxWriteOptions struct {
+	// Bitmap writes reachability bitmaps along with the reverse index they rely on
+	Bitmap bool
+	// PreferredPack is the pack whose copies of the objects are indexed and whose objects
+	// come first in the bitmaps. Without it, the oldest pack is preferred when writing
+	// bitmaps.
+	PreferredPack []byte
+}
+
+// WriteMultiPackIndex indexes every pack of the repository in a new multi-pack-index,
+// which replaces the current one
+func (manager *DefaulPackManager) WriteMultiPackIndex(options MidxWriteOptions) error {
+	return manager.writeMultiPackIndex(nil, options)
+}
+
+// writeMultiPackIndex indexes every pack but those excluded
+func (manager *DefaulPackManager) writeMultiPackIndex(excluded [][]byte, options MidxWriteOptions) error {
+	hexChecksums, err := manager.store.ListPackIndices()
+	if err != nil {
+		return err
+	}
+	packs := []MidxPack{}
+	preferred := -1
+	for _, hexChecksum := range hexChecksums {
+		checksum, err := hash.ChecksumFromHex(hexChecksum)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(excluded, func(c []byte) bool { return bytes.Equal(c, checksum) }) {
+			continue
+		}
+		entries, err := manager.indexEntries(hexChecksum)
+		if err != nil {
+			return err
+		}
+		info, err := manager.store.StatPack(hexChecksum)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(checksum, options.PreferredPack) {
+			preferred = len(packs)
+		}
+		packs = append(packs, MidxPack{Checksum: checksum, Entries: entries, ModTime: info.ModTime()})
+	}
+	if options.PreferredPack != nil && preferred < 0 {
+		return fmt.Errorf("preferred pack %x is not indexed", options.PreferredPack)
+	}
+	if options.Bitmap && preferred < 0 {
+		for i, pack := range packs {
+			if len(pack.Entries) > 0 && (preferred < 0 || pack.ModTime.Before(packs[preferred].ModTime)) {
+				preferred = i
+			}
+		}
+	}
+	if preferred >= 0 {
+		packs[preferred].Preferred = true
+	}
+	midx := newMultiPackIndex(packs)
+
+	file, err := manager.store.NewMultiPackIndexWriter()
+	if err != nil {
+		return err
+	}
+	files := []store.WriteReadFile{file}
+	discard := func() {
+		for _, file := range files {
+			file.Remove()
+		}
+	}
+	checksum, err := encodeMultiPackIndex(midx, file)
+	if err != nil {
+		discard()
+		return err
+	}
+	if options.Bitmap {
+		var preferredPack []byte
+		if preferred >= 0 {
+			preferredPack = packs[preferred].Checksum
+		}
+		auxfiles, err := manager.writeMidxBitmaps(midx, checksum, preferredPack)
+		files = append(auxfiles, files...)
+		if err != nil {
+			discard()
+			return err
+		}
+	}
+	// The bitmaps are renamed before the multi-pack-index they describe
+	if err := publish(checksum, files...); err != nil {
+		discard()
+		return err
+	}
+	if err := manager.store.PruneMultiPackIndexFiles(hash.ChecksumToHex(checksum)); err != nil {
+		return err
+	}
+	// Force the multi-pack-index to be loaded again
+	manager.resetIndexfiles()
+	return nil
+}
+
+// writeMidxBitmaps writes the reverse index and the bitmaps of a multi-pack-index to
+// temporary files, which are returned even on failure so that they can be removed
+func (manager *DefaulPackManager) writeMidxBitmaps(midx *MultiPackIndex, checksum []byte, preferred []byte) ([]store.WriteReadFile, error) {
+	files := []store.WriteReadFile{}
+	order := midxPackOrder(midx, preferred)
+	revfile, err := manager.store.NewMultiPackIndexRevWriter()
+	if err != nil {
+		return files, err
+	}
+	files = append(files, revfile)
+	if err := encodeReverseIndex(order, checksum, revfile); err != nil {
+		return files, err
+	}
+	objects := make([][]byte, len(order))
+	for i, index := range order {
+		objects[i] = midx.Entries[index].ID
+	}
+	tips, err := manager.refTips()
+	if err != nil {
+		return files, err
+	}
+	types := make(map[string]common.ObjectType, len(objects))
+	for _, pack := range midx.Packs {
+		if err := manager.packTypes(pack, types); err != nil {
+			return files, err
+		}
+	}
+	bitmaps, err := buildBitmaps(manager, objects, types, tips, checksum)
+	if err != nil {
+		return files, err
+	}
+	bitmapfile, err := manager.store.NewMultiPackIndexBitmapWriter()
+	if err != nil {
+		return files, err
+	}
+	files = append(files, bitmapfile)
+	return files, encodeBitmapFile(bitmaps, bitmapfile)
+}
+
+// midxPackOrder returns the positions of the objects of a multi-pack-index in the order
+// of their bits, as git orders them: as if the packs were concatenated, the preferred
+// pack first
+func midxPackOrder(midx *MultiPackIndex, preferred []byte) []int {
+	rank := func(pack int) int {
+		if bytes.Equal(midx.Packs[pack], preferred) {
+			return -1
+		}
+		return pack
+	}
+	order := make([]int, len(midx.Entries))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return cmp.Or(
+			cmp.Compare(rank(midx.Entries[a].Pack), rank(midx.Entries[b].Pack)),
+			cmp.Compare(midx.Entries[a].Offset, midx.Entries[b].Offset),
+		)
+	})
+	return order
+}
+
+// encodeReverseIndex writes a reverse index as git does: a header, the position in the
+// index of each object in the order of its pack, the checksum of the pack or
+// multi-pack-index and the checksum of the file
+func encodeReverseIndex(order []int, checksum []byte, w io.Writer) error {
+	hashWriter := hash.NewHashWriter(w, hash.SHA1)
+	bw := bufio.NewWriter(hashWriter)
+	bw.Write(RIDX_MAGIC)
+	binary.Write(bw, binary.BigEndian, uint32(RIDX_VERSION))
+	binary.Write(bw, binary.BigEndian, uint32(RIDX_SHA1))
+	for _, position := range order {
+		binary.Write(bw, binary.BigEndian, uint32(position))
+	}
+	bw.Write(checksum)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(hashWriter.Sum(nil))
+	return err
+}
+
+// DecodeReverseIndex decodes the reverse index of count objects of the pack or
+// multi-pack-index whose checksum is given
+func DecodeReverseIndex(data []byte, count int, checksum []byte) ([]int, error) {
+	if len(data) != RIDX_HEADER_SIZE+count*RIDX_ENTRY_SIZE+2*CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: expected %d objects", ErrInvalidReverseIndex, count)
+	}
+	if !bytes.HasPrefix(data, RIDX_MAGIC) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidReverseIndex)
+	}
+	if version := binary.BigEndian.Uint32(data[4:]); version != RIDX_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidReverseIndex, version)
+	}
+	trailer := len(data) - CHECKSUM_LEN
+	sum := hash.New(hash.SHA1)
+	sum.Write(data[:trailer])
+	if !bytes.Equal(sum.Sum(nil), data[trailer:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidReverseIndex)
+	}
+	if !bytes.Equal(data[trailer-CHECKSUM_LEN:trailer], checksum) {
+		return nil, fmt.Errorf("%w: not the reverse index of %x", ErrInvalidReverseIndex, checksum)
+	}
+	order := make([]int, count)
+	seen := NewBitmap()
+	for i := range order {
+		order[i] = int(binary.BigEndian.Uint32(data[RIDX_HEADER_SIZE+i*RIDX_ENTRY_SIZE:]))
+		if order[i] >= count || seen.Has(order[i]) {
+			return nil, fmt.Errorf("%w: bad position %d", ErrInvalidReverseIndex, order[i])
+		}
+		seen.Set(order[i])
+	}
+	return order, nil
+}
+
+// indexEntries decodes the index of a pack
+func (manager *DefaulPackManager) indexEntries(hexChecksum string) ([]IndexEntry, error) {
+	index, err := readAll(manager.store.NewPackIndexReader(hexChecksum))
+	if err != nil {
+		return nil, err
+	}
+	entries, _, err := DecodeIndex(index)
+	if err != nil {
+		return nil, fmt.Errorf("pack %s: %w", hexChecksum, err)
+	}
+	return entries, nil
+}
+
+// readMultiPackIndex decodes the multi-pack-index of the repository
+func (manager *DefaulPackManager) readMultiPackIndex() (*MultiPackIndex, error) {
+	data, err := readAll(manager.store.NewMultiPackIndexReader())
+	if err != nil {
+		return nil, err
+	}
+	return DecodeMultiPackIndex(data)
+}
+
+// VerifyMultiPackIndex checks that the multi-pack-index is well formed and that it
+// lists every object of the packs it covers at the offset given by the index of its pack
+func (manager *DefaulPackManager) VerifyMultiPackIndex() error {
+	midx, err := manager.readMultiPackIndex()
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(midx.Packs); i++ {
+		if midxPackName(midx.Packs[i-1]) >= midxPackName(midx.Packs[i]) {
+			return fmt.Errorf("%w: pack names out of order", ErrInvalidMultiPackIndex)
+		}
+	}
+	offsets := make([]map[string]int, len(midx.Packs))
+	for i, checksum := range midx.Packs {
+		entries, err := manager.indexEntries(hash.ChecksumToHex(checksum))
+		if err != nil {
+			return err
+		}
+		offsets[i] = make(map[string]int, len(entries))
+		for _, entry := range entries {
+			offsets[i][string(entry.ID)] = entry.Offset
+		}
+	}
+	listed := make(map[string]bool, len(midx.Entries))
+	for _, entry := range midx.Entries {
+		offset, ok := offsets[entry.Pack][string(entry.ID)]
+		if !ok {
+			return fmt.Errorf("%w: object %x is not in pack %x", ErrInvalidMultiPackIndex, entry.ID, midx.Packs[entry.Pack])
+		}
+		if offset != entry.Offset {
+			return fmt.Errorf("%w: incorrect offset for object %x: %d != %d", ErrInvalidMultiPackIndex, entry.ID, entry.Offset, offset)
+		}
+		listed[string(entry.ID)] = true
+	}
+	for i, packOffsets := range offsets {
+		for id := range packOffsets {
+			if !listed[id] {
+				return fmt.Errorf("%w: object %x of pack %x is missing", ErrInvalidMultiPackIndex, []byte(id), midx.Packs[i])
+			}
+		}
+	}
+	return nil
+}
+
+// ExpireMultiPackIndex removes the packs covered by the multi-pack-index that none of
+// its objects refer to, as their objects are all found in other packs, unless they have
+// a .keep. The multi-pack-index is rewritten before the packs are removed. The checksums
+// of the removed packs are returned.
+func (manager *DefaulPackManager) ExpireMultiPackIndex() ([][]byte, error) {
+	midx, err := manager.readMultiPackIndex()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make([]int, len(midx.Packs))
+	for _, entry := range midx.Entries {
+		referenced[entry.Pack]++
+	}
+	expired := [][]byte{}
+	for i, checksum := range midx.Packs {
+		if referenced[i] > 0 {
+			continue
+		}
+		kept, err := manager.store.IsPackKept(hash.ChecksumToHex(checksum))
+		if err != nil {
+			return nil, err
+		}
+		if !kept {
+			expired = append(expired, checksum)
+		}
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	if err := manager.writeMultiPackIndex(expired, MidxWriteOptions{}); err != nil {
+		return nil, err
+	}
+	for _, checksum := range expired {
+		if err := manager.RemovePack(checksum); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// RepackMultiPackIndex writes the objects the multi-pack-index finds in some of its packs
+// to a new pack, then indexes it. The packs are picked from the oldest, until the size
+// of their referenced objects, estimated in proportion to the size of the pack, reaches
+// batchSize. At least two packs must be picked, and a batchSize of 0 picks every pack.
+// The packs picked are left for ExpireMultiPackIndex to remove. The checksum of the new
+// pack is returned, or nil when there was nothing to repack.
+func (manager *DefaulPackManager) RepackMultiPackIndex(batchSize int64, options WriteOptions) ([]byte, error) {
+	midx, err := manager.readMultiPackIndex()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make([]int, len(midx.Packs))
+	for _, entry := range midx.Entries {
+		referenced[entry.Pack]++
+	}
+	type candidate struct {
+		pack         int
+		modTime      time.Time
+		expectedSize int64
+	}
+	candidates := make([]candidate, len(midx.Packs))
+	for i, checksum := range midx.Packs {
+		hexChecksum := hash.ChecksumToHex(checksum)
+		info, err := manager.store.StatPack(hexChecksum)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := manager.indexEntries(hexChecksum)
+		if err != nil {
+			return nil, err
+		}
+		candidates[i] = candidate{pack: i, modTime: info.ModTime()}
+		if len(entries) > 0 {
+			candidates[i].expectedSize = info.Size() * int64(referenced[i]) / int64(len(entries))
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return a.modTime.Compare(b.modTime)
+	})
+
+	picked := make([]bool, len(midx.Packs))
+	count, size := 0, int64(0)
+	for _, candidate := range candidates {
+		if batchSize > 0 && size >= batchSize {
+			break
+		}
+		if batchSize > 0 && candidate.expectedSize >= batchSize {
+			continue
+		}
+		picked[candidate.pack] = true
+		count++
+		size += candidate.expectedSize
+	}
+	if count < 2 || (batchSize > 0 && size < batchSize) {
+		return nil, nil
+	}
+	objects := []ObjectToPack{}
+	for _, entry := range midx.Entries {
+		if picked[entry.Pack] {
+			objects = append(objects, ObjectToPack{ID: entry.ID})
+		}
+	}
+	checksum, err := manager.Write(objects, options)
+	if err != nil {
+		return nil, err
+	}
+	return checksum, manager.WriteMultiPackIndex(MidxWriteOptions{})
+}
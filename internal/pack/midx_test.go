@@ -0,0 +1,118 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+func TestMultiPackIndex(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.InitBare(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The second pack holds every object of the first one
+	storeBlobs(t, st, versions(3))
+	old, err := filepath.Glob(filepath.Join(dir, "objects", "pack", "*.pack"))
+	if err != nil || len(old) != 1 {
+		t.Fatalf("expected: a single pack\tactual: %v %v", old, err)
+	}
+	if err := os.Chtimes(old[0], time.Unix(1000, 0), time.Unix(1000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	ids := storeBlobs(t, st, versions(4))
+
+	manager := New(st)
+	if err := manager.WriteMultiPackIndex(MidxWriteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manager.VerifyMultiPackIndex(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		object, err := New(st).Object(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual, _ := object.Hash(); !bytes.Equal(actual, id) {
+			t.Fatalf("expected: %x\tactual: %x", id, actual)
+		}
+	}
+
+	// Every object is found in the most recent pack, the first one can go
+	expired, err := manager.ExpireMultiPackIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || filepath.Base(old[0]) != "pack-"+hash.ChecksumToHex(expired[0])+".pack" {
+		t.Fatalf("expected: %s expired\tactual: %x", old[0], expired)
+	}
+	if err := manager.VerifyMultiPackIndex(); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if _, err := manager.Object(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeMultiPackIndex(t *testing.T) {
+	ids := [][]byte{bytes.Repeat([]byte{1}, 20), bytes.Repeat([]byte{2}, 20), bytes.Repeat([]byte{3}, 20)}
+	packs := []MidxPack{
+		{Checksum: bytes.Repeat([]byte{0xbb}, 20), Entries: []IndexEntry{{ID: ids[0], Offset: 12}, {ID: ids[1], Offset: 1 << 33}}},
+		{Checksum: bytes.Repeat([]byte{0xaa}, 20), Entries: []IndexEntry{{ID: ids[1], Offset: 40}, {ID: ids[2], Offset: 80}}, ModTime: time.Unix(100, 0)},
+	}
+	var buf bytes.Buffer
+	checksum, err := encodeMultiPackIndex(newMultiPackIndex(packs), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	midx, err := DecodeMultiPackIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Packs are sorted by name and the object stored twice is found in the recent pack
+	expected := []MidxEntry{{ID: ids[0], Pack: 1, Offset: 12}, {ID: ids[1], Pack: 0, Offset: 40}, {ID: ids[2], Pack: 0, Offset: 80}}
+	if !bytes.Equal(midx.Checksum, checksum) {
+		t.Fatalf("expected: checksum %x\tactual: %x", checksum, midx.Checksum)
+	}
+	if len(midx.Packs) != 2 || !bytes.Equal(midx.Packs[0], packs[1].Checksum) || len(midx.Entries) != len(expected) {
+		t.Fatalf("expected: packs %x, %x\tactual: %x with %d objects", packs[1].Checksum, packs[0].Checksum, midx.Packs, len(midx.Entries))
+	}
+	for i, entry := range midx.Entries {
+		if !bytes.Equal(entry.ID, expected[i].ID) || entry.Pack != expected[i].Pack || entry.Offset != expected[i].Offset {
+			t.Fatalf("expected: %v\tactual: %v", expected[i], entry)
+		}
+	}
+
+	// Offsets too large for 31 bits go to the LOFF chunk
+	packs[0].ModTime = time.Unix(200, 0)
+	buf.Reset()
+	if _, err := encodeMultiPackIndex(newMultiPackIndex(packs), &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(MIDX_CHUNK_LARGE_OFFSETS)) {
+		t.Fatalf("expected: a %s chunk", MIDX_CHUNK_LARGE_OFFSETS)
+	}
+	midx, err = DecodeMultiPackIndex(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry := midx.Entries[1]; entry.Pack != 1 || entry.Offset != 1<<33 {
+		t.Fatalf("expected: offset %d in pack 1\tactual: offset %d in pack %d", 1<<33, entry.Offset, entry.Pack)
+	}
+
+	corrupted := bytes.Clone(buf.Bytes())
+	corrupted[MIDX_HEADER_SIZE] ^= 0xff
+	if _, err := DecodeMultiPackIndex(corrupted); !errors.Is(err, ErrInvalidMultiPackIndex) {
+		t.Fatalf("expected: %v\tactual: %v", ErrInvalidMultiPackIndex, err)
+	}
+}
@@ -0,0 +1,93 @@
+package pack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+const (
+	MTIMES_VERSION = 1
+	// MTIMES_SHA1 identifies the hash function of the ids of the pack
+	MTIMES_SHA1        = 1
+	MTIMES_HEADER_SIZE = 12
+	MTIME_SIZE         = 4
+)
+
+var (
+	// MTIMES_MAGIC starts the mtimes files of cruft packs
+	MTIMES_MAGIC = []byte("MTME")
+
+	ErrInvalidMtimes = errors.New("invalid mtimes file")
+)
+
+// CruftObject is an unreachable object kept in a cruft pack until it expires
+type CruftObject struct {
+	ID []byte
+	// ModTime is when the object was last written, from which its expiry is counted
+	ModTime time.Time
+}
+
+// encodeMtimes writes the mtimes file of a cruft pack, as git does: a header followed by
+// the modification time of each object in the order of the index, the checksum of the
+// pack and the checksum of the file.
+func encodeMtimes(objects []CruftObject, packChecksum []byte, w io.Writer) error {
+	sorted := slices.Clone(objects)
+	slices.SortFunc(sorted, func(a, b CruftObject) int {
+		return bytes.Compare(a.ID, b.ID)
+	})
+	sum := hash.NewHashWriter(w, hash.SHA1)
+	bw := bufio.NewWriter(sum)
+	header := make([]byte, MTIMES_HEADER_SIZE)
+	copy(header, MTIMES_MAGIC)
+	binary.BigEndian.PutUint32(header[4:], MTIMES_VERSION)
+	binary.BigEndian.PutUint32(header[8:], MTIMES_SHA1)
+	bw.Write(header)
+	for _, object := range sorted {
+		if err := binary.Write(bw, binary.BigEndian, uint32(object.ModTime.Unix())); err != nil {
+			return err
+		}
+	}
+	bw.Write(packChecksum)
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	_, err := w.Write(sum.Sum(nil))
+	return err
+}
+
+// DecodeMtimes returns the modification times recorded by the mtimes file of a cruft pack
+// of count objects, in the order of its index. The checksum of the file is verified.
+func DecodeMtimes(mtimes []byte, count int, packChecksum []byte) ([]time.Time, error) {
+	if len(mtimes) != MTIMES_HEADER_SIZE+count*MTIME_SIZE+2*CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: expected %d objects", ErrInvalidMtimes, count)
+	}
+	if !bytes.HasPrefix(mtimes, MTIMES_MAGIC) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidMtimes)
+	}
+	if version := binary.BigEndian.Uint32(mtimes[4:8]); version != MTIMES_VERSION {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidMtimes, version)
+	}
+	trailer := len(mtimes) - CHECKSUM_LEN
+	sum := hash.New(hash.SHA1)
+	sum.Write(mtimes[:trailer])
+	if !bytes.Equal(sum.Sum(nil), mtimes[trailer:]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrInvalidMtimes)
+	}
+	if !bytes.Equal(mtimes[trailer-CHECKSUM_LEN:trailer], packChecksum) {
+		return nil, fmt.Errorf("%w: not the mtimes of pack %x", ErrInvalidMtimes, packChecksum)
+	}
+	times := make([]time.Time, count)
+	for i := range times {
+		offset := MTIMES_HEADER_SIZE + i*MTIME_SIZE
+		times[i] = time.Unix(int64(binary.BigEndian.Uint32(mtimes[offset:])), 0)
+	}
+	return times, nil
+}
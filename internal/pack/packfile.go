@@ -1,22 +1,19 @@
 package pack
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	common "github.com/codecrafters-io/git-starter-go/internal"
-	"github.com/codecrafters-io/git-starter-go/internal/hash"
 	store "github.com/codecrafters-io/git-starter-go/internal/stor"
 	"github.com/codecrafters-io/git-starter-go/internal/util"
 )
 
-const (
-	BUFFER_SIZE = 2 * (1 << 20)
-)
-
 type PackObject struct {
 	objectType   common.ObjectType
 	content      []byte
@@ -25,54 +22,29 @@ type PackObject struct {
 	size         int    // The size of the uncompressed object
 }
 
-// Packfile represents a packfile in the store
+// Packfile represents a packfile in the store. Objects are read from the file as needed
+// rather than loading the pack in memory.
 type Packfile struct {
-	buffer       []byte
+	file         io.ReaderAt
 	totalObjects int
-	reader       *bytes.Reader
-	readObjects  int
-	checksum     []byte
-}
-
-func WritePackfile(r io.Reader, w io.Writer) ([]byte, error) {
-	var buffer bytes.Buffer
-	if _, err := util.LimitedRead(r, &buffer, BUFFER_SIZE); err != nil {
-		return nil, err
-	}
-	if buffer.Len() < (HEADER_LEN + CHECKSUM_LEN) {
-		return nil, errors.New("pack file too small")
-	}
-
-	if _, err := verifyHeader(buffer.Bytes()[:HEADER_LEN]); err != nil {
-		return nil, fmt.Errorf("invalid header: %w", err)
-	}
-
-	hashFn := hash.New(hash.SHA1)
-	if _, err := hashFn.Write(buffer.Bytes()[:buffer.Len()-CHECKSUM_LEN]); err != nil {
-		return nil, err
-	}
-
-	calChecksum := hashFn.Sum(nil)
-	if !bytes.Equal(calChecksum, buffer.Bytes()[buffer.Len()-CHECKSUM_LEN:]) {
-		return nil, errors.New("failed to validate checksum")
-	}
-	if _, err := io.Copy(w, &buffer); err != nil {
-		return nil, err
-	}
-	return calChecksum, nil
+	// offset is where ReadObject reads the next object
+	offset      int
+	readObjects int
+	checksum    []byte
 }
 
 func NewPackfile(checksum []byte, file store.ReadOnlyFile) (*Packfile, error) {
+	return newPackfileFromReaderAt(checksum, file)
+}
 
-	var buffer bytes.Buffer
-	if _, err := util.LimitedRead(file, &buffer, BUFFER_SIZE); err != nil {
-		return nil, err
-	}
-
-	reader := bytes.NewReader(buffer.Bytes())
+// newPackfileFromBytes returns the packfile held in data
+func newPackfileFromBytes(checksum []byte, data []byte) (*Packfile, error) {
+	return newPackfileFromReaderAt(checksum, bytes.NewReader(data))
+}
 
-	header := make([]byte, 12)
-	if _, err := reader.Read(header); err != nil {
+func newPackfileFromReaderAt(checksum []byte, file io.ReaderAt) (*Packfile, error) {
+	header := make([]byte, HEADER_LEN)
+	if _, err := file.ReadAt(header, 0); err != nil {
 		return nil, err
 	}
 
@@ -82,9 +54,9 @@ func NewPackfile(checksum []byte, file store.ReadOnlyFile) (*Packfile, error) {
 	}
 
 	return &Packfile{
-		buffer:       buffer.Bytes(),
+		file:         file,
 		totalObjects: numObjects,
-		reader:       reader,
+		offset:       HEADER_LEN,
 		checksum:     checksum,
 	}, nil
 }
@@ -96,29 +68,44 @@ func (p *Packfile) TotalObjects() int {
 
 // ReadingOffset returns the current reading offset
 func (p *Packfile) ReadingOffset() int {
-	return int(p.reader.Size()) - p.reader.Len()
+	return p.offset
 }
 
-// ReadObjectAt start reading an object start offset. n is the number of bytes read.
+// ReadObjectAt start reading an object start offset.
 //
 // It does not effect the underlying reading offset
 func (p *Packfile) ReadObjectAt(offset int64, object *PackObject) (err error) {
-	prevOffset := p.ReadingOffset()
+	_, err = p.readEntry(offset, object)
+	return err
+}
 
-	if _, err := p.reader.Seek(offset, io.SeekStart); err != nil {
-		return err
-	}
+// entryHeader is the header of a pack entry, along with the base of a delta
+type entryHeader struct {
+	objectType common.ObjectType
+	baseOffset int
+	baseID     []byte
+}
 
-	if err = readObject(p.reader, object); err != nil {
-		return err
+// readEntryHeaderAt reads the header of the entry at offset, leaving its data compressed
+func (p *Packfile) readEntryHeaderAt(offset int64) (header entryHeader, err error) {
+	r := bufio.NewReaderSize(io.NewSectionReader(p.file, offset, math.MaxInt64-offset), 64)
+	if header.objectType, _, err = readEntryHeader(r); err != nil {
+		return header, err
 	}
-
-	// Move seeking offset back to where it was
-	if _, err := p.reader.Seek(int64(prevOffset), io.SeekStart); err != nil {
-		return err
+	switch header.objectType {
+	case common.OBJ_OFS_DELTA:
+		baseOffset, err := readOffsetEncoding(r)
+		if err != nil {
+			return header, err
+		}
+		header.baseOffset = int(baseOffset)
+	case common.OBJ_REF_DELTA:
+		header.baseID = make([]byte, CHECKSUM_LEN)
+		if _, err := io.ReadFull(r, header.baseID); err != nil {
+			return header, err
+		}
 	}
-
-	return err
+	return header, nil
 }
 
 // ReadObject reads an object starting at the underlying reading offset
@@ -130,18 +117,49 @@ func (p *Packfile) ReadObject(object *PackObject) (offset int, err error) {
 		return offset, io.EOF
 	}
 
-	offset = p.ReadingOffset()
+	offset = p.offset
 
-	err = readObject(p.reader, object)
+	next, err := p.readEntry(int64(offset), object)
 	if err != nil {
 		return offset, fmt.Errorf("failed to read object: %w", err)
 	}
 
+	p.offset = int(next)
 	p.readObjects++
 
 	return offset, err
 }
 
+// readEntry reads the entry at offset and returns the offset of the next entry
+func (p *Packfile) readEntry(offset int64, object *PackObject) (next int64, err error) {
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(p.file, offset, math.MaxInt64-offset))}
+	if err := readObject(r, object); err != nil {
+		return 0, err
+	}
+	return offset + r.n, nil
+}
+
+// countingReader counts the bytes read. Being a byte reader, the decompression of an
+// object does not read past its end.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
 type ByteCounter struct {
 	counter int
 }
@@ -172,7 +190,7 @@ func readObject(r io.Reader, objectEntry *PackObject) error {
 
 	if objectType == common.OBJ_REF_DELTA {
 		baseChecksum := make([]byte, 20)
-		if _, err := r.Read(baseChecksum); err != nil {
+		if _, err := io.ReadFull(r, baseChecksum); err != nil {
 			return fmt.Errorf("failed to read ref delta checksum: %w", err)
 		}
 		objectEntry.baseChecksum = baseChecksum
@@ -224,7 +242,7 @@ func readOffsetEncoding(r io.Reader) (uint, error) {
 	}
 	if n >= 2 {
 		for i := uint(1); i < n; i++ {
-			offset += i << (7 * i)
+			offset += 1 << (7 * i)
 		}
 	}
 	return offset, nil
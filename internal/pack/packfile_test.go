@@ -1,10 +1,17 @@
 package pack
 
 import (
+	"bytes"
 	"io"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
 
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
 	packfileFixture "github.com/codecrafters-io/git-starter-go/internal/pack/fixture"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
 )
 
 func TestReadObject(t *testing.T) {
@@ -103,3 +110,55 @@ func TestReadObjectAt(t *testing.T) {
 	}
 
 }
+
+func TestFromLeavesNothingOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	st, err := store.InitBare(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(common.OBJ_BLOB, []byte("hello world\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	manager := New(st)
+	packDir := filepath.Join(dir, store.PACK_PREFIX)
+
+	// A pack cut short fails its checksum
+	if _, err := manager.From(bytes.NewReader(buf.Bytes()[:buf.Len()-5])); err == nil {
+		t.Fatalf("expected a truncated pack to be rejected")
+	}
+	entries, err := os.ReadDir(packDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected: no file in %s\tactual: %s", packDir, entries[0].Name())
+	}
+
+	checksum, err := manager.From(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = os.ReadDir(packDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	hexChecksum := hash.ChecksumToHex(checksum)
+	expected := []string{"pack-" + hexChecksum + ".idx", "pack-" + hexChecksum + ".pack"}
+	if len(names) != 2 || names[0] != expected[0] || names[1] != expected[1] {
+		t.Fatalf("expected: %v\tactual: %v", expected, names)
+	}
+}
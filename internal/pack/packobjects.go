@@ -0,0 +1,345 @@
+package pack
+
+import (
+	"bufio"
+	"cmp"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+	"unicode"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+const (
+	DEFAULT_WINDOW = 10
+	DEFAULT_DEPTH  = 50
+	// MIN_DELTA_SIZE is the size below which objects are neither deltified nor delta bases
+	MIN_DELTA_SIZE = 2 * DELTA_BLOCK_SIZE
+)
+
+// WriteOptions tune the delta compression of the packs written by WriteObjects
+type WriteOptions struct {
+	// Window is the number of objects each object is compared to when looking for a
+	// delta base. 0 disables the search for new deltas.
+	Window int
+	// Depth is the longest chain of deltas to reach an object
+	Depth int
+	// Threads is the number of deltas searched in parallel, 0 uses every CPU
+	Threads int
+	// OfsDelta refers to delta bases by offset rather than by id, which only readers
+	// asking for ofs-delta understand
+	OfsDelta bool
+	// ReuseDelta copies the deltas of the source packs rather than searching new ones
+	ReuseDelta bool
+}
+
+// DefaultWriteOptions returns the options git uses by default
+func DefaultWriteOptions() WriteOptions {
+	return WriteOptions{
+		Window:     DEFAULT_WINDOW,
+		Depth:      DEFAULT_DEPTH,
+		ReuseDelta: true,
+	}
+}
+
+// ObjectToPack is an object written by WriteObjects
+type ObjectToPack struct {
+	ID []byte
+	// Name is the path the object was found at. Objects are compared to the objects of
+	// similar names first when looking for a delta base.
+	Name string
+	// NameHash is the hash of the name when only the hash is known, as for the objects
+	// listed from bitmaps. It is ignored when the name is set.
+	NameHash uint32
+}
+
+// ObjectSource is where the objects written to a pack are read from
+type ObjectSource interface {
+	Object([]byte) (common.Object, error)
+}
+
+// deltaSource is an ObjectSource whose packs hold deltas that can be reused
+type deltaSource interface {
+	storedDelta([]byte) (baseID []byte, delta []byte, ok bool, err error)
+}
+
+// packingObject is an object being written along with the delta it is written as
+type packingObject struct {
+	id         []byte
+	objectType common.ObjectType
+	size       int
+	nameHash   uint32
+	// order is the position of the object in the list to write
+	order int
+	base  *packingObject
+	delta []byte
+	// reused is set when the delta is copied from a source pack
+	reused  bool
+	depth   int
+	written bool
+}
+
+// packWriter searches deltas for the objects of a pack and writes them. Reads from the
+// source are serialized, the search for deltas runs in parallel.
+type packWriter struct {
+	db      ObjectSource
+	mu      sync.Mutex
+	options WriteOptions
+}
+
+// WriteObjects writes the objects as a pack to w and returns the closed encoder, whose
+// index can then be written. Objects are compressed as deltas of similar objects of the
+// pack, following the heuristics of git: objects are sorted by type, name and size so
+// that each object is compared to the objects of the window before it. Objects are read
+// as needed rather than held in memory.
+func WriteObjects(db ObjectSource, w io.Writer, objects []ObjectToPack, options WriteOptions) (*Encoder, error) {
+	writer := &packWriter{db: db, options: options}
+	entries, err := writer.prepare(objects)
+	if err != nil {
+		return nil, err
+	}
+	if options.ReuseDelta {
+		if err := writer.reuseDeltas(entries); err != nil {
+			return nil, err
+		}
+	}
+	if options.Window > 0 && options.Depth > 0 {
+		if err := writer.searchDeltas(entries); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range entries {
+		limitDepth(entry, options.Depth)
+	}
+	return writer.write(w, entries)
+}
+
+// prepare reads the type and size of the objects, leaving out duplicates
+func (pw *packWriter) prepare(objects []ObjectToPack) ([]*packingObject, error) {
+	entries := make([]*packingObject, 0, len(objects))
+	seen := map[string]bool{}
+	for _, object := range objects {
+		key := hash.ChecksumToHex(object.ID)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		encodedObject, err := pw.db.Object(object.ID)
+		if err != nil {
+			return nil, err
+		}
+		entry := &packingObject{
+			id:         object.ID,
+			objectType: encodedObject.Type(),
+			size:       encodedObject.Size(),
+			nameHash:   object.NameHash,
+			order:      len(entries),
+		}
+		if object.Name != "" {
+			entry.nameHash = nameHash(object.Name)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// reuseDeltas takes the deltas of the source packs whose base is also written
+func (pw *packWriter) reuseDeltas(entries []*packingObject) error {
+	source, ok := pw.db.(deltaSource)
+	if !ok {
+		return nil
+	}
+	byID := map[string]*packingObject{}
+	for _, entry := range entries {
+		byID[hash.ChecksumToHex(entry.id)] = entry
+	}
+	for _, entry := range entries {
+		baseID, delta, ok, err := source.storedDelta(entry.id)
+		if err != nil {
+			return err
+		}
+		if base := byID[hash.ChecksumToHex(baseID)]; ok && base != nil {
+			entry.base, entry.delta, entry.reused = base, delta, true
+		}
+	}
+	// Objects stored in different packs may be deltas of each other
+	for _, entry := range entries {
+		for cur := entry.base; cur != nil; cur = cur.base {
+			if cur == entry {
+				entry.base, entry.delta, entry.reused = nil, nil, false
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// searchDeltas splits the sorted objects in as many parts as there are threads and
+// searches the deltas of each part in parallel
+func (pw *packWriter) searchDeltas(entries []*packingObject) error {
+	sorted := slices.Clone(entries)
+	slices.SortFunc(sorted, func(a, b *packingObject) int {
+		return cmp.Or(
+			-cmp.Compare(a.objectType, b.objectType),
+			-cmp.Compare(a.nameHash, b.nameHash),
+			-cmp.Compare(a.size, b.size),
+			cmp.Compare(a.order, b.order),
+		)
+	})
+	threads := pw.options.Threads
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	// Parts smaller than a few windows lose more deltas at their edges than they gain
+	partSize := max((len(sorted)+threads-1)/threads, 4*pw.options.Window)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, threads)
+	for start := 0; start < len(sorted); start += partSize {
+		part := sorted[start:min(start+partSize, len(sorted))]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pw.findDeltas(part); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// windowEntry is an object of the window along with its content and the index of its
+// blocks, built the first time it is tried as a base
+type windowEntry struct {
+	object  *packingObject
+	content []byte
+	index   *deltaIndex
+}
+
+// findDeltas compares every object to the objects of the window before it and keeps the
+// smallest delta. Objects whose delta is reused are neither searched nor used as bases,
+// so that the chains of new deltas never loop through them.
+func (pw *packWriter) findDeltas(part []*packingObject) error {
+	window := []*windowEntry{}
+	for _, target := range part {
+		if target.reused || target.size < MIN_DELTA_SIZE {
+			continue
+		}
+		content, err := pw.content(target)
+		if err != nil {
+			return err
+		}
+		// A delta is only worth it when it is at most half the size of the object
+		maxSize := target.size/2 - CHECKSUM_LEN
+		for i := len(window) - 1; i >= 0; i-- {
+			candidate := window[i]
+			base := candidate.object
+			if base.objectType != target.objectType || base.depth >= pw.options.Depth || target.size < base.size/32 {
+				continue
+			}
+			// Deeper bases have to earn their place with a smaller delta
+			limit := maxSize * (pw.options.Depth - base.depth) / pw.options.Depth
+			if target.delta != nil {
+				limit = min(limit, len(target.delta)-1)
+			}
+			if limit <= 0 {
+				continue
+			}
+			if candidate.index == nil {
+				candidate.index = newDeltaIndex(candidate.content)
+			}
+			if delta, ok := candidate.index.delta(content, limit); ok {
+				target.base, target.delta, target.depth = base, delta, base.depth+1
+			}
+		}
+		window = append(window, &windowEntry{object: target, content: content})
+		if len(window) > pw.options.Window {
+			window = window[1:]
+		}
+	}
+	return nil
+}
+
+// limitDepth returns the depth of the delta chain of an object, dropping reused deltas
+// whose chain would be deeper than depth
+func limitDepth(object *packingObject, depth int) int {
+	if object.base == nil {
+		return 0
+	}
+	if !object.reused {
+		return object.depth
+	}
+	object.depth = limitDepth(object.base, depth) + 1
+	if object.depth > depth {
+		object.base, object.delta, object.reused, object.depth = nil, nil, false, 0
+	}
+	return object.depth
+}
+
+// write writes the objects in their original order, each delta base before its deltas
+func (pw *packWriter) write(w io.Writer, entries []*packingObject) (*Encoder, error) {
+	buffered := bufio.NewWriter(w)
+	encoder, err := NewEncoder(buffered, len(entries))
+	if err != nil {
+		return nil, err
+	}
+	var writeObject func(object *packingObject) error
+	writeObject = func(object *packingObject) error {
+		if object.written {
+			return nil
+		}
+		object.written = true
+		if object.base == nil {
+			content, err := pw.content(object)
+			if err != nil {
+				return err
+			}
+			return encoder.Encode(object.objectType, content)
+		}
+		if err := writeObject(object.base); err != nil {
+			return err
+		}
+		if pw.options.OfsDelta {
+			return encoder.EncodeOfsDelta(object.id, object.base.id, object.delta)
+		}
+		return encoder.EncodeRefDelta(object.id, object.base.id, object.delta)
+	}
+	for _, entry := range entries {
+		if err := writeObject(entry); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return encoder, buffered.Flush()
+}
+
+// content reads the content of an object from the source
+func (pw *packWriter) content(object *packingObject) ([]byte, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	encodedObject, err := pw.db.Object(object.id)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(encodedObject)
+}
+
+// nameHash hashes a path so that paths ending alike sort next to each other, as in git.
+// The last characters weigh the most.
+func nameHash(name string) uint32 {
+	var h uint32
+	for _, c := range name {
+		if unicode.IsSpace(c) {
+			continue
+		}
+		h = h>>2 + uint32(c)<<24
+	}
+	return h
+}
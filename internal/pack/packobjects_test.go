@@ -0,0 +1,172 @@
+package pack
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// storeBlobs stores a pack of blobs and returns their ids
+func storeBlobs(t *testing.T, st store.Store, contents [][]byte) [][]byte {
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, len(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := [][]byte{}
+	for _, content := range contents {
+		if err := encoder.Encode(common.OBJ_BLOB, content); err != nil {
+			t.Fatal(err)
+		}
+		id, _ := common.NewObjectBuffer(common.OBJ_BLOB, content).Hash()
+		ids = append(ids, id)
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(st).From(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+// versions returns the successive versions of a file, each one line longer
+func versions(n int) [][]byte {
+	contents := [][]byte{}
+	var content bytes.Buffer
+	for i := 0; i < 100; i++ {
+		fmt.Fprintf(&content, "line %d of the file\n", i)
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&content, "line added by version %d\n", i)
+		contents = append(contents, bytes.Clone(content.Bytes()))
+	}
+	return contents
+}
+
+func TestWriteObjectsDeltas(t *testing.T) {
+	source, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := versions(8)
+	ids := storeBlobs(t, source, contents)
+	objects := []ObjectToPack{}
+	for _, id := range ids {
+		objects = append(objects, ObjectToPack{ID: id, Name: "file.txt"})
+	}
+
+	for _, options := range []WriteOptions{
+		{Window: 10, Depth: 50, Threads: 1},
+		{Window: 10, Depth: 2, Threads: 1},
+		{Window: 10, Depth: 50, Threads: 4},
+		{Window: 0},
+	} {
+		var buf bytes.Buffer
+		if _, err := WriteObjects(New(source), &buf, objects, options); err != nil {
+			t.Fatal(err)
+		}
+		packfile, err := newPackfileFromBytes(nil, buf.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Every base is written before its deltas
+		depths := map[string]int{}
+		written := map[string][]byte{}
+		deltas := 0
+		for i := 0; i < packfile.TotalObjects(); i++ {
+			object := &PackObject{}
+			if _, err := packfile.ReadObject(object); err != nil {
+				t.Fatal(err)
+			}
+			content := object.content
+			depth := 0
+			if object.objectType == common.OBJ_REF_DELTA {
+				base, ok := written[string(object.baseChecksum)]
+				if !ok {
+					t.Fatalf("expected the base %x to be written before its delta", object.baseChecksum)
+				}
+				if content, err = applyDelta(object.content, base); err != nil {
+					t.Fatal(err)
+				}
+				depth = depths[string(object.baseChecksum)] + 1
+				deltas++
+			}
+			if depth > options.Depth && options.Window > 0 {
+				t.Fatalf("expected: chains of at most %d deltas\tactual: %d", options.Depth, depth)
+			}
+			id, _ := common.NewObjectBuffer(common.OBJ_BLOB, content).Hash()
+			depths[string(id)] = depth
+			written[string(id)] = content
+		}
+		if options.Window == 0 && deltas != 0 {
+			t.Fatalf("expected: no delta without a window\tactual: %d deltas", deltas)
+		}
+		if options.Window > 0 && deltas != len(ids)-1 {
+			t.Fatalf("expected: %d deltas\tactual: %d", len(ids)-1, deltas)
+		}
+
+		// The pack reads back to the same objects
+		target, err := store.InitBare(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		manager := New(target)
+		if _, err := manager.From(&buf); err != nil {
+			t.Fatal(err)
+		}
+		for i, id := range ids {
+			object, err := manager.Object(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(content, contents[i]) {
+				t.Fatalf("object %x\texpected: %d bytes\tactual: %d bytes", id, len(contents[i]), len(content))
+			}
+		}
+	}
+}
+
+func TestWriteObjectsReusesDeltas(t *testing.T) {
+	source, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := versions(4)
+	ids := storeBlobs(t, source, contents)
+	objects := []ObjectToPack{}
+	for _, id := range ids {
+		objects = append(objects, ObjectToPack{ID: id, Name: "file.txt"})
+	}
+	// Repack the source so that its objects are stored as deltas
+	var deltified bytes.Buffer
+	if _, err := WriteObjects(New(source), &deltified, objects, DefaultWriteOptions()); err != nil {
+		t.Fatal(err)
+	}
+	reused, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(reused).From(bytes.NewReader(deltified.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a window the only deltas are the stored ones
+	options := DefaultWriteOptions()
+	options.Window = 0
+	var buf bytes.Buffer
+	if _, err := WriteObjects(New(reused), &buf, objects, options); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), deltified.Bytes()) {
+		t.Fatalf("expected: the stored deltas to be copied")
+	}
+}
@@ -0,0 +1,64 @@
+package pack
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// Promisor fetches the objects left out of a partial clone from the remote that promised them
+type Promisor interface {
+	// FetchObjects returns a pack holding the objects ids
+	FetchObjects(ids [][]byte) (io.ReadCloser, error)
+}
+
+// NewWithPromisor returns a manager that fetches objects missing from store from promisor
+func NewWithPromisor(store store.Store, promisor Promisor) *DefaulPackManager {
+	return &DefaulPackManager{
+		store:    store,
+		promisor: promisor,
+		cache:    newDeltaBaseCache(DEFAULT_DELTA_BASE_CACHE_LIMIT),
+	}
+}
+
+// FromPromisor stores a pack fetched from a promisor remote and marks it as such
+func (manager *DefaulPackManager) FromPromisor(r io.Reader) ([]byte, error) {
+	checksum, err := manager.From(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.store.WritePackPromisor(hash.ChecksumToHex(checksum)); err != nil {
+		return nil, err
+	}
+	return checksum, nil
+}
+
+// Prefetch fetches the objects among ids that are missing from the store in a single
+// request to the promisor remote. Without a promisor remote it does nothing.
+func (manager *DefaulPackManager) Prefetch(ids [][]byte) error {
+	if manager.promisor == nil {
+		return nil
+	}
+	missing := [][]byte{}
+	for _, id := range ids {
+		ok, err := manager.ObjectExist(id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	r, err := manager.promisor.FetchObjects(missing)
+	if err != nil {
+		return fmt.Errorf("failed to fetch missing objects from promisor remote: %w", err)
+	}
+	defer r.Close()
+	_, err = manager.FromPromisor(r)
+	return err
+}
@@ -0,0 +1,76 @@
+package pack
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// fakePromisor serves a pack holding a single blob and records the ids requested
+type fakePromisor struct {
+	content   []byte
+	requested [][]byte
+}
+
+func (p *fakePromisor) FetchObjects(ids [][]byte) (io.ReadCloser, error) {
+	p.requested = append(p.requested, ids...)
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := encoder.Encode(common.OBJ_BLOB, p.content); err != nil {
+		return nil, err
+	}
+	if _, err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(&buf), nil
+}
+
+func TestPromisorLazyFetch(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("hello world\n")
+	id, err := common.NewObjectBuffer(common.OBJ_BLOB, content).Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	promisor := &fakePromisor{content: content}
+	manager := NewWithPromisor(st, promisor)
+
+	object, err := manager.Object(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.Type() != common.OBJ_BLOB || object.Size() != len(content) {
+		t.Fatalf("expected: blob of size %d\tactual: %s of size %d", len(content), object.Type(), object.Size())
+	}
+	if len(promisor.requested) != 1 || !bytes.Equal(promisor.requested[0], id) {
+		t.Fatalf("expected: requested=[%x]\tactual: requested=%x", id, promisor.requested)
+	}
+
+	// the object is now local so prefetching it again must not hit the remote
+	if err := manager.Prefetch([][]byte{id}); err != nil {
+		t.Fatal(err)
+	}
+	if len(promisor.requested) != 1 {
+		t.Fatalf("expected: 1 request\tactual: %d requests", len(promisor.requested))
+	}
+
+	packs, err := st.ListPacks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 1 {
+		t.Fatalf("expected: 1 pack\tactual: %d packs", len(packs))
+	}
+	if ok, err := st.IsPackPromisor(packs[0]); err != nil || !ok {
+		t.Fatalf("expected pack %s to be marked as promisor", packs[0])
+	}
+}
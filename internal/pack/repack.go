@@ -0,0 +1,149 @@
+package pack
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+// StoredObject is an object of the repository as listed by StoredObjects
+type StoredObject struct {
+	ID []byte
+	// Pack is the checksum of the pack holding the object, nil for loose objects
+	Pack []byte
+	// Cruft is set for the objects of cruft packs
+	Cruft bool
+	// ModTime is when the object was last written: the modification time of the loose
+	// object or of its pack, or the time recorded by its cruft pack
+	ModTime time.Time
+}
+
+// StoredObjects lists every copy of every object of the repository, packed or loose
+func (manager *DefaulPackManager) StoredObjects() ([]StoredObject, error) {
+	objects := []StoredObject{}
+	packs, err := manager.store.ListPackIndices()
+	if err != nil {
+		return nil, err
+	}
+	for _, hexChecksum := range packs {
+		packObjects, err := manager.packObjects(hexChecksum)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, packObjects...)
+	}
+	loose, err := manager.store.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, hexChecksum := range loose {
+		id, err := hash.ChecksumFromHex(hexChecksum)
+		if err != nil {
+			return nil, err
+		}
+		info, err := manager.store.StatObject(hexChecksum)
+		if err != nil {
+			// The object was pruned since it was listed
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		objects = append(objects, StoredObject{ID: id, ModTime: info.ModTime()})
+	}
+	return objects, nil
+}
+
+// packObjects lists the objects of the pack, dated by the mtimes file of a cruft pack or
+// by the pack itself
+func (manager *DefaulPackManager) packObjects(hexChecksum string) ([]StoredObject, error) {
+	index, err := readAll(manager.store.NewPackIndexReader(hexChecksum))
+	if err != nil {
+		return nil, err
+	}
+	entries, checksum, err := DecodeIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]StoredObject, len(entries))
+	mtimes, err := readAll(manager.store.NewPackMtimesReader(hexChecksum))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if mtimes != nil {
+		times, err := DecodeMtimes(mtimes, len(entries), checksum)
+		if err != nil {
+			return nil, err
+		}
+		for i, entry := range entries {
+			objects[i] = StoredObject{ID: entry.ID, Pack: checksum, Cruft: true, ModTime: times[i]}
+		}
+		return objects, nil
+	}
+	info, err := manager.store.StatPack(hexChecksum)
+	if err != nil {
+		return nil, err
+	}
+	for i, entry := range entries {
+		objects[i] = StoredObject{ID: entry.ID, Pack: checksum, ModTime: info.ModTime()}
+	}
+	return objects, nil
+}
+
+// readAll reads a file opened by the store
+func readAll(file io.ReadCloser, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// WriteCruft stores a cruft pack of unreachable objects along with the mtimes file
+// recording when each object was last written, so that the objects only expire once
+// they have been unreachable long enough. The checksum of the pack is returned.
+func (manager *DefaulPackManager) WriteCruft(objects []CruftObject, options WriteOptions) ([]byte, error) {
+	toPack := make([]ObjectToPack, len(objects))
+	for i, object := range objects {
+		toPack[i] = ObjectToPack{ID: object.ID}
+	}
+	return manager.write(toPack, options, objects)
+}
+
+// RemovePack deletes a pack and stops searching objects in it. A multi-pack-index
+// covering the pack is deleted first, as git does.
+func (manager *DefaulPackManager) RemovePack(checksum []byte) error {
+	// Load the multi-pack-index if it has not been yet
+	if _, err := manager.IndexfilesIter(); err != nil {
+		return err
+	}
+	if manager.midx != nil && manager.midx.covers(checksum) {
+		manager.midx.file.Close()
+		manager.midx = nil
+		if err := manager.store.RemoveMultiPackIndex(); err != nil {
+			return err
+		}
+	}
+	manager.bitmaps, manager.bitmapsLoaded = nil, false
+	manager.indexfiles = slices.DeleteFunc(manager.indexfiles, func(indexfile *Indexfile) bool {
+		if !bytes.Equal(indexfile.packfile, checksum) {
+			return false
+		}
+		indexfile.file.Close()
+		return true
+	})
+	manager.packfiles = slices.DeleteFunc(manager.packfiles, func(packfile *Packfile) bool {
+		if !bytes.Equal(packfile.checksum, checksum) {
+			return false
+		}
+		if file, ok := packfile.file.(io.Closer); ok {
+			file.Close()
+		}
+		return true
+	})
+	return manager.store.RemovePack(hash.ChecksumToHex(checksum))
+}
@@ -0,0 +1,92 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+func TestWriteCruft(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := storeBlobs(t, st, versions(3))
+	manager := New(st)
+	cruft := []CruftObject{}
+	for i, id := range ids {
+		cruft = append(cruft, CruftObject{ID: id, ModTime: time.Unix(int64(1000*(i+1)), 0)})
+	}
+	checksum, err := manager.WriteCruft(cruft, DefaultWriteOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := manager.StoredObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, object := range stored {
+		if !bytes.Equal(object.Pack, checksum) {
+			if object.Cruft {
+				t.Fatalf("expected: only the objects of %x in a cruft pack\tactual: %x of %x", checksum, object.ID, object.Pack)
+			}
+			continue
+		}
+		for _, expected := range cruft {
+			if bytes.Equal(expected.ID, object.ID) {
+				found++
+				if !object.Cruft || !object.ModTime.Equal(expected.ModTime) {
+					t.Fatalf("expected: %x written at %v\tactual: cruft=%v written at %v", object.ID, expected.ModTime, object.Cruft, object.ModTime)
+				}
+			}
+		}
+	}
+	if found != len(cruft) {
+		t.Fatalf("expected: %d objects in the cruft pack\tactual: %d", len(cruft), found)
+	}
+
+	// The objects remain readable from the first pack once the cruft pack is removed
+	if err := manager.RemovePack(checksum); err != nil {
+		t.Fatal(err)
+	}
+	packs, err := st.ListPacks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 1 || packs[0] == hash.ChecksumToHex(checksum) {
+		t.Fatalf("expected: a single pack left\tactual: %v", packs)
+	}
+	for _, id := range ids {
+		if _, err := manager.Object(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDecodeMtimes(t *testing.T) {
+	ids := [][]byte{bytes.Repeat([]byte{2}, 20), bytes.Repeat([]byte{1}, 20)}
+	packChecksum := bytes.Repeat([]byte{9}, 20)
+	var buf bytes.Buffer
+	if err := encodeMtimes([]CruftObject{{ID: ids[0], ModTime: time.Unix(200, 0)}, {ID: ids[1], ModTime: time.Unix(100, 0)}}, packChecksum, &buf); err != nil {
+		t.Fatal(err)
+	}
+	// Times are in the order of the ids
+	times, err := DecodeMtimes(buf.Bytes(), 2, packChecksum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if times[0].Unix() != 100 || times[1].Unix() != 200 {
+		t.Fatalf("expected: [100 200]\tactual: [%d %d]", times[0].Unix(), times[1].Unix())
+	}
+	corrupted := bytes.Clone(buf.Bytes())
+	corrupted[MTIMES_HEADER_SIZE] ^= 0xff
+	if _, err := DecodeMtimes(corrupted, 2, packChecksum); !errors.Is(err, ErrInvalidMtimes) {
+		t.Fatalf("expected: %v\tactual: %v", ErrInvalidMtimes, err)
+	}
+}
@@ -0,0 +1,184 @@
+package pack
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+)
+
+const (
+	// MAX_DELTA_CHAIN bounds the deltas followed to resolve an object. git never writes
+	// chains deeper than 4095, a longer chain is a corrupted pack.
+	MAX_DELTA_CHAIN = 10000
+	// DEFAULT_DELTA_BASE_CACHE_LIMIT is the size of the inflated bases kept in memory, the
+	// default of core.deltaBaseCacheLimit
+	DEFAULT_DELTA_BASE_CACHE_LIMIT = 96 << 20
+)
+
+var (
+	ErrBaseNotFound      = errors.New("delta base not found")
+	ErrDeltaCycle        = errors.New("delta chain loops")
+	ErrDeltaChainTooLong = errors.New("delta chain is too long")
+)
+
+// packResolver resolves the objects of a pack by following their chains of ofs and ref
+// deltas down to an object that is not a delta, then applying the deltas back up
+type packResolver struct {
+	packfile *Packfile
+	// findBase returns the offset of the base of a ref delta in the pack
+	findBase func(id []byte) (offset int, found bool, err error)
+	// external reads the bases of ref deltas found outside of the pack, depth being the
+	// length of the chain so far. It is nil when the pack must be self-contained.
+	external func(id []byte, depth int) (common.ObjectType, []byte, error)
+	// cache holds the objects resolved along the chains, it may be nil
+	cache *deltaBaseCache
+}
+
+// chainLink is a delta of the chain being resolved
+type chainLink struct {
+	offset int
+	delta  []byte
+}
+
+// resolve returns the type and the content of the object at offset. depth is the length
+// of the chain that led to the object, when it is the base of a delta of another pack.
+func (r *packResolver) resolve(offset int, depth int) (common.ObjectType, []byte, error) {
+	chain := []chainLink{}
+	visited := map[int]bool{}
+	var objectType common.ObjectType
+	var content []byte
+	for resolved := false; !resolved; {
+		if depth+len(chain) > MAX_DELTA_CHAIN {
+			return 0, nil, fmt.Errorf("%w: object at %d", ErrDeltaChainTooLong, offset)
+		}
+		if visited[offset] {
+			return 0, nil, fmt.Errorf("%w: object at %d", ErrDeltaCycle, offset)
+		}
+		visited[offset] = true
+		if cachedType, cached, ok := r.cache.get(r.packfile.checksum, offset); ok {
+			objectType, content = cachedType, cached
+			break
+		}
+
+		object := &PackObject{}
+		if err := r.packfile.ReadObjectAt(int64(offset), object); err != nil {
+			return 0, nil, err
+		}
+		switch object.objectType {
+		case common.OBJ_OFS_DELTA:
+			if object.baseOffset <= 0 || object.baseOffset > offset {
+				return 0, nil, fmt.Errorf("invalid base offset of object at %d", offset)
+			}
+			chain = append(chain, chainLink{offset: offset, delta: object.content})
+			offset -= object.baseOffset
+		case common.OBJ_REF_DELTA:
+			chain = append(chain, chainLink{offset: offset, delta: object.content})
+			baseOffset, found, err := r.findBase(object.baseChecksum)
+			if err != nil {
+				return 0, nil, err
+			}
+			if found {
+				offset = baseOffset
+				continue
+			}
+			if r.external == nil {
+				return 0, nil, fmt.Errorf("%w: %x is not in the pack", ErrBaseNotFound, object.baseChecksum)
+			}
+			objectType, content, err = r.external(object.baseChecksum, depth+len(chain))
+			if err != nil {
+				return 0, nil, err
+			}
+			resolved = true
+		default:
+			objectType, content = object.objectType, object.content
+			// Bases are worth keeping, objects that are not deltas are cheap to read again
+			if len(chain) > 0 {
+				r.cache.add(r.packfile.checksum, offset, objectType, content)
+			}
+			resolved = true
+		}
+	}
+
+	for i := len(chain) - 1; i >= 0; i-- {
+		var err error
+		content, err = applyDelta(chain[i].delta, content)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to apply delta at %d: %w", chain[i].offset, err)
+		}
+		r.cache.add(r.packfile.checksum, chain[i].offset, objectType, content)
+	}
+	return objectType, content, nil
+}
+
+// deltaBaseCache is a cache of inflated objects of packs, bounded by the size of their
+// content. The least recently used objects are evicted first.
+type deltaBaseCache struct {
+	mu      sync.Mutex
+	limit   int
+	size    int
+	entries map[deltaBaseKey]*list.Element
+	// lru holds the cached objects, the most recently used first
+	lru *list.List
+}
+
+type deltaBaseKey struct {
+	pack   string
+	offset int
+}
+
+type cachedBase struct {
+	key        deltaBaseKey
+	objectType common.ObjectType
+	content    []byte
+}
+
+func newDeltaBaseCache(limit int) *deltaBaseCache {
+	return &deltaBaseCache{
+		limit:   limit,
+		entries: map[deltaBaseKey]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+// get returns the object at offset in the pack. The content is shared and must not be modified.
+func (c *deltaBaseCache) get(pack []byte, offset int) (common.ObjectType, []byte, bool) {
+	if c == nil {
+		return 0, nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[deltaBaseKey{string(pack), offset}]
+	if !ok {
+		return 0, nil, false
+	}
+	c.lru.MoveToFront(element)
+	base := element.Value.(*cachedBase)
+	return base.objectType, base.content, true
+}
+
+// add caches the object at offset in the pack, evicting the least recently used objects
+// until the cache fits its limit. Objects larger than the limit are not cached.
+func (c *deltaBaseCache) add(pack []byte, offset int, objectType common.ObjectType, content []byte) {
+	if c == nil || len(content) > c.limit {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := deltaBaseKey{string(pack), offset}
+	if element, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cachedBase{key: key, objectType: objectType, content: content})
+	c.size += len(content)
+	for c.size > c.limit {
+		oldest := c.lru.Back()
+		base := oldest.Value.(*cachedBase)
+		c.lru.Remove(oldest)
+		delete(c.entries, base.key)
+		c.size -= len(base.content)
+	}
+}
@@ -0,0 +1,107 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+// offsetFinder looks up ref delta bases among the entries of a pack
+func offsetFinder(entries []IndexEntry) func([]byte) (int, bool, error) {
+	offsets := map[string]int{}
+	for _, entry := range entries {
+		offsets[hash.ChecksumToHex(entry.ID)] = entry.Offset
+	}
+	return func(id []byte) (int, bool, error) {
+		offset, ok := offsets[hash.ChecksumToHex(id)]
+		return offset, ok, nil
+	}
+}
+
+func TestResolveMixedChain(t *testing.T) {
+	contents := versions(6)
+	data, encoder := encodeChain(t, contents)
+	packfile, err := newPackfileFromBytes(encoder.Checksum(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := newDeltaBaseCache(DEFAULT_DELTA_BASE_CACHE_LIMIT)
+	resolver := &packResolver{packfile: packfile, findBase: offsetFinder(encoder.entries), cache: cache}
+	// The deepest object first, then the cached chain is reused
+	for i := len(contents) - 1; i >= 0; i-- {
+		objectType, content, err := resolver.resolve(encoder.entries[i].Offset, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if objectType != common.OBJ_BLOB || !bytes.Equal(content, contents[i]) {
+			t.Fatalf("entry %d\texpected: blob of %d bytes\tactual: %s of %d bytes", i, len(contents[i]), objectType, len(content))
+		}
+		if _, _, ok := cache.get(encoder.Checksum(), encoder.entries[i].Offset); !ok {
+			t.Fatalf("expected the object at %d to be cached", encoder.entries[i].Offset)
+		}
+	}
+
+	// Cached objects are not walked again, the chain is only too deep without the cache
+	if _, _, err := resolver.resolve(encoder.entries[2].Offset, MAX_DELTA_CHAIN-1); err != nil {
+		t.Fatal(err)
+	}
+	resolver.cache = nil
+	if _, _, err := resolver.resolve(encoder.entries[2].Offset, MAX_DELTA_CHAIN-1); !errors.Is(err, ErrDeltaChainTooLong) {
+		t.Fatalf("expected: %v\tactual: %v", ErrDeltaChainTooLong, err)
+	}
+}
+
+func TestResolveCycle(t *testing.T) {
+	first, second := []byte("first version\n"), []byte("second version\n")
+	firstID, _ := common.NewObjectBuffer(common.OBJ_BLOB, first).Hash()
+	secondID, _ := common.NewObjectBuffer(common.OBJ_BLOB, second).Hash()
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each object is a delta of the other one
+	firstDelta, _ := createDelta(second, first, 1000)
+	secondDelta, _ := createDelta(first, second, 1000)
+	if err := encoder.EncodeRefDelta(firstID, secondID, firstDelta); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.EncodeRefDelta(secondID, firstID, secondDelta); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	packfile, err := newPackfileFromBytes(encoder.Checksum(), buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := &packResolver{packfile: packfile, findBase: offsetFinder(encoder.entries)}
+	if _, _, err := resolver.resolve(encoder.entries[0].Offset, 0); !errors.Is(err, ErrDeltaCycle) {
+		t.Fatalf("expected: %v\tactual: %v", ErrDeltaCycle, err)
+	}
+}
+
+func TestDeltaBaseCacheEviction(t *testing.T) {
+	cache := newDeltaBaseCache(10)
+	pack := []byte("pack")
+	cache.add(pack, 1, common.OBJ_BLOB, []byte("1111"))
+	cache.add(pack, 2, common.OBJ_BLOB, []byte("2222"))
+	// Using the first object makes the second one the least recently used
+	if _, _, ok := cache.get(pack, 1); !ok {
+		t.Fatalf("expected the object at 1 to be cached")
+	}
+	cache.add(pack, 3, common.OBJ_BLOB, []byte("3333"))
+	cache.add(pack, 4, common.OBJ_BLOB, []byte("too large to be cached"))
+	for offset, expected := range map[int]bool{1: true, 2: false, 3: true, 4: false} {
+		if _, _, ok := cache.get(pack, offset); ok != expected {
+			t.Fatalf("offset %d\texpected: cached=%v\tactual: cached=%v", offset, expected, ok)
+		}
+	}
+	if cache.size != 8 {
+		t.Fatalf("expected: 8 bytes cached\tactual: %d bytes cached", cache.size)
+	}
+}
@@ -0,0 +1,241 @@
+package pack
+
+import (
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/filemode"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+)
+
+var (
+	ErrMissingLink = errors.New("object refers to a missing object")
+)
+
+// UnpackOptions tune Unpack
+type UnpackOptions struct {
+	// DryRun verifies the pack without writing any object
+	DryRun bool
+	// Strict checks that the commits, trees and tags of the pack are well formed and that
+	// every object they refer to is in the pack or in the repository. Nothing is written
+	// unless every object passes.
+	Strict bool
+	// Explode writes the objects already stored in packs, only loose objects are skipped
+	Explode bool
+}
+
+// Unpack reads a pack from r and writes its objects as loose objects, skipping those the
+// repository already holds. The bases missing from a thin pack are read from the
+// repository. The pack is spooled to a temporary file that is removed once unpacked, and
+// every object is written under a temporary name renamed once complete. The ids of the
+// objects written, or that would be written by a dry run, are returned in the order of
+// the pack.
+func (manager *DefaulPackManager) Unpack(r io.Reader, options UnpackOptions) ([][]byte, error) {
+	file, err := manager.store.NewPackWriter("")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Remove()
+	indexed, err := IndexPack(r, file, file, IndexOptions{Bases: manager})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack: %w", err)
+	}
+	packfile, err := newPackfileFromReaderAt(indexed.Checksum, file)
+	if err != nil {
+		return nil, err
+	}
+	offsets := make(map[string]int, len(indexed.Entries))
+	for _, entry := range indexed.Entries {
+		offsets[string(entry.ID)] = entry.Offset
+	}
+	resolver := &packResolver{
+		packfile: packfile,
+		findBase: func(id []byte) (int, bool, error) {
+			offset, found := offsets[string(id)]
+			return offset, found, nil
+		},
+		external: manager.resolve,
+		cache:    manager.cache,
+	}
+	if options.Strict {
+		if err := manager.checkLinks(resolver, indexed.Entries, offsets); err != nil {
+			return nil, err
+		}
+	}
+
+	unpacked := [][]byte{}
+	for _, entry := range indexed.Entries {
+		exist, err := manager.looseObjectExist(entry.ID)
+		if err == nil && !exist && !options.Explode {
+			_, _, exist, err = manager.searchIndex(entry.ID)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if exist {
+			continue
+		}
+		unpacked = append(unpacked, entry.ID)
+		if options.DryRun {
+			continue
+		}
+		objectType, content, err := resolver.resolve(entry.Offset, 0)
+		if err != nil {
+			return nil, err
+		}
+		if err := manager.writeLooseObject(entry.ID, objectType, content); err != nil {
+			return nil, err
+		}
+	}
+	return unpacked, nil
+}
+
+// checkLinks checks that the commits, trees and tags of the pack parse and that the
+// objects they refer to are either in the pack, at offsets, or in the repository
+func (manager *DefaulPackManager) checkLinks(resolver *packResolver, entries []IndexEntry, offsets map[string]int) error {
+	links := map[string][]byte{}
+	for _, entry := range entries {
+		objectType, content, err := resolver.resolve(entry.Offset, 0)
+		if err != nil {
+			return err
+		}
+		ids, err := objectLinks(objectType, content)
+		if err != nil {
+			return fmt.Errorf("invalid %s %x: %w", objectType, entry.ID, err)
+		}
+		for _, id := range ids {
+			links[string(id)] = entry.ID
+		}
+	}
+	for link, from := range links {
+		if _, found := offsets[link]; found {
+			continue
+		}
+		exist, err := manager.ObjectExist([]byte(link))
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return fmt.Errorf("%w: %x refers to %x", ErrMissingLink, from, []byte(link))
+		}
+	}
+	return nil
+}
+
+// objectLinks returns the ids of the objects an object refers to. Submodule commits are
+// left out as they belong to another repository.
+func objectLinks(objectType common.ObjectType, content []byte) ([][]byte, error) {
+	encodedObject := common.NewObjectBuffer(objectType, content)
+	switch objectType {
+	case common.OBJ_COMMIT:
+		commit, err := object.DecodeCommit(encodedObject)
+		if err != nil {
+			return nil, err
+		}
+		if len(commit.Tree()) != common.CHECKSUM_LEN {
+			return nil, errors.New("missing tree")
+		}
+		return append([][]byte{commit.Tree()}, commit.Parents()...), nil
+	case common.OBJ_TREE:
+		tree, err := object.DecodeTree(encodedObject)
+		if err != nil {
+			return nil, err
+		}
+		ids := [][]byte{}
+		entryIter := tree.TreeIter()
+		for {
+			entry, ok := entryIter.Next()
+			if !ok {
+				return ids, nil
+			}
+			if entry.Mode != filemode.Submodule {
+				ids = append(ids, entry.Checksum)
+			}
+		}
+	case common.OBJ_TAG:
+		tag, err := object.DecodeTag(encodedObject)
+		if err != nil {
+			return nil, err
+		}
+		if len(tag.Object()) != common.CHECKSUM_LEN {
+			return nil, errors.New("missing tagged object")
+		}
+		return [][]byte{tag.Object()}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// writeLooseObject writes an object under a temporary name renamed once complete, so that
+// an interrupted write never leaves a truncated object behind
+func (manager *DefaulPackManager) writeLooseObject(id []byte, objectType common.ObjectType, content []byte) error {
+	file, err := manager.store.ObjectWriter("")
+	if err != nil {
+		return err
+	}
+	comp := zlib.NewWriter(file)
+	err = common.NewObjectBuffer(objectType, content).Encode(comp)
+	if closeErr := comp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		file.Remove()
+		return err
+	}
+	return file.Rename(hash.ChecksumToHex(id))
+}
+
+// looseObjectExist reports whether the object is stored outside of any pack
+func (manager *DefaulPackManager) looseObjectExist(id []byte) (bool, error) {
+	file, err := manager.store.ObjectReader(hash.ChecksumToHex(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	file.Close()
+	return true, nil
+}
+
+// PrunePacked removes the loose objects that are also stored in a pack and returns their
+// ids. A dry run only lists them.
+func (manager *DefaulPackManager) PrunePacked(dryRun bool) ([][]byte, error) {
+	checksums, err := manager.store.ListObjects()
+	if err != nil {
+		return nil, err
+	}
+	pruned := [][]byte{}
+	for _, checksum := range checksums {
+		id, err := hash.ChecksumFromHex(checksum)
+		if err != nil {
+			return nil, err
+		}
+		_, _, packed, err := manager.searchIndex(id)
+		if err != nil {
+			return nil, err
+		}
+		if !packed {
+			continue
+		}
+		pruned = append(pruned, id)
+		if dryRun {
+			continue
+		}
+		if err := manager.store.RemoveObject(checksum); err != nil {
+			return nil, err
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,128 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+func TestUnpack(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, encoder := encodeChain(t, versions(4))
+	manager := New(st)
+
+	unpacked, err := manager.Unpack(bytes.NewReader(data), UnpackOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	loose, err := st.ListObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpacked) != 4 || len(loose) != 0 {
+		t.Fatalf("expected: 4 objects to unpack and none written\tactual: %d to unpack and %d written", len(unpacked), len(loose))
+	}
+
+	if _, err := manager.Unpack(bytes.NewReader(data), UnpackOptions{Strict: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range encoder.entries {
+		object, err := manager.looseObject(entry.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := object.Hash()
+		if !bytes.Equal(id, entry.ID) {
+			t.Fatalf("expected: %x\tactual: %x", entry.ID, id)
+		}
+	}
+	// Objects the repository already holds are skipped
+	unpacked, err = manager.Unpack(bytes.NewReader(data), UnpackOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unpacked) != 0 {
+		t.Fatalf("expected: no object unpacked again\tactual: %d objects", len(unpacked))
+	}
+}
+
+func TestUnpackStrict(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tree refers to a blob that is neither in the pack nor in the repository
+	missing, _ := common.NewObjectBuffer(common.OBJ_BLOB, []byte("missing\n")).Hash()
+	tree := append([]byte("100644 file.txt\x00"), missing...)
+	var buf bytes.Buffer
+	encoder, err := NewEncoder(&buf, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(common.OBJ_TREE, tree); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := encoder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := New(st)
+	if _, err := manager.Unpack(bytes.NewReader(buf.Bytes()), UnpackOptions{Strict: true}); !errors.Is(err, ErrMissingLink) {
+		t.Fatalf("expected: %v\tactual: %v", ErrMissingLink, err)
+	}
+	loose, err := st.ListObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loose) != 0 {
+		t.Fatalf("expected: no object written\tactual: %d objects", len(loose))
+	}
+	if _, err := manager.Unpack(bytes.NewReader(buf.Bytes()), UnpackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrunePacked(t *testing.T) {
+	st, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := versions(3)
+	data, _ := encodeChain(t, contents)
+	manager := New(st)
+	if _, err := manager.Unpack(bytes.NewReader(data), UnpackOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the first two versions are packed
+	storeBlobs(t, st, contents[:2])
+
+	pruned, err := manager.PrunePacked(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loose, err := st.ListObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned) != 2 || len(loose) != 3 {
+		t.Fatalf("expected: 2 objects to prune out of 3\tactual: %d out of %d", len(pruned), len(loose))
+	}
+	if _, err := manager.PrunePacked(false); err != nil {
+		t.Fatal(err)
+	}
+	loose, err = st.ListObjects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, _ := common.NewObjectBuffer(common.OBJ_BLOB, contents[2]).Hash()
+	if len(loose) != 1 || loose[0] != hash.ChecksumToHex(last) {
+		t.Fatalf("expected: only %x left\tactual: %v", last, loose)
+	}
+}
@@ -0,0 +1,184 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"slices"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+var (
+	ErrCorruptPack = errors.New("corrupt pack")
+)
+
+// VerifiedObject is an object of a verified pack, as listed by verify-pack -v
+type VerifiedObject struct {
+	ID []byte
+	// Type is the type of the object, that of its base for deltas
+	Type common.ObjectType
+	// Size is the size of the entry data, which is the size of the delta for deltas
+	Size int
+	// PackedSize is the size of the entry in the pack, header included
+	PackedSize int
+	Offset     int
+	// Depth is the length of the delta chain of the object, and BaseID the id of the
+	// base of a delta
+	Depth  int
+	BaseID []byte
+}
+
+// VerifyPack checks a pack of size bytes against its index: the checksums of both files,
+// the CRC of every entry when the index holds them, and the id of every object. The
+// objects are returned in the order of the pack.
+func VerifyPack(pack io.ReaderAt, size int64, index []byte) ([]VerifiedObject, error) {
+	entries, packChecksum, err := DecodeIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	if size < HEADER_LEN+CHECKSUM_LEN {
+		return nil, fmt.Errorf("%w: pack too small", ErrCorruptPack)
+	}
+	sum := hash.New(hash.SHA1)
+	if _, err := io.Copy(sum, io.NewSectionReader(pack, 0, size-CHECKSUM_LEN)); err != nil {
+		return nil, err
+	}
+	trailer := make([]byte, CHECKSUM_LEN)
+	if _, err := pack.ReadAt(trailer, size-CHECKSUM_LEN); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sum.Sum(nil), trailer) {
+		return nil, fmt.Errorf("%w: pack checksum mismatch", ErrCorruptPack)
+	}
+	if !bytes.Equal(packChecksum, trailer) {
+		return nil, fmt.Errorf("%w: index is not the index of the pack", ErrCorruptPack)
+	}
+	packfile, err := newPackfileFromReaderAt(trailer, pack)
+	if err != nil {
+		return nil, err
+	}
+	if packfile.TotalObjects() != len(entries) {
+		return nil, fmt.Errorf("%w: pack holds %d objects, index lists %d", ErrCorruptPack, packfile.TotalObjects(), len(entries))
+	}
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		return a.Offset - b.Offset
+	})
+	verifier := &packVerifier{
+		resolver: &packResolver{
+			packfile: packfile,
+			findBase: func(id []byte) (int, bool, error) {
+				return search(id, bytes.NewReader(index))
+			},
+			cache: newDeltaBaseCache(DEFAULT_DELTA_BASE_CACHE_LIMIT),
+		},
+		hasCRC:      bytes.HasPrefix(index, INDEX_V2_MAGIC),
+		idAt:        map[int][]byte{},
+		baseOffsets: map[int]int{},
+	}
+	for _, entry := range entries {
+		verifier.idAt[entry.Offset] = entry.ID
+	}
+	objects := make([]VerifiedObject, len(entries))
+	for i, entry := range entries {
+		end := size - CHECKSUM_LEN
+		if i+1 < len(entries) {
+			end = int64(entries[i+1].Offset)
+		}
+		object, err := verifier.verify(entry, end)
+		if err != nil {
+			return nil, fmt.Errorf("%w: object %x at %d: %v", ErrCorruptPack, entry.ID, entry.Offset, err)
+		}
+		objects[i] = object
+	}
+	// Every chain is resolved, the depths can be counted without checking for cycles
+	depths := map[int]int{}
+	var depth func(offset int) int
+	depth = func(offset int) int {
+		baseOffset, ok := verifier.baseOffsets[offset]
+		if !ok {
+			return 0
+		}
+		if d, ok := depths[offset]; ok {
+			return d
+		}
+		depths[offset] = depth(baseOffset) + 1
+		return depths[offset]
+	}
+	for i := range objects {
+		objects[i].Depth = depth(objects[i].Offset)
+	}
+	return objects, nil
+}
+
+// packVerifier verifies the entries of a pack listed by its index
+type packVerifier struct {
+	resolver *packResolver
+	hasCRC   bool
+	// idAt maps the offsets of the objects to their ids
+	idAt map[int][]byte
+	// baseOffsets maps the offsets of the deltas to the offsets of their bases
+	baseOffsets map[int]int
+}
+
+// verify checks the CRC and the id of the entry, which ends at end
+func (v *packVerifier) verify(entry IndexEntry, end int64) (VerifiedObject, error) {
+	object := VerifiedObject{ID: entry.ID, Offset: entry.Offset, PackedSize: int(end) - entry.Offset}
+	if object.PackedSize <= 0 {
+		return object, errors.New("invalid offset")
+	}
+	packfile := v.resolver.packfile
+	if v.hasCRC {
+		crc := crc32.NewIEEE()
+		if _, err := io.Copy(crc, io.NewSectionReader(packfile.file, int64(entry.Offset), int64(object.PackedSize))); err != nil {
+			return object, err
+		}
+		if crc.Sum32() != entry.CRC {
+			return object, errors.New("CRC mismatch")
+		}
+	}
+	packObject := &PackObject{}
+	if err := packfile.ReadObjectAt(int64(entry.Offset), packObject); err != nil {
+		return object, err
+	}
+	if len(packObject.content) != packObject.size {
+		return object, fmt.Errorf("expected %d bytes of content, got %d", packObject.size, len(packObject.content))
+	}
+	object.Size = packObject.size
+	switch packObject.objectType {
+	case common.OBJ_REF_DELTA:
+		baseOffset, found, err := v.resolver.findBase(packObject.baseChecksum)
+		if err != nil {
+			return object, err
+		}
+		if !found {
+			return object, fmt.Errorf("%w: %x", ErrBaseNotFound, packObject.baseChecksum)
+		}
+		object.BaseID = packObject.baseChecksum
+		v.baseOffsets[entry.Offset] = baseOffset
+	case common.OBJ_OFS_DELTA:
+		baseOffset := entry.Offset - packObject.baseOffset
+		id, found := v.idAt[baseOffset]
+		if !found {
+			return object, fmt.Errorf("no object at base offset %d", baseOffset)
+		}
+		object.BaseID = id
+		v.baseOffsets[entry.Offset] = baseOffset
+	}
+	objectType, content, err := v.resolver.resolve(entry.Offset, 0)
+	if err != nil {
+		return object, err
+	}
+	object.Type = objectType
+	id, err := common.NewObjectBuffer(objectType, content).Hash()
+	if err != nil {
+		return object, err
+	}
+	if !bytes.Equal(id, entry.ID) {
+		return object, fmt.Errorf("content hashes to %x", id)
+	}
+	return object, nil
+}
@@ -0,0 +1,64 @@
+package pack
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+func TestVerifyPack(t *testing.T) {
+	data, encoder := encodeChain(t, versions(4))
+	var index bytes.Buffer
+	if err := encoder.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := VerifyPack(bytes.NewReader(data), int64(len(data)), index.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 4 {
+		t.Fatalf("expected: 4 objects\tactual: %d objects", len(objects))
+	}
+	for i, object := range objects {
+		if !bytes.Equal(object.ID, encoder.entries[i].ID) || object.Type != common.OBJ_BLOB || object.Depth != i {
+			t.Fatalf("entry %d\texpected: blob %x at depth %d\tactual: %s %x at depth %d", i, encoder.entries[i].ID, i, object.Type, object.ID, object.Depth)
+		}
+		if i > 0 && !bytes.Equal(object.BaseID, encoder.entries[i-1].ID) {
+			t.Fatalf("entry %d\texpected: base %x\tactual: base %x", i, encoder.entries[i-1].ID, object.BaseID)
+		}
+	}
+	last := objects[len(objects)-1]
+	if last.Offset+last.PackedSize != len(data)-CHECKSUM_LEN {
+		t.Fatalf("expected: last entry ending at %d\tactual: ending at %d", len(data)-CHECKSUM_LEN, last.Offset+last.PackedSize)
+	}
+}
+
+func TestVerifyPackCorrupted(t *testing.T) {
+	data, encoder := encodeChain(t, versions(2))
+	var index bytes.Buffer
+	if err := encoder.WriteIndex(&index); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := bytes.Clone(data)
+	corrupted[encoder.entries[1].Offset+4] ^= 0xff
+	if _, err := VerifyPack(bytes.NewReader(corrupted), int64(len(corrupted)), index.Bytes()); !errors.Is(err, ErrCorruptPack) {
+		t.Fatalf("expected: %v\tactual: %v", ErrCorruptPack, err)
+	}
+
+	// With a valid trailer and an index of the corrupted pack, the CRC no longer matches
+	sum := hash.New(hash.SHA1)
+	sum.Write(corrupted[:len(corrupted)-CHECKSUM_LEN])
+	checksum := sum.Sum(nil)
+	copy(corrupted[len(corrupted)-CHECKSUM_LEN:], checksum)
+	var corruptedIndex bytes.Buffer
+	if err := encodeIndex(encoder.entries, checksum, &corruptedIndex); err != nil {
+		t.Fatal(err)
+	}
+	_, err := VerifyPack(bytes.NewReader(corrupted), int64(len(corrupted)), corruptedIndex.Bytes())
+	if !errors.Is(err, ErrCorruptPack) || !bytes.Contains([]byte(err.Error()), []byte("CRC mismatch")) {
+		t.Fatalf("expected: CRC mismatch\tactual: %v", err)
+	}
+}
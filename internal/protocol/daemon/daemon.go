@@ -0,0 +1,224 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	// EXPORT_OK_FILE marks a repository as served by the daemon unless every repository is exported
+	EXPORT_OK_FILE = "git-daemon-export-ok"
+	// INIT_TIMEOUT bounds the time a client takes to send its request
+	INIT_TIMEOUT = 10 * time.Second
+)
+
+var (
+	ErrAccessDenied      = errors.New("access denied or repository not exported")
+	ErrServiceNotEnabled = errors.New("service not enabled")
+	ErrUnknownService    = errors.New("unknown service")
+)
+
+// Service is a service of the daemon along with its state for every repository
+type Service struct {
+	Enabled bool
+	// An overridable service is enabled or disabled by the daemon.<ConfigName> setting of
+	// each repository
+	Overridable bool
+	ConfigName  string
+}
+
+// DefaultServices returns the services as configured by default in git: upload-pack is
+// enabled and receive-pack must be enabled by the daemon or by a repository
+func DefaultServices() map[string]*Service {
+	return map[string]*Service{
+		"upload-pack":  {Enabled: true, Overridable: true, ConfigName: "uploadpack"},
+		"receive-pack": {Enabled: false, Overridable: true, ConfigName: "receivepack"},
+	}
+}
+
+type Options struct {
+	// BasePath is prepended to the path of every request
+	BasePath string
+	// ExportAll serves repositories without the EXPORT_OK_FILE
+	ExportAll bool
+	// Directories restricts the repositories served to those below these directories when not empty
+	Directories []string
+	// Services are keyed by their name without the git- prefix, DefaultServices when nil
+	Services map[string]*Service
+}
+
+// Daemon serves repositories over the git:// protocol. A connection starts with a
+// [githttp.DaemonReq] and goes on with the conversation of the requested service.
+type Daemon struct {
+	options Options
+}
+
+func New(options Options) *Daemon {
+	if options.Services == nil {
+		options.Services = DefaultServices()
+	}
+	return &Daemon{
+		options: options,
+	}
+}
+
+// Serve handles the connections accepted on listener until it is closed
+func (d *Daemon) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go d.handle(conn)
+	}
+}
+
+func (d *Daemon) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(INIT_TIMEOUT))
+	req, err := githttp.DecodeDaemonReq(conn)
+	if err != nil {
+		log.Printf("%s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err := d.serve(conn, req); err != nil {
+		log.Printf("%s: %s %s: %v", conn.RemoteAddr(), req.Service, req.Path, err)
+	}
+}
+
+// serve runs the service of req. A refused request is answered with an ERR line, which
+// does not tell whether the repository exists.
+func (d *Daemon) serve(conn net.Conn, req *githttp.DaemonReq) error {
+	name, _ := strings.CutPrefix(req.Service, "git-")
+	service, ok := d.options.Services[name]
+	if !ok {
+		return refuse(conn, req, ErrUnknownService)
+	}
+	if !service.Enabled && !service.Overridable {
+		return refuse(conn, req, ErrServiceNotEnabled)
+	}
+	st, err := d.resolve(req.Path)
+	if err != nil {
+		return refuse(conn, req, err)
+	}
+	enabled, err := d.enabled(st, service)
+	if err != nil {
+		return err
+	}
+	if !enabled {
+		return refuse(conn, req, ErrServiceNotEnabled)
+	}
+
+	switch req.Service {
+	case githttp.UPLOAD_PACK_SERVICE:
+		session := githttp.NewUploadPackSession(st, false)
+		if err := session.AdvertiseRefs(conn); err != nil {
+			return err
+		}
+		return session.UploadPack(conn, conn)
+	case githttp.RECEIVE_PACK_SERVICE:
+		session := githttp.NewReceivePackSession(st)
+		if err := session.AdvertiseRefs(conn); err != nil {
+			return err
+		}
+		return session.ReceivePack(conn, conn)
+	default:
+		return refuse(conn, req, ErrUnknownService)
+	}
+}
+
+// resolve returns the repository at repoPath. As in git, the path may leave out the .git
+// suffix of a bare repository and may name the working tree of a repository.
+func (d *Daemon) resolve(repoPath string) (*store.FSStore, error) {
+	if !strings.HasPrefix(repoPath, "/") {
+		return nil, ErrAccessDenied
+	}
+	for _, component := range strings.Split(repoPath, "/") {
+		if component == ".." {
+			return nil, ErrAccessDenied
+		}
+	}
+	dir := filepath.Clean(repoPath)
+	if d.options.BasePath != "" {
+		dir = filepath.Join(d.options.BasePath, dir)
+	}
+	for _, candidate := range []string{dir, dir + ".git"} {
+		st, err := store.Open(candidate)
+		if err != nil {
+			continue
+		}
+		if !d.allowed(candidate) {
+			return nil, ErrAccessDenied
+		}
+		if !d.options.ExportAll {
+			if _, err := os.Stat(filepath.Join(st.RootDir(), EXPORT_OK_FILE)); err != nil {
+				return nil, ErrAccessDenied
+			}
+		}
+		return st, nil
+	}
+	return nil, ErrAccessDenied
+}
+
+// allowed reports whether dir is below one of the directories the daemon is restricted to
+func (d *Daemon) allowed(dir string) bool {
+	if len(d.options.Directories) == 0 {
+		return true
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range d.options.Directories {
+		allowed, err := filepath.Abs(allowed)
+		if err != nil {
+			continue
+		}
+		if dir == allowed || strings.HasPrefix(dir, allowed+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// enabled reports whether service is enabled for the repository
+func (d *Daemon) enabled(st *store.FSStore, service *Service) (bool, error) {
+	if !service.Overridable {
+		return service.Enabled, nil
+	}
+	cfg, err := st.Config()
+	if err != nil {
+		return false, err
+	}
+	value, ok := cfg.Get("daemon", "", service.ConfigName)
+	if !ok {
+		return service.Enabled, nil
+	}
+	enabled, err := config.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%w for daemon.%s", err, service.ConfigName)
+	}
+	return enabled, nil
+}
+
+// refuse sends the reason a request is refused to the client
+func refuse(conn net.Conn, req *githttp.DaemonReq, reason error) error {
+	if _, err := githttp.NewPktLineEncoder(conn).WriteLineString(fmt.Sprintf("ERR %v: %s", reason, req.Path)); err != nil {
+		return err
+	}
+	return reason
+}
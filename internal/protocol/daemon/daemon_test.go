@@ -0,0 +1,134 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/transport"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+// startDaemon serves the repositories below baseDir on a free port and returns its url
+func startDaemon(t *testing.T, baseDir string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go New(Options{BasePath: baseDir}).Serve(listener)
+	return "git://" + listener.Addr().String()
+}
+
+func TestDaemonFetch(t *testing.T) {
+	baseDir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, filepath.Join(baseDir, "repo.git"))
+	testing_helper.SetupRepo(t, filepath.Join(baseDir, "hidden.git"))
+	if err := os.WriteFile(filepath.Join(baseDir, "repo.git", EXPORT_OK_FILE), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url := startDaemon(t, baseDir)
+	ctx := context.Background()
+
+	// The .git suffix may be left out
+	remote, err := transport.New(url+"/repo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	refs, err := remote.FetchRefs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(refs.Head(), commit) {
+		t.Fatalf("expected HEAD %x actual %x", commit, refs.Head())
+	}
+	local, err := store.InitBare(filepath.Join(t.TempDir(), "local.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(local)
+	reply, err := remote.FetchPack(ctx, githttp.BuildPacReqFromRefDisc(refs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.From(reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := reply.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := manager.ObjectExist(commit); err != nil || !ok {
+		t.Fatalf("expected fetched commit %x to exist", commit)
+	}
+
+	for _, path := range []string{"/hidden.git", "/missing.git", "/../" + filepath.Base(baseDir) + "/repo.git"} {
+		remote, err := transport.New(url+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := remote.FetchRefs(ctx); !errors.Is(err, githttp.ErrRemote) {
+			t.Fatalf("%s: expected the daemon to refuse the request but got %v", path, err)
+		}
+	}
+}
+
+func TestDaemonPush(t *testing.T) {
+	baseDir := t.TempDir()
+	st, commit := testing_helper.SetupRepo(t, filepath.Join(baseDir, "repo.git"))
+	if err := os.WriteFile(filepath.Join(baseDir, "repo.git", EXPORT_OK_FILE), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	url := startDaemon(t, baseDir)
+	ctx := context.Background()
+
+	remote, err := transport.New(url+"/repo.git", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if _, err := remote.PushRefs(ctx); !errors.Is(err, githttp.ErrRemote) {
+		t.Fatalf("expected receive-pack to be disabled but got %v", err)
+	}
+
+	// The repository enables pushing on its own
+	cfg, err := st.Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Set("daemon", "", "receivepack", "true")
+	if err := st.SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(githttp.WritePack(pack.New(st), w, [][]byte{commit}, nil, revlist.Options{}, pack.DefaultWriteOptions()))
+	}()
+	req := &githttp.ReceivePackReq{
+		Updates: []*githttp.RefUpdate{{Name: "refs/heads/feature", New: commit}},
+		Caps:    githttp.NewCapList("report-status"),
+	}
+	report, err := remote.Push(ctx, req, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := st.ReadRef("refs/heads/feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ref.Checksum, commit) {
+		t.Fatalf("expected refs/heads/feature %x actual %x", commit, ref.Checksum)
+	}
+}
@@ -0,0 +1,240 @@
+package githttp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	BUNDLE_V2_SIGNATURE = "# v2 git bundle"
+	BUNDLE_V3_SIGNATURE = "# v3 git bundle"
+)
+
+var (
+	ErrInvalidBundle = errors.New("invalid bundle")
+)
+
+// BundleRef is a ref recorded in a bundle
+type BundleRef struct {
+	Name string
+	ID   common.Checksum
+}
+
+// BundlePrerequisite is a commit the bundle does not hold but builds upon. It must exist
+// in a repository before the bundle is unbundled into it.
+type BundlePrerequisite struct {
+	ID common.Checksum
+	// Comment is the subject of the commit, only meant for people reading the header
+	Comment string
+}
+
+// BundleHeader is the header of a bundle file, which is followed by a pack:
+//
+//	# v2 git bundle
+//	-<prerequisite> <comment>
+//	<id> <refname>
+//
+// The version 3 header adds @<key>=<value> capabilities after the signature.
+type BundleHeader struct {
+	Version       int
+	Caps          []string
+	Prerequisites []BundlePrerequisite
+	Refs          []BundleRef
+}
+
+// IsBundleSignature reports whether line is the first line of a bundle
+func IsBundleSignature(line string) bool {
+	return line == BUNDLE_V2_SIGNATURE || line == BUNDLE_V3_SIGNATURE
+}
+
+func (h *BundleHeader) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	switch h.Version {
+	case 2:
+		if len(h.Caps) > 0 {
+			return fmt.Errorf("%w: capabilities need version 3", ErrInvalidBundle)
+		}
+		fmt.Fprintln(bw, BUNDLE_V2_SIGNATURE)
+	case 3:
+		fmt.Fprintln(bw, BUNDLE_V3_SIGNATURE)
+		for _, capability := range h.Caps {
+			fmt.Fprintf(bw, "@%s\n", capability)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, h.Version)
+	}
+	for _, prerequisite := range h.Prerequisites {
+		if prerequisite.Comment != "" {
+			fmt.Fprintf(bw, "-%x %s\n", prerequisite.ID, prerequisite.Comment)
+		} else {
+			fmt.Fprintf(bw, "-%x\n", prerequisite.ID)
+		}
+	}
+	for _, ref := range h.Refs {
+		fmt.Fprintf(bw, "%x %s\n", ref.ID, ref.Name)
+	}
+	fmt.Fprintln(bw)
+	return bw.Flush()
+}
+
+// DecodeBundleHeader reads the header of a bundle and leaves r at the start of its pack
+func DecodeBundleHeader(r *bufio.Reader) (*BundleHeader, error) {
+	line, err := readBundleLine(r)
+	if err != nil {
+		return nil, err
+	}
+	header := &BundleHeader{}
+	switch line {
+	case BUNDLE_V2_SIGNATURE:
+		header.Version = 2
+	case BUNDLE_V3_SIGNATURE:
+		header.Version = 3
+	default:
+		return nil, fmt.Errorf("%w: unknown signature %q", ErrInvalidBundle, line)
+	}
+	for {
+		line, err := readBundleLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "":
+			return header, nil
+		case strings.HasPrefix(line, "@") && header.Version == 3:
+			capability := strings.TrimPrefix(line, "@")
+			key, value, _ := strings.Cut(capability, "=")
+			switch key {
+			case "object-format":
+				if value != "sha1" {
+					return nil, fmt.Errorf("%w: unsupported object format %s", ErrInvalidBundle, value)
+				}
+			case "filter":
+			default:
+				return nil, fmt.Errorf("%w: unknown capability %s", ErrInvalidBundle, key)
+			}
+			header.Caps = append(header.Caps, capability)
+		case strings.HasPrefix(line, "-"):
+			encodedId, comment, _ := strings.Cut(strings.TrimPrefix(line, "-"), " ")
+			id, err := decodeBundleId(encodedId)
+			if err != nil {
+				return nil, err
+			}
+			header.Prerequisites = append(header.Prerequisites, BundlePrerequisite{ID: id, Comment: comment})
+		default:
+			encodedId, name, found := strings.Cut(line, " ")
+			if !found {
+				return nil, fmt.Errorf("%w: invalid line %q", ErrInvalidBundle, line)
+			}
+			id, err := decodeBundleId(encodedId)
+			if err != nil {
+				return nil, err
+			}
+			header.Refs = append(header.Refs, BundleRef{Name: name, ID: id})
+		}
+	}
+}
+
+func readBundleLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", fmt.Errorf("%w: failed to read header: %w", ErrInvalidBundle, err)
+	}
+	return strings.TrimSuffix(line, "\n"), nil
+}
+
+func decodeBundleId(encodedId string) (common.Checksum, error) {
+	id, err := hash.ChecksumFromHex(encodedId)
+	if err != nil || len(id) != HEX_ID_LEN {
+		return nil, fmt.Errorf("%w: invalid object id %q", ErrInvalidBundle, encodedId)
+	}
+	return id, nil
+}
+
+// RefDiscReply returns the refs of the bundle as advertised by a remote. A HEAD recorded
+// in the bundle is the advertised HEAD.
+func (h *BundleHeader) RefDiscReply() *RefDiscReply {
+	reply := NewRefDiscReply()
+	for _, ref := range h.Refs {
+		if ref.Name == store.HEAD {
+			reply.setHead(ref.ID)
+			continue
+		}
+		reply.addRef(ref.Name, ref.ID)
+	}
+	return reply
+}
+
+// WriteBundle writes a bundle of the refs with every object reachable from them but not
+// from haves. The commits left out whose children are in the bundle are its prerequisites.
+func WriteBundle(manager pack.PackManageer, w io.Writer, version int, refs []BundleRef, haves [][]byte) error {
+	wants := [][]byte{}
+	for _, ref := range refs {
+		wants = append(wants, ref.ID)
+	}
+	entries, err := revlist.Objects(manager, wants, haves, revlist.Options{})
+	if err != nil {
+		return err
+	}
+	prerequisites, err := bundlePrerequisites(manager, entries)
+	if err != nil {
+		return err
+	}
+	header := &BundleHeader{
+		Version:       version,
+		Prerequisites: prerequisites,
+		Refs:          refs,
+	}
+	if version == 3 {
+		header.Caps = []string{"object-format=sha1"}
+	}
+	if err := header.Encode(w); err != nil {
+		return err
+	}
+	// Bundles are read by index-pack, which always understands ofs deltas
+	options := pack.DefaultWriteOptions()
+	options.OfsDelta = true
+	return writeEntries(manager, w, entries, options)
+}
+
+// bundlePrerequisites returns the parents of the commits among entries that are not in entries
+func bundlePrerequisites(manager pack.PackManageer, entries []revlist.Entry) ([]BundlePrerequisite, error) {
+	included := map[string]bool{}
+	for _, entry := range entries {
+		included[string(entry.Checksum)] = true
+	}
+	prerequisites := []BundlePrerequisite{}
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if entry.Type != common.OBJ_COMMIT {
+			continue
+		}
+		commit, err := revlist.ReadCommit(manager, entry.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		for _, parent := range commit.Parents {
+			if included[string(parent)] || seen[string(parent)] {
+				continue
+			}
+			seen[string(parent)] = true
+			prerequisite := BundlePrerequisite{ID: parent}
+			if parentCommit, err := readCommit(manager, parent); err == nil {
+				prerequisite.Comment, _, _ = strings.Cut(parentCommit.Message(), "\n")
+			}
+			prerequisites = append(prerequisites, prerequisite)
+		}
+	}
+	return prerequisites, nil
+}
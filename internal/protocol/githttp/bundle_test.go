@@ -0,0 +1,82 @@
+package githttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+func TestWriteBundle(t *testing.T) {
+	repo, base := testing_helper.SetupRepo(t, t.TempDir())
+	blob := testing_helper.WriteObject(t, repo, common.OBJ_BLOB, []byte("goodbye\n"))
+	tree := testing_helper.WriteObject(t, repo, common.OBJ_TREE, append([]byte("100644 bye.txt\x00"), blob...))
+	commit := testing_helper.WriteObject(t, repo, common.OBJ_COMMIT, []byte(fmt.Sprintf(
+		"tree %x\nparent %x\nauthor A U Thor <author@example.com> 1700000100 +0000\ncommitter A U Thor <author@example.com> 1700000100 +0000\n\nsecond\n", tree, base)))
+
+	// Leaving out the base commit makes it a prerequisite
+	var buf bytes.Buffer
+	refs := []BundleRef{{Name: "refs/heads/main", ID: commit}}
+	if err := WriteBundle(pack.New(repo), &buf, 3, refs, [][]byte{base}); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("# v3 git bundle\n@object-format=sha1\n-%x initial\n%x refs/heads/main\n\nPACK", base, commit)
+	if !strings.HasPrefix(buf.String(), expected) {
+		t.Fatalf("expected: %q\tactual: %q", expected, buf.String()[:min(buf.Len(), len(expected))])
+	}
+
+	r := bufio.NewReader(&buf)
+	header, err := DecodeBundleHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHeader := &BundleHeader{
+		Version:       3,
+		Caps:          []string{"object-format=sha1"},
+		Prerequisites: []BundlePrerequisite{{ID: base, Comment: "initial"}},
+		Refs:          refs,
+	}
+	if !reflect.DeepEqual(header, expectedHeader) {
+		t.Fatalf("expected: %+v\tactual: %+v", expectedHeader, header)
+	}
+	if !bytes.Equal(header.RefDiscReply().Refs()["refs/heads/main"], commit) {
+		t.Fatalf("expected refs/heads/main to be advertised as %x", commit)
+	}
+
+	// The pack only holds the objects missing from the prerequisites
+	target, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(target)
+	if _, err := manager.From(r); err != nil {
+		t.Fatal(err)
+	}
+	for id, expected := range map[string]bool{string(commit): true, string(blob): true, string(base): false} {
+		if ok, err := manager.ObjectExist([]byte(id)); err != nil || ok != expected {
+			t.Fatalf("expected: %x exists=%v\tactual: exists=%v (%v)", id, expected, ok, err)
+		}
+	}
+}
+
+func TestDecodeBundleHeaderErrors(t *testing.T) {
+	for _, header := range []string{
+		"# v4 git bundle\n\n",
+		"# v2 git bundle\n@object-format=sha1\n\n",
+		"# v3 git bundle\n@object-format=sha256\n\n",
+		"# v2 git bundle\nnot-an-id refs/heads/main\n\n",
+		"# v2 git bundle\n",
+	} {
+		if _, err := DecodeBundleHeader(bufio.NewReader(strings.NewReader(header))); !errors.Is(err, ErrInvalidBundle) {
+			t.Fatalf("%q: expected an invalid bundle but got %v", header, err)
+		}
+	}
+}
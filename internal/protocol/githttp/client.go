@@ -2,43 +2,157 @@ package githttp
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/credential"
+)
+
+const (
+	// DEFAULT_POST_BUFFER is the largest request body sent with a length, as http.postBuffer
+	DEFAULT_POST_BUFFER = 1 << 20
+	// DEFAULT_RETRY_DELAY is the delay before the first retry of a request
+	DEFAULT_RETRY_DELAY = time.Second
+	// MAX_RETRY_DELAY caps the delay between retries, including the one asked by Retry-After
+	MAX_RETRY_DELAY = time.Minute
+	// GZIP_THRESHOLD is the size above which buffered upload-pack requests are compressed
+	GZIP_THRESHOLD = 1024
+)
+
+var (
+	ErrAuthenticationFailed = errors.New("authentication failed")
 )
 
 type GitHttpClient struct {
-	httpClient *http.Client
+	httpClient  *http.Client
+	header      http.Header
+	userAgent   string
+	credentials *credential.Manager
+	// credential is the one sent with every request once the server asked for one
+	credential    *credential.Credential
+	lowSpeedLimit int
+	lowSpeedTime  time.Duration
+	postBuffer    int
+	maxRetries    int
+	retryDelay    time.Duration
+}
+
+// ClientOptions configures the requests of a GitHttpClient
+type ClientOptions struct {
+	// Header is sent with every request, as set by http.extraHeader
+	Header http.Header
+	// Credentials supplies the credentials asked for by a server answering 401. Without it
+	// only the credentials in the userinfo of the url are used.
+	Credentials *credential.Manager
+	// UserAgent defaults to AGENT
+	UserAgent string
+	// Proxy is used for every request. The proxy of the environment is used when nil.
+	Proxy *url.URL
+	// CAFile replaces the system certificate authorities with those of a PEM file
+	CAFile string
+	// InsecureSkipVerify disables the verification of the server certificate
+	InsecureSkipVerify bool
+	// A transfer is aborted when it is slower than LowSpeedLimit bytes per second for
+	// LowSpeedTime. Both must be set to enable the check.
+	LowSpeedLimit int
+	LowSpeedTime  time.Duration
+	// PostBuffer is the largest request body sent with a length, DEFAULT_POST_BUFFER if zero.
+	// Larger bodies are streamed with chunked encoding and are never sent again.
+	PostBuffer int
+	// MaxRetries is how many times a request answered with 429 or a 5xx status is sent again
+	MaxRetries int
+	// RetryDelay is the delay before the first retry, DEFAULT_RETRY_DELAY if zero. It
+	// doubles with each retry unless the server asks for a delay with Retry-After.
+	RetryDelay time.Duration
 }
 
 func NewGitHttpClient() *GitHttpClient {
-	return &GitHttpClient{
-		httpClient: http.DefaultClient,
+	// The default options have no file to load so they cannot fail
+	client, _ := NewGitHttpClientWithOptions(ClientOptions{})
+	return client
+}
+
+func NewGitHttpClientWithOptions(options ClientOptions) (*GitHttpClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if options.Proxy != nil {
+		transport.Proxy = http.ProxyURL(options.Proxy)
+	}
+	if options.CAFile != "" || options.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify}
+		if options.CAFile != "" {
+			pem, err := os.ReadFile(options.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read certificate authorities: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", options.CAFile)
+			}
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	client := &GitHttpClient{
+		httpClient:    &http.Client{Transport: &tracingTransport{next: transport}},
+		header:        options.Header,
+		userAgent:     options.UserAgent,
+		credentials:   options.Credentials,
+		lowSpeedLimit: options.LowSpeedLimit,
+		lowSpeedTime:  options.LowSpeedTime,
+		postBuffer:    options.PostBuffer,
+		maxRetries:    options.MaxRetries,
+		retryDelay:    options.RetryDelay,
+	}
+	if client.userAgent == "" {
+		client.userAgent = AGENT
 	}
+	if client.postBuffer <= 0 {
+		client.postBuffer = DEFAULT_POST_BUFFER
+	}
+	if client.retryDelay <= 0 {
+		client.retryDelay = DEFAULT_RETRY_DELAY
+	}
+	return client, nil
 }
 
+// GetRefs discovers the refs that can be fetched from the repository at gitUrl
 func (c *GitHttpClient) GetRefs(ctx context.Context, gitUrl string) (*RefDiscReply, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, time.Second*5)
-	defer cancel()
-	req, err := http.NewRequestWithContext(timeoutCtx, http.MethodGet, fmt.Sprintf("%s/info/refs?service=git-upload-pack", stripTrailingSlash(gitUrl)), nil)
+	return c.getRefs(ctx, gitUrl, UPLOAD_PACK_SERVICE)
+}
+
+// GetReceivePackRefs discovers the refs of the repository at gitUrl that can be pushed to
+func (c *GitHttpClient) GetReceivePackRefs(ctx context.Context, gitUrl string) (*RefDiscReply, error) {
+	return c.getRefs(ctx, gitUrl, RECEIVE_PACK_SERVICE)
+}
+
+func (c *GitHttpClient) getRefs(ctx context.Context, gitUrl string, service string) (*RefDiscReply, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s/info/refs?service=%s", stripTrailingSlash(gitUrl), service), nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct http request: %w", err)
 	}
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to senf request: %w", err)
+		return nil, fmt.Errorf("failed to send request: %w", err)
 	}
+	defer res.Body.Close()
 	if res.StatusCode != 200 {
 		return nil, fmt.Errorf("server responded with: %s", res.Status)
 	}
-	if res.Header.Get("Content-Type") != "application/x-git-upload-pack-advertisement" {
-		return nil, fmt.Errorf("server responded with invalid content type: %s", res.Header.Get("Content-Type"))
+	// A dumb server answers with the static info/refs file whatever the service asked for
+	if res.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-advertisement", service) {
+		return nil, fmt.Errorf("%w: server responded with content type %s", ErrDumbServer, res.Header.Get("Content-Type"))
 	}
 	decoder := NewRefDiscReplyDecoder(res.Body)
-	defer res.Body.Close()
 	reply, err := decoder.Decode()
 	if err != nil {
 		return nil, err
@@ -46,26 +160,264 @@ func (c *GitHttpClient) GetRefs(ctx context.Context, gitUrl string) (*RefDiscRep
 	return reply, nil
 }
 
-func (c *GitHttpClient) FetchPack(pr *PackReq, gitUrl string) (io.ReadCloser, error) {
+// FetchPack sends a pack request to the repository at gitUrl and returns the response body.
+// Reading the body is bound to ctx.
+func (c *GitHttpClient) FetchPack(ctx context.Context, pr *PackReq, gitUrl string) (io.ReadCloser, error) {
 	var encodedPackReq bytes.Buffer
 	if err := pr.Encode(&encodedPackReq); err != nil {
 		return nil, err
 	}
-	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/git-upload-pack", gitUrl), &encodedPackReq)
-	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
+	req, err := c.newRPCRequest(ctx, gitUrl, UPLOAD_PACK_SERVICE, &encodedPackReq, true)
 	if err != nil {
 		return nil, err
 	}
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	if res.StatusCode != 200 {
+		res.Body.Close()
 		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
 	}
 	return res.Body, nil
 }
 
+// SendPack sends the ref update commands followed by the pack to the repository at gitUrl
+func (c *GitHttpClient) SendPack(ctx context.Context, req *ReceivePackReq, pack io.Reader, gitUrl string) (*ReportStatus, error) {
+	var encodedReq bytes.Buffer
+	if err := req.Encode(&encodedReq); err != nil {
+		return nil, err
+	}
+	body := io.Reader(&encodedReq)
+	if pack != nil {
+		body = io.MultiReader(&encodedReq, pack)
+	}
+	httpReq, err := c.newRPCRequest(ctx, gitUrl, RECEIVE_PACK_SERVICE, body, false)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	}
+	if !req.Caps.Has("report-status") {
+		return &ReportStatus{}, nil
+	}
+	return DecodeReportStatus(NewPktLineParser(res.Body))
+}
+
+// newRPCRequest builds the POST request of service. A body that fits in the post buffer is
+// sent with its length, so that it can be sent again, and compressed when compress is set
+// and it is large enough to benefit. A larger body is streamed.
+func (c *GitHttpClient) newRPCRequest(ctx context.Context, gitUrl string, service string, body io.Reader, compress bool) (*http.Request, error) {
+	buffered, err := io.ReadAll(io.LimitReader(body, int64(c.postBuffer)+1))
+	if err != nil {
+		return nil, err
+	}
+	var reqBody io.Reader
+	encoding := ""
+	switch {
+	case len(buffered) > c.postBuffer:
+		reqBody = io.MultiReader(bytes.NewReader(buffered), body)
+	case compress && len(buffered) > GZIP_THRESHOLD:
+		var compressed bytes.Buffer
+		gzipWriter := gzip.NewWriter(&compressed)
+		if _, err := gzipWriter.Write(buffered); err != nil {
+			return nil, err
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(compressed.Bytes())
+		encoding = "gzip"
+	default:
+		reqBody = bytes.NewReader(buffered)
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, fmt.Sprintf("%s/%s", stripTrailingSlash(gitUrl), service), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", fmt.Sprintf("application/x-%s-request", service))
+	req.Header.Set("Accept", fmt.Sprintf("application/x-%s-result", service))
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
+	return req, nil
+}
+
+// newRequest builds a request carrying the extra headers and the current credential. The
+// userinfo of rawUrl is moved out of the url: a username with a password is sent with basic
+// auth, a password alone as a bearer token and a username alone is completed by the helpers.
+func (c *GitHttpClient) newRequest(ctx context.Context, method string, rawUrl string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if u.User != nil {
+		if c.credential == nil {
+			c.credential = credential.FromURL(u)
+		}
+		u.User = nil
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	for name, values := range c.header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	c.authorize(req)
+	return req, nil
+}
+
+func (c *GitHttpClient) authorize(req *http.Request) {
+	switch {
+	case c.credential == nil || c.credential.Password == "":
+	case c.credential.Username == "":
+		req.Header.Set("Authorization", "Bearer "+c.credential.Password)
+	default:
+		req.SetBasicAuth(c.credential.Username, c.credential.Password)
+	}
+}
+
+// do sends req and, when the server answers 401, asks the credential helpers for a
+// credential and sends req again with it. The helpers are told whether it was accepted.
+// A request whose body cannot be replayed is not retried.
+func (c *GitHttpClient) do(req *http.Request) (*http.Response, error) {
+	res, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusUnauthorized || c.credentials == nil || !canResend(req) {
+		return res, nil
+	}
+	res.Body.Close()
+
+	filled := credential.FromURL(req.URL)
+	if c.credential != nil {
+		if c.credential.Password != "" {
+			if err := c.credentials.Reject(c.credential); err != nil {
+				return nil, err
+			}
+		}
+		filled.Username = c.credential.Username
+	}
+	if err := c.credentials.Fill(filled); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuthenticationFailed, err)
+	}
+	c.credential = filled
+
+	retry, err := resend(req)
+	if err != nil {
+		return nil, err
+	}
+	c.authorize(retry)
+	res, err = c.send(retry)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		res.Body.Close()
+		c.credential = nil
+		if err := c.credentials.Reject(filled); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w for '%s'", ErrAuthenticationFailed, filled)
+	}
+	if res.StatusCode < http.StatusMultipleChoices {
+		if err := c.credentials.Approve(filled); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// send sends req, sending it again with an exponential backoff while the server answers
+// with a transient error status. The response body is subject to the low-speed limit.
+func (c *GitHttpClient) send(req *http.Request) (*http.Response, error) {
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		res, err := c.sendOnce(req)
+		if err != nil {
+			return nil, err
+		}
+		if !isTransient(res.StatusCode) || attempt >= c.maxRetries || !canResend(req) {
+			return res, nil
+		}
+		wait := delay
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			wait = time.Duration(seconds) * time.Second
+		}
+		res.Body.Close()
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(min(wait, MAX_RETRY_DELAY)):
+		}
+		delay *= 2
+		if req, err = resend(req); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sendOnce sends req, aborting it when the transfer in either direction falls below the
+// low-speed limit
+func (c *GitHttpClient) sendOnce(req *http.Request) (*http.Response, error) {
+	if c.lowSpeedLimit <= 0 || c.lowSpeedTime <= 0 {
+		return c.httpClient.Do(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	watch := newLowSpeedWatch(c.lowSpeedLimit, c.lowSpeedTime, cancel)
+	watched := req.WithContext(ctx)
+	if req.Body != nil {
+		watched.Body = watch.wrap(req.Body, false)
+	}
+	res, err := c.httpClient.Do(watched)
+	if err != nil {
+		watch.stop()
+		return nil, watch.err(err)
+	}
+	res.Body = watch.wrap(res.Body, true)
+	return res, nil
+}
+
+// isTransient reports whether a request answered with status may succeed when sent again
+func isTransient(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// canResend reports whether the body of req can be read again
+func canResend(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+// resend returns a copy of req with a fresh body
+func resend(req *http.Request) (*http.Request, error) {
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retry.Body = body
+	}
+	return retry, nil
+}
+
 func stripTrailingSlash(url string) string {
 	return strings.TrimSuffix(url, "/")
 }
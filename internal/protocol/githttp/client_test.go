@@ -1,19 +1,232 @@
 package githttp
 
 import (
+	"bytes"
 	"context"
+	"encoding/pem"
+	"errors"
+	"io"
 	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/credential"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
 )
 
 func TestDiscoverRef(t *testing.T) {
 	gitUrl := os.Getenv("GIT_URL")
+	if gitUrl == "" {
+		t.Skip()
+	}
 	baseContext := context.Background()
 	client := NewGitHttpClient()
-	reply, err := client.discoverRef(baseContext, gitUrl)
+	reply, err := client.GetRefs(baseContext, gitUrl)
 	if err != nil {
 		t.Fatalf("failed to discover ref: %v", err)
 	}
 	log.Printf("%+v", reply)
 }
+
+func TestClientAuthentication(t *testing.T) {
+	baseDir := t.TempDir()
+	testing_helper.SetupRepo(t, baseDir+"/repo.git")
+	handler := NewServer(NewFSResolver(baseDir), ServerOptions{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Extra") != "1" {
+			http.Error(w, "missing extra header", http.StatusBadRequest)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer token" && (!ok || username != "bob" || password != "secret") {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "credentials")
+	cfg := config.New()
+	cfg.Add("credential", "", "helper", "store --file "+file)
+	cfg.Add("credential", "", "helper", "!f() { test $1 != get || printf 'username=bob\\npassword=secret\\n'; }; f")
+	credentials, err := credential.NewManager(cfg, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewGitHttpClientWithOptions(ClientOptions{
+		Header:      http.Header{"X-Extra": []string{"1"}},
+		Credentials: credentials,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetRefs(context.Background(), server.URL+"/repo"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := credential.NewStoreHelper(file).Get(&credential.Credential{Protocol: "http"})
+	if err != nil || stored.Username != "bob" || stored.Password != "secret" {
+		t.Fatalf("expected the accepted credential to be stored but got %s (%v)", stored, err)
+	}
+	// The credential is reused without asking the server again
+	if _, err := client.FetchPack(context.Background(), NewPackReq(), server.URL+"/repo"); err != nil {
+		t.Fatal(err)
+	}
+
+	client, _ = NewGitHttpClientWithOptions(ClientOptions{Header: http.Header{"X-Extra": []string{"1"}}})
+	bearerUrl := strings.Replace(server.URL, "http://", "http://:token@", 1)
+	if _, err := client.GetRefs(context.Background(), bearerUrl+"/repo"); err != nil {
+		t.Fatal(err)
+	}
+	wrongUrl := strings.Replace(server.URL, "http://", "http://bob:wrong@", 1)
+	client, _ = NewGitHttpClientWithOptions(ClientOptions{Header: http.Header{"X-Extra": []string{"1"}}})
+	if _, err := client.GetRefs(context.Background(), wrongUrl+"/repo"); err == nil || strings.Contains(err.Error(), "wrong") {
+		t.Fatalf("expected the request to fail without revealing the password but got %v", err)
+	}
+}
+
+func TestClientRetryAndGzip(t *testing.T) {
+	baseDir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, baseDir+"/repo.git")
+	handler := NewServer(NewFSResolver(baseDir), ServerOptions{})
+	failures := 2
+	var encodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("User-Agent") != "test-agent" {
+			http.Error(w, "unexpected user agent", http.StatusBadRequest)
+			return
+		}
+		if failures > 0 {
+			failures--
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method == http.MethodPost {
+			encodings = append(encodings, r.Header.Get("Content-Encoding"))
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client, err := NewGitHttpClientWithOptions(ClientOptions{UserAgent: "test-agent", MaxRetries: 2, RetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetRefs(context.Background(), server.URL+"/repo"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A request large enough is compressed, and so is its retry
+	failures = 1
+	req := NewPackReq()
+	req.AddWant(commit)
+	for i := 0; i < 100; i++ {
+		req.AddHave(bytes.Repeat([]byte{byte(i)}, 20))
+	}
+	body, err := client.FetchPack(context.Background(), req, server.URL+"/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	if len(encodings) != 1 || encodings[0] != "gzip" {
+		t.Fatalf("expected a single gzip request but got %q", encodings)
+	}
+
+	failures = 3
+	if _, err := client.GetRefs(context.Background(), server.URL+"/repo"); err == nil {
+		t.Fatalf("expected the request to fail after 2 retries")
+	}
+}
+
+func TestClientTLSAndProxy(t *testing.T) {
+	baseDir := t.TempDir()
+	testing_helper.SetupRepo(t, baseDir+"/repo.git")
+	server := httptest.NewTLSServer(NewServer(NewFSResolver(baseDir), ServerOptions{}))
+	defer server.Close()
+
+	if _, err := NewGitHttpClient().GetRefs(context.Background(), server.URL+"/repo"); err == nil {
+		t.Fatalf("expected a certificate signed by an unknown authority to be refused")
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, options := range []ClientOptions{{CAFile: caFile}, {InsecureSkipVerify: true}} {
+		client, err := NewGitHttpClientWithOptions(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.GetRefs(context.Background(), server.URL+"/repo"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plain := httptest.NewServer(NewServer(NewFSResolver(baseDir), ServerOptions{}))
+	defer plain.Close()
+	proxied := 0
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied++
+		res, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for name, values := range res.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	}))
+	defer proxy.Close()
+	proxyUrl, _ := url.Parse(proxy.URL)
+	client, err := NewGitHttpClientWithOptions(ClientOptions{Proxy: proxyUrl})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetRefs(context.Background(), plain.URL+"/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if proxied != 1 {
+		t.Fatalf("expected the request to go through the proxy")
+	}
+}
+
+func TestClientLowSpeedAndCancel(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := NewGitHttpClientWithOptions(ClientOptions{LowSpeedLimit: 1000, LowSpeedTime: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetRefs(context.Background(), server.URL+"/repo"); !errors.Is(err, ErrTooSlow) {
+		t.Fatalf("expected a stalled transfer to be aborted but got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewGitHttpClient().GetRefs(ctx, server.URL+"/repo"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the request to end with its context but got %v", err)
+	}
+}
@@ -3,7 +3,7 @@ package githttp
 import (
 	"bytes"
 
-	"github.com/codecrafters-io/git-starter-go/internal/common"
+	common "github.com/codecrafters-io/git-starter-go/internal"
 )
 
 var (
@@ -15,10 +15,7 @@ var (
 	NUL         = []byte("\x00")
 )
 
+// isZeroId reports whether id is the null object id, which stands for a ref that does not exist
 func isZeroId(id common.Checksum) bool {
-	return bytes.Equal(id, ZERO_ID)
-}
-
-func isFlushPkt(b []byte) bool {
-	return bytes.Equal(FLUSH_PKT, b)
+	return len(id) == 0 || bytes.Equal(id, make([]byte, len(id)))
 }
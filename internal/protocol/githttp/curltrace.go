@@ -0,0 +1,66 @@
+package githttp
+
+import (
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
+)
+
+// tracingTransport traces the headers of every request and response to GIT_TRACE_CURL,
+// in the format of curl's verbose output. Credentials are redacted unless GIT_TRACE_REDACT
+// is set to 0.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !trace.Curl.Enabled() {
+		return t.next.RoundTrip(req)
+	}
+	trace.Curl.Printf("=> Send header: %s %s %s", req.Method, req.URL.RequestURI(), req.Proto)
+	trace.Curl.Printf("=> Send header: Host: %s", req.URL.Host)
+	traceHeaders("=> Send header", req.Header)
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		trace.Curl.Printf("== Info: %v", err)
+		return nil, err
+	}
+	trace.Curl.Printf("<= Recv header: %s %s", res.Proto, res.Status)
+	traceHeaders("<= Recv header", res.Header)
+	return res, nil
+}
+
+func traceHeaders(prefix string, header http.Header) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	redact := os.Getenv("GIT_TRACE_REDACT") != "0"
+	for _, name := range names {
+		for _, value := range header[name] {
+			if redact {
+				value = redactHeader(name, value)
+			}
+			trace.Curl.Printf("%s: %s: %s", prefix, name, value)
+		}
+	}
+}
+
+// redactHeader hides the credentials sent in a header, keeping the authentication scheme
+func redactHeader(name string, value string) string {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization":
+		if scheme, _, found := strings.Cut(value, " "); found {
+			return scheme + " <redacted>"
+		}
+		return "<redacted>"
+	case "Cookie", "Set-Cookie":
+		return "<redacted>"
+	default:
+		return value
+	}
+}
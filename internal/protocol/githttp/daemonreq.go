@@ -0,0 +1,71 @@
+package githttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	ErrInvalidDaemonReq = errors.New("invalid daemon request")
+)
+
+// DaemonReq is the first pkt-line sent to a git daemon, naming the service to run and
+// the repository it runs for:
+//
+//	git-upload-pack /path/repo.git\0host=example.com\0
+type DaemonReq struct {
+	Service string
+	Path    string
+	// Host is the host the client connected to, including the port if one was given
+	Host string
+	// ExtraParams follow the host after an empty parameter, such as version=2
+	ExtraParams []string
+}
+
+func (req *DaemonReq) Encode(w io.Writer) error {
+	var line bytes.Buffer
+	fmt.Fprintf(&line, "%s %s\x00", req.Service, req.Path)
+	if req.Host != "" {
+		fmt.Fprintf(&line, "host=%s\x00", req.Host)
+	}
+	if len(req.ExtraParams) > 0 {
+		line.WriteString("\x00")
+		for _, param := range req.ExtraParams {
+			fmt.Fprintf(&line, "%s\x00", param)
+		}
+	}
+	_, err := NewPktLineEncoder(w).WriteData(line.Bytes())
+	return err
+}
+
+func DecodeDaemonReq(r io.Reader) (*DaemonReq, error) {
+	line, flush, err := NewPktLineParser(r).ReadPktLine()
+	if err != nil {
+		return nil, err
+	}
+	if flush {
+		return nil, fmt.Errorf("%w: unexpected flush-pkt", ErrInvalidDaemonReq)
+	}
+	command, params, _ := strings.Cut(string(line), "\x00")
+	service, path, found := strings.Cut(command, " ")
+	if !found || path == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDaemonReq, command)
+	}
+	req := &DaemonReq{Service: service, Path: path}
+	// The extra parameters follow an empty parameter, other parameters are ignored as by git
+	extra := false
+	for _, param := range strings.Split(strings.TrimSuffix(params, "\x00"), "\x00") {
+		switch {
+		case param == "":
+			extra = true
+		case extra:
+			req.ExtraParams = append(req.ExtraParams, param)
+		case strings.HasPrefix(param, "host="):
+			req.Host = strings.TrimPrefix(param, "host=")
+		}
+	}
+	return req, nil
+}
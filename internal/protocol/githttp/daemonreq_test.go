@@ -0,0 +1,38 @@
+package githttp
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDaemonReq(t *testing.T) {
+	req := &DaemonReq{
+		Service:     UPLOAD_PACK_SERVICE,
+		Path:        "/repo.git",
+		Host:        "example.com:9418",
+		ExtraParams: []string{"version=2"},
+	}
+	var buf bytes.Buffer
+	if err := req.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "003fgit-upload-pack /repo.git\x00host=example.com:9418\x00\x00version=2\x00"
+	if buf.String() != expected {
+		t.Fatalf("expected: %q\tactual: %q", expected, buf.String())
+	}
+	decoded, err := DecodeDaemonReq(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, req) {
+		t.Fatalf("expected: %+v\tactual: %+v", req, decoded)
+	}
+
+	buf.Reset()
+	NewPktLineEncoder(&buf).WriteLineString("git-upload-pack")
+	if _, err := DecodeDaemonReq(&buf); !errors.Is(err, ErrInvalidDaemonReq) {
+		t.Fatalf("expected an invalid request but got %v", err)
+	}
+}
@@ -0,0 +1,442 @@
+package githttp
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/filemode"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+var (
+	// ErrDumbServer is returned by the ref discovery of a server that only serves the
+	// files of the repository, without running upload-pack or receive-pack
+	ErrDumbServer    = errors.New("server does not support the smart http protocol")
+	ErrFileNotFound  = errors.New("file not found")
+	ErrObjectMissing = errors.New("object not found on the server")
+	// ErrRangeNotSupported is returned when an interrupted download cannot be resumed
+	ErrRangeNotSupported = errors.New("server does not support resuming downloads")
+)
+
+const (
+	// DOWNLOAD_RETRIES is the number of times a download is resumed before giving up
+	DOWNLOAD_RETRIES = 3
+)
+
+// GetDumbRefs reads the refs of a repository served by a dumb server from info/refs, as
+// written by update-server-info, and the branch HEAD points to from the HEAD file
+func (c *GitHttpClient) GetDumbRefs(ctx context.Context, gitUrl string) (*RefDiscReply, error) {
+	infoRefs, err := c.GetFile(ctx, gitUrl, "info/refs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read info/refs: %w", err)
+	}
+	reply := NewRefDiscReply()
+	scanner := bufio.NewScanner(bytes.NewReader(infoRefs))
+	for scanner.Scan() {
+		encodedId, name, found := strings.Cut(scanner.Text(), "\t")
+		if !found {
+			return nil, fmt.Errorf("invalid line in info/refs: %q", scanner.Text())
+		}
+		id, err := hash.ChecksumFromHex(encodedId)
+		if err != nil || len(id) != HEX_ID_LEN {
+			return nil, fmt.Errorf("invalid object id in info/refs: %q", encodedId)
+		}
+		if peeled, ok := strings.CutSuffix(name, "^{}"); ok {
+			reply.addPeeledRef(peeled, id)
+		} else {
+			reply.addRef(name, id)
+		}
+	}
+
+	head, err := c.GetFile(ctx, gitUrl, "HEAD")
+	if err != nil {
+		// A repository without HEAD can still be fetched from
+		if errors.Is(err, ErrFileNotFound) {
+			return reply, nil
+		}
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	content := strings.TrimSpace(string(head))
+	if target, ok := strings.CutPrefix(content, "ref: "); ok {
+		reply.caps = NewCapList("symref=HEAD:" + target)
+		reply.setHead(reply.refs[target])
+	} else if id, err := hash.ChecksumFromHex(content); err == nil && len(id) == HEX_ID_LEN {
+		reply.setHead(id)
+	}
+	return reply, nil
+}
+
+// GetFile downloads the file at path in the repository at gitUrl. [ErrFileNotFound] is
+// returned when the server does not have it.
+func (c *GitHttpClient) GetFile(ctx context.Context, gitUrl string, path string) ([]byte, error) {
+	body, err := c.getFile(ctx, gitUrl, path)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func (c *GitHttpClient) getFile(ctx context.Context, gitUrl string, path string) (io.ReadCloser, error) {
+	return c.getFileFrom(ctx, gitUrl, path, 0)
+}
+
+// getFileFrom downloads the file at path starting at offset, which the server must honor
+func (c *GitHttpClient) getFileFrom(ctx context.Context, gitUrl string, path string, offset int64) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, fmt.Sprintf("%s/%s", stripTrailingSlash(gitUrl), path), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct http request: %w", err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			res.Body.Close()
+			return nil, fmt.Errorf("%w: %s", ErrRangeNotSupported, path)
+		}
+		return res.Body, nil
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(res.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			res.Body.Close()
+			return nil, fmt.Errorf("%w: %s", ErrRangeNotSupported, path)
+		}
+		return res.Body, nil
+	case http.StatusNotFound, http.StatusGone:
+		res.Body.Close()
+		return nil, fmt.Errorf("%w: %s", ErrFileNotFound, path)
+	default:
+		res.Body.Close()
+		return nil, fmt.Errorf("server responded with %s for %s", res.Status, path)
+	}
+}
+
+// downloadFile downloads the file at path. A download cut short, such as by a dropped
+// connection, is resumed from where it stopped rather than started over.
+func (c *GitHttpClient) downloadFile(ctx context.Context, gitUrl string, path string) (io.ReadCloser, error) {
+	body, err := c.getFile(ctx, gitUrl, path)
+	if err != nil {
+		return nil, err
+	}
+	return &resumableBody{
+		ctx:    ctx,
+		client: c,
+		url:    gitUrl,
+		path:   path,
+		body:   body,
+	}, nil
+}
+
+// resumableBody reads a download, requesting the rest of the file with a range request
+// when reading fails midway
+type resumableBody struct {
+	ctx     context.Context
+	client  *GitHttpClient
+	url     string
+	path    string
+	body    io.ReadCloser
+	offset  int64
+	retries int
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.offset += int64(n)
+	if err == nil || err == io.EOF || b.ctx.Err() != nil || b.retries >= DOWNLOAD_RETRIES {
+		return n, err
+	}
+	b.retries++
+	b.body.Close()
+	body, resumeErr := b.client.getFileFrom(b.ctx, b.url, b.path, b.offset)
+	if resumeErr != nil {
+		b.body = http.NoBody
+		return n, fmt.Errorf("%w, failed to resume download: %w", err, resumeErr)
+	}
+	b.body = body
+	return n, nil
+}
+
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
+
+// FetchObjects downloads from a dumb server the objects reachable from wants that are
+// missing from the store, as git's http walker does. The walk stops at the haves, which
+// must be complete in the store. Every downloaded object and pack is verified before it
+// is stored.
+func (c *GitHttpClient) FetchObjects(ctx context.Context, gitUrl string, st store.Store, manager *pack.DefaulPackManager, wants [][]byte, haves [][]byte) error {
+	w := &dumbWalker{
+		client:  c,
+		ctx:     ctx,
+		url:     gitUrl,
+		store:   st,
+		manager: manager,
+		seen:    map[string]bool{},
+	}
+	for _, have := range haves {
+		w.seen[string(have)] = true
+	}
+	return w.walk(wants)
+}
+
+type dumbWalker struct {
+	client  *GitHttpClient
+	ctx     context.Context
+	url     string
+	store   store.Store
+	manager *pack.DefaulPackManager
+	seen    map[string]bool
+	// packs are the packs of the server, listed on the first object that is not loose
+	packs []*remotePack
+}
+
+// remotePack is a pack of the server along with the objects of its index
+type remotePack struct {
+	name    string
+	objects map[string]bool
+	fetched bool
+}
+
+// walkEntry is an object to visit. Blobs are not read once stored since they link to nothing.
+type walkEntry struct {
+	id   []byte
+	blob bool
+}
+
+func (w *dumbWalker) walk(wants [][]byte) error {
+	stack := []walkEntry{}
+	for _, want := range wants {
+		stack = append(stack, walkEntry{id: want})
+	}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w.seen[string(cur.id)] {
+			continue
+		}
+		w.seen[string(cur.id)] = true
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+
+		ok, err := w.manager.ObjectExist(cur.id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := w.fetch(cur.id); err != nil {
+				return err
+			}
+		}
+		if cur.blob {
+			continue
+		}
+
+		// Objects that were already stored are still walked, as an interrupted fetch may
+		// have left them without the objects they link to
+		encodedObject, err := w.manager.Object(cur.id)
+		if err != nil {
+			return err
+		}
+		switch encodedObject.Type() {
+		case common.OBJ_COMMIT:
+			commit, err := object.DecodeCommit(encodedObject)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, walkEntry{id: commit.Tree()})
+			for _, parent := range commit.Parents() {
+				stack = append(stack, walkEntry{id: parent})
+			}
+		case common.OBJ_TAG:
+			tag, err := object.DecodeTag(encodedObject)
+			if err != nil {
+				return err
+			}
+			stack = append(stack, walkEntry{id: tag.Object(), blob: tag.ObjectType() == common.OBJ_BLOB})
+		case common.OBJ_TREE:
+			tree, err := object.DecodeTree(encodedObject)
+			if err != nil {
+				return err
+			}
+			iter := tree.TreeIter()
+			for {
+				entry, ok := iter.Next()
+				if !ok {
+					break
+				}
+				// Submodule commits belong to another repository
+				if entry.Mode == filemode.Submodule {
+					continue
+				}
+				stack = append(stack, walkEntry{id: entry.Checksum, blob: entry.Type() == common.OBJ_BLOB})
+			}
+		}
+	}
+	return nil
+}
+
+// fetch downloads the loose object id, falling back to the pack of the server holding it
+func (w *dumbWalker) fetch(id []byte) error {
+	err := w.fetchLoose(id)
+	if !errors.Is(err, ErrFileNotFound) {
+		return err
+	}
+	return w.fetchPacked(id)
+}
+
+func (w *dumbWalker) fetchLoose(id []byte) error {
+	hexId := hash.ChecksumToHex(id)
+	data, err := w.client.GetFile(w.ctx, w.url, fmt.Sprintf("objects/%s/%s", hexId[:2], hexId[2:]))
+	if err != nil {
+		return err
+	}
+	if err := verifyLooseObject(id, data); err != nil {
+		return err
+	}
+	// The object is written under a temporary name so that it only appears once complete
+	file, err := w.store.ObjectWriter("")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Remove()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		file.Remove()
+		return err
+	}
+	return file.Rename(hexId)
+}
+
+// verifyLooseObject checks that data is the compressed object id
+func verifyLooseObject(id []byte, data []byte) error {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("corrupt object %x: %w", id, err)
+	}
+	defer r.Close()
+	header, err := common.DecodeObjectHeader(r)
+	if err != nil {
+		return fmt.Errorf("corrupt object %x: %w", id, err)
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("corrupt object %x: %w", id, err)
+	}
+	if len(content) != header.Size {
+		return fmt.Errorf("corrupt object %x: expected %d bytes but got %d", id, header.Size, len(content))
+	}
+	actual, err := common.HashObject(header.Type, content)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(actual, id) {
+		return fmt.Errorf("corrupt object %x: content hashes to %x", id, actual)
+	}
+	return nil
+}
+
+func (w *dumbWalker) fetchPacked(id []byte) error {
+	if w.packs == nil {
+		if err := w.listPacks(); err != nil {
+			return err
+		}
+	}
+	for _, remote := range w.packs {
+		if remote.fetched {
+			continue
+		}
+		if remote.objects == nil {
+			if err := w.readIndex(remote); err != nil {
+				return err
+			}
+		}
+		if !remote.objects[string(id)] {
+			continue
+		}
+		return w.fetchPack(remote)
+	}
+	return fmt.Errorf("%w: %x", ErrObjectMissing, id)
+}
+
+// listPacks reads the packs of the server from objects/info/packs
+func (w *dumbWalker) listPacks() error {
+	w.packs = []*remotePack{}
+	data, err := w.client.GetFile(w.ctx, w.url, "objects/info/packs")
+	if err != nil {
+		if errors.Is(err, ErrFileNotFound) {
+			return nil
+		}
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// Other lines, such as the list of alternates, are not supported
+		filename, ok := strings.CutPrefix(scanner.Text(), "P ")
+		if !ok {
+			continue
+		}
+		name, ok := strings.CutPrefix(strings.TrimSuffix(filename, ".pack"), "pack-")
+		if !ok {
+			return fmt.Errorf("invalid pack name in objects/info/packs: %q", filename)
+		}
+		w.packs = append(w.packs, &remotePack{name: name})
+	}
+	return nil
+}
+
+func (w *dumbWalker) readIndex(remote *remotePack) error {
+	data, err := w.client.GetFile(w.ctx, w.url, fmt.Sprintf("objects/pack/pack-%s.idx", remote.name))
+	if err != nil {
+		return err
+	}
+	ids, packChecksum, err := pack.IndexObjects(data)
+	if err != nil {
+		return fmt.Errorf("pack-%s.idx: %w", remote.name, err)
+	}
+	if hash.ChecksumToHex(packChecksum) != remote.name {
+		return fmt.Errorf("pack-%s.idx describes pack %x", remote.name, packChecksum)
+	}
+	remote.objects = map[string]bool{}
+	for _, id := range ids {
+		remote.objects[string(id)] = true
+	}
+	return nil
+}
+
+// fetchPack downloads a pack of the server, resuming the download if it is cut short. The
+// checksum of the pack is verified as it is stored, and the store indexes it on its own.
+func (w *dumbWalker) fetchPack(remote *remotePack) error {
+	body, err := w.client.downloadFile(w.ctx, w.url, fmt.Sprintf("objects/pack/pack-%s.pack", remote.name))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	checksum, err := w.manager.From(body)
+	if err != nil {
+		return fmt.Errorf("pack-%s.pack: %w", remote.name, err)
+	}
+	if hash.ChecksumToHex(checksum) != remote.name {
+		return fmt.Errorf("pack-%s.pack has checksum %x", remote.name, checksum)
+	}
+	remote.fetched = true
+	return nil
+}
@@ -0,0 +1,166 @@
+package githttp
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+func TestDumbFetch(t *testing.T) {
+	baseDir := t.TempDir()
+	repoDir := filepath.Join(baseDir, "repo.git")
+	repo, commit := testing_helper.SetupRepo(t, repoDir)
+	// The info/refs file written by update-server-info
+	infoRefs := fmt.Sprintf("%x\trefs/heads/main\n", commit)
+	if err := os.MkdirAll(filepath.Join(repoDir, "info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "info", "refs"), []byte(infoRefs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir(baseDir)))
+	defer server.Close()
+
+	client := NewGitHttpClient()
+	if _, err := client.GetRefs(context.Background(), server.URL+"/repo.git"); !errors.Is(err, ErrDumbServer) {
+		t.Fatalf("expected a dumb server but got %v", err)
+	}
+	reply, err := client.GetDumbRefs(context.Background(), server.URL+"/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Refs()["refs/heads/main"], commit) || !bytes.Equal(reply.Head(), commit) {
+		t.Fatalf("expected main and HEAD to be %x but got %x and %x", commit, reply.Refs()["refs/heads/main"], reply.Head())
+	}
+	if target, ok := reply.HeadTarget(); !ok || target != "refs/heads/main" {
+		t.Fatalf("expected HEAD to point to refs/heads/main but got %q", target)
+	}
+
+	clone, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(clone)
+	if err := client.FetchObjects(context.Background(), server.URL+"/repo.git", clone, manager, [][]byte{commit}, nil); err != nil {
+		t.Fatal(err)
+	}
+	blob, err := manager.Object(hashBlob(t, []byte("hello world\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blob.Type() != common.OBJ_BLOB {
+		t.Fatalf("expected a blob but got %s", blob.Type())
+	}
+
+	// An object that does not match its name is not stored
+	forged := hashBlob(t, []byte("forged\n"))
+	var compressed bytes.Buffer
+	comp := zlib.NewWriter(&compressed)
+	common.EncodeObject(common.OBJ_BLOB, []byte("other\n"), comp)
+	comp.Close()
+	objectPath := filepath.Join(repoDir, "objects", hash.ChecksumToHex(forged)[:2], hash.ChecksumToHex(forged)[2:])
+	if err := os.MkdirAll(filepath.Dir(objectPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(objectPath, compressed.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tag := testing_helper.WriteObject(t, repo, common.OBJ_TAG, []byte(fmt.Sprintf(
+		"object %x\ntype blob\ntag forged\ntagger A U Thor <author@example.com> 1700000000 +0000\n\nforged\n", forged)))
+	if err := client.FetchObjects(context.Background(), server.URL+"/repo.git", clone, manager, [][]byte{tag}, nil); err == nil {
+		t.Fatalf("expected the forged object to be rejected")
+	}
+	if ok, err := manager.ObjectExist(forged); err != nil || ok {
+		t.Fatalf("expected the forged object not to be stored but got %v (%v)", ok, err)
+	}
+}
+
+func TestDumbFetchResumesPackDownload(t *testing.T) {
+	repo, commit := testing_helper.SetupRepo(t, t.TempDir())
+	var packData bytes.Buffer
+	if err := WritePack(pack.New(repo), &packData, [][]byte{commit}, nil, revlist.Options{}, pack.DefaultWriteOptions()); err != nil {
+		t.Fatal(err)
+	}
+	// The served repository only holds the pack
+	baseDir := t.TempDir()
+	served, err := store.InitBare(filepath.Join(baseDir, "repo.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	packChecksum, err := pack.New(served).From(bytes.NewReader(packData.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	infoFiles := map[string]string{
+		"info/refs":          fmt.Sprintf("%x\trefs/heads/main\n", commit),
+		"objects/info/packs": fmt.Sprintf("P pack-%x.pack\n", packChecksum),
+	}
+	for name, content := range infoFiles {
+		path := filepath.Join(served.RootDir(), name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The first download of the pack is cut off halfway
+	fileServer := http.FileServer(http.Dir(baseDir))
+	ranges := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".pack") {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			w.Header().Set("Content-Length", fmt.Sprint(packData.Len()))
+			w.Write(packData.Bytes()[:packData.Len()/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	clone, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(clone)
+	client := NewGitHttpClient()
+	if err := client.FetchObjects(context.Background(), server.URL+"/repo.git", clone, manager, [][]byte{commit}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("bytes=%d-", packData.Len()/2)
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != expected {
+		t.Fatalf("expected: ranges=[\"\" %q]\tactual: ranges=%q", expected, ranges)
+	}
+	if ok, err := manager.ObjectExist(commit); err != nil || !ok {
+		t.Fatalf("expected fetched commit %x to exist", commit)
+	}
+}
+
+func hashBlob(t *testing.T, content []byte) []byte {
+	id, err := common.HashObject(common.OBJ_BLOB, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
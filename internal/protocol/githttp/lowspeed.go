@@ -0,0 +1,88 @@
+package githttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTooSlow = errors.New("transfer too slow")
+)
+
+// lowSpeedWatch cancels a request when fewer than limit bytes per second were transferred
+// over the last window, as http.lowSpeedLimit and http.lowSpeedTime do in git
+type lowSpeedWatch struct {
+	limit       int
+	window      time.Duration
+	cancel      context.CancelFunc
+	timer       *time.Timer
+	transferred atomic.Int64
+	checked     int64
+	tooSlow     atomic.Bool
+}
+
+func newLowSpeedWatch(limit int, window time.Duration, cancel context.CancelFunc) *lowSpeedWatch {
+	watch := &lowSpeedWatch{
+		limit:  limit,
+		window: window,
+		cancel: cancel,
+	}
+	watch.timer = time.AfterFunc(window, watch.check)
+	return watch
+}
+
+func (w *lowSpeedWatch) check() {
+	transferred := w.transferred.Load()
+	if float64(transferred-w.checked) < float64(w.limit)*w.window.Seconds() {
+		w.tooSlow.Store(true)
+		w.cancel()
+		return
+	}
+	w.checked = transferred
+	w.timer.Reset(w.window)
+}
+
+// stop ends the watch and releases the context of the request
+func (w *lowSpeedWatch) stop() {
+	w.timer.Stop()
+	w.cancel()
+}
+
+// err replaces the error of a request cancelled by the watch with ErrTooSlow
+func (w *lowSpeedWatch) err(err error) error {
+	if err != nil && w.tooSlow.Load() {
+		return fmt.Errorf("%w: less than %d bytes/s during %s", ErrTooSlow, w.limit, w.window)
+	}
+	return err
+}
+
+// wrap counts the bytes read from body. Closing the body of the response ends the watch.
+func (w *lowSpeedWatch) wrap(body io.ReadCloser, response bool) io.ReadCloser {
+	return &watchedBody{body: body, watch: w, response: response}
+}
+
+type watchedBody struct {
+	body     io.ReadCloser
+	watch    *lowSpeedWatch
+	response bool
+}
+
+func (b *watchedBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	b.watch.transferred.Add(int64(n))
+	if err == io.EOF {
+		return n, err
+	}
+	return n, b.watch.err(err)
+}
+
+func (b *watchedBody) Close() error {
+	if b.response {
+		b.watch.stop()
+	}
+	return b.body.Close()
+}
@@ -0,0 +1,104 @@
+package githttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+)
+
+var (
+	PACK_SIGNATURE = []byte("PACK")
+)
+
+// PackReply is the response of the server to a pack request. The shallow update and
+// acknowledgement lines are decoded up front and reading the reply yields the pack itself.
+type PackReply struct {
+	acks      []common.Checksum
+	shallow   []common.Checksum
+	unshallow []common.Checksum
+	pack      *bufio.Reader
+	closer    io.Closer
+}
+
+// DecodePackReply reads the acknowledgements that precede the pack. If r is
+// an [io.Closer] it is closed when the reply is closed.
+func DecodePackReply(r io.Reader) (*PackReply, error) {
+	reply := &PackReply{
+		pack: bufio.NewReader(r),
+	}
+	if closer, ok := r.(io.Closer); ok {
+		reply.closer = closer
+	}
+	for {
+		signature, err := reply.pack.Peek(len(PACK_SIGNATURE))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read pack reply: %w", err)
+		}
+		if bytes.Equal(signature, PACK_SIGNATURE) {
+			return reply, nil
+		}
+		line, flush, err := readPktLine(reply.pack)
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			continue
+		}
+		command, value, _ := bytes.Cut(line, SP)
+		switch string(command) {
+		case "NAK":
+		case "ACK":
+			hexId, _, _ := bytes.Cut(value, SP)
+			id, err := decodeHexId(hexId)
+			if err != nil {
+				return nil, err
+			}
+			reply.acks = append(reply.acks, id)
+		case "shallow", "unshallow":
+			id, err := decodeHexId(value)
+			if err != nil {
+				return nil, err
+			}
+			if string(command) == "shallow" {
+				reply.shallow = append(reply.shallow, id)
+			} else {
+				reply.unshallow = append(reply.unshallow, id)
+			}
+		case "ERR":
+			return nil, fmt.Errorf("remote error: %s", value)
+		default:
+			return nil, errors.New("unexpected line in pack reply: " + string(line))
+		}
+	}
+}
+
+// Acks returns the objects the server acknowledged as common
+func (reply *PackReply) Acks() []common.Checksum {
+	return reply.acks
+}
+
+// Shallows returns the commits that are new shallow commits of the client
+func (reply *PackReply) Shallows() []common.Checksum {
+	return reply.shallow
+}
+
+// Unshallows returns the shallow commits of the client whose parents are sent
+func (reply *PackReply) Unshallows() []common.Checksum {
+	return reply.unshallow
+}
+
+// Read reads the pack data
+func (reply *PackReply) Read(p []byte) (int, error) {
+	return reply.pack.Read(p)
+}
+
+func (reply *PackReply) Close() error {
+	if reply.closer == nil {
+		return nil
+	}
+	return reply.closer.Close()
+}
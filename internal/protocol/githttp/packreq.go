@@ -1,20 +1,41 @@
 package githttp
 
 import (
+	"bytes"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
 
 	common "github.com/codecrafters-io/git-starter-go/internal"
 )
 
+const (
+	// INFINITE_DEPTH is the depth requested to fetch the complete history of a shallow repository
+	INFINITE_DEPTH = 0x7fffffff
+)
+
 type PackReq struct {
 	want map[string]bool
-	done bool
+	have map[string]bool
+	// shallow are the shallow commits of the client
+	shallow     map[string]bool
+	depth       int
+	deepenSince int64
+	deepenNot   []string
+	// filter is the spec of the objects left out of the pack
+	filter string
+	caps   CapList
+	done   bool
 }
 
 func NewPackReq() *PackReq {
 	return &PackReq{
-		want: map[string]bool{},
+		want:    map[string]bool{},
+		have:    map[string]bool{},
+		shallow: map[string]bool{},
 	}
 }
 
@@ -31,20 +52,110 @@ func (pr *PackReq) IsInWant(id common.Checksum) bool {
 	return ok
 }
 
+func (pr *PackReq) AddHave(id common.Checksum) {
+	pr.have[fmt.Sprintf("%x", id)] = true
+}
+
+// AddShallow tells the server that the parents of id are missing on the client
+func (pr *PackReq) AddShallow(id common.Checksum) {
+	pr.shallow[fmt.Sprintf("%x", id)] = true
+}
+
+// Shallows returns the shallow commits of the client sorted by their hex representation
+func (pr *PackReq) Shallows() []common.Checksum {
+	return sortedIds(pr.shallow)
+}
+
+// SetDepth limits the history sent to depth commits from the wants
+func (pr *PackReq) SetDepth(depth int) {
+	pr.depth = depth
+}
+
+func (pr *PackReq) Depth() int {
+	return pr.depth
+}
+
+// SetDeepenSince limits the history sent to the commits committed at or after the unix time since
+func (pr *PackReq) SetDeepenSince(since int64) {
+	pr.deepenSince = since
+}
+
+func (pr *PackReq) DeepenSince() int64 {
+	return pr.deepenSince
+}
+
+// AddDeepenNot excludes the history reachable from ref
+func (pr *PackReq) AddDeepenNot(ref string) {
+	pr.deepenNot = append(pr.deepenNot, ref)
+}
+
+func (pr *PackReq) DeepenNots() []string {
+	return pr.deepenNot
+}
+
+// SetFilter asks for a pack leaving out the objects matching the filter spec
+func (pr *PackReq) SetFilter(spec string) {
+	pr.filter = spec
+}
+
+func (pr *PackReq) Filter() string {
+	return pr.filter
+}
+
+// IsDeepen reports whether the request changes the shallow boundary of the client
+func (pr *PackReq) IsDeepen() bool {
+	return pr.depth > 0 || pr.deepenSince > 0 || len(pr.deepenNot) > 0
+}
+
+// Wants returns the wanted object ids sorted by their hex representation
+func (pr *PackReq) Wants() []common.Checksum {
+	return sortedIds(pr.want)
+}
+
+// Haves returns the ids the client already has sorted by their hex representation
+func (pr *PackReq) Haves() []common.Checksum {
+	return sortedIds(pr.have)
+}
+
+// SetCaps sets the capabilities sent along with the first want line
+func (pr *PackReq) SetCaps(caps CapList) {
+	pr.caps = caps
+}
+
+func (pr *PackReq) Caps() CapList {
+	return pr.caps
+}
+
 func (pr *PackReq) Done() {
 	pr.done = true
 }
 
+func (pr *PackReq) IsDone() bool {
+	return pr.done
+}
+
 func (pr *PackReq) Encode(w io.Writer) error {
 	encoder := NewPktLineEncoder(w)
-	for id, _ := range pr.want {
-		if _, err := encoder.WriteLineString(fmt.Sprintf("want %s", id)); err != nil {
+	for i, id := range pr.Wants() {
+		line := fmt.Sprintf("want %x", id)
+		if i == 0 && pr.caps != "" {
+			line = fmt.Sprintf("%s %s", line, pr.caps)
+		}
+		if _, err := encoder.WriteLineString(line); err != nil {
 			return err
 		}
 	}
+	if err := pr.encodeShallow(encoder); err != nil {
+		return err
+	}
 	if err := encoder.WriteFlush(); err != nil {
 		return err
 	}
+	for _, id := range pr.Haves() {
+		if _, err := encoder.WriteLineString(fmt.Sprintf("have %x", id)); err != nil {
+			return err
+		}
+	}
 	if pr.done {
 		if _, err := encoder.WriteLineString("done"); err != nil {
 			return err
@@ -53,6 +164,112 @@ func (pr *PackReq) Encode(w io.Writer) error {
 	return nil
 }
 
+// encodeShallow writes the shallow commits of the client, the deepen lines and the filter, which follow the wants
+func (pr *PackReq) encodeShallow(encoder *PktLineEncoder) error {
+	lines := []string{}
+	for _, id := range pr.Shallows() {
+		lines = append(lines, fmt.Sprintf("shallow %x", id))
+	}
+	if pr.depth > 0 {
+		lines = append(lines, fmt.Sprintf("deepen %d", pr.depth))
+	}
+	if pr.deepenSince > 0 {
+		lines = append(lines, fmt.Sprintf("deepen-since %d", pr.deepenSince))
+	}
+	for _, ref := range pr.deepenNot {
+		lines = append(lines, fmt.Sprintf("deepen-not %s", ref))
+	}
+	if pr.filter != "" {
+		lines = append(lines, fmt.Sprintf("filter %s", pr.filter))
+	}
+	for _, line := range lines {
+		if _, err := encoder.WriteLineString(line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecodePackReqWants decodes the want section of a request up to and including the flush-pkt.
+// An empty request, which is a client disconnecting after the ref advertisement, is reported with a nil request.
+func DecodePackReqWants(decoder *PktLineDecoder) (*PackReq, error) {
+	pr := NewPackReq()
+	for first := true; ; first = false {
+		line, flush, err := decoder.ReadPktLine()
+		if err != nil {
+			if err == io.EOF && first {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if flush {
+			if first {
+				return nil, nil
+			}
+			return pr, nil
+		}
+		command, value, _ := bytes.Cut(line, SP)
+		switch string(command) {
+		case "want":
+		case "shallow":
+			id, err := decodeHexId(value)
+			if err != nil {
+				return nil, err
+			}
+			pr.AddShallow(id)
+			continue
+		case "deepen", "deepen-since":
+			n, err := strconv.ParseInt(string(value), 10, 64)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s: %s", command, value)
+			}
+			if string(command) == "deepen" {
+				pr.depth = int(min(n, INFINITE_DEPTH))
+			} else {
+				pr.deepenSince = n
+			}
+			continue
+		case "deepen-not":
+			pr.AddDeepenNot(string(value))
+			continue
+		case "filter":
+			pr.SetFilter(string(value))
+			continue
+		default:
+			return nil, fmt.Errorf("unexpected line in want list: %s", line)
+		}
+		hexId, caps, _ := bytes.Cut(value, SP)
+		id, err := decodeHexId(hexId)
+		if err != nil {
+			return nil, err
+		}
+		if first {
+			pr.caps = CapList(caps)
+		}
+		pr.AddWant(id)
+	}
+}
+
+func decodeHexId(hexId []byte) (common.Checksum, error) {
+	if len(hexId) != BYTE_ID_LEN {
+		return nil, errors.New("invalid id length")
+	}
+	return hex.DecodeString(string(hexId))
+}
+
+func sortedIds(set map[string]bool) []common.Checksum {
+	hexIds := make([]string, 0, len(set))
+	for hexId := range set {
+		hexIds = append(hexIds, hexId)
+	}
+	sort.Strings(hexIds)
+	ids := make([]common.Checksum, len(hexIds))
+	for i, hexId := range hexIds {
+		ids[i], _ = hex.DecodeString(hexId)
+	}
+	return ids
+}
+
 func BuildPacReqFromRefDisc(refDisc *RefDiscReply) *PackReq {
 	packReq := NewPackReq()
 	for _, id := range refDisc.Refs() {
@@ -7,11 +7,16 @@ import (
 	"io"
 	"strconv"
 
-	"github.com/codecrafters-io/git-starter-go/internal/util"
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
 )
 
 const (
 	MAX_LINE_DATA = 65516
+	PKT_LEN_SIZE  = 4
+)
+
+var (
+	ErrInvalidPktLen = errors.New("invalid pkt-len")
 )
 
 // Parser for pkt-line formatted data
@@ -36,33 +41,42 @@ func (p *PktLineDecoder) Read(b []byte) (int, error) {
 	return p.src.Read(b)
 }
 
-func (p *PktLineDecoder) ReadPktLine() (PktLine, bool, error) {
-	line, err := util.ReadBefore(p.src, []byte("\n"))
-	if err != nil && err != io.EOF {
-		return nil, false, fmt.Errorf("failed to read line: %w", err)
-	}
-	if bytes.Equal(line, FLUSH_PKT) {
-		return nil, true, nil
-	}
-	_, err = strconv.ParseInt(string(line[:4]), 16, 64)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to parse pkt-len: %w", err)
-	}
-	data := line[4:]
-	return data, false, nil
+// ReadPktLine reads the next pkt-line. The trailing LF of the line, if any, is stripped.
+// flush is true when a flush-pkt is read, in which case line is nil.
+func (p *PktLineDecoder) ReadPktLine() (line PktLine, flush bool, err error) {
+	return readPktLine(p.src)
 }
 
 func ReadSktLine(r io.Reader) (PktLine, error) {
-	line, err := util.ReadBefore(r, []byte("\n"))
-	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("failed to read line: %w", err)
+	line, _, err := readPktLine(r)
+	return line, err
+}
+
+func readPktLine(r io.Reader) (PktLine, bool, error) {
+	pktLen := make([]byte, PKT_LEN_SIZE)
+	if _, err := io.ReadFull(r, pktLen); err != nil {
+		if err == io.EOF {
+			return nil, false, err
+		}
+		return nil, false, fmt.Errorf("failed to read pkt-len: %w", err)
 	}
-	_, err = strconv.ParseInt(string(line[:4]), 16, 64)
+	length, err := strconv.ParseUint(string(pktLen), 16, 16)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse pkt-len: %w", err)
+		return nil, false, fmt.Errorf("failed to parse pkt-len: %w", err)
+	}
+	if length == 0 {
+		trace.PacketLine(false, nil, true)
+		return nil, true, nil
+	}
+	if length < PKT_LEN_SIZE {
+		return nil, false, ErrInvalidPktLen
 	}
-	data := line[4:]
-	return data, nil
+	data := make([]byte, length-PKT_LEN_SIZE)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, false, fmt.Errorf("failed to read line: %w", err)
+	}
+	trace.PacketLine(false, data, false)
+	return bytes.TrimSuffix(data, []byte("\n")), false, nil
 }
 
 type PktLineEncoder struct {
@@ -75,14 +89,28 @@ func NewPktLineEncoder(dest io.Writer) *PktLineEncoder {
 	}
 }
 
+// WriteLine writes p as a pkt-line terminated by LF.
 func (e *PktLineEncoder) WriteLine(p []byte) (int, error) {
+	return e.writePkt(append(p[:len(p):len(p)], '\n'))
+}
+
+func (e *PktLineEncoder) WriteLineString(s string) (int, error) {
+	return e.WriteLine([]byte(s))
+}
+
+// WriteData writes p as a single pkt-line as is, without appending LF.
+func (e *PktLineEncoder) WriteData(p []byte) (int, error) {
+	return e.writePkt(p)
+}
+
+func (e *PktLineEncoder) writePkt(p []byte) (int, error) {
 	if len(p) > MAX_LINE_DATA {
 		return 0, errors.New("data exceeds max line data")
 	}
 
-	pktLen := fmt.Sprintf("%04x", len(p)+5)
+	trace.PacketLine(true, p, false)
+	pktLen := fmt.Sprintf("%04x", len(p)+PKT_LEN_SIZE)
 	line := append([]byte(pktLen), p...)
-	line = append(line, '\n')
 
 	n, err := e.dest.Write(line)
 	if err != nil {
@@ -92,11 +120,8 @@ func (e *PktLineEncoder) WriteLine(p []byte) (int, error) {
 	return n, nil
 }
 
-func (e *PktLineEncoder) WriteLineString(s string) (int, error) {
-	return e.WriteLine([]byte(s))
-}
-
 func (e *PktLineEncoder) WriteFlush() error {
+	trace.PacketLine(true, nil, true)
 	_, err := e.dest.Write(FLUSH_PKT)
 	return err
 }
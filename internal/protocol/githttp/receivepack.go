@@ -0,0 +1,287 @@
+package githttp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	RECEIVE_PACK_SERVICE = "git-receive-pack"
+)
+
+var (
+	// Clients may send thin packs, whose missing bases are appended from the store
+	receivePackCaps = []string{"report-status", "delete-refs", "ofs-delta", "agent=" + AGENT}
+)
+
+// RefUpdate is a command sent by a pushing client to move a ref from Old to New
+type RefUpdate struct {
+	Name string
+	Old  common.Checksum
+	New  common.Checksum
+}
+
+func (u *RefUpdate) IsDelete() bool {
+	return isZeroId(u.New)
+}
+
+func (u *RefUpdate) IsCreate() bool {
+	return isZeroId(u.Old)
+}
+
+// ReceivePackReq is the list of ref updates sent by a pushing client
+type ReceivePackReq struct {
+	Updates []*RefUpdate
+	Caps    CapList
+}
+
+// HasPack reports whether a pack follows the commands. A pack is only sent if at least one ref is not deleted.
+func (req *ReceivePackReq) HasPack() bool {
+	for _, update := range req.Updates {
+		if !update.IsDelete() {
+			return true
+		}
+	}
+	return false
+}
+
+// Encode writes the command list terminated by a flush-pkt. The capabilities are sent with the first command.
+func (req *ReceivePackReq) Encode(w io.Writer) error {
+	encoder := NewPktLineEncoder(w)
+	for i, update := range req.Updates {
+		line := []byte(fmt.Sprintf("%x %x %s", zeroIdIfEmpty(update.Old), zeroIdIfEmpty(update.New), update.Name))
+		if i == 0 {
+			line = append(append(line, NUL...), req.Caps...)
+		}
+		if _, err := encoder.WriteLine(line); err != nil {
+			return err
+		}
+	}
+	return encoder.WriteFlush()
+}
+
+// DecodeReceivePackReq decodes the command list up to and including the flush-pkt
+func DecodeReceivePackReq(decoder *PktLineDecoder) (*ReceivePackReq, error) {
+	req := &ReceivePackReq{}
+	for {
+		line, flush, err := decoder.ReadPktLine()
+		if err != nil {
+			if err == io.EOF && len(req.Updates) == 0 {
+				return req, nil
+			}
+			return nil, err
+		}
+		if flush {
+			return req, nil
+		}
+		command, caps, found := bytes.Cut(line, NUL)
+		if found {
+			req.Caps = CapList(caps)
+		}
+		fields := bytes.Fields(command)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid command: %s", command)
+		}
+		old, err := decodeHexId(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		new, err := decodeHexId(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		req.Updates = append(req.Updates, &RefUpdate{Name: string(fields[2]), Old: old, New: new})
+	}
+}
+
+// ReceivePackSession stores the objects pushed by a client and updates its refs
+type ReceivePackSession struct {
+	store   store.Store
+	manager *pack.DefaulPackManager
+}
+
+func NewReceivePackSession(st store.Store) *ReceivePackSession {
+	return &ReceivePackSession{
+		store:   st,
+		manager: pack.New(st),
+	}
+}
+
+// RefDiscReply lists the refs of the repository. HEAD is not advertised to pushing clients.
+func (s *ReceivePackSession) RefDiscReply() (*RefDiscReply, error) {
+	return buildRefDiscReply(s.store, s.manager, false, receivePackCaps)
+}
+
+// AdvertiseRefs writes the ref advertisement of the repository
+func (s *ReceivePackSession) AdvertiseRefs(w io.Writer) error {
+	reply, err := s.RefDiscReply()
+	if err != nil {
+		return err
+	}
+	return reply.Encode(w)
+}
+
+// ReceivePack reads the commands and the pack from r, then applies the ref updates.
+// The status of each update is reported to w if the client asked for report-status.
+func (s *ReceivePackSession) ReceivePack(r io.Reader, w io.Writer) error {
+	req, err := DecodeReceivePackReq(NewPktLineParser(r))
+	if err != nil {
+		return err
+	}
+	if len(req.Updates) == 0 {
+		return nil
+	}
+
+	var unpackErr error
+	var kept []byte
+	if req.HasPack() {
+		// The pack is read up to its trailer, as a client may keep its side open for the
+		// reply. It is kept until the refs point to its objects, so that a concurrent
+		// repack does not remove it as unreachable.
+		options := pack.IndexOptions{Bases: s.manager, Keep: fmt.Sprintf("receive-pack %d", os.Getpid())}
+		kept, unpackErr = s.manager.Index(bufio.NewReader(r), options)
+	}
+
+	report := &ReportStatus{}
+	if unpackErr != nil {
+		report.UnpackErr = unpackErr.Error()
+	}
+	for _, update := range req.Updates {
+		status := RefStatus{Name: update.Name}
+		if unpackErr != nil {
+			status.Err = "unpacker error"
+		} else if err := s.updateRef(update); err != nil {
+			status.Err = err.Error()
+		}
+		report.Refs = append(report.Refs, status)
+	}
+	if kept != nil {
+		if err := s.store.RemovePackKeep(hash.ChecksumToHex(kept)); err != nil {
+			return err
+		}
+	}
+
+	if !req.Caps.Has("report-status") {
+		return nil
+	}
+	return report.Encode(w)
+}
+
+func (s *ReceivePackSession) updateRef(update *RefUpdate) error {
+	if update.IsDelete() {
+		if err := s.store.DeleteRef(update.Name, update.Old); err != nil {
+			return err
+		}
+		return nil
+	}
+	ok, err := s.manager.ObjectExist(update.New)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("missing necessary objects")
+	}
+	old := update.Old
+	if update.IsCreate() {
+		old = []byte{}
+	}
+	return s.store.WriteRef(update.Name, update.New, old)
+}
+
+// RefStatus is the result of a single ref update. Err is empty if the update succeeded.
+type RefStatus struct {
+	Name string
+	Err  string
+}
+
+// ReportStatus is the result of a push as reported by the server
+type ReportStatus struct {
+	// UnpackErr is empty if the pack was stored successfully
+	UnpackErr string
+	Refs      []RefStatus
+}
+
+// Err summarises the failures of the push, if any
+func (report *ReportStatus) Err() error {
+	if report.UnpackErr != "" {
+		return fmt.Errorf("unpack failed: %s", report.UnpackErr)
+	}
+	for _, status := range report.Refs {
+		if status.Err != "" {
+			return fmt.Errorf("failed to update %s: %s", status.Name, status.Err)
+		}
+	}
+	return nil
+}
+
+func (report *ReportStatus) Encode(w io.Writer) error {
+	encoder := NewPktLineEncoder(w)
+	unpackStatus := "unpack ok"
+	if report.UnpackErr != "" {
+		unpackStatus = fmt.Sprintf("unpack %s", report.UnpackErr)
+	}
+	if _, err := encoder.WriteLineString(unpackStatus); err != nil {
+		return err
+	}
+	for _, status := range report.Refs {
+		line := fmt.Sprintf("ok %s", status.Name)
+		if status.Err != "" {
+			line = fmt.Sprintf("ng %s %s", status.Name, status.Err)
+		}
+		if _, err := encoder.WriteLineString(line); err != nil {
+			return err
+		}
+	}
+	return encoder.WriteFlush()
+}
+
+func DecodeReportStatus(decoder *PktLineDecoder) (*ReportStatus, error) {
+	report := &ReportStatus{}
+	line, _, err := decoder.ReadPktLine()
+	if err != nil {
+		return nil, err
+	}
+	unpackStatus, found := bytes.CutPrefix(line, []byte("unpack "))
+	if !found {
+		return nil, fmt.Errorf("unexpected unpack status: %s", line)
+	}
+	if string(unpackStatus) != "ok" {
+		report.UnpackErr = string(unpackStatus)
+	}
+	for {
+		line, flush, err := decoder.ReadPktLine()
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return report, nil
+		}
+		result, rest, _ := bytes.Cut(line, SP)
+		name, reason, _ := bytes.Cut(rest, SP)
+		status := RefStatus{Name: string(name)}
+		switch string(result) {
+		case "ok":
+		case "ng":
+			status.Err = string(reason)
+		default:
+			return nil, fmt.Errorf("unexpected ref status: %s", line)
+		}
+		report.Refs = append(report.Refs, status)
+	}
+}
+
+func zeroIdIfEmpty(id common.Checksum) common.Checksum {
+	if len(id) == 0 {
+		return make([]byte, 20)
+	}
+	return id
+}
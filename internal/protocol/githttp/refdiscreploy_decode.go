@@ -10,6 +10,10 @@ import (
 	common "github.com/codecrafters-io/git-starter-go/internal"
 )
 
+var (
+	ErrRemote = errors.New("remote error")
+)
+
 type RefDiscReployDecoder struct {
 	parser  *PktLineDecoder
 	decoded *RefDiscReply
@@ -29,23 +33,22 @@ func (d *RefDiscReployDecoder) Decode() (*RefDiscReply, error) {
 	} else if flush {
 		return nil, io.EOF
 	}
-	if string(serviceSig) != "# service=git-upload-pack" {
-		return nil, err
+	if !bytes.HasPrefix(serviceSig, []byte("# service=")) {
+		return nil, fmt.Errorf("unexpected service line: %s", serviceSig)
 	}
 
 	// Skip flush flush-pkt
-	flushPkt := make([]byte, len(FLUSH_PKT))
-	if _, err := d.parser.Read(flushPkt); err != nil {
+	if _, flush, err := d.parser.ReadPktLine(); err != nil {
 		return nil, err
-	}
-	if !isFlushPkt(flushPkt) {
-		if _, flush, err := d.parser.ReadPktLine(); err != nil {
-			return nil, err
-		} else if !flush {
-			return nil, errors.New("expected flush-pkt")
-		}
+	} else if !flush {
 		return nil, errors.New("expected flush-pkt")
 	}
+	return d.DecodeRefs()
+}
+
+// DecodeRefs decodes a ref advertisement that is not preceded by a service line,
+// as sent by upload-pack and receive-pack over stdio
+func (d *RefDiscReployDecoder) DecodeRefs() (*RefDiscReply, error) {
 	emptyList, err := d.decodeRefListHeader()
 	if err != nil {
 		return nil, err
@@ -68,6 +71,10 @@ func (d *RefDiscReployDecoder) decodeRefListHeader() (empty bool, err error) {
 	if flush {
 		return true, nil
 	}
+	// A server refusing the request, such as a daemon not exporting the repository, explains why
+	if message, found := bytes.CutPrefix(line, []byte("ERR ")); found {
+		return empty, fmt.Errorf("%w: %s", ErrRemote, message)
+	}
 	encodedRef, encodedCap, found := bytes.Cut(line, NUL)
 	if !found {
 		return empty, errors.New("expected SP between object id and name")
@@ -77,11 +84,18 @@ func (d *RefDiscReployDecoder) decodeRefListHeader() (empty bool, err error) {
 		return empty, err
 	}
 	d.decoded.caps = capList
-	_, id, _, err := decodeRef(encodedRef)
+	name, id, _, err := decodeRef(encodedRef)
 	if err != nil {
 		return empty, err
 	}
-	d.decoded.setHead(id)
+	switch name {
+	case "HEAD":
+		d.decoded.setHead(id)
+	case CAPABILITIES_REF:
+		// An empty repository only advertises its capabilities
+	default:
+		d.decoded.addRef(name, id)
+	}
 	return empty, nil
 }
 
@@ -121,9 +135,12 @@ func decodeRef(encodedRef []byte) (string, common.Checksum, bool, error) {
 		return "", nil, false, err
 	}
 
+	if string(name) == CAPABILITIES_REF {
+		return string(name), id, false, nil
+	}
 	peeled := bytes.HasSuffix(name, []byte("^{}"))
 	if peeled {
-		name = bytes.TrimRight(name, "^{}")
+		name = bytes.TrimSuffix(name, []byte("^{}"))
 	}
 	return string(name), id, peeled, nil
 }
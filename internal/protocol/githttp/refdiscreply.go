@@ -1,6 +1,10 @@
 package githttp
 
-import common "github.com/codecrafters-io/git-starter-go/internal"
+import (
+	"strings"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+)
 
 type RefList map[string]common.Checksum
 
@@ -10,11 +14,44 @@ func NewRefList() RefList {
 
 type CapList string
 
+// Has reports whether the capability name is present, either on its own or with a value
+func (c CapList) Has(name string) bool {
+	_, ok := c.Value(name)
+	return ok
+}
+
+// Value returns the value of a capability of the form name=value
+func (c CapList) Value(name string) (string, bool) {
+	for _, capability := range strings.Fields(string(c)) {
+		key, value, _ := strings.Cut(capability, "=")
+		if key == name {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Values returns every value of a capability that may be repeated, such as symref
+func (c CapList) Values(name string) []string {
+	values := []string{}
+	for _, capability := range strings.Fields(string(c)) {
+		key, value, found := strings.Cut(capability, "=")
+		if key == name && found {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func NewCapList(caps ...string) CapList {
+	return CapList(strings.Join(caps, " "))
+}
+
 type RefDiscReply struct {
 	head      common.Checksum
 	refs      RefList
 	peeledRef RefList
-	caps      CapList // TODO parse row cap list
+	caps      CapList
 }
 
 func NewRefDiscReply() *RefDiscReply {
@@ -40,6 +77,25 @@ func (r *RefDiscReply) Refs() RefList {
 	return r.refs
 }
 
+// PeeledRefs returns the objects that annotated tags point to, keyed by the tag ref name
+func (r *RefDiscReply) PeeledRefs() RefList {
+	return r.peeledRef
+}
+
 func (r *RefDiscReply) Head() common.Checksum {
 	return r.head
 }
+
+func (r *RefDiscReply) Caps() CapList {
+	return r.caps
+}
+
+// HeadTarget returns the ref HEAD points to as advertised by the symref capability
+func (r *RefDiscReply) HeadTarget() (string, bool) {
+	for _, symref := range r.caps.Values("symref") {
+		if source, target, found := strings.Cut(symref, ":"); found && source == "HEAD" {
+			return target, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,54 @@
+package githttp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	CAPABILITIES_REF = "capabilities^{}"
+)
+
+// Encode writes the ref list of the reply in pkt-line format, HEAD first, followed by the refs sorted by name.
+// Each annotated tag is followed by its peeled value. The ref list is terminated by a flush-pkt.
+func (r *RefDiscReply) Encode(w io.Writer) error {
+	encoder := NewPktLineEncoder(w)
+	names := make([]string, 0, len(r.refs))
+	for name := range r.refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	caps := []byte(r.caps)
+	writeRef := func(name string, id []byte) error {
+		line := []byte(fmt.Sprintf("%x %s", id, name))
+		if caps != nil {
+			line = append(append(line, NUL...), caps...)
+			caps = nil
+		}
+		_, err := encoder.WriteLine(line)
+		return err
+	}
+
+	if r.head != nil {
+		if err := writeRef("HEAD", r.head); err != nil {
+			return err
+		}
+	} else if len(names) == 0 {
+		if err := writeRef(CAPABILITIES_REF, make([]byte, 20)); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := writeRef(name, r.refs[name]); err != nil {
+			return err
+		}
+		if peeled, ok := r.peeledRef[name]; ok {
+			if err := writeRef(name+"^{}", peeled); err != nil {
+				return err
+			}
+		}
+	}
+	return encoder.WriteFlush()
+}
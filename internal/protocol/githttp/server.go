@@ -0,0 +1,165 @@
+package githttp
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+var (
+	ErrRepoNotFound = errors.New("repository not found")
+)
+
+// RepoResolver maps the repository path of a request to the store serving it
+type RepoResolver func(repoPath string) (store.Store, error)
+
+// NewFSResolver resolves repositories on disk below baseDir. A request for repo
+// is served from baseDir/repo, falling back to baseDir/repo.git.
+func NewFSResolver(baseDir string) RepoResolver {
+	return func(repoPath string) (store.Store, error) {
+		// Cleaning an absolute path removes any ".." that would escape baseDir
+		repoPath = path.Clean("/" + repoPath)
+		for _, candidate := range []string{repoPath, repoPath + ".git"} {
+			st, err := store.Open(path.Join(baseDir, candidate))
+			if err == nil {
+				return st, nil
+			}
+			if err != store.ErrStoreNotExist {
+				return nil, err
+			}
+		}
+		return nil, ErrRepoNotFound
+	}
+}
+
+type ServerOptions struct {
+	// ReceivePack enables pushing to the served repositories
+	ReceivePack bool
+}
+
+// Server serves repositories over the smart HTTP protocol
+type Server struct {
+	resolver RepoResolver
+	options  ServerOptions
+}
+
+func NewServer(resolver RepoResolver, options ServerOptions) *Server {
+	return &Server{
+		resolver: resolver,
+		options:  options,
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/info/refs"):
+		s.serveInfoRefs(w, r, strings.TrimSuffix(r.URL.Path, "/info/refs"))
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+UPLOAD_PACK_SERVICE):
+		s.serveRPC(w, r, strings.TrimSuffix(r.URL.Path, "/"+UPLOAD_PACK_SERVICE), UPLOAD_PACK_SERVICE)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/"+RECEIVE_PACK_SERVICE):
+		s.serveRPC(w, r, strings.TrimSuffix(r.URL.Path, "/"+RECEIVE_PACK_SERVICE), RECEIVE_PACK_SERVICE)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveInfoRefs(w http.ResponseWriter, r *http.Request, repoPath string) {
+	service := r.URL.Query().Get("service")
+	if !s.serviceEnabled(service) {
+		http.Error(w, "service not enabled", http.StatusForbidden)
+		return
+	}
+	st, ok := s.resolve(w, r, repoPath)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-advertisement", service))
+	w.Header().Set("Cache-Control", "no-cache")
+	encoder := NewPktLineEncoder(w)
+	if _, err := encoder.WriteLineString(fmt.Sprintf("# service=%s", service)); err != nil {
+		return
+	}
+	if err := encoder.WriteFlush(); err != nil {
+		return
+	}
+
+	var err error
+	if service == UPLOAD_PACK_SERVICE {
+		err = NewUploadPackSession(st, true).AdvertiseRefs(w)
+	} else {
+		err = NewReceivePackSession(st).AdvertiseRefs(w)
+	}
+	if err != nil {
+		log.Printf("failed to advertise refs of %s: %v", repoPath, err)
+	}
+}
+
+func (s *Server) serveRPC(w http.ResponseWriter, r *http.Request, repoPath string, service string) {
+	if !s.serviceEnabled(service) {
+		http.Error(w, "service not enabled", http.StatusForbidden)
+		return
+	}
+	if r.Header.Get("Content-Type") != fmt.Sprintf("application/x-%s-request", service) {
+		http.Error(w, "invalid content type", http.StatusUnsupportedMediaType)
+		return
+	}
+	st, ok := s.resolve(w, r, repoPath)
+	if !ok {
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	w.Header().Set("Content-Type", fmt.Sprintf("application/x-%s-result", service))
+	w.Header().Set("Cache-Control", "no-cache")
+	var err error
+	if service == UPLOAD_PACK_SERVICE {
+		err = NewUploadPackSession(st, true).UploadPack(body, w)
+	} else {
+		err = NewReceivePackSession(st).ReceivePack(body, w)
+	}
+	if err != nil {
+		log.Printf("%s on %s failed: %v", service, repoPath, err)
+	}
+}
+
+func (s *Server) serviceEnabled(service string) bool {
+	switch service {
+	case UPLOAD_PACK_SERVICE:
+		return true
+	case RECEIVE_PACK_SERVICE:
+		return s.options.ReceivePack
+	default:
+		return false
+	}
+}
+
+func (s *Server) resolve(w http.ResponseWriter, r *http.Request, repoPath string) (store.Store, bool) {
+	st, err := s.resolver(repoPath)
+	if err != nil {
+		if errors.Is(err, ErrRepoNotFound) {
+			http.NotFound(w, r)
+		} else {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	return st, true
+}
@@ -0,0 +1,184 @@
+package githttp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+func TestServerUploadPack(t *testing.T) {
+	baseDir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, baseDir+"/repo.git")
+	server := httptest.NewServer(NewServer(NewFSResolver(baseDir), ServerOptions{}))
+	defer server.Close()
+
+	client := NewGitHttpClient()
+	reply, err := client.GetRefs(context.Background(), server.URL+"/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reply.Head(), commit) {
+		t.Fatalf("expected HEAD to be %x but got %x", commit, reply.Head())
+	}
+	if !bytes.Equal(reply.Refs()["refs/heads/main"], commit) {
+		t.Fatalf("expected refs/heads/main to be %x but got %x", commit, reply.Refs()["refs/heads/main"])
+	}
+	if target, ok := reply.HeadTarget(); !ok || target != "refs/heads/main" {
+		t.Fatalf("expected HEAD to point to refs/heads/main but got %q", target)
+	}
+
+	resBody, err := client.FetchPack(context.Background(), BuildPacReqFromRefDisc(reply), server.URL+"/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resBody.Close()
+	line, err := ReadSktLine(resBody)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(line) != "NAK" {
+		t.Fatalf("expected NAK but got %q", line)
+	}
+
+	clone, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(clone)
+	if _, err := manager.From(resBody); err != nil {
+		t.Fatal(err)
+	}
+	encodedCommit, err := manager.Object(commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encodedCommit.Type() != common.OBJ_COMMIT {
+		t.Fatalf("expected a commit but got %s", encodedCommit.Type())
+	}
+}
+
+func TestServerReceivePack(t *testing.T) {
+	baseDir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, baseDir+"/repo.git")
+	remote, err := store.InitBare(baseDir + "/remote.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(NewFSResolver(baseDir), ServerOptions{ReceivePack: true}))
+	defer server.Close()
+
+	// Push the commit of repo to remote
+	source, err := store.Open(baseDir + "/repo.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	encoder := NewPktLineEncoder(&body)
+	encoder.WriteLine(append([]byte(fmt.Sprintf("%x %x refs/heads/main", make([]byte, 20), commit)), append(NUL, "report-status"...)...))
+	encoder.WriteFlush()
+	if err := WritePack(pack.New(source), &body, [][]byte{commit}, nil, revlist.Options{}, pack.DefaultWriteOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.Post(server.URL+"/remote/git-receive-pack", "application/x-git-receive-pack-request", &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	decoder := NewPktLineParser(res.Body)
+	for _, expected := range []string{"unpack ok", "ok refs/heads/main"} {
+		line, _, err := decoder.ReadPktLine()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(line) != expected {
+			t.Fatalf("expected %q but got %q", expected, line)
+		}
+	}
+
+	ref, err := remote.ResolveRef("HEAD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ref.Checksum, commit) {
+		t.Fatalf("expected HEAD of remote to be %x but got %x", commit, ref.Checksum)
+	}
+	// The pack is only kept from repack until the ref is updated
+	packs, err := remote.ListPackIndices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(packs) != 1 {
+		t.Fatalf("expected a single pack but got %v", packs)
+	}
+	if kept, err := remote.IsPackKept(packs[0]); err != nil || kept {
+		t.Fatalf("expected the .keep of pack %s to be removed", packs[0])
+	}
+}
+
+func TestServerUploadPackReachableWant(t *testing.T) {
+	baseDir := t.TempDir()
+	st, commit := testing_helper.SetupRepo(t, baseDir+"/repo.git")
+	blob := testing_helper.WriteObject(t, st, common.OBJ_BLOB, []byte("hello world\n"))
+	unreachable := testing_helper.WriteObject(t, st, common.OBJ_BLOB, []byte("dangling\n"))
+	server := httptest.NewServer(NewServer(NewFSResolver(baseDir), ServerOptions{}))
+	defer server.Close()
+
+	client := NewGitHttpClient()
+	reply, err := client.GetRefs(context.Background(), server.URL+"/repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Caps().Has("allow-reachable-sha1-in-want") {
+		t.Fatalf("expected allow-reachable-sha1-in-want to be advertised")
+	}
+	clone, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(clone)
+	fetch := func(want []byte, filter string) error {
+		req := NewPackReq()
+		req.AddWant(want)
+		req.SetFilter(filter)
+		req.SetCaps(NewCapList("filter"))
+		req.Done()
+		resBody, err := client.FetchPack(context.Background(), req, server.URL+"/repo")
+		if err != nil {
+			return err
+		}
+		defer resBody.Close()
+		packReply, err := DecodePackReply(resBody)
+		if err != nil {
+			return err
+		}
+		_, err = manager.From(packReply)
+		return err
+	}
+
+	// The filtered clone leaves the blob out, which is then fetched on its own
+	if err := fetch(commit, "blob:none"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := manager.ObjectExist(blob); err != nil || ok {
+		t.Fatalf("expected blob %x to be left out of the clone", blob)
+	}
+	if err := fetch(blob, ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := manager.ObjectExist(blob); err != nil || !ok {
+		t.Fatalf("expected blob %x to be fetched", blob)
+	}
+	if err := fetch(unreachable, ""); err == nil {
+		t.Fatalf("expected the unreachable blob %x to be refused", unreachable)
+	}
+}
@@ -0,0 +1,418 @@
+package githttp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	AGENT               = "mygit/0.1"
+	UPLOAD_PACK_SERVICE = "git-upload-pack"
+)
+
+var (
+	uploadPackCaps = []string{"ofs-delta", "shallow", "allow-reachable-sha1-in-want", "deepen-since", "deepen-not", "deepen-relative", "filter", "no-progress", "agent=" + AGENT}
+)
+
+// UploadPackSession serves the objects of a repository to a fetching client
+type UploadPackSession struct {
+	store   store.Store
+	manager *pack.DefaulPackManager
+	// In stateless mode, as used over HTTP, every request is complete and no
+	// state is kept between the rounds of negotiation
+	statelessRPC bool
+}
+
+func NewUploadPackSession(st store.Store, statelessRPC bool) *UploadPackSession {
+	return &UploadPackSession{
+		store:        st,
+		manager:      pack.New(st),
+		statelessRPC: statelessRPC,
+	}
+}
+
+// RefDiscReply lists the refs of the repository along with the capabilities of the session
+func (s *UploadPackSession) RefDiscReply() (*RefDiscReply, error) {
+	return buildRefDiscReply(s.store, s.manager, true, uploadPackCaps)
+}
+
+// AdvertiseRefs writes the ref advertisement of the repository
+func (s *UploadPackSession) AdvertiseRefs(w io.Writer) error {
+	reply, err := s.RefDiscReply()
+	if err != nil {
+		return err
+	}
+	return reply.Encode(w)
+}
+
+// UploadPack reads the wants and haves of the client from r, negotiates the common
+// objects and writes a pack with the missing objects to w.
+func (s *UploadPackSession) UploadPack(r io.Reader, w io.Writer) error {
+	decoder := NewPktLineParser(r)
+	encoder := NewPktLineEncoder(w)
+
+	req, err := DecodePackReqWants(decoder)
+	if err != nil {
+		return err
+	}
+	if req == nil {
+		return nil
+	}
+	wants := make([][]byte, 0, len(req.Wants()))
+	for _, want := range req.Wants() {
+		wants = append(wants, want)
+	}
+	if err := s.checkWants(wants); err != nil {
+		return err
+	}
+	var filter *revlist.Filter
+	if req.Filter() != "" {
+		if filter, err = revlist.ParseFilter(req.Filter()); err != nil {
+			return err
+		}
+	}
+	shallow, unshallowed, err := s.updateShallow(req, encoder)
+	if err != nil {
+		return err
+	}
+	// The parents of unshallowed commits are missing on the client even though it has the commits
+	for _, id := range unshallowed {
+		commit, err := revlist.ReadCommit(s.manager, id)
+		if err != nil {
+			return err
+		}
+		wants = append(wants, commit.Parents...)
+	}
+
+	commons, err := s.negotiate(decoder, encoder)
+	if err != nil {
+		return err
+	}
+	if commons == nil {
+		// Stateless client ended a negotiation round without being done
+		return nil
+	}
+	bitmaps, err := s.bitmaps()
+	if err != nil {
+		return err
+	}
+	options := revlist.Options{Shallow: shallow, Filter: filter, Bitmaps: bitmaps}
+	writeOptions := pack.DefaultWriteOptions()
+	writeOptions.OfsDelta = req.Caps().Has("ofs-delta")
+	return WritePack(s.manager, w, wants, commons, options, writeOptions)
+}
+
+// checkWants makes sure that every want is reachable from a ref, as advertised by
+// allow-reachable-sha1-in-want. Wants that are ref tips are accepted without a walk, the
+// walk for the others ends once they are all found.
+func (s *UploadPackSession) checkWants(wants [][]byte) error {
+	refs, err := s.store.ListRefs()
+	if err != nil {
+		return err
+	}
+	head, err := s.store.ResolveRef(store.HEAD)
+	if err != nil && !errors.Is(err, store.ErrRefNotExist) {
+		return err
+	}
+	if head != nil {
+		refs = append(refs, head)
+	}
+	tips := make([][]byte, 0, len(refs))
+	advertised := map[string]bool{}
+	for _, ref := range refs {
+		peeled, err := peelRef(s.manager, ref.Checksum)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, ref.Checksum)
+		advertised[string(ref.Checksum)] = true
+		advertised[string(peeled)] = true
+	}
+	unadvertised := [][]byte{}
+	for _, want := range wants {
+		if !advertised[string(want)] {
+			unadvertised = append(unadvertised, want)
+		}
+	}
+	if len(unadvertised) == 0 {
+		return nil
+	}
+
+	bitmaps, err := s.bitmaps()
+	if err != nil {
+		return err
+	}
+	unreachable, err := revlist.Unreachable(s.manager, tips, unadvertised, revlist.Options{Bitmaps: bitmaps})
+	if err != nil {
+		return err
+	}
+	if len(unreachable) > 0 {
+		return fmt.Errorf("not our ref %x", unreachable[0])
+	}
+	return nil
+}
+
+// bitmaps returns the reachability bitmaps of the repository, which the manager decodes
+// once. Without bitmaps the interface is nil, so that walks read the objects.
+func (s *UploadPackSession) bitmaps() (revlist.Reachability, error) {
+	index, err := s.manager.Bitmaps()
+	if err != nil || index == nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// updateShallow computes the shallow boundary of the client and sends the shallow update,
+// which is only sent to clients asking for a deeper or shallower history. It returns
+// the commits whose parents are not sent and the client shallow commits that are unshallowed.
+func (s *UploadPackSession) updateShallow(req *PackReq, encoder *PktLineEncoder) (shallow [][]byte, unshallowed [][]byte, err error) {
+	shallow, err = s.store.Shallow()
+	if err != nil {
+		return nil, nil, err
+	}
+	clientShallow := make([][]byte, 0, len(req.Shallows()))
+	for _, id := range req.Shallows() {
+		clientShallow = append(clientShallow, id)
+	}
+	if !req.IsDeepen() {
+		return append(shallow, clientShallow...), nil, nil
+	}
+
+	cut, err := s.cutHistory(req, shallow, clientShallow)
+	if err != nil {
+		return nil, nil, err
+	}
+	isClientShallow := map[string]bool{}
+	for _, id := range clientShallow {
+		isClientShallow[string(id)] = true
+	}
+	isBoundary := map[string]bool{}
+	for _, id := range cut.Boundary {
+		isBoundary[string(id)] = true
+		if isClientShallow[string(id)] {
+			continue
+		}
+		if _, err := encoder.WriteLineString(fmt.Sprintf("shallow %x", id)); err != nil {
+			return nil, nil, err
+		}
+	}
+	isDBShallow := map[string]bool{}
+	for _, id := range shallow {
+		isDBShallow[string(id)] = true
+	}
+	for _, id := range clientShallow {
+		if !cut.Kept(id) || isBoundary[string(id)] || isDBShallow[string(id)] {
+			continue
+		}
+		if _, err := encoder.WriteLineString(fmt.Sprintf("unshallow %x", id)); err != nil {
+			return nil, nil, err
+		}
+		unshallowed = append(unshallowed, id)
+	}
+	if err := encoder.WriteFlush(); err != nil {
+		return nil, nil, err
+	}
+
+	// Unshallowed commits stay roots so that walking the haves of the client does not reach
+	// their missing parents, which are instead sent as wants
+	shallow = append(shallow, cut.Boundary...)
+	return append(shallow, clientShallow...), unshallowed, nil
+}
+
+// cutHistory cuts the history of the wants as asked by the deepen lines of req
+func (s *UploadPackSession) cutHistory(req *PackReq, shallow [][]byte, clientShallow [][]byte) (*revlist.Cut, error) {
+	wants := make([][]byte, 0, len(req.Wants()))
+	for _, want := range req.Wants() {
+		wants = append(wants, want)
+	}
+	switch {
+	case req.Depth() > 0 && req.Caps().Has("deepen-relative"):
+		// The depth counts from the current shallow commits of the client, which are at depth 1
+		starts := [][]byte{}
+		for _, id := range clientShallow {
+			ok, err := s.manager.ObjectExist(id)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				starts = append(starts, id)
+			}
+		}
+		return revlist.ShallowByDepth(s.manager, starts, shallow, req.Depth()+1)
+	case req.Depth() > 0:
+		return revlist.ShallowByDepth(s.manager, wants, shallow, req.Depth())
+	case req.DeepenSince() > 0:
+		return revlist.ShallowSince(s.manager, wants, shallow, time.Unix(req.DeepenSince(), 0))
+	default:
+		excludes := [][]byte{}
+		for _, name := range req.DeepenNots() {
+			id, err := s.resolveRefName(name)
+			if err != nil {
+				return nil, err
+			}
+			excludes = append(excludes, id)
+		}
+		return revlist.ShallowExclude(s.manager, wants, shallow, excludes)
+	}
+}
+
+// resolveRefName resolves a ref given by its full or short name
+func (s *UploadPackSession) resolveRefName(name string) ([]byte, error) {
+	for _, candidate := range []string{name, "refs/tags/" + name, "refs/heads/" + name} {
+		ref, err := s.store.ResolveRef(candidate)
+		if err == nil {
+			return ref.Checksum, nil
+		}
+		if !errors.Is(err, store.ErrRefNotExist) {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", store.ErrRefNotExist, name)
+}
+
+// negotiate reads have lines until the client is done, acknowledging common objects.
+// A nil list of commons means the client has not finished the negotiation.
+func (s *UploadPackSession) negotiate(decoder *PktLineDecoder, encoder *PktLineEncoder) ([][]byte, error) {
+	commons := [][]byte{}
+	for {
+		line, flush, err := decoder.ReadPktLine()
+		if err != nil {
+			if err == io.EOF && s.statelessRPC {
+				return nil, nil
+			}
+			return nil, err
+		}
+		if flush {
+			if len(commons) == 0 {
+				if _, err := encoder.WriteLineString("NAK"); err != nil {
+					return nil, err
+				}
+			}
+			if s.statelessRPC {
+				return nil, nil
+			}
+			continue
+		}
+		if string(line) == "done" {
+			if len(commons) == 0 {
+				if _, err := encoder.WriteLineString("NAK"); err != nil {
+					return nil, err
+				}
+			}
+			return commons, nil
+		}
+		command, value, _ := bytes.Cut(line, SP)
+		if string(command) != "have" {
+			return nil, fmt.Errorf("unexpected line in have list: %s", line)
+		}
+		id, err := decodeHexId(value)
+		if err != nil {
+			return nil, err
+		}
+		ok, err := s.manager.ObjectExist(id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		commons = append(commons, id)
+		// Without multi_ack only the first common object is acknowledged
+		if len(commons) == 1 {
+			if _, err := encoder.WriteLineString(fmt.Sprintf("ACK %x", id)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// WritePack writes a pack with every object reachable from wants but not from haves
+func WritePack(manager pack.PackManageer, w io.Writer, wants [][]byte, haves [][]byte, options revlist.Options, writeOptions pack.WriteOptions) error {
+	entries, err := revlist.Objects(manager, wants, haves, options)
+	if err != nil {
+		return err
+	}
+	return writeEntries(manager, w, entries, writeOptions)
+}
+
+// writeEntries writes a pack with the objects of entries
+func writeEntries(manager pack.PackManageer, w io.Writer, entries []revlist.Entry, options pack.WriteOptions) error {
+	objects := make([]pack.ObjectToPack, len(entries))
+	for i, entry := range entries {
+		objects[i] = pack.ObjectToPack{ID: entry.Checksum, Name: entry.Name, NameHash: entry.NameHash}
+	}
+	_, err := pack.WriteObjects(manager, w, objects, options)
+	return err
+}
+
+// buildRefDiscReply lists the refs of a repository. HEAD is only included when withHead is set.
+func buildRefDiscReply(st store.Store, manager pack.PackManageer, withHead bool, caps []string) (*RefDiscReply, error) {
+	reply := NewRefDiscReply()
+	refs, err := st.ListRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		reply.addRef(ref.Name, ref.Checksum)
+		peeled, err := peelRef(manager, ref.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(peeled, ref.Checksum) {
+			reply.addPeeledRef(ref.Name, peeled)
+		}
+	}
+	if withHead {
+		head, err := st.ReadRef(store.HEAD)
+		if err != nil && !errors.Is(err, store.ErrRefNotExist) {
+			return nil, err
+		}
+		if head != nil && head.IsSymbolic() {
+			caps = append([]string{fmt.Sprintf("symref=%s:%s", store.HEAD, head.Target)}, caps...)
+		}
+		resolved, err := st.ResolveRef(store.HEAD)
+		if err != nil && !errors.Is(err, store.ErrRefNotExist) {
+			return nil, err
+		}
+		if resolved != nil {
+			reply.setHead(resolved.Checksum)
+		}
+	}
+	reply.caps = NewCapList(caps...)
+	return reply, nil
+}
+
+func readCommit(manager pack.PackManageer, id []byte) (*object.Commit, error) {
+	encodedObject, err := manager.Object(id)
+	if err != nil {
+		return nil, err
+	}
+	return object.DecodeCommit(encodedObject)
+}
+
+// peelRef follows annotated tags until a non-tag object is found
+func peelRef(manager pack.PackManageer, id []byte) ([]byte, error) {
+	for {
+		encodedObject, err := manager.Object(id)
+		if err != nil {
+			return nil, err
+		}
+		if encodedObject.Type() != common.OBJ_TAG {
+			return id, nil
+		}
+		tag, err := object.DecodeTag(encodedObject)
+		if err != nil {
+			return nil, err
+		}
+		id = tag.Object()
+	}
+}
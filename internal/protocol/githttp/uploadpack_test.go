@@ -0,0 +1,127 @@
+package githttp
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+func TestUploadPackNegotiation(t *testing.T) {
+	st, first := testing_helper.SetupRepo(t, t.TempDir())
+	tree := testing_helper.WriteObject(t, st, common.OBJ_TREE, []byte{})
+	commit := testing_helper.WriteObject(t, st, common.OBJ_COMMIT, []byte(fmt.Sprintf(
+		"tree %x\nparent %x\nauthor A U Thor <author@example.com> 1700000000 +0000\ncommitter A U Thor <author@example.com> 1700000000 +0000\n\nsecond\n",
+		tree, first)))
+	if err := st.WriteRef("refs/heads/main", commit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var req bytes.Buffer
+	encoder := NewPktLineEncoder(&req)
+	encoder.WriteLineString(fmt.Sprintf("want %x ofs-delta", commit))
+	encoder.WriteFlush()
+	encoder.WriteLineString(fmt.Sprintf("have %x", make([]byte, 20)))
+	encoder.WriteLineString(fmt.Sprintf("have %x", first))
+	encoder.WriteFlush()
+	encoder.WriteLineString("done")
+
+	var res bytes.Buffer
+	if err := NewUploadPackSession(st, false).UploadPack(&req, &res); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := DecodePackReply(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Acks()) != 1 || !bytes.Equal(reply.Acks()[0], first) {
+		t.Fatalf("expected a single ACK of %x actual %x", first, reply.Acks())
+	}
+}
+
+func TestUploadPackDeepen(t *testing.T) {
+	st, first := testing_helper.SetupRepo(t, t.TempDir())
+	tree := testing_helper.WriteObject(t, st, common.OBJ_TREE, []byte{})
+	commit := testing_helper.WriteObject(t, st, common.OBJ_COMMIT, []byte(fmt.Sprintf(
+		"tree %x\nparent %x\nauthor A U Thor <author@example.com> 1700000000 +0000\ncommitter A U Thor <author@example.com> 1700000000 +0000\n\nsecond\n",
+		tree, first)))
+	if err := st.WriteRef("refs/heads/main", commit, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	fetch := func(req *PackReq) *PackReply {
+		var body, res bytes.Buffer
+		if err := req.Encode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewUploadPackSession(st, true).UploadPack(&body, &res); err != nil {
+			t.Fatal(err)
+		}
+		reply, err := DecodePackReply(&res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	req := NewPackReq()
+	req.AddWant(commit)
+	req.SetDepth(1)
+	req.Done()
+	reply := fetch(req)
+	if len(reply.Shallows()) != 1 || !bytes.Equal(reply.Shallows()[0], commit) {
+		t.Fatalf("expected %x to become shallow but got %x", commit, reply.Shallows())
+	}
+
+	req = NewPackReq()
+	req.AddWant(commit)
+	req.AddHave(commit)
+	req.AddShallow(commit)
+	req.SetDepth(INFINITE_DEPTH)
+	req.Done()
+	reply = fetch(req)
+	if len(reply.Shallows()) != 0 || len(reply.Unshallows()) != 1 || !bytes.Equal(reply.Unshallows()[0], commit) {
+		t.Fatalf("expected %x to be unshallowed but got shallow %x unshallow %x", commit, reply.Shallows(), reply.Unshallows())
+	}
+}
+
+func TestUploadPackFilter(t *testing.T) {
+	st, commit := testing_helper.SetupRepo(t, t.TempDir())
+	blob := testing_helper.WriteObject(t, st, common.OBJ_BLOB, []byte("hello world\n"))
+
+	req := NewPackReq()
+	req.AddWant(commit)
+	req.SetFilter("blob:none")
+	req.SetCaps(NewCapList("filter"))
+	req.Done()
+	var body, res bytes.Buffer
+	if err := req.Encode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewUploadPackSession(st, true).UploadPack(&body, &res); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := DecodePackReply(&res)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(local)
+	if _, err := manager.From(reply); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := manager.ObjectExist(commit); err != nil || !ok {
+		t.Fatalf("expected commit %x to be sent", commit)
+	}
+	if ok, err := manager.ObjectExist(blob); err != nil || ok {
+		t.Fatalf("expected blob %x to be left out", blob)
+	}
+}
@@ -0,0 +1,110 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+)
+
+var (
+	ErrBundlePush = errors.New("cannot push to a bundle")
+)
+
+// BundleTransport reads a bundle file as if it were a remote. The bundle holds a single
+// pack, which is sent whatever is requested.
+type BundleTransport struct {
+	path string
+}
+
+// IsBundle reports whether the file at path is a bundle rather than a repository
+func IsBundle(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return githttp.IsBundleSignature(strings.TrimSuffix(line, "\n"))
+}
+
+func NewBundleTransport(endpoint *Endpoint) (*BundleTransport, error) {
+	// The path is made absolute so that the transport keeps working after a change of directory
+	path, err := filepath.Abs(endpoint.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &BundleTransport{
+		path: path,
+	}, nil
+}
+
+func (t *BundleTransport) FetchRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	file, header, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	return header.RefDiscReply(), nil
+}
+
+// FetchPack returns the pack of the bundle. The objects the bundle builds upon must
+// already be in the repository, which the caller checks once the pack is stored.
+func (t *BundleTransport) FetchPack(ctx context.Context, req *githttp.PackReq) (*githttp.PackReply, error) {
+	file, _, err := t.open()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := githttp.DecodePackReply(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (t *BundleTransport) PushRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	return nil, ErrBundlePush
+}
+
+func (t *BundleTransport) Push(ctx context.Context, req *githttp.ReceivePackReq, pack io.Reader) (*githttp.ReportStatus, error) {
+	return nil, ErrBundlePush
+}
+
+func (t *BundleTransport) Close() error {
+	return nil
+}
+
+// open reads the header of the bundle and returns the bundle positioned at its pack
+func (t *BundleTransport) open() (*bundleFile, *githttp.BundleHeader, error) {
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r := &bundleFile{Reader: bufio.NewReader(file), file: file}
+	header, err := githttp.DecodeBundleHeader(r.Reader)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("%s: %w", t.path, err)
+	}
+	return r, header, nil
+}
+
+// bundleFile reads a bundle through a buffer, closing the file when done
+type bundleFile struct {
+	*bufio.Reader
+	file *os.File
+}
+
+func (f *bundleFile) Close() error {
+	return f.file.Close()
+}
@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+func TestBundleFetch(t *testing.T) {
+	repo, commit := testing_helper.SetupRepo(t, t.TempDir())
+	var bundle bytes.Buffer
+	refs := []githttp.BundleRef{{Name: store.HEAD, ID: commit}, {Name: "refs/heads/main", ID: commit}}
+	if err := githttp.WriteBundle(pack.New(repo), &bundle, 2, refs, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "repo.bundle")
+	if err := os.WriteFile(path, bundle.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := New(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := remote.(*BundleTransport); !ok {
+		t.Fatalf("expected a bundle transport but got %T", remote)
+	}
+	defer remote.Close()
+	ctx := context.Background()
+	refDiscReply, err := remote.FetchRefs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(refDiscReply.Head(), commit) || !bytes.Equal(refDiscReply.Refs()["refs/heads/main"], commit) {
+		t.Fatalf("expected HEAD and main to be %x but got %x and %x", commit, refDiscReply.Head(), refDiscReply.Refs()["refs/heads/main"])
+	}
+
+	local, err := store.InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(local)
+	reply, err := remote.FetchPack(ctx, githttp.BuildPacReqFromRefDisc(refDiscReply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reply.Close()
+	if _, err := manager.From(reply); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := manager.ObjectExist(commit); err != nil || !ok {
+		t.Fatalf("expected fetched commit %x to exist", commit)
+	}
+
+	if _, err := remote.PushRefs(ctx); !errors.Is(err, ErrBundlePush) {
+		t.Fatalf("expected: %v\tactual: %v", ErrBundlePush, err)
+	}
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"context"
+	"net"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+)
+
+const (
+	// DEFAULT_DAEMON_PORT is the port of git:// urls that do not name one
+	DEFAULT_DAEMON_PORT = "9418"
+)
+
+// NewGitTransport talks to a git daemon over TCP. Each service runs on its own connection,
+// opened by a request naming the service, the repository and the host asked for, which
+// lets a daemon serve several virtual hosts.
+func NewGitTransport(endpoint *Endpoint) *StreamTransport {
+	return newStreamTransport(func(ctx context.Context, service string) (*streamConn, error) {
+		addr := endpoint.Host
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			addr = net.JoinHostPort(addr, DEFAULT_DAEMON_PORT)
+		}
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		// Like the commands of the other transports, the connection ends with ctx
+		stop := context.AfterFunc(ctx, func() { conn.Close() })
+		req := &githttp.DaemonReq{Service: service, Path: endpoint.Path, Host: endpoint.Host}
+		if err := req.Encode(conn); err != nil {
+			stop()
+			conn.Close()
+			return nil, err
+		}
+		return newStreamConn(&tcpWriter{conn.(*net.TCPConn)}, conn, func() error {
+			stop()
+			return nil
+		}), nil
+	})
+}
+
+// tcpWriter closes the sending side of a connection, telling the daemon that the request
+// is complete while the reply can still be read
+type tcpWriter struct {
+	*net.TCPConn
+}
+
+func (w *tcpWriter) Close() error {
+	return w.CloseWrite()
+}
@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/credential"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// HttpTransport speaks the smart HTTP protocol, falling back to the dumb protocol when the
+// server only serves the files of the repository
+type HttpTransport struct {
+	client *githttp.GitHttpClient
+	url    string
+	// dumb is set once the ref discovery found a dumb server
+	dumb bool
+}
+
+const (
+	// DEFAULT_MAX_RETRIES is how many times a request failing with a transient error is sent
+	// again unless http.maxRetries is set
+	DEFAULT_MAX_RETRIES = 3
+)
+
+// NewHttpTransport returns a transport configured by the http.* and credential.* settings
+// of cfg that apply to the endpoint
+func NewHttpTransport(endpoint *Endpoint, cfg *config.Config) (*HttpTransport, error) {
+	options, err := httpOptions(endpoint.Raw, cfg)
+	if err != nil {
+		return nil, err
+	}
+	client, err := githttp.NewGitHttpClientWithOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	return &HttpTransport{
+		client: client,
+		url:    endpoint.Raw,
+	}, nil
+}
+
+// httpOptions reads the client options from the settings of cfg for rawUrl. As in git,
+// the GIT_SSL_*, GIT_HTTP_* environment variables take precedence over the config.
+func httpOptions(rawUrl string, cfg *config.Config) (githttp.ClientOptions, error) {
+	credentials, err := credential.NewManager(cfg, rawUrl)
+	if err != nil {
+		return githttp.ClientOptions{}, err
+	}
+	options := githttp.ClientOptions{
+		Header:      http.Header{},
+		Credentials: credentials,
+		MaxRetries:  DEFAULT_MAX_RETRIES,
+	}
+	for _, header := range cfg.GetAllForURL("http", rawUrl, "extraHeader") {
+		// An empty value clears the headers configured so far
+		if header == "" {
+			options.Header = http.Header{}
+			continue
+		}
+		name, value, found := strings.Cut(header, ":")
+		if !found {
+			// The value is not echoed, as it may hold a secret such as a token
+			return options, fmt.Errorf("%w: invalid http.extraHeader", config.ErrInvalidConfig)
+		}
+		options.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	setting := func(key string, env string) (string, bool) {
+		if value, ok := os.LookupEnv(env); env != "" && ok {
+			return value, true
+		}
+		return cfg.GetForURL("http", rawUrl, key)
+	}
+	number := func(key string, env string) (int64, bool, error) {
+		value, ok := setting(key, env)
+		if !ok {
+			return 0, false, nil
+		}
+		n, err := config.ParseInt(value)
+		if err != nil {
+			return 0, false, fmt.Errorf("%w for http.%s", err, key)
+		}
+		return n, true, nil
+	}
+
+	if proxy, ok := cfg.GetForURL("http", rawUrl, "proxy"); ok && proxy != "" {
+		// Like curl, a proxy without a scheme is an http proxy
+		if !strings.Contains(proxy, "://") {
+			proxy = "http://" + proxy
+		}
+		if options.Proxy, err = url.Parse(proxy); err != nil {
+			return options, fmt.Errorf("%w: invalid http.proxy: %w", config.ErrInvalidConfig, err)
+		}
+	}
+	options.CAFile, _ = setting("sslCAInfo", "GIT_SSL_CAINFO")
+	if _, ok := os.LookupEnv("GIT_SSL_NO_VERIFY"); ok {
+		options.InsecureSkipVerify = true
+	} else if value, ok := cfg.GetForURL("http", rawUrl, "sslVerify"); ok {
+		verify, err := config.ParseBool(value)
+		if err != nil {
+			return options, fmt.Errorf("%w for http.sslVerify", err)
+		}
+		options.InsecureSkipVerify = !verify
+	}
+	options.UserAgent, _ = setting("userAgent", "GIT_HTTP_USER_AGENT")
+
+	limit, _, err := number("lowSpeedLimit", "GIT_HTTP_LOW_SPEED_LIMIT")
+	if err != nil {
+		return options, err
+	}
+	seconds, _, err := number("lowSpeedTime", "GIT_HTTP_LOW_SPEED_TIME")
+	if err != nil {
+		return options, err
+	}
+	options.LowSpeedLimit, options.LowSpeedTime = int(limit), time.Duration(seconds)*time.Second
+	postBuffer, _, err := number("postBuffer", "")
+	if err != nil {
+		return options, err
+	}
+	options.PostBuffer = int(postBuffer)
+	retries, ok, err := number("maxRetries", "")
+	if err != nil {
+		return options, err
+	}
+	if ok {
+		options.MaxRetries = int(retries)
+	}
+	return options, nil
+}
+
+func (t *HttpTransport) FetchRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	reply, err := t.client.GetRefs(ctx, t.url)
+	if !errors.Is(err, githttp.ErrDumbServer) {
+		return reply, err
+	}
+	t.dumb = true
+	return t.client.GetDumbRefs(ctx, t.url)
+}
+
+// Dumb reports whether the server was found to only speak the dumb protocol by FetchRefs
+func (t *HttpTransport) Dumb() bool {
+	return t.dumb
+}
+
+// FetchObjects downloads the objects of a dumb server, see [githttp.GitHttpClient.FetchObjects]
+func (t *HttpTransport) FetchObjects(ctx context.Context, st store.Store, manager *pack.DefaulPackManager, wants [][]byte, haves [][]byte) error {
+	return t.client.FetchObjects(ctx, t.url, st, manager, wants, haves)
+}
+
+func (t *HttpTransport) FetchPack(ctx context.Context, req *githttp.PackReq) (*githttp.PackReply, error) {
+	if t.dumb {
+		return nil, fmt.Errorf("%w: objects can only be fetched one by one", githttp.ErrDumbServer)
+	}
+	body, err := t.client.FetchPack(ctx, req, t.url)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := githttp.DecodePackReply(body)
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (t *HttpTransport) PushRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	reply, err := t.client.GetReceivePackRefs(ctx, t.url)
+	if errors.Is(err, githttp.ErrDumbServer) {
+		return nil, fmt.Errorf("%w: pushing over the dumb protocol is not supported", githttp.ErrDumbServer)
+	}
+	return reply, err
+}
+
+func (t *HttpTransport) Push(ctx context.Context, req *githttp.ReceivePackReq, pack io.Reader) (*githttp.ReportStatus, error) {
+	return t.client.SendPack(ctx, req, pack, t.url)
+}
+
+func (t *HttpTransport) Close() error {
+	return nil
+}
@@ -0,0 +1,81 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// LocalTransport talks to a repository on the local file system. The upload-pack and
+// receive-pack sessions run in process, so no git binary is needed on either side.
+type LocalTransport struct {
+	store store.Store
+}
+
+// NewLocalTransport opens the repository at a plain path or a file:// url
+func NewLocalTransport(endpoint *Endpoint) (*LocalTransport, error) {
+	// The path is made absolute so that the transport keeps working after a change of directory
+	path, err := filepath.Abs(endpoint.Path)
+	if err != nil {
+		return nil, err
+	}
+	st, err := store.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &LocalTransport{
+		store: st,
+	}, nil
+}
+
+func (t *LocalTransport) FetchRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	return githttp.NewUploadPackSession(t.store, true).RefDiscReply()
+}
+
+func (t *LocalTransport) FetchPack(ctx context.Context, req *githttp.PackReq) (*githttp.PackReply, error) {
+	var encodedReq bytes.Buffer
+	if err := req.Encode(&encodedReq); err != nil {
+		return nil, err
+	}
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(githttp.NewUploadPackSession(t.store, true).UploadPack(&encodedReq, w))
+	}()
+	reply, err := githttp.DecodePackReply(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (t *LocalTransport) PushRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	return githttp.NewReceivePackSession(t.store).RefDiscReply()
+}
+
+func (t *LocalTransport) Push(ctx context.Context, req *githttp.ReceivePackReq, pack io.Reader) (*githttp.ReportStatus, error) {
+	var encodedReq bytes.Buffer
+	if err := req.Encode(&encodedReq); err != nil {
+		return nil, err
+	}
+	body := io.Reader(&encodedReq)
+	if pack != nil {
+		body = io.MultiReader(&encodedReq, pack)
+	}
+	var res bytes.Buffer
+	if err := githttp.NewReceivePackSession(t.store).ReceivePack(body, &res); err != nil {
+		return nil, err
+	}
+	if !req.Caps.Has("report-status") {
+		return &githttp.ReportStatus{}, nil
+	}
+	return githttp.DecodeReportStatus(githttp.NewPktLineParser(&res))
+}
+
+func (t *LocalTransport) Close() error {
+	return nil
+}
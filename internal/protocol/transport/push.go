@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+const (
+	// REJECT_NON_FAST_FORWARD rejects an update that would drop commits from the remote
+	REJECT_NON_FAST_FORWARD = "non-fast-forward"
+	// REJECT_FETCH_FIRST rejects an update of a remote ref whose commit is not known
+	// locally, which has to be fetched before the update can be checked
+	REJECT_FETCH_FIRST = "fetch first"
+)
+
+// RejectedUpdate is a ref update left out of a push, with the reason git reports
+type RejectedUpdate struct {
+	Src    string
+	Dst    string
+	Reason string
+}
+
+// PlanPush turns refspecs into the ref updates of a push. A refspec is either <src>:<dst>,
+// :<dst> to delete dst, or a single name pushed to the same name on the remote. An update
+// of an existing remote ref is rejected unless its commit is an ancestor of the new one,
+// or the refspec starts with + or force is set.
+func PlanPush(st store.Store, db revlist.ObjectReader, remoteRefs githttp.RefList, refspecs []string, force bool) (*githttp.ReceivePackReq, []RejectedUpdate, error) {
+	req := &githttp.ReceivePackReq{Caps: githttp.NewCapList("report-status", "agent="+githttp.AGENT)}
+	shallow, err := st.Shallow()
+	if err != nil {
+		return nil, nil, err
+	}
+	rejected := []RejectedUpdate{}
+	for _, refspec := range refspecs {
+		refspec, forced := strings.CutPrefix(refspec, "+")
+		src, dst, found := strings.Cut(refspec, ":")
+		if !found {
+			dst = src
+		}
+		dst = QualifyRef(dst)
+		update := &githttp.RefUpdate{Name: dst, Old: remoteRefs[dst]}
+		if src == "" {
+			if update.Old == nil {
+				return nil, nil, fmt.Errorf("unable to delete %s: remote ref does not exist", dst)
+			}
+			req.Updates = append(req.Updates, update)
+			continue
+		}
+		ref, err := st.ResolveRef(QualifyRef(src))
+		if err != nil {
+			return nil, nil, fmt.Errorf("src refspec %s does not match any: %w", src, err)
+		}
+		update.New = ref.Checksum
+		if update.Old != nil && !forced && !force {
+			reason, err := checkFastForward(db, update.Old, update.New, shallow)
+			if err != nil {
+				return nil, nil, err
+			}
+			if reason != "" {
+				rejected = append(rejected, RejectedUpdate{Src: src, Dst: dst, Reason: reason})
+				continue
+			}
+		}
+		req.Updates = append(req.Updates, update)
+	}
+	return req, rejected, nil
+}
+
+// checkFastForward returns why updating a ref from old to new is rejected, or an empty
+// reason for a fast-forward
+func checkFastForward(db revlist.ObjectReader, old []byte, new []byte, shallow [][]byte) (string, error) {
+	ok, err := db.ObjectExist(old)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return REJECT_FETCH_FIRST, nil
+	}
+	ok, err = revlist.IsAncestor(db, old, new, shallow)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return REJECT_NON_FAST_FORWARD, nil
+	}
+	return "", nil
+}
+
+// QualifyRef expands a short branch name to its full ref name
+func QualifyRef(name string) string {
+	if strings.HasPrefix(name, "refs/") || name == store.HEAD {
+		return name
+	}
+	return "refs/heads/" + name
+}
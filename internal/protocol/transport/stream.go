@@ -0,0 +1,158 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+)
+
+// dialFunc opens a connection to service, git-upload-pack or git-receive-pack, on a remote
+type dialFunc func(ctx context.Context, service string) (*streamConn, error)
+
+// StreamTransport speaks the pack protocol over a bidirectional stream, such as the stdin
+// and stdout of a command or a TCP connection. Unlike HTTP the conversation is stateful:
+// the refs are advertised on the same connection that later carries the request, so a
+// connection opened by FetchRefs is kept for FetchPack and one opened by PushRefs for Push.
+type StreamTransport struct {
+	dial dialFunc
+	conn *streamConn
+}
+
+func newStreamTransport(dial dialFunc) *StreamTransport {
+	return &StreamTransport{
+		dial: dial,
+	}
+}
+
+func (t *StreamTransport) FetchRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	conn, err := t.connect(ctx, githttp.UPLOAD_PACK_SERVICE)
+	if err != nil {
+		return nil, err
+	}
+	return conn.refs, nil
+}
+
+func (t *StreamTransport) FetchPack(ctx context.Context, req *githttp.PackReq) (*githttp.PackReply, error) {
+	conn, err := t.connect(ctx, githttp.UPLOAD_PACK_SERVICE)
+	if err != nil {
+		return nil, err
+	}
+	// The connection is handed over to the reply, which ends the conversation when closed
+	t.conn = nil
+	conn.requested = true
+	if err := req.Encode(conn.stdin); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply, err := githttp.DecodePackReply(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (t *StreamTransport) PushRefs(ctx context.Context) (*githttp.RefDiscReply, error) {
+	conn, err := t.connect(ctx, githttp.RECEIVE_PACK_SERVICE)
+	if err != nil {
+		return nil, err
+	}
+	return conn.refs, nil
+}
+
+func (t *StreamTransport) Push(ctx context.Context, req *githttp.ReceivePackReq, pack io.Reader) (*githttp.ReportStatus, error) {
+	conn, err := t.connect(ctx, githttp.RECEIVE_PACK_SERVICE)
+	if err != nil {
+		return nil, err
+	}
+	t.conn = nil
+	defer conn.Close()
+	conn.requested = true
+	if err := req.Encode(conn.stdin); err != nil {
+		return nil, err
+	}
+	if pack != nil {
+		if _, err := io.Copy(conn.stdin, pack); err != nil {
+			return nil, err
+		}
+	}
+	// Closing stdin marks the end of the pack for servers reading until EOF
+	if err := conn.stdin.Close(); err != nil {
+		return nil, err
+	}
+	if !req.Caps.Has("report-status") {
+		return &githttp.ReportStatus{}, nil
+	}
+	return githttp.DecodeReportStatus(githttp.NewPktLineParser(conn.stdout))
+}
+
+func (t *StreamTransport) Close() error {
+	if t.conn == nil {
+		return nil
+	}
+	err := t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+// connect returns the open connection to service, dialing the remote if needed
+func (t *StreamTransport) connect(ctx context.Context, service string) (*streamConn, error) {
+	if t.conn != nil && t.conn.service == service {
+		return t.conn, nil
+	}
+	if err := t.Close(); err != nil {
+		return nil, err
+	}
+	conn, err := t.dial(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	conn.service = service
+	conn.refs, err = githttp.NewRefDiscReplyDecoder(conn.stdout).DecodeRefs()
+	if err != nil {
+		conn.requested = true
+		conn.Close()
+		return nil, fmt.Errorf("failed to read refs from %s: %w", service, err)
+	}
+	t.conn = conn
+	return conn, nil
+}
+
+// streamConn is an open conversation with a service
+type streamConn struct {
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	// wait returns once the service ended, after both ends of the stream are closed
+	wait    func() error
+	service string
+	refs    *githttp.RefDiscReply
+	// requested is set once a request was sent. A connection closed before that
+	// sends a flush-pkt so that the service ends without error.
+	requested bool
+}
+
+func newStreamConn(stdin io.WriteCloser, stdout io.ReadCloser, wait func() error) *streamConn {
+	return &streamConn{
+		stdin:  stdin,
+		stdout: stdout,
+		wait:   wait,
+	}
+}
+
+func (c *streamConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *streamConn) Close() error {
+	if !c.requested {
+		githttp.NewPktLineEncoder(c.stdin).WriteFlush()
+	}
+	c.stdin.Close()
+	c.stdout.Close()
+	if err := c.wait(); err != nil {
+		return fmt.Errorf("%s failed: %w", c.service, err)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/trace"
+)
+
+const (
+	EXT_PREFIX = "ext::"
+)
+
+var (
+	ErrProtocolNotAllowed = errors.New("protocol not allowed")
+)
+
+// CommandFunc builds the command that runs service, git-upload-pack or git-receive-pack, for a remote
+type CommandFunc func(ctx context.Context, service string) (*exec.Cmd, error)
+
+// NewSubprocessTransport speaks the pack protocol over the stdin and stdout of the command
+// built for each service
+func NewSubprocessTransport(command CommandFunc) *StreamTransport {
+	return newStreamTransport(func(ctx context.Context, service string) (*streamConn, error) {
+		cmd, err := command(ctx, service)
+		if err != nil {
+			return nil, err
+		}
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stderr = os.Stderr
+		trace.Command("run_command", cmd.Args)
+		if err := cmd.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start %s: %w", service, err)
+		}
+		return newStreamConn(stdin, stdout, cmd.Wait), nil
+	})
+}
+
+// NewSSHTransport runs the service on the remote host through ssh. The ssh command is
+// taken from GIT_SSH_COMMAND, which is run by the shell, or GIT_SSH, defaulting to ssh.
+func NewSSHTransport(endpoint *Endpoint) *StreamTransport {
+	return NewSubprocessTransport(func(ctx context.Context, service string) (*exec.Cmd, error) {
+		args := []string{}
+		host := endpoint.Host
+		if h, port, err := net.SplitHostPort(endpoint.Host); err == nil {
+			host = h
+			args = append(args, "-p", port)
+		}
+		if endpoint.User != "" {
+			host = endpoint.User + "@" + host
+		}
+		// ssh://host/~user/repo is relative to the home directory of user
+		path := endpoint.Path
+		if strings.HasPrefix(path, "/~") {
+			path = path[1:]
+		}
+		args = append(args, host, fmt.Sprintf("%s %s", service, shellQuote(path)))
+
+		if sshCommand := os.Getenv("GIT_SSH_COMMAND"); sshCommand != "" {
+			return exec.CommandContext(ctx, "sh", append([]string{"-c", sshCommand + ` "$@"`, sshCommand}, args...)...), nil
+		}
+		ssh := os.Getenv("GIT_SSH")
+		if ssh == "" {
+			ssh = "ssh"
+		}
+		return exec.CommandContext(ctx, ssh, args...), nil
+	})
+}
+
+// NewExtTransport runs the command of an ext::<command> url. As the url runs an arbitrary
+// command, the ext protocol must be listed in the colon separated GIT_ALLOW_PROTOCOL.
+//
+// The command is split on spaces. %S expands to the service name, %s to the service name
+// without the git- prefix, "% " to a literal space and %% to a literal percent sign.
+func NewExtTransport(endpoint *Endpoint) (*StreamTransport, error) {
+	if !protocolAllowed("ext") {
+		return nil, fmt.Errorf("%w: ext", ErrProtocolNotAllowed)
+	}
+	return NewSubprocessTransport(func(ctx context.Context, service string) (*exec.Cmd, error) {
+		args, err := expandExtCommand(endpoint.Path, service)
+		if err != nil {
+			return nil, err
+		}
+		return exec.CommandContext(ctx, args[0], args[1:]...), nil
+	}), nil
+}
+
+// expandExtCommand splits the command of an ext:: url into arguments and expands its placeholders
+func expandExtCommand(command string, service string) ([]string, error) {
+	args := []string{}
+	var arg strings.Builder
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		if c == ' ' {
+			if arg.Len() > 0 {
+				args = append(args, arg.String())
+				arg.Reset()
+			}
+			continue
+		}
+		if c != '%' {
+			arg.WriteByte(c)
+			continue
+		}
+		if i+1 == len(command) {
+			return nil, errors.New("ext command ends with %")
+		}
+		i++
+		switch command[i] {
+		case 'S':
+			arg.WriteString(service)
+		case 's':
+			arg.WriteString(strings.TrimPrefix(service, "git-"))
+		case ' ', '%':
+			arg.WriteByte(command[i])
+		default:
+			return nil, fmt.Errorf("invalid placeholder %%%c in ext command", command[i])
+		}
+	}
+	if arg.Len() > 0 {
+		args = append(args, arg.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty ext command")
+	}
+	return args, nil
+}
+
+func protocolAllowed(protocol string) bool {
+	for _, allowed := range strings.Split(os.Getenv("GIT_ALLOW_PROTOCOL"), ":") {
+		if allowed == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// shellQuote quotes s for the shell of the remote host
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
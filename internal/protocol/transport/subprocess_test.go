@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+// recordUploadPack records the conversation of an upload-pack session serving req and
+// returns a script replaying it: the advertisement is written, the request is consumed
+// and the reply is written.
+func recordUploadPack(t *testing.T, dir string, req *githttp.PackReq) string {
+	st, err := store.Open(filepath.Join(dir, "remote.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := githttp.NewUploadPackSession(st, false)
+	var advertisement, request, reply bytes.Buffer
+	if err := session.AdvertiseRefs(&advertisement); err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Encode(&request); err != nil {
+		t.Fatal(err)
+	}
+	if err := session.UploadPack(bytes.NewReader(request.Bytes()), &reply); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string][]byte{"advertisement": advertisement.Bytes(), "reply": reply.Bytes()} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	script := filepath.Join(dir, "replay.sh")
+	content := fmt.Sprintf("cat %s/advertisement\nhead -c %d > /dev/null\ncat %s/reply\n", dir, request.Len(), dir)
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+func fetchAll(t *testing.T, remote Transport, dir string) []byte {
+	ctx := context.Background()
+	refs, err := remote.FetchRefs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := store.InitBare(filepath.Join(dir, "local.git"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(local)
+	reply, err := remote.FetchPack(ctx, githttp.BuildPacReqFromRefDisc(refs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.From(reply); err != nil {
+		t.Fatal(err)
+	}
+	if err := reply.Close(); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := manager.ObjectExist(refs.Head())
+	if err != nil || !ok {
+		t.Fatalf("expected fetched HEAD %x to exist", refs.Head())
+	}
+	return refs.Head()
+}
+
+func TestExtTransport(t *testing.T) {
+	dir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, filepath.Join(dir, "remote.git"))
+	req := githttp.NewPackReq()
+	req.AddWant(commit)
+	req.Done()
+	script := recordUploadPack(t, dir, req)
+
+	if _, err := New("ext::sh "+script, nil); err == nil {
+		t.Fatalf("expected ext to be disallowed without GIT_ALLOW_PROTOCOL")
+	}
+	t.Setenv("GIT_ALLOW_PROTOCOL", "ext")
+	remote, err := New("ext::sh "+script+" %S", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if head := fetchAll(t, remote, dir); !bytes.Equal(head, commit) {
+		t.Fatalf("expected HEAD %x actual %x", commit, head)
+	}
+}
+
+func TestSSHTransport(t *testing.T) {
+	dir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, filepath.Join(dir, "remote.git"))
+	req := githttp.NewPackReq()
+	req.AddWant(commit)
+	req.Done()
+	script := recordUploadPack(t, dir, req)
+
+	// The ssh command receives the host and the remote command as its last arguments
+	wrapper := filepath.Join(dir, "ssh.sh")
+	content := fmt.Sprintf("[ \"$1 $2 $3 $4\" = \"-p 2222 git@example.com git-upload-pack '/org/repo.git'\" ] || exit 1\nexec sh %s\n", script)
+	if err := os.WriteFile(wrapper, []byte(content), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GIT_SSH_COMMAND", "sh "+wrapper)
+	remote, err := New("ssh://git@example.com:2222/org/repo.git", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	if head := fetchAll(t, remote, dir); !bytes.Equal(head, commit) {
+		t.Fatalf("expected HEAD %x actual %x", commit, head)
+	}
+}
+
+func TestExpandExtCommand(t *testing.T) {
+	args, err := expandExtCommand("ssh -p% 1 host %S %s 100%%", "git-upload-pack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"ssh", "-p 1", "host", "git-upload-pack", "upload-pack", "100%"}
+	if fmt.Sprint(args) != fmt.Sprint(expected) {
+		t.Fatalf("expected %q actual %q", expected, args)
+	}
+}
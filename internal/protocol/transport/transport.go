@@ -0,0 +1,131 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+var (
+	ErrUnsupportedScheme = errors.New("unsupported url scheme")
+)
+
+// Transport is a connection to a remote repository. Every transport speaks the
+// same pack protocol, only the way requests reach the remote differs.
+type Transport interface {
+	// FetchRefs returns the refs of the remote available for fetching
+	FetchRefs(ctx context.Context) (*githttp.RefDiscReply, error)
+	// FetchPack sends the pack request and returns the reply of the remote
+	FetchPack(ctx context.Context, req *githttp.PackReq) (*githttp.PackReply, error)
+	// PushRefs returns the refs of the remote that can be updated by a push
+	PushRefs(ctx context.Context) (*githttp.RefDiscReply, error)
+	// Push sends the ref updates followed by pack, which is nil if only refs are deleted
+	Push(ctx context.Context, req *githttp.ReceivePackReq, pack io.Reader) (*githttp.ReportStatus, error)
+	Close() error
+}
+
+// ObjectFetcher is implemented by transports that may have to download objects one by one
+// instead of as a pack, as the dumb HTTP protocol does
+type ObjectFetcher interface {
+	// Dumb reports whether the objects must be downloaded with FetchObjects. It is only
+	// known once the refs were fetched.
+	Dumb() bool
+	// FetchObjects stores the objects reachable from wants, stopping at the haves
+	FetchObjects(ctx context.Context, st store.Store, manager *pack.DefaulPackManager, wants [][]byte, haves [][]byte) error
+}
+
+// Endpoint is a parsed remote url
+type Endpoint struct {
+	Scheme string
+	User   string
+	// Host includes the port if one is given
+	Host string
+	Path string
+	// Raw is the url as given by the user
+	Raw string
+}
+
+// ParseEndpoint parses a remote url. Besides urls with a scheme, the scp-like
+// [user@]host:path syntax is parsed as ssh, ext::<command> runs a command and
+// anything else is a local path.
+func ParseEndpoint(rawUrl string) (*Endpoint, error) {
+	if command, found := strings.CutPrefix(rawUrl, EXT_PREFIX); found {
+		return &Endpoint{Scheme: "ext", Path: command, Raw: rawUrl}, nil
+	}
+	if !strings.Contains(rawUrl, "://") {
+		// A colon before the first slash separates the host from the path
+		colon := strings.Index(rawUrl, ":")
+		if colon > 0 && !strings.Contains(rawUrl[:colon], "/") {
+			endpoint := &Endpoint{Scheme: "ssh", Host: rawUrl[:colon], Path: rawUrl[colon+1:], Raw: rawUrl}
+			if user, host, found := strings.Cut(endpoint.Host, "@"); found {
+				endpoint.User, endpoint.Host = user, host
+			}
+			return endpoint, nil
+		}
+		return &Endpoint{Scheme: "file", Path: rawUrl, Raw: rawUrl}, nil
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	return &Endpoint{Scheme: u.Scheme, User: u.User.Username(), Host: u.Host, Path: u.Path, Raw: rawUrl}, nil
+}
+
+// New returns the transport for the scheme of rawUrl, configured by cfg which may be nil
+func New(rawUrl string, cfg *config.Config) (Transport, error) {
+	endpoint, err := ParseEndpoint(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = config.New()
+	}
+	switch endpoint.Scheme {
+	case "http", "https":
+		return NewHttpTransport(endpoint, cfg)
+	case "file":
+		if IsBundle(endpoint.Path) {
+			return NewBundleTransport(endpoint)
+		}
+		return NewLocalTransport(endpoint)
+	case "git":
+		return NewGitTransport(endpoint), nil
+	case "ssh", "git+ssh", "ssh+git":
+		return NewSSHTransport(endpoint), nil
+	case "ext":
+		return NewExtTransport(endpoint)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedScheme, endpoint.Scheme)
+	}
+}
+
+// Anonymize removes the username and password from rawUrl so that it can be printed
+func Anonymize(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.User == nil || u.Scheme == "" {
+		return rawUrl
+	}
+	u.User = nil
+	return u.String()
+}
+
+// RepoName derives the name of the directory a repository is cloned into,
+// dropping the trailing .git, as in https://host/org/repo.git -> repo
+func RepoName(rawUrl string) string {
+	name := strings.TrimRight(rawUrl, "/")
+	name = strings.TrimSuffix(name, "/.git")
+	name = strings.TrimSuffix(name, ".git")
+	if i := strings.LastIndexAny(name, "/:"); i >= 0 {
+		name = name[i+1:]
+	}
+	return filepath.Base(name)
+}
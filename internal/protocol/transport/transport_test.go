@@ -0,0 +1,181 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/pack"
+	"github.com/codecrafters-io/git-starter-go/internal/protocol/githttp"
+	"github.com/codecrafters-io/git-starter-go/internal/revlist"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+	testing_helper "github.com/codecrafters-io/git-starter-go/internal/test"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	cases := []struct {
+		url    string
+		scheme string
+		path   string
+	}{
+		{"https://example.com/org/repo.git", "https", "/org/repo.git"},
+		{"file:///srv/repo.git", "file", "/srv/repo.git"},
+		{"../repo", "file", "../repo"},
+		{"git@example.com:org/repo.git", "ssh", "org/repo.git"},
+		{"ext::ssh host %S", "ext", "ssh host %S"},
+	}
+	for _, c := range cases {
+		endpoint, err := ParseEndpoint(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if endpoint.Scheme != c.scheme || endpoint.Path != c.path {
+			t.Fatalf("expected %s %s actual %s %s", c.scheme, c.path, endpoint.Scheme, endpoint.Path)
+		}
+	}
+	if _, err := New("foo://example.com/repo", nil); err == nil {
+		t.Fatalf("expected unsupported scheme error")
+	}
+}
+
+func TestRepoName(t *testing.T) {
+	cases := map[string]string{
+		"https://example.com/org/repo.git": "repo",
+		"/srv/repo/.git":                   "repo",
+		"file:///srv/repo/":                "repo",
+	}
+	for url, expected := range cases {
+		if actual := RepoName(url); actual != expected {
+			t.Fatalf("expected %s actual %s", expected, actual)
+		}
+	}
+}
+
+func TestLocalFetchAndPush(t *testing.T) {
+	dir := t.TempDir()
+	_, commit := testing_helper.SetupRepo(t, dir+"/remote.git")
+	remote, err := New("file://"+dir+"/remote.git", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	ctx := context.Background()
+
+	refs, err := remote.FetchRefs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(refs.Head(), commit) {
+		t.Fatalf("expected HEAD %x actual %x", commit, refs.Head())
+	}
+
+	local, err := store.InitBare(dir + "/local.git")
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(local)
+	reply, err := remote.FetchPack(ctx, githttp.BuildPacReqFromRefDisc(refs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.From(reply); err != nil {
+		t.Fatal(err)
+	}
+	reply.Close()
+	if ok, err := manager.ObjectExist(commit); err != nil || !ok {
+		t.Fatalf("expected fetched commit %x to exist", commit)
+	}
+
+	r, w := io.Pipe()
+	go func() {
+		w.CloseWithError(githttp.WritePack(manager, w, [][]byte{commit}, nil, revlist.Options{}, pack.DefaultWriteOptions()))
+	}()
+	req := &githttp.ReceivePackReq{
+		Updates: []*githttp.RefUpdate{{Name: "refs/heads/feature", New: commit}},
+		Caps:    githttp.NewCapList("report-status"),
+	}
+	report, err := remote.Push(ctx, req, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := report.Err(); err != nil {
+		t.Fatal(err)
+	}
+	refs, err = remote.PushRefs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(refs.Refs()["refs/heads/feature"], commit) {
+		t.Fatalf("expected refs/heads/feature %x actual %x", commit, refs.Refs()["refs/heads/feature"])
+	}
+}
+
+func TestPlanPushNonFastForward(t *testing.T) {
+	dir := t.TempDir()
+	remoteStore, base := testing_helper.SetupRepo(t, dir+"/remote.git")
+	local, _ := testing_helper.SetupRepo(t, dir+"/local.git")
+	commit := func(st *store.FSStore, message string) []byte {
+		return testing_helper.WriteObject(t, st, common.OBJ_COMMIT, []byte(fmt.Sprintf(
+			"tree %x\nparent %x\nauthor A U Thor <author@example.com> 1700000100 +0000\ncommitter A U Thor <author@example.com> 1700000100 +0000\n\n%s\n",
+			base, base, message)))
+	}
+	// The histories diverge after the base commit, the remote commit is fetched locally
+	remoteTip := commit(remoteStore, "remote")
+	commit(local, "remote")
+	localTip := commit(local, "local")
+	if err := remoteStore.WriteRef("refs/heads/main", remoteTip, base); err != nil {
+		t.Fatal(err)
+	}
+	if err := local.WriteRef("refs/heads/main", localTip, nil); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := New("file://"+dir+"/remote.git", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+	refs, err := remote.PushRefs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager := pack.New(local)
+
+	req, rejected, err := PlanPush(local, manager, refs.Refs(), []string{"main"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(req.Updates) != 0 || len(rejected) != 1 || rejected[0].Reason != REJECT_NON_FAST_FORWARD {
+		t.Fatalf("expected: main rejected as %s\tactual: %v %v", REJECT_NON_FAST_FORWARD, req.Updates, rejected)
+	}
+	for _, c := range []struct {
+		refspec string
+		force   bool
+	}{{"+main", false}, {"main", true}, {"main:feature", false}} {
+		req, rejected, err := PlanPush(local, manager, refs.Refs(), []string{c.refspec}, c.force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(req.Updates) != 1 || len(rejected) != 0 || !bytes.Equal(req.Updates[0].New, localTip) {
+			t.Fatalf("expected: %s pushed\tactual: %v %v", c.refspec, req.Updates, rejected)
+		}
+	}
+
+	// A remote commit missing locally has to be fetched first
+	remoteRefs := githttp.RefList{"refs/heads/main": bytes.Repeat([]byte{1}, 20)}
+	_, rejected, err = PlanPush(local, manager, remoteRefs, []string{"main"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rejected) != 1 || rejected[0].Reason != REJECT_FETCH_FIRST {
+		t.Fatalf("expected: main rejected as %s\tactual: %v", REJECT_FETCH_FIRST, rejected)
+	}
+	// The remote tip is an ancestor of the local one
+	remoteRefs = githttp.RefList{"refs/heads/main": base}
+	req, rejected, err = PlanPush(local, manager, remoteRefs, []string{"main"}, false)
+	if err != nil || len(req.Updates) != 1 || len(rejected) != 0 {
+		t.Fatalf("expected: a fast-forward of main\tactual: %v %v %v", req.Updates, rejected, err)
+	}
+}
@@ -0,0 +1,117 @@
+package revlist
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+)
+
+// Commit is what walks need of a commit
+type Commit struct {
+	Tree    []byte
+	Parents [][]byte
+	// Date is when the commit was committed
+	Date time.Time
+	// Generation is the corrected commit date of the commit, in seconds since the epoch. It
+	// is zero unless the commit was found in a commit-graph holding corrected commit dates.
+	Generation int64
+}
+
+// CommitGraph answers the parents, tree and dates of commits without reading the commits,
+// such as from a commit-graph file
+type CommitGraph interface {
+	// LookupCommit returns the commit id, ok is false when the graph does not hold it
+	LookupCommit(id []byte) (commit *Commit, ok bool, err error)
+}
+
+// ReadCommit returns the commit id from the commit-graph of db when it holds the commit,
+// and otherwise from the commit object
+func ReadCommit(db ObjectReader, id []byte) (*Commit, error) {
+	commit, ok, err := lookupCommit(db, id)
+	if err != nil || ok {
+		return commit, err
+	}
+	encodedObject, err := db.Object(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", hash.ChecksumToHex(id), err)
+	}
+	decoded, err := object.DecodeCommit(encodedObject)
+	if err != nil {
+		return nil, err
+	}
+	committer := decoded.Committer()
+	return &Commit{Tree: decoded.Tree(), Parents: decoded.Parents(), Date: committer.Date()}, nil
+}
+
+// lookupCommit returns the commit id from the commit-graph of db, ok is false when db has
+// no graph or the graph does not hold the commit
+func lookupCommit(db ObjectReader, id []byte) (*Commit, bool, error) {
+	graph, ok := db.(CommitGraph)
+	if !ok {
+		return nil, false, nil
+	}
+	return graph.LookupCommit(id)
+}
+
+// IsAncestor reports whether ancestor is reachable from descendant through the parents of
+// commits. Commits in shallow, the boundary of db, are not walked past. The walk skips the
+// commits whose generation is below the one of ancestor, when the commit-graph holds them.
+func IsAncestor(db ObjectReader, ancestor []byte, descendant []byte, shallow [][]byte) (bool, error) {
+	target, err := ReadCommit(db, ancestor)
+	if err != nil {
+		return false, err
+	}
+	boundary := idSet(shallow)
+	seen := map[string]bool{string(descendant): true}
+	queue := [][]byte{descendant}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if bytes.Equal(id, ancestor) {
+			return true, nil
+		}
+		if boundary[string(id)] {
+			continue
+		}
+		commit, err := ReadCommit(db, id)
+		if err != nil {
+			return false, err
+		}
+		if target.Generation > 0 && commit.Generation > 0 && commit.Generation < target.Generation {
+			continue
+		}
+		for _, parent := range commit.Parents {
+			if !seen[string(parent)] {
+				seen[string(parent)] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	return false, nil
+}
+
+// Parent returns the nth parent of the commit id, peeling tags to the commit they point to.
+// The parent 0 is the commit itself.
+func Parent(db ObjectReader, id []byte, n int) ([]byte, error) {
+	commits, err := peelCommits(db, [][]byte{id})
+	if err != nil {
+		return nil, err
+	}
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("%s is not a commit", hash.ChecksumToHex(id))
+	}
+	if n == 0 {
+		return commits[0], nil
+	}
+	commit, err := ReadCommit(db, commits[0])
+	if err != nil {
+		return nil, err
+	}
+	if n > len(commit.Parents) {
+		return nil, fmt.Errorf("commit %s has no parent %d", hash.ChecksumToHex(commits[0]), n)
+	}
+	return commit.Parents[n-1], nil
+}
@@ -0,0 +1,80 @@
+package revlist
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
+)
+
+var (
+	ErrInvalidFilter = errors.New("invalid filter")
+)
+
+// Filter leaves objects out of a walk, as done for partial clones. Objects that are
+// wanted explicitly are never left out.
+type Filter struct {
+	spec string
+	// Blobs of at least blobLimit bytes are left out. A negative limit keeps every blob.
+	blobLimit int64
+	// Trees and blobs at treeDepth or deeper are left out, root trees being at depth 0.
+	// A negative depth keeps every tree.
+	treeDepth int
+}
+
+// ParseFilter parses a filter spec: blob:none, blob:limit=<n>[kmg] or tree:<depth>
+func ParseFilter(spec string) (*Filter, error) {
+	filter := &Filter{spec: spec, blobLimit: -1, treeDepth: -1}
+	kind, value, _ := strings.Cut(spec, ":")
+	switch {
+	case spec == "blob:none":
+		filter.blobLimit = 0
+	case kind == "blob" && strings.HasPrefix(value, "limit="):
+		limit, err := config.ParseInt(strings.TrimPrefix(value, "limit="))
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFilter, spec)
+		}
+		filter.blobLimit = limit
+	case kind == "tree":
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidFilter, spec)
+		}
+		filter.treeDepth = depth
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFilter, spec)
+	}
+	return filter, nil
+}
+
+func (f *Filter) String() string {
+	return f.spec
+}
+
+// keepTree reports whether a tree at depth is walked
+func (f *Filter) keepTree(depth int) bool {
+	return f == nil || f.treeDepth < 0 || depth < f.treeDepth
+}
+
+// keepBlob reports whether a blob of size at depth is kept. Blobs found in a tree at depth d are at depth d+1.
+func (f *Filter) keepBlob(size int, depth int) bool {
+	if f == nil {
+		return true
+	}
+	if f.treeDepth >= 0 && depth >= f.treeDepth {
+		return false
+	}
+	return f.blobLimit < 0 || int64(size) < f.blobLimit
+}
+
+// needsBlobSize reports whether the size of blobs is needed to decide whether they are kept
+func (f *Filter) needsBlobSize() bool {
+	return f != nil && f.blobLimit > 0
+}
+
+// limitsDepth reports whether trees are left out by their depth
+func (f *Filter) limitsDepth() bool {
+	return f != nil && f.treeDepth >= 0
+}
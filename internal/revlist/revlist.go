@@ -0,0 +1,329 @@
+package revlist
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/filemode"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+)
+
+var (
+	ErrNotConnected = errors.New("missing objects")
+)
+
+// ObjectReader is the source of objects that are walked
+type ObjectReader interface {
+	Object([]byte) (common.Object, error)
+	ObjectExist([]byte) (bool, error)
+}
+
+// Entry is an object found during a walk
+type Entry struct {
+	Checksum common.Checksum
+	Type     common.ObjectType
+	// Name is the path the tree or blob was first found at, from the root tree of a commit.
+	// It is empty for commits, tags and root trees.
+	Name string
+	// NameHash stands for the name when the walk is answered by bitmaps, which only keep
+	// a hash of the names
+	NameHash uint32
+}
+
+// Reachability answers walks without reading every object, such as from reachability
+// bitmaps
+type Reachability interface {
+	// Reachable returns the objects reachable from wants but not from haves. ok is false
+	// when some of the objects are not covered, in which case the objects are walked.
+	Reachable(wants [][]byte, haves [][]byte) (entries []Entry, ok bool, err error)
+	// Unreachable returns the ids that are not reachable from tips, ok is false as for
+	// Reachable
+	Unreachable(tips [][]byte, ids [][]byte) (unreachable [][]byte, ok bool, err error)
+}
+
+// Options tune a walk
+type Options struct {
+	// Shallow commits are roots of the history, their parents are not walked
+	Shallow [][]byte
+	// Filter leaves objects out of the result, nil keeps every object
+	Filter *Filter
+	// Bitmaps answer the walk when they cover it and there is neither a shallow commit nor
+	// a filter, nil always walks
+	Bitmaps Reachability
+}
+
+type walker struct {
+	db      ObjectReader
+	seen    map[string]bool
+	shallow map[string]bool
+	filter  *Filter
+	// treeDepths holds the smallest depth each tree was walked at when filtering by depth
+	treeDepths map[string]int
+	entries    []Entry
+	// When collect is false objects are only marked as seen
+	collect bool
+	// targets, when set, are the objects left to find before the walk ends
+	targets map[string]bool
+}
+
+// Objects returns all objects reachable from wants but not reachable from haves.
+// Commits and tags are returned before trees and blobs. haves that do not exist in db are ignored.
+func Objects(db ObjectReader, wants [][]byte, haves [][]byte, options Options) ([]Entry, error) {
+	if options.Bitmaps != nil && len(options.Shallow) == 0 && options.Filter == nil {
+		entries, ok, err := options.Bitmaps.Reachable(wants, haves)
+		if err != nil || ok {
+			return entries, err
+		}
+	}
+	w := &walker{
+		db:         db,
+		seen:       map[string]bool{},
+		shallow:    idSet(options.Shallow),
+		treeDepths: map[string]int{},
+	}
+	for _, have := range haves {
+		ok, err := db.ObjectExist(have)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		if err := w.walk(have); err != nil {
+			return nil, err
+		}
+	}
+	w.collect = true
+	// The filter only applies to the objects sent, every object reachable from the haves is known
+	w.filter = options.Filter
+	for _, want := range wants {
+		if err := w.walk(want); err != nil {
+			return nil, err
+		}
+	}
+	return w.entries, nil
+}
+
+// CheckConnected verifies that every object reachable from wants is in db, such as the
+// objects of a fetched pack before the refs pointing to them are updated. The walk stops at
+// the objects reachable from haves and at the shallow commits.
+func CheckConnected(db ObjectReader, wants [][]byte, haves [][]byte, shallow [][]byte) error {
+	entries, err := Objects(db, wants, haves, Options{Shallow: shallow})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNotConnected, err)
+	}
+	// Blobs are not read by the walk
+	for _, entry := range entries {
+		if entry.Type != common.OBJ_BLOB {
+			continue
+		}
+		ok, err := db.ObjectExist(entry.Checksum)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w: blob %s", ErrNotConnected, hash.ChecksumToHex(entry.Checksum))
+		}
+	}
+	return nil
+}
+
+// Unreachable returns the ids that are not reachable from tips. The walk ends as soon as
+// every id is found, before reading any tree when the ids are commits.
+func Unreachable(db ObjectReader, tips [][]byte, ids [][]byte, options Options) ([][]byte, error) {
+	if options.Bitmaps != nil && len(options.Shallow) == 0 {
+		unreachable, ok, err := options.Bitmaps.Unreachable(tips, ids)
+		if err != nil || ok {
+			return unreachable, err
+		}
+	}
+	w := &walker{
+		db:         db,
+		seen:       map[string]bool{},
+		shallow:    idSet(options.Shallow),
+		treeDepths: map[string]int{},
+		targets:    idSet(ids),
+	}
+	for _, tip := range tips {
+		if w.done() {
+			break
+		}
+		if err := w.walk(tip); err != nil {
+			return nil, err
+		}
+	}
+	unreachable := [][]byte{}
+	for _, id := range ids {
+		if w.targets[string(id)] {
+			unreachable = append(unreachable, id)
+		}
+	}
+	return unreachable, nil
+}
+
+// walk visits id and everything reachable from it. Commits are visited first
+// and their trees afterward so that commits are grouped together.
+func (w *walker) walk(id []byte) error {
+	var trees, explicit []Entry
+	stack := [][]byte{id}
+	for len(stack) > 0 && !w.done() {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if w.seen[string(cur)] {
+			continue
+		}
+		// Commits of the commit-graph are walked without reading them
+		commit, ok, err := lookupCommit(w.db, cur)
+		if err != nil {
+			return err
+		}
+		if ok {
+			w.add(Entry{Checksum: cur, Type: common.OBJ_COMMIT})
+			trees = append(trees, Entry{Checksum: commit.Tree, Type: common.OBJ_TREE})
+			if !w.shallow[string(cur)] {
+				stack = append(stack, commit.Parents...)
+			}
+			continue
+		}
+		encodedObject, err := w.db.Object(cur)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", hash.ChecksumToHex(cur), err)
+		}
+		switch encodedObject.Type() {
+		case common.OBJ_COMMIT:
+			w.add(Entry{Checksum: cur, Type: common.OBJ_COMMIT})
+			commit, err := object.DecodeCommit(encodedObject)
+			if err != nil {
+				return err
+			}
+			trees = append(trees, Entry{Checksum: commit.Tree(), Type: common.OBJ_TREE})
+			if !w.shallow[string(cur)] {
+				stack = append(stack, commit.Parents()...)
+			}
+		case common.OBJ_TAG:
+			w.add(Entry{Checksum: cur, Type: common.OBJ_TAG})
+			tag, err := object.DecodeTag(encodedObject)
+			if err != nil {
+				return err
+			}
+			if tag.ObjectType() == common.OBJ_COMMIT || tag.ObjectType() == common.OBJ_TAG {
+				stack = append(stack, tag.Object())
+			} else {
+				explicit = append(explicit, Entry{Checksum: tag.Object(), Type: tag.ObjectType()})
+			}
+		case common.OBJ_TREE:
+			explicit = append(explicit, Entry{Checksum: cur, Type: common.OBJ_TREE})
+		case common.OBJ_BLOB:
+			w.add(Entry{Checksum: cur, Type: common.OBJ_BLOB})
+		}
+	}
+	// Trees and blobs named explicitly are kept whatever the filter
+	for _, entry := range explicit {
+		if w.done() {
+			return nil
+		}
+		if entry.Type == common.OBJ_BLOB {
+			w.add(entry)
+			continue
+		}
+		if err := w.walkTree(entry.Checksum, entry.Name, 0, true); err != nil {
+			return err
+		}
+	}
+	for _, tree := range trees {
+		if w.done() {
+			return nil
+		}
+		if err := w.walkTree(tree.Checksum, tree.Name, 0, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTree visits a tree found at depth and everything below it that passes the filter.
+// The entries below the tree are named by their path under name.
+func (w *walker) walkTree(id []byte, name string, depth int, explicit bool) error {
+	if !explicit && !w.filter.keepTree(depth) {
+		return nil
+	}
+	if w.seen[string(id)] {
+		// A tree cut short by a depth filter is walked again when found at a smaller depth
+		if minDepth, ok := w.treeDepths[string(id)]; !ok || minDepth <= depth || !w.filter.limitsDepth() {
+			return nil
+		}
+	}
+	w.treeDepths[string(id)] = depth
+	w.add(Entry{Checksum: id, Type: common.OBJ_TREE, Name: name})
+	encodedObject, err := w.db.Object(id)
+	if err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", hash.ChecksumToHex(id), err)
+	}
+	tree, err := object.DecodeTree(encodedObject)
+	if err != nil {
+		return err
+	}
+	entryIter := tree.TreeIter()
+	for !w.done() {
+		entry, ok := entryIter.Next()
+		if !ok {
+			break
+		}
+		// Submodule commits live in another repository
+		if entry.Mode == filemode.Submodule {
+			continue
+		}
+		if entry.Type() == common.OBJ_TREE {
+			if err := w.walkTree(entry.Checksum, path.Join(name, entry.Name), depth+1, false); err != nil {
+				return err
+			}
+			continue
+		}
+		keep, err := w.keepBlob(entry.Checksum, depth+1)
+		if err != nil {
+			return err
+		}
+		if keep {
+			w.add(Entry{Checksum: entry.Checksum, Type: common.OBJ_BLOB, Name: path.Join(name, entry.Name)})
+		}
+	}
+	return nil
+}
+
+func (w *walker) keepBlob(id []byte, depth int) (bool, error) {
+	if w.seen[string(id)] || !w.filter.needsBlobSize() {
+		return w.filter.keepBlob(0, depth), nil
+	}
+	encodedObject, err := w.db.Object(id)
+	if err != nil {
+		return false, fmt.Errorf("failed to read blob %s: %w", hash.ChecksumToHex(id), err)
+	}
+	return w.filter.keepBlob(encodedObject.Size(), depth), nil
+}
+
+func (w *walker) add(entry Entry) {
+	if w.seen[string(entry.Checksum)] {
+		return
+	}
+	w.seen[string(entry.Checksum)] = true
+	delete(w.targets, string(entry.Checksum))
+	if w.collect {
+		w.entries = append(w.entries, entry)
+	}
+}
+
+// done reports whether every target of the walk is found
+func (w *walker) done() bool {
+	return w.targets != nil && len(w.targets) == 0
+}
+
+func idSet(ids [][]byte) map[string]bool {
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		set[string(id)] = true
+	}
+	return set
+}
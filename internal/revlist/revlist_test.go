@@ -0,0 +1,98 @@
+package revlist
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+)
+
+// memoryObject is the type and content of an object held in memory
+type memoryObject struct {
+	objectType common.ObjectType
+	content    []byte
+}
+
+// memoryDB holds objects in memory by id
+type memoryDB map[string]memoryObject
+
+func (db memoryDB) add(objectType common.ObjectType, content []byte) []byte {
+	id, _ := common.NewObjectBuffer(objectType, content).Hash()
+	db[string(id)] = memoryObject{objectType: objectType, content: content}
+	return id
+}
+
+// Object returns a new reader of the object, as an object is read once
+func (db memoryDB) Object(id []byte) (common.Object, error) {
+	object, ok := db[string(id)]
+	if !ok {
+		return nil, fmt.Errorf("object %x not found", id)
+	}
+	return common.NewObjectBuffer(object.objectType, object.content), nil
+}
+
+func (db memoryDB) ObjectExist(id []byte) (bool, error) {
+	_, ok := db[string(id)]
+	return ok, nil
+}
+
+// storeNestedTree stores a commit of a tree with a file top and a file at a/b/c
+func storeNestedTree(db memoryDB) (commit []byte, root []byte, c []byte) {
+	c = db.add(common.OBJ_BLOB, []byte("1\n"))
+	top := db.add(common.OBJ_BLOB, []byte("2\n"))
+	b := db.add(common.OBJ_TREE, append([]byte("100644 c\x00"), c...))
+	a := db.add(common.OBJ_TREE, append([]byte("40000 b\x00"), b...))
+	root = db.add(common.OBJ_TREE, append(append(append([]byte("40000 a\x00"), a...), "100644 top\x00"...), top...))
+	commit = db.add(common.OBJ_COMMIT, []byte(fmt.Sprintf(
+		"tree %x\nauthor A <a@example.com> 1000 +0000\ncommitter A <a@example.com> 1000 +0000\n\ncommit\n", root)))
+	return commit, root, c
+}
+
+func TestObjectsPaths(t *testing.T) {
+	db := memoryDB{}
+	commit, _, _ := storeNestedTree(db)
+	entries, err := Objects(db, [][]byte{commit}, nil, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The objects of git rev-list --objects for the same tree
+	expected := []string{
+		hash.ChecksumToHex(commit) + " ",
+		"cfe796730d3f80e84ac8e048905b3f5bf67df691 ",
+		"d9a35f6326ee03b7c60318a66a0b2fa8c1d2f870 a",
+		"25e870ca1943b82e36a8b8095fdce66a4844107f a/b",
+		"d00491fd7e5bb6fa28c517a0bb32b8b506539d4d a/b/c",
+		"0cfbf08886fca9a91cb753ec8734c84fcbe52c9f top",
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected: %d objects\tactual: %d objects", len(expected), len(entries))
+	}
+	for i, entry := range entries {
+		if actual := hash.ChecksumToHex(entry.Checksum) + " " + entry.Name; actual != expected[i] {
+			t.Fatalf("expected: %q\tactual: %q", expected[i], actual)
+		}
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	db := memoryDB{}
+	commit, root, c := storeNestedTree(db)
+	child := db.add(common.OBJ_COMMIT, []byte(fmt.Sprintf(
+		"tree %x\nparent %x\nauthor A <a@example.com> 1000 +0000\ncommitter A <a@example.com> 1000 +0000\n\nchild\n", root, commit)))
+	missing := bytes.Repeat([]byte{1}, 20)
+	unreachable, err := Unreachable(db, [][]byte{child}, [][]byte{c, missing}, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unreachable) != 1 || !bytes.Equal(unreachable[0], missing) {
+		t.Fatalf("expected: %x unreachable\tactual: %x", missing, unreachable)
+	}
+
+	// The walk ends once the commits are found, without reading the trees
+	delete(db, string(root))
+	if unreachable, err := Unreachable(db, [][]byte{child}, [][]byte{commit}, Options{}); err != nil || len(unreachable) != 0 {
+		t.Fatalf("expected: %x reachable\tactual: %x %v", commit, unreachable, err)
+	}
+}
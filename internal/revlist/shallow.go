@@ -0,0 +1,201 @@
+package revlist
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	object "github.com/codecrafters-io/git-starter-go/internal/obj"
+)
+
+var (
+	ErrNoShallowCommits = errors.New("no commits selected for shallow request")
+)
+
+// Cut is a history cut short. Boundary holds the kept commits whose parents are not kept.
+type Cut struct {
+	Boundary [][]byte
+	kept     map[string]bool
+}
+
+// Kept reports whether the commit id is part of the history that was kept
+func (c *Cut) Kept(id []byte) bool {
+	return c.kept[string(id)]
+}
+
+// ShallowByDepth returns the shallow boundary of the history of starts cut at depth commits,
+// where the starts themselves are at depth 1. The boundary is made of the commits at the cut
+// that have parents. Commits in shallow, the boundary of db itself, are never walked past.
+func ShallowByDepth(db ObjectReader, starts [][]byte, shallow [][]byte, depth int) (*Cut, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid depth %d", depth)
+	}
+	dbShallow := idSet(shallow)
+	seen := map[string]bool{}
+	var boundary [][]byte
+	// A breadth first walk reaches every commit at its smallest depth first
+	level, err := peelCommits(db, starts)
+	if err != nil {
+		return nil, err
+	}
+	for d := 1; len(level) > 0; d++ {
+		var next [][]byte
+		for _, id := range level {
+			if seen[string(id)] {
+				continue
+			}
+			seen[string(id)] = true
+			commit, err := ReadCommit(db, id)
+			if err != nil {
+				return nil, err
+			}
+			if len(commit.Parents) == 0 || dbShallow[string(id)] {
+				continue
+			}
+			if d == depth {
+				boundary = append(boundary, id)
+				continue
+			}
+			next = append(next, commit.Parents...)
+		}
+		level = next
+	}
+	return &Cut{Boundary: boundary, kept: seen}, nil
+}
+
+// ShallowSince returns the shallow boundary of the history of starts that keeps the
+// commits committed at or after since
+func ShallowSince(db ObjectReader, starts [][]byte, shallow [][]byte, since time.Time) (*Cut, error) {
+	return shallowBoundary(db, starts, shallow, func(id []byte, commit *Commit) bool {
+		return !commit.Date.Before(since)
+	})
+}
+
+// ShallowExclude returns the shallow boundary of the history of starts that keeps the
+// commits not reachable from excludes
+func ShallowExclude(db ObjectReader, starts [][]byte, shallow [][]byte, excludes [][]byte) (*Cut, error) {
+	excluded := map[string]bool{}
+	dbShallow := idSet(shallow)
+	stack, err := peelCommits(db, excludes)
+	if err != nil {
+		return nil, err
+	}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if excluded[string(id)] {
+			continue
+		}
+		excluded[string(id)] = true
+		if dbShallow[string(id)] {
+			continue
+		}
+		commit, err := ReadCommit(db, id)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, commit.Parents...)
+	}
+	return shallowBoundary(db, starts, shallow, func(id []byte, commit *Commit) bool {
+		return !excluded[string(id)]
+	})
+}
+
+// shallowBoundary walks the commits of starts that are kept and returns those with a parent that is not kept
+func shallowBoundary(db ObjectReader, starts [][]byte, shallow [][]byte, keep func([]byte, *Commit) bool) (*Cut, error) {
+	dbShallow := idSet(shallow)
+	seen := map[string]bool{}
+	kept := map[string]bool{}
+	var boundary [][]byte
+	stack, err := peelCommits(db, starts)
+	if err != nil {
+		return nil, err
+	}
+	isKept := func(id []byte) (bool, error) {
+		if ok, found := kept[string(id)]; found {
+			return ok, nil
+		}
+		commit, err := ReadCommit(db, id)
+		if err != nil {
+			return false, err
+		}
+		kept[string(id)] = keep(id, commit)
+		return kept[string(id)], nil
+	}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[string(id)] {
+			continue
+		}
+		seen[string(id)] = true
+		ok, err := isKept(id)
+		if err != nil {
+			return nil, err
+		}
+		if !ok || dbShallow[string(id)] {
+			continue
+		}
+		commit, err := ReadCommit(db, id)
+		if err != nil {
+			return nil, err
+		}
+		isBoundary := false
+		for _, parent := range commit.Parents {
+			ok, err := isKept(parent)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				stack = append(stack, parent)
+			} else {
+				isBoundary = true
+			}
+		}
+		if isBoundary {
+			boundary = append(boundary, id)
+		}
+	}
+	for _, ok := range kept {
+		if ok {
+			return &Cut{Boundary: boundary, kept: kept}, nil
+		}
+	}
+	return nil, ErrNoShallowCommits
+}
+
+// peelCommits resolves tags to the commits they point to. Objects that are not commits are dropped.
+func peelCommits(db ObjectReader, ids [][]byte) ([][]byte, error) {
+	var commits [][]byte
+	for _, id := range ids {
+		for {
+			_, ok, err := lookupCommit(db, id)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				commits = append(commits, id)
+				break
+			}
+			encodedObject, err := db.Object(id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", hash.ChecksumToHex(id), err)
+			}
+			if encodedObject.Type() == common.OBJ_COMMIT {
+				commits = append(commits, id)
+				break
+			}
+			if encodedObject.Type() != common.OBJ_TAG {
+				break
+			}
+			tag, err := object.DecodeTag(encodedObject)
+			if err != nil {
+				return nil, err
+			}
+			id = tag.Object()
+		}
+	}
+	return commits, nil
+}
@@ -0,0 +1,173 @@
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+const (
+	// INFO_PREFIX holds the files describing the objects of the repository
+	INFO_PREFIX = "objects/info"
+	// COMMIT_GRAPH lists the commits of the repository along with their parents, trees and
+	// dates so that walks do not need to read the commits
+	COMMIT_GRAPH = "commit-graph"
+	// COMMIT_GRAPHS_DIR holds the layers of a split commit-graph, each layer holding the
+	// commits that are not in the layers below it
+	COMMIT_GRAPHS_DIR = "objects/info/commit-graphs"
+	// COMMIT_GRAPH_CHAIN lists the layers of the split commit-graph from the bottom one
+	COMMIT_GRAPH_CHAIN = "commit-graph-chain"
+	TMP_GRAPH_PREFIX   = "tmp_graph_"
+)
+
+type CommitGraphFile struct {
+	file
+}
+
+// Rename gives the commit-graph its name, there is a single one per repository
+func (f *CommitGraphFile) Rename(string) error {
+	return f.file.Rename(path.Join(path.Dir(f.Name()), COMMIT_GRAPH))
+}
+
+// NewCommitGraphWriter creates a temporary commit-graph, which replaces the current one
+// once renamed
+func (store *FSStore) NewCommitGraphWriter() (WriteReadFile, error) {
+	f, err := store.openTemporaryFile(INFO_PREFIX, TMP_GRAPH_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &CommitGraphFile{file{f}}, nil
+}
+
+// NewCommitGraphReader opens the commit-graph, which may not exist
+func (store *FSStore) NewCommitGraphReader() (ReadOnlyFile, error) {
+	f, err := os.Open(path.Join(store.rootDir, INFO_PREFIX, COMMIT_GRAPH))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader[*CommitGraphFile]{
+		file: &CommitGraphFile{file{f}},
+	}, nil
+}
+
+// RemoveCommitGraph deletes the commit-graph if there is one
+func (store *FSStore) RemoveCommitGraph() error {
+	err := os.Remove(path.Join(store.rootDir, INFO_PREFIX, COMMIT_GRAPH))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// CommitGraphLayerFile is a layer of the split commit-graph, named after its checksum
+type CommitGraphLayerFile struct {
+	file
+}
+
+func (f *CommitGraphLayerFile) Rename(checksum string) error {
+	return f.file.Rename(path.Join(path.Dir(f.Name()), fmt.Sprintf("graph-%s.graph", checksum)))
+}
+
+// NewCommitGraphLayerWriter creates a temporary layer of the split commit-graph, renamed
+// after its checksum once complete. The layer is only used once listed by the chain.
+func (store *FSStore) NewCommitGraphLayerWriter() (WriteReadFile, error) {
+	f, err := store.openTemporaryFile(COMMIT_GRAPHS_DIR, TMP_GRAPH_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &CommitGraphLayerFile{file{f}}, nil
+}
+
+func (store *FSStore) NewCommitGraphLayerReader(checksum string) (ReadOnlyFile, error) {
+	f, err := os.Open(path.Join(store.rootDir, COMMIT_GRAPHS_DIR, fmt.Sprintf("graph-%s.graph", checksum)))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader[*CommitGraphLayerFile]{
+		file: &CommitGraphLayerFile{file{f}},
+	}, nil
+}
+
+// ReadCommitGraphChain returns the checksums of the layers of the split commit-graph
+// from the bottom one. An error satisfying os.IsNotExist is returned when the
+// commit-graph is not split.
+func (store *FSStore) ReadCommitGraphChain() ([]string, error) {
+	f, err := os.Open(path.Join(store.rootDir, COMMIT_GRAPHS_DIR, COMMIT_GRAPH_CHAIN))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	checksums := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			checksums = append(checksums, line)
+		}
+	}
+	return checksums, scanner.Err()
+}
+
+// WriteCommitGraphChain replaces the chain of the split commit-graph with the layers
+// given from the bottom one
+func (store *FSStore) WriteCommitGraphChain(checksums []string) error {
+	f, err := store.openTemporaryFile(COMMIT_GRAPHS_DIR, TMP_GRAPH_PREFIX)
+	if err != nil {
+		return err
+	}
+	chain := &file{f}
+	for _, checksum := range checksums {
+		if _, err := fmt.Fprintln(chain, checksum); err != nil {
+			chain.Remove()
+			return err
+		}
+	}
+	if err := chain.Sync(); err != nil {
+		chain.Remove()
+		return err
+	}
+	if err := chain.Close(); err != nil {
+		chain.Remove()
+		return err
+	}
+	return chain.Rename(path.Join(path.Dir(chain.Name()), COMMIT_GRAPH_CHAIN))
+}
+
+// PruneCommitGraphLayers removes the layers of the split commit-graph that are not
+// listed in chain. An empty chain removes the chain itself along with every layer.
+func (store *FSStore) PruneCommitGraphLayers(chain []string) error {
+	dir := path.Join(store.rootDir, COMMIT_GRAPHS_DIR)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		remove := name == COMMIT_GRAPH_CHAIN && len(chain) == 0
+		if checksum, ok := strings.CutPrefix(name, "graph-"); ok {
+			checksum, ok = strings.CutSuffix(checksum, ".graph")
+			remove = ok && !slices.Contains(chain, checksum)
+		}
+		if !remove {
+			continue
+		}
+		if err := os.Remove(path.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// openTemporaryFile creates a temporary file in dir, which is created if needed
+func (store *FSStore) openTemporaryFile(dir string, tmpPrefix string) (*os.File, error) {
+	dir = path.Join(store.rootDir, dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, tmpPrefix)
+}
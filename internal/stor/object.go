@@ -3,10 +3,14 @@ package store
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"io/fs"
 	"os"
 	"path"
 )
 
+// TMP_OBJECT_DIR holds the loose objects being written, renamed once complete
+const TMP_OBJECT_DIR = "temp"
+
 type ObjectFile struct {
 	file
 }
@@ -36,7 +40,7 @@ func (store *FSStore) ObjectWriter(checksum string) (WriteReadFile, error) {
 		if _, err := rand.Read(b); err != nil {
 			return nil, err
 		}
-		p = path.Join(p, "temp", hex.EncodeToString(b))
+		p = path.Join(p, TMP_OBJECT_DIR, hex.EncodeToString(b))
 	} else {
 		p = path.Join(p, checksum[:2], checksum[2:])
 	}
@@ -51,3 +55,49 @@ func (store *FSStore) ObjectWriter(checksum string) (WriteReadFile, error) {
 	}
 	return &ObjectFile{file{_file}}, nil
 }
+
+// ListObjects returns the checksums of the loose objects
+func (store *FSStore) ListObjects() ([]string, error) {
+	dirs, err := os.ReadDir(path.Join(store.rootDir, OBJECT_PREFIX))
+	if err != nil {
+		return nil, err
+	}
+	checksums := []string{}
+	for _, dir := range dirs {
+		// Objects are spread over directories named after the first byte of their checksum
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		if _, err := hex.DecodeString(dir.Name()); err != nil {
+			continue
+		}
+		entries, err := os.ReadDir(path.Join(store.rootDir, OBJECT_PREFIX, dir.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			checksum := dir.Name() + entry.Name()
+			if _, err := hex.DecodeString(checksum); entry.IsDir() || err != nil || len(checksum) != 40 {
+				continue
+			}
+			checksums = append(checksums, checksum)
+		}
+	}
+	return checksums, nil
+}
+
+// RemoveObject deletes a loose object, along with its directory once empty
+func (store *FSStore) RemoveObject(checksum string) error {
+	objectPath := path.Join(store.rootDir, OBJECT_PREFIX, checksum[:2], checksum[2:])
+	if err := os.Remove(objectPath); err != nil {
+		return err
+	}
+	// The directory is only removed when no other object is left in it
+	os.Remove(path.Dir(objectPath))
+	return nil
+}
+
+// StatObject returns the size of the loose object and the time it was written
+func (store *FSStore) StatObject(checksum string) (fs.FileInfo, error) {
+	return os.Stat(path.Join(store.rootDir, OBJECT_PREFIX, checksum[:2], checksum[2:]))
+}
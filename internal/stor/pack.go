@@ -2,12 +2,26 @@ package store
 
 import (
 	"fmt"
+	"io/fs"
 	"os"
 	"path"
 	"strings"
 	"time"
 )
 
+const (
+	// TMP_PACK_PREFIX and TMP_IDX_PREFIX name the files of a pack being written. They are
+	// renamed once complete, so that an interrupted write never leaves a pack in use.
+	TMP_PACK_PREFIX   = "tmp_pack_"
+	TMP_IDX_PREFIX    = "tmp_idx_"
+	TMP_MTIMES_PREFIX = "tmp_mtimes_"
+	TMP_MIDX_PREFIX   = "tmp_midx_"
+	TMP_BITMAP_PREFIX = "tmp_bitmap_"
+	TMP_REV_PREFIX    = "tmp_rev_"
+	// MULTI_PACK_INDEX indexes the objects of several packs of the pack directory at once
+	MULTI_PACK_INDEX = "multi-pack-index"
+)
+
 type PackFile struct {
 	file
 }
@@ -21,14 +35,13 @@ type PackIndexFile struct {
 }
 
 func (f *PackIndexFile) Rename(checksome string) error {
-	return f.file.Rename(fmt.Sprintf("pack-%s.idx", checksome))
+	return f.file.Rename(path.Join(path.Dir(f.Name()), fmt.Sprintf("pack-%s.idx", checksome)))
 }
 
+// NewPackWriter creates the pack named key. Without a key the pack is written to a
+// temporary file, renamed after its checksum once complete.
 func (store *FSStore) NewPackWriter(key string) (WriteReadFile, error) {
-	if key == "" {
-		key = fmt.Sprintf("%d", time.Now().Unix())
-	}
-	f, err := os.OpenFile(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.pack", key)), os.O_RDWR|os.O_CREATE, 0644)
+	f, err := store.openPackFile(key, "pack-%s.pack", TMP_PACK_PREFIX)
 	if err != nil {
 		return nil, err
 	}
@@ -47,11 +60,9 @@ func (store *FSStore) NewPackReader(checksum string) (ReadOnlyFile, error) {
 	}, nil
 }
 
+// NewPackIndexWriter creates the index of the pack named key, or a temporary index without a key
 func (store *FSStore) NewPackIndexWriter(key string) (WriteReadFile, error) {
-	if key == "" {
-		key = fmt.Sprintf("%d", time.Now().Unix())
-	}
-	f, err := os.OpenFile(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.idx", key)), os.O_RDWR|os.O_CREATE, 0644)
+	f, err := store.openPackFile(key, "pack-%s.idx", TMP_IDX_PREFIX)
 	if err != nil {
 		return nil, err
 	}
@@ -60,6 +71,196 @@ func (store *FSStore) NewPackIndexWriter(key string) (WriteReadFile, error) {
 	}, nil
 }
 
+type PackMtimesFile struct {
+	file
+}
+
+func (f *PackMtimesFile) Rename(checksome string) error {
+	return f.file.Rename(path.Join(path.Dir(f.Name()), fmt.Sprintf("pack-%s.mtimes", checksome)))
+}
+
+// NewPackMtimesWriter creates the mtimes file of the cruft pack named key, or a temporary
+// file without a key
+func (store *FSStore) NewPackMtimesWriter(key string) (WriteReadFile, error) {
+	f, err := store.openPackFile(key, "pack-%s.mtimes", TMP_MTIMES_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &PackMtimesFile{
+		file{f},
+	}, nil
+}
+
+// NewPackMtimesReader opens the mtimes file of a cruft pack. Packs that are not cruft
+// packs have none.
+func (store *FSStore) NewPackMtimesReader(checksum string) (ReadOnlyFile, error) {
+	f, err := os.Open(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.mtimes", checksum)))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader[*PackMtimesFile]{
+		file: &PackMtimesFile{file{f}},
+	}, nil
+}
+
+type PackBitmapFile struct {
+	file
+}
+
+func (f *PackBitmapFile) Rename(checksome string) error {
+	return f.file.Rename(path.Join(path.Dir(f.Name()), fmt.Sprintf("pack-%s.bitmap", checksome)))
+}
+
+// NewPackBitmapWriter creates the reachability bitmaps of the pack named key, or a
+// temporary file without a key
+func (store *FSStore) NewPackBitmapWriter(key string) (WriteReadFile, error) {
+	f, err := store.openPackFile(key, "pack-%s.bitmap", TMP_BITMAP_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &PackBitmapFile{
+		file{f},
+	}, nil
+}
+
+// NewPackBitmapReader opens the reachability bitmaps of a pack, which may have none
+func (store *FSStore) NewPackBitmapReader(checksum string) (ReadOnlyFile, error) {
+	f, err := os.Open(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.bitmap", checksum)))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader[*PackBitmapFile]{
+		file: &PackBitmapFile{file{f}},
+	}, nil
+}
+
+type MultiPackIndexFile struct {
+	file
+}
+
+// Rename gives the multi-pack-index its name, there is a single one per repository
+func (f *MultiPackIndexFile) Rename(string) error {
+	return f.file.Rename(path.Join(path.Dir(f.Name()), MULTI_PACK_INDEX))
+}
+
+// NewMultiPackIndexWriter creates a temporary multi-pack-index, which replaces the
+// current one once renamed
+func (store *FSStore) NewMultiPackIndexWriter() (WriteReadFile, error) {
+	f, err := store.openPackFile("", "", TMP_MIDX_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiPackIndexFile{
+		file{f},
+	}, nil
+}
+
+// NewMultiPackIndexReader opens the multi-pack-index, which may not exist
+func (store *FSStore) NewMultiPackIndexReader() (ReadOnlyFile, error) {
+	f, err := os.Open(path.Join(store.rootDir, PACK_PREFIX, MULTI_PACK_INDEX))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader[*MultiPackIndexFile]{
+		file: &MultiPackIndexFile{file{f}},
+	}, nil
+}
+
+// RemoveMultiPackIndex deletes the multi-pack-index if there is one, along with its
+// bitmaps and reverse index
+func (store *FSStore) RemoveMultiPackIndex() error {
+	err := os.Remove(path.Join(store.rootDir, PACK_PREFIX, MULTI_PACK_INDEX))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return store.PruneMultiPackIndexFiles("")
+}
+
+// MultiPackIndexAuxFile is a file describing the multi-pack-index whose checksum it is
+// named after, such as its bitmaps
+type MultiPackIndexAuxFile struct {
+	file
+	ext string
+}
+
+func (f *MultiPackIndexAuxFile) Rename(checksum string) error {
+	return f.file.Rename(path.Join(path.Dir(f.Name()), fmt.Sprintf("%s-%s.%s", MULTI_PACK_INDEX, checksum, f.ext)))
+}
+
+// NewMultiPackIndexBitmapWriter creates temporary reachability bitmaps, renamed after the
+// checksum of the multi-pack-index they describe
+func (store *FSStore) NewMultiPackIndexBitmapWriter() (WriteReadFile, error) {
+	f, err := store.openPackFile("", "", TMP_BITMAP_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiPackIndexAuxFile{file{f}, "bitmap"}, nil
+}
+
+// NewMultiPackIndexBitmapReader opens the reachability bitmaps of the multi-pack-index,
+// which may have none
+func (store *FSStore) NewMultiPackIndexBitmapReader(checksum string) (ReadOnlyFile, error) {
+	return store.openMultiPackIndexAuxFile(checksum, "bitmap")
+}
+
+// NewMultiPackIndexRevWriter creates a temporary reverse index, which orders the objects
+// of the multi-pack-index as its bitmaps do
+func (store *FSStore) NewMultiPackIndexRevWriter() (WriteReadFile, error) {
+	f, err := store.openPackFile("", "", TMP_REV_PREFIX)
+	if err != nil {
+		return nil, err
+	}
+	return &MultiPackIndexAuxFile{file{f}, "rev"}, nil
+}
+
+// NewMultiPackIndexRevReader opens the reverse index of the multi-pack-index
+func (store *FSStore) NewMultiPackIndexRevReader(checksum string) (ReadOnlyFile, error) {
+	return store.openMultiPackIndexAuxFile(checksum, "rev")
+}
+
+func (store *FSStore) openMultiPackIndexAuxFile(checksum string, ext string) (ReadOnlyFile, error) {
+	f, err := os.Open(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("%s-%s.%s", MULTI_PACK_INDEX, checksum, ext)))
+	if err != nil {
+		return nil, err
+	}
+	return &fileReader[*MultiPackIndexAuxFile]{
+		file: &MultiPackIndexAuxFile{file{f}, ext},
+	}, nil
+}
+
+// PruneMultiPackIndexFiles removes the bitmaps and reverse indexes of the
+// multi-pack-indexes other than the one whose checksum is given
+func (store *FSStore) PruneMultiPackIndexFiles(checksum string) error {
+	entries, err := os.ReadDir(path.Join(store.rootDir, PACK_PREFIX))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		name, ok := strings.CutPrefix(entry.Name(), MULTI_PACK_INDEX+"-")
+		if !ok || checksum != "" && strings.HasPrefix(name, checksum+".") {
+			continue
+		}
+		if err := os.Remove(path.Join(store.rootDir, PACK_PREFIX, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (store *FSStore) openPackFile(key string, format string, tmpPrefix string) (*os.File, error) {
+	dir := path.Join(store.rootDir, PACK_PREFIX)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if key == "" {
+		return os.CreateTemp(dir, tmpPrefix)
+	}
+	return os.OpenFile(path.Join(dir, fmt.Sprintf(format, key)), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+}
+
 func (store *FSStore) NewPackIndexReader(checksum string) (ReadOnlyFile, error) {
 	f, err := os.OpenFile(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.idx", checksum)), os.O_RDONLY, 0644)
 	if err != nil {
@@ -99,3 +300,112 @@ func (store *FSStore) ListPacks() ([]string, error) {
 	}
 	return packFiles, nil
 }
+
+// WritePackPromisor marks a pack as fetched from a promisor remote. Objects referenced by
+// a promisor pack but missing from the store can be fetched again from that remote.
+func (store *FSStore) WritePackPromisor(checksum string) error {
+	f, err := os.OpenFile(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.promisor", checksum)), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// IsPackPromisor reports whether a pack was fetched from a promisor remote
+func (store *FSStore) IsPackPromisor(checksum string) (bool, error) {
+	_, err := os.Stat(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.promisor", checksum)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// WritePackKeep marks a pack to be kept by repack, which neither rewrites its objects nor
+// removes it. The pack does not need to exist yet, so that it is kept as soon as it is
+// published. The message says why the pack is kept.
+func (store *FSStore) WritePackKeep(checksum string, message string) error {
+	return os.WriteFile(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.keep", checksum)), []byte(message+"\n"), 0644)
+}
+
+// IsPackKept reports whether a pack is marked to be kept by repack
+func (store *FSStore) IsPackKept(checksum string) (bool, error) {
+	_, err := os.Stat(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.keep", checksum)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// RemovePackKeep lets repack rewrite and remove a pack again
+func (store *FSStore) RemovePackKeep(checksum string) error {
+	err := os.Remove(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.keep", checksum)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// StatPack returns the size of the pack and the time it was written
+func (store *FSStore) StatPack(checksum string) (fs.FileInfo, error) {
+	return os.Stat(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.pack", checksum)))
+}
+
+// StatPackIndex returns the size of the pack index and the time it was written
+func (store *FSStore) StatPackIndex(checksum string) (fs.FileInfo, error) {
+	return os.Stat(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.idx", checksum)))
+}
+
+// RemovePack deletes a pack along with the files describing it. The index is removed
+// first so that readers stop looking for objects in the pack before it disappears. The
+// .keep of the pack is left alone, as whoever wrote it is still relying on the pack.
+func (store *FSStore) RemovePack(checksum string) error {
+	for _, ext := range []string{"idx", "pack", "promisor", "mtimes", "rev", "bitmap"} {
+		err := os.Remove(path.Join(store.rootDir, PACK_PREFIX, fmt.Sprintf("pack-%s.%s", checksum, ext)))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveTemporaryFiles deletes the temporary files of packs and objects last modified
+// before expiry, left behind by interrupted writes, and returns how many were removed.
+func (store *FSStore) RemoveTemporaryFiles(expiry time.Time) (int, error) {
+	removed := 0
+	for _, dir := range []string{PACK_PREFIX, path.Join(OBJECT_PREFIX, TMP_OBJECT_DIR), INFO_PREFIX, COMMIT_GRAPHS_DIR} {
+		entries, err := os.ReadDir(path.Join(store.rootDir, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return removed, err
+		}
+		for _, entry := range entries {
+			// Only the temporary object directory holds nothing but temporary files
+			if entry.IsDir() || dir != path.Join(OBJECT_PREFIX, TMP_OBJECT_DIR) && !strings.HasPrefix(entry.Name(), "tmp_") {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return removed, err
+			}
+			if !info.ModTime().Before(expiry) {
+				continue
+			}
+			if err := os.Remove(path.Join(store.rootDir, dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LOGS_PREFIX holds the reflogs, logs/<ref> recording the updates of <ref>
+const LOGS_PREFIX = "logs"
+
+// ReflogEntry is an update of a ref recorded in its reflog
+type ReflogEntry struct {
+	Old  []byte
+	New  []byte
+	Time time.Time
+	// line is the entry as written in the reflog
+	line string
+}
+
+// ListReflogs returns the names of the refs that have a reflog
+func (store *FSStore) ListReflogs() ([]string, error) {
+	root := path.Join(store.rootDir, LOGS_PREFIX)
+	names := []string{}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		name, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(name))
+		return nil
+	})
+	return names, err
+}
+
+// ReadReflog returns the entries of the reflog of the ref, the oldest first
+func (store *FSStore) ReadReflog(name string) ([]ReflogEntry, error) {
+	content, err := os.ReadFile(path.Join(store.rootDir, LOGS_PREFIX, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	entries := []ReflogEntry{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		entry, err := decodeReflogEntry(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("reflog of %s: %w", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// ExpireReflog removes the entries of the reflog of the ref recorded before expiry and
+// returns how many were removed. The ref is locked while its reflog is rewritten.
+func (store *FSStore) ExpireReflog(name string, expiry time.Time) (int, error) {
+	lock, err := store.lockRef(name)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+	entries, err := store.ReadReflog(name)
+	if err != nil {
+		return 0, err
+	}
+	var kept strings.Builder
+	expired := 0
+	for _, entry := range entries {
+		if entry.Time.Before(expiry) {
+			expired++
+			continue
+		}
+		kept.WriteString(entry.line)
+		kept.WriteString("\n")
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	logPath := path.Join(store.rootDir, LOGS_PREFIX, name)
+	tmp, err := os.CreateTemp(path.Dir(logPath), "tmp_reflog_")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.WriteString(kept.String())
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+	return expired, os.Rename(tmp.Name(), logPath)
+}
+
+// decodeReflogEntry decodes "<old> <new> <name> <<email>> <timestamp> <tz>\t<message>"
+func decodeReflogEntry(line string) (ReflogEntry, error) {
+	entry := ReflogEntry{line: line}
+	oldHex, rest, found := strings.Cut(line, " ")
+	if !found {
+		return entry, errors.New("invalid entry")
+	}
+	newHex, rest, found := strings.Cut(rest, " ")
+	if !found {
+		return entry, errors.New("invalid entry")
+	}
+	var err error
+	if entry.Old, err = hex.DecodeString(oldHex); err != nil {
+		return entry, err
+	}
+	if entry.New, err = hex.DecodeString(newHex); err != nil {
+		return entry, err
+	}
+	ident, _, _ := strings.Cut(rest, "\t")
+	end := strings.LastIndex(ident, ">")
+	if end < 0 {
+		return entry, errors.New("invalid identity")
+	}
+	fields := strings.Fields(ident[end+1:])
+	if len(fields) == 0 {
+		return entry, errors.New("missing timestamp")
+	}
+	seconds, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return entry, err
+	}
+	entry.Time = time.Unix(seconds, 0)
+	return entry, nil
+}
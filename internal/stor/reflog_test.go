@@ -0,0 +1,57 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestExpireReflog(t *testing.T) {
+	store, err := InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	zero := bytes.Repeat([]byte("0"), 40)
+	first := bytes.Repeat([]byte("1"), 40)
+	second := bytes.Repeat([]byte("2"), 40)
+	log := string(zero) + " " + string(first) + " A U Thor <a@example.com> 1000 +0000\tcommit (initial): first\n" +
+		string(first) + " " + string(second) + " A U Thor <a@example.com> 3000 -0700\tcommit: second\n"
+	if err := os.MkdirAll(path.Join(store.RootDir(), "logs/refs/heads"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path.Join(store.RootDir(), "logs/refs/heads/main"), []byte(log), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := store.ListReflogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "refs/heads/main" {
+		t.Fatalf("expected the reflog of refs/heads/main but got %v", names)
+	}
+	entries, err := store.ReadReflog("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[1].Time.Unix() != 3000 || !bytes.Equal(entries[1].Old, bytes.Repeat([]byte{0x11}, 20)) {
+		t.Fatalf("unexpected entries: %+v", entries)
+	}
+
+	expired, err := store.ExpireReflog("refs/heads/main", time.Unix(2000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err = store.ReadReflog("refs/heads/main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expired != 1 || len(entries) != 1 || entries[0].Time.Unix() != 3000 {
+		t.Fatalf("expected the first entry to expire but got %d expired and %+v", expired, entries)
+	}
+	if _, err := os.Stat(path.Join(store.RootDir(), "refs/heads/main.lock")); !os.IsNotExist(err) {
+		t.Fatalf("expected the lock to be released but got %v", err)
+	}
+}
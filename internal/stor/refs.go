@@ -0,0 +1,367 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	PACKED_REFS      = "packed-refs"
+	SYMREF_PREFIX    = "ref: "
+	MAX_SYMREF_DEPTH = 5
+)
+
+var (
+	ErrRefNotExist   = errors.New("ref not found")
+	ErrRefLocked     = errors.New("ref is locked")
+	ErrInvalidRefVal = errors.New("invalid ref value")
+)
+
+// Ref represents a named reference. A symbolic ref has a Target and no Checksum.
+type Ref struct {
+	Name     string
+	Checksum []byte
+	Target   string
+}
+
+func (r *Ref) IsSymbolic() bool {
+	return r.Target != ""
+}
+
+// ReadRef reads the ref with the given name without resolving symbolic refs.
+// Loose refs take precedence over packed refs.
+func (store *FSStore) ReadRef(name string) (*Ref, error) {
+	content, err := os.ReadFile(path.Join(store.rootDir, name))
+	if err == nil {
+		return decodeRef(name, content)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	packed, err := store.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := packed[name]; ok {
+		return ref, nil
+	}
+	return nil, ErrRefNotExist
+}
+
+// ResolveRef follows symbolic refs until a ref pointing to an object is found.
+func (store *FSStore) ResolveRef(name string) (*Ref, error) {
+	for i := 0; i < MAX_SYMREF_DEPTH; i++ {
+		ref, err := store.ReadRef(name)
+		if err != nil {
+			return nil, err
+		}
+		if !ref.IsSymbolic() {
+			return ref, nil
+		}
+		name = ref.Target
+	}
+	return nil, fmt.Errorf("symbolic ref nested too deeply: %s", name)
+}
+
+// WriteRef points the ref name to checksum. If old is non-nil the update only succeeds
+// if the ref currently points to old. A zero-length old requires the ref to not exist.
+func (store *FSStore) WriteRef(name string, checksum []byte, old []byte) error {
+	return store.updateRef(name, old, func(w *bufio.Writer) error {
+		_, err := fmt.Fprintf(w, "%x\n", checksum)
+		return err
+	})
+}
+
+// WriteSymbolicRef points the ref name to the ref target.
+func (store *FSStore) WriteSymbolicRef(name string, target string) error {
+	return store.updateRef(name, nil, func(w *bufio.Writer) error {
+		_, err := fmt.Fprintf(w, "%s%s\n", SYMREF_PREFIX, target)
+		return err
+	})
+}
+
+// DeleteRef removes both the loose and the packed version of the ref.
+func (store *FSStore) DeleteRef(name string, old []byte) error {
+	lock, err := store.lockRef(name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+	if err := store.checkOldRef(name, old); err != nil {
+		return err
+	}
+	if err := os.Remove(path.Join(store.rootDir, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	packed, err := store.packedRefs()
+	if err != nil {
+		return err
+	}
+	if _, ok := packed[name]; !ok {
+		return nil
+	}
+	delete(packed, name)
+	return store.writePackedRefs(packed)
+}
+
+// ListRefs returns all refs under refs/, sorted by name. Symbolic refs are resolved.
+func (store *FSStore) ListRefs() ([]*Ref, error) {
+	refs, err := store.packedRefs()
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(path.Join(store.rootDir, REF_PREFIX), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		name, err := filepath.Rel(store.rootDir, p)
+		if err != nil {
+			return err
+		}
+		ref, err := store.ResolveRef(filepath.ToSlash(name))
+		if err != nil {
+			// Dangling symbolic refs are not listed
+			if err == ErrRefNotExist {
+				return nil
+			}
+			return err
+		}
+		refs[filepath.ToSlash(name)] = &Ref{Name: filepath.ToSlash(name), Checksum: ref.Checksum}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*Ref, 0, len(refs))
+	for _, ref := range refs {
+		list = append(list, ref)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list, nil
+}
+
+// PackRefs moves the loose refs under refs/ to the packed-refs file, as git pack-refs
+// --all does, and returns how many were packed. Symbolic refs stay loose. A loose ref is
+// only removed if it did not change while the refs were packed.
+func (store *FSStore) PackRefs() (int, error) {
+	packed, err := store.packedRefs()
+	if err != nil {
+		return 0, err
+	}
+	loose := []*Ref{}
+	err = filepath.WalkDir(path.Join(store.rootDir, REF_PREFIX), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".lock") {
+			return nil
+		}
+		name, err := filepath.Rel(store.rootDir, p)
+		if err != nil {
+			return err
+		}
+		ref, err := store.ReadRef(filepath.ToSlash(name))
+		if err != nil {
+			return err
+		}
+		if !ref.IsSymbolic() {
+			loose = append(loose, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(loose) == 0 {
+		return 0, nil
+	}
+	for _, ref := range loose {
+		packed[ref.Name] = ref
+	}
+	if err := store.writePackedRefs(packed); err != nil {
+		return 0, err
+	}
+	for _, ref := range loose {
+		if err := store.pruneLooseRef(ref); err != nil {
+			return 0, err
+		}
+	}
+	return len(loose), nil
+}
+
+// pruneLooseRef removes a loose ref that was packed, unless it was updated since
+func (store *FSStore) pruneLooseRef(packed *Ref) error {
+	lock, err := store.lockRef(packed.Name)
+	if err != nil {
+		// A ref being updated is left loose
+		if errors.Is(err, ErrRefLocked) {
+			return nil
+		}
+		return err
+	}
+	defer os.Remove(lock.Name())
+	defer lock.Close()
+	content, err := os.ReadFile(path.Join(store.rootDir, packed.Name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	ref, err := decodeRef(packed.Name, content)
+	if err != nil || ref.IsSymbolic() || !bytes.Equal(ref.Checksum, packed.Checksum) {
+		return err
+	}
+	return os.Remove(path.Join(store.rootDir, packed.Name))
+}
+
+func (store *FSStore) updateRef(name string, old []byte, encode func(w *bufio.Writer) error) error {
+	lock, err := store.lockRef(name)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(lock.Name())
+	if err := store.checkOldRef(name, old); err != nil {
+		lock.Close()
+		return err
+	}
+	w := bufio.NewWriter(lock)
+	if err := encode(w); err != nil {
+		lock.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		lock.Close()
+		return err
+	}
+	if err := lock.Close(); err != nil {
+		return err
+	}
+	return os.Rename(lock.Name(), path.Join(store.rootDir, name))
+}
+
+// lockRef creates the lock file of a ref. The lock file is later renamed to the ref to commit the update.
+func (store *FSStore) lockRef(name string) (*os.File, error) {
+	p := path.Join(store.rootDir, name)
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := os.OpenFile(p+".lock", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrRefLocked, name)
+		}
+		return nil, err
+	}
+	return lock, nil
+}
+
+func (store *FSStore) checkOldRef(name string, old []byte) error {
+	if old == nil {
+		return nil
+	}
+	ref, err := store.ResolveRef(name)
+	if err != nil && err != ErrRefNotExist {
+		return err
+	}
+	if len(old) == 0 || isZeroChecksum(old) {
+		if ref != nil {
+			return fmt.Errorf("ref %s already exists", name)
+		}
+		return nil
+	}
+	if ref == nil || !bytes.Equal(ref.Checksum, old) {
+		return fmt.Errorf("ref %s is at a different value than expected", name)
+	}
+	return nil
+}
+
+func (store *FSStore) packedRefs() (map[string]*Ref, error) {
+	refs := map[string]*Ref{}
+	content, err := os.ReadFile(path.Join(store.rootDir, PACKED_REFS))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return refs, nil
+		}
+		return nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		// Skip the header and peeled lines, peeled values are computed on demand
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "^") || line == "" {
+			continue
+		}
+		hexChecksum, name, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidRefVal, line)
+		}
+		checksum, err := hex.DecodeString(hexChecksum)
+		if err != nil {
+			return nil, err
+		}
+		refs[name] = &Ref{Name: name, Checksum: checksum}
+	}
+	return refs, scanner.Err()
+}
+
+func (store *FSStore) writePackedRefs(refs map[string]*Ref) error {
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return store.updateRef(PACKED_REFS, nil, func(w *bufio.Writer) error {
+		if _, err := w.WriteString("# pack-refs with: sorted \n"); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, err := fmt.Fprintf(w, "%x %s\n", refs[name].Checksum, name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func decodeRef(name string, content []byte) (*Ref, error) {
+	value := strings.TrimSpace(string(content))
+	if target, found := strings.CutPrefix(value, SYMREF_PREFIX); found {
+		return &Ref{Name: name, Target: target}, nil
+	}
+	checksum, err := hex.DecodeString(value)
+	if err != nil || len(checksum) != 20 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidRefVal, name)
+	}
+	return &Ref{Name: name, Checksum: checksum}, nil
+}
+
+func isZeroChecksum(checksum []byte) bool {
+	for _, b := range checksum {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
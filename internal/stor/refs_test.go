@@ -0,0 +1,89 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRefs(t *testing.T) {
+	store, err := InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bytes.Repeat([]byte{1}, 20)
+	second := bytes.Repeat([]byte{2}, 20)
+
+	t.Run("HEAD resolves through the symbolic ref", func(t *testing.T) {
+		if err := store.WriteRef("refs/heads/main", first, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		ref, err := store.ResolveRef(HEAD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(ref.Checksum, first) {
+			t.Fatalf("expected %x but got %x", first, ref.Checksum)
+		}
+	})
+
+	t.Run("update fails when the old value does not match", func(t *testing.T) {
+		if err := store.WriteRef("refs/heads/main", second, second); err == nil {
+			t.Fatal("expected update to fail")
+		}
+		if err := store.WriteRef("refs/heads/main", second, first); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("list refs sorted by name", func(t *testing.T) {
+		if err := store.WriteRef("refs/heads/dev", first, nil); err != nil {
+			t.Fatal(err)
+		}
+		refs, err := store.ListRefs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) != 2 || refs[0].Name != "refs/heads/dev" || refs[1].Name != "refs/heads/main" {
+			t.Fatalf("unexpected refs: %+v", refs)
+		}
+	})
+
+	t.Run("deleted ref does not exist", func(t *testing.T) {
+		if err := store.DeleteRef("refs/heads/dev", first); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.ReadRef("refs/heads/dev"); err != ErrRefNotExist {
+			t.Fatalf("expected %v but got %v", ErrRefNotExist, err)
+		}
+	})
+
+	t.Run("packed refs keep their value and leave symbolic refs loose", func(t *testing.T) {
+		if err := store.WriteRef("refs/tags/v1", second, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.WriteSymbolicRef("refs/remotes/origin/HEAD", "refs/heads/main"); err != nil {
+			t.Fatal(err)
+		}
+		packed, err := store.PackRefs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if packed != 2 {
+			t.Fatalf("expected 2 packed refs but got %d", packed)
+		}
+		if _, err := os.Stat(path.Join(store.RootDir(), "refs/heads/main")); !os.IsNotExist(err) {
+			t.Fatalf("expected the loose ref to be removed but got %v", err)
+		}
+		for name, expected := range map[string][]byte{"refs/heads/main": second, "refs/tags/v1": second, "refs/remotes/origin/HEAD": second} {
+			ref, err := store.ResolveRef(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(ref.Checksum, expected) {
+				t.Fatalf("expected %s at %x but got %x", name, expected, ref.Checksum)
+			}
+		}
+	})
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+)
+
+const (
+	SHALLOW = "shallow"
+)
+
+// Shallow returns the commits of a shallow repository whose parents are missing, sorted by id.
+// A complete repository has none.
+func (store *FSStore) Shallow() ([][]byte, error) {
+	content, err := os.ReadFile(path.Join(store.rootDir, SHALLOW))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var ids [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if scanner.Text() == "" {
+			continue
+		}
+		id, err := hex.DecodeString(scanner.Text())
+		if err != nil || len(id) != 20 {
+			return nil, fmt.Errorf("invalid shallow commit %q", scanner.Text())
+		}
+		ids = append(ids, id)
+	}
+	return ids, scanner.Err()
+}
+
+// WriteShallow replaces the shallow commits. Writing none makes the repository complete.
+func (store *FSStore) WriteShallow(ids [][]byte) error {
+	if len(ids) == 0 {
+		if err := os.Remove(path.Join(store.rootDir, SHALLOW)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	sorted := make([][]byte, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return store.updateRef(SHALLOW, nil, func(w *bufio.Writer) error {
+		for i, id := range sorted {
+			if i > 0 && bytes.Equal(id, sorted[i-1]) {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "%x\n", id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
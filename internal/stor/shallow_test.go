@@ -0,0 +1,41 @@
+package store
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestShallow(t *testing.T) {
+	store, err := InitBare(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := bytes.Repeat([]byte{1}, 20)
+	second := bytes.Repeat([]byte{2}, 20)
+
+	ids, err := store.Shallow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 0 {
+		t.Fatalf("expected a complete repository but got %d shallow commits", len(ids))
+	}
+
+	if err := store.WriteShallow([][]byte{second, first, second}); err != nil {
+		t.Fatal(err)
+	}
+	ids, err = store.Shallow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || !bytes.Equal(ids[0], first) || !bytes.Equal(ids[1], second) {
+		t.Fatalf("expected %x %x but got %x", first, second, ids)
+	}
+
+	if err := store.WriteShallow(nil); err != nil {
+		t.Fatal(err)
+	}
+	if ids, err = store.Shallow(); err != nil || len(ids) != 0 {
+		t.Fatalf("expected shallow file to be removed but got %x %v", ids, err)
+	}
+}
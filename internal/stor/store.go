@@ -4,10 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"github.com/codecrafters-io/git-starter-go/internal/config"
 )
 
 const (
@@ -15,7 +19,9 @@ const (
 	OBJECT_PREFIX = "objects"
 	PACK_PREFIX   = "objects/pack"
 	REF_PREFIX    = "refs"
-	HEAD          = "refs/HEAD"
+	HEAD          = "HEAD"
+	CONFIG        = "config"
+	DEFAULT_HEAD  = "refs/heads/main"
 )
 
 var (
@@ -28,10 +34,52 @@ type Store interface {
 	NewPackWriter(checksum string) (WriteReadFile, error)
 	NewPackIndexReader(checksum string) (ReadOnlyFile, error)
 	NewPackIndexWriter(checkum string) (WriteReadFile, error)
+	NewPackMtimesWriter(key string) (WriteReadFile, error)
+	NewPackMtimesReader(checksum string) (ReadOnlyFile, error)
+	NewPackBitmapWriter(key string) (WriteReadFile, error)
+	NewPackBitmapReader(checksum string) (ReadOnlyFile, error)
 	ListPacks() ([]string, error)
 	ListPackIndices() ([]string, error)
+	StatPack(checksum string) (fs.FileInfo, error)
+	StatPackIndex(checksum string) (fs.FileInfo, error)
+	RemovePack(checksum string) error
+	WritePackPromisor(checksum string) error
+	IsPackPromisor(checksum string) (bool, error)
+	WritePackKeep(checksum string, message string) error
+	IsPackKept(checksum string) (bool, error)
+	RemovePackKeep(checksum string) error
+	NewMultiPackIndexReader() (ReadOnlyFile, error)
+	NewMultiPackIndexWriter() (WriteReadFile, error)
+	RemoveMultiPackIndex() error
+	NewMultiPackIndexBitmapWriter() (WriteReadFile, error)
+	NewMultiPackIndexBitmapReader(checksum string) (ReadOnlyFile, error)
+	NewMultiPackIndexRevWriter() (WriteReadFile, error)
+	NewMultiPackIndexRevReader(checksum string) (ReadOnlyFile, error)
+	PruneMultiPackIndexFiles(checksum string) error
+	NewCommitGraphReader() (ReadOnlyFile, error)
+	NewCommitGraphWriter() (WriteReadFile, error)
+	RemoveCommitGraph() error
+	NewCommitGraphLayerReader(checksum string) (ReadOnlyFile, error)
+	NewCommitGraphLayerWriter() (WriteReadFile, error)
+	ReadCommitGraphChain() ([]string, error)
+	WriteCommitGraphChain(checksums []string) error
+	PruneCommitGraphLayers(chain []string) error
 	ObjectReader(string) (ReadOnlyFile, error)
 	ObjectWriter(string) (WriteReadFile, error)
+	ListObjects() ([]string, error)
+	RemoveObject(string) error
+	StatObject(string) (fs.FileInfo, error)
+	ReadRef(name string) (*Ref, error)
+	ResolveRef(name string) (*Ref, error)
+	WriteRef(name string, checksum []byte, old []byte) error
+	WriteSymbolicRef(name string, target string) error
+	DeleteRef(name string, old []byte) error
+	ListRefs() ([]*Ref, error)
+	PackRefs() (int, error)
+	ListReflogs() ([]string, error)
+	ReadReflog(name string) ([]ReflogEntry, error)
+	ExpireReflog(name string, expiry time.Time) (int, error)
+	Shallow() ([][]byte, error)
 }
 
 type FSStore struct {
@@ -45,6 +93,7 @@ type File interface {
 	io.ReadCloser
 	Sync() error
 	Rename(string) error
+	Remove() error
 }
 
 type file struct {
@@ -52,13 +101,19 @@ type file struct {
 }
 
 func (f *file) Sync() error {
-	return f.Sync()
+	return f.File.Sync()
 }
 
 func (f *file) Rename(path string) error {
 	return os.Rename(f.Name(), path)
 }
 
+// Remove closes the file and deletes it
+func (f *file) Remove() error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
 // New() returns a store object representing an existing store on disk.
 // The existing store is discovered by walking up the file system tree until a [DIR] is found.
 func New() (*FSStore, error) {
@@ -75,32 +130,72 @@ func Init() (*FSStore, error) {
 	if err := os.Mkdir(DIR, 0755); err != nil {
 		return nil, err
 	}
+	return initStore(path.Join("./", DIR))
+}
 
+// InitBare initialises a bare store directly in dir, which is created if it does not exist.
+func InitBare(dir string) (*FSStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return initStore(dir)
+}
+
+// Open returns the store of the repository at dir. dir is either a bare repository
+// or a working tree containing a [DIR] directory.
+func Open(dir string) (*FSStore, error) {
+	for _, rootDir := range []string{path.Join(dir, DIR), dir} {
+		stat, err := os.Stat(path.Join(rootDir, OBJECT_PREFIX))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if stat.IsDir() {
+			return &FSStore{
+				rootDir: rootDir,
+			}, nil
+		}
+	}
+	return nil, ErrStoreNotExist
+}
+
+func initStore(rootDir string) (*FSStore, error) {
 	requiredSubDirs := []string{"objects", "refs", "objects/pack"}
 	for _, subDir := range requiredSubDirs {
-		path := path.Join(DIR, subDir)
+		path := path.Join(rootDir, subDir)
 		if err := os.MkdirAll(path, 0755); err != nil {
 			return nil, fmt.Errorf("failed creating directory %s: %w", path, err)
 		}
 	}
 
-	requiredFiles := map[string][]byte{"HEAD": []byte("ref: refs/heads/main\n")}
+	requiredFiles := map[string][]byte{HEAD: []byte(SYMREF_PREFIX + DEFAULT_HEAD + "\n")}
 	for name, content := range requiredFiles {
-		fullPath := path.Join(DIR, name)
+		fullPath := path.Join(rootDir, name)
 		if err := os.WriteFile(fullPath, content, 0644); err != nil {
 			return nil, fmt.Errorf("failed writing file: %w\n", err)
 		}
 	}
 
-	// cwd, err := os.Getwd()
-	// if err != nil {
-	// 	return nil, fmt.Errorf("failed to get cwd: %w", err)
-	// }
-
 	return &FSStore{
-		rootDir: path.Join("./", DIR),
+		rootDir: rootDir,
 	}, nil
+}
+
+// Config reads the configuration of the repository
+func (store *FSStore) Config() (*config.Config, error) {
+	return config.Load(path.Join(store.rootDir, CONFIG))
+}
+
+// SaveConfig replaces the configuration of the repository
+func (store *FSStore) SaveConfig(c *config.Config) error {
+	return c.Save(path.Join(store.rootDir, CONFIG))
+}
 
+// RootDir returns the path of the store directory.
+func (store *FSStore) RootDir() string {
+	return store.rootDir
 }
 
 func findStoreDir() (string, error) {
@@ -9,7 +9,10 @@ type WriteReadFile interface {
 	io.Writer
 	io.Reader
 	io.ReaderAt
+	io.WriterAt
 	Rename(string) error
+	// Remove discards a file that was not renamed, such as a temporary file left incomplete
+	Remove() error
 	Close() error
 	Sync() error
 }
@@ -35,6 +38,10 @@ func (w *defaultWriter[T]) ReadAt(p []byte, offset int64) (int, error) {
 	return w.file.ReadAt(p, offset)
 }
 
+func (w *defaultWriter[T]) Remove() error {
+	return w.file.Remove()
+}
+
 func (w *defaultWriter[T]) Sync() error {
 	return w.file.Sync()
 }
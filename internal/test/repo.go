@@ -0,0 +1,49 @@
+package testing_helper
+
+import (
+	"compress/zlib"
+	"fmt"
+	"testing"
+
+	common "github.com/codecrafters-io/git-starter-go/internal"
+	"github.com/codecrafters-io/git-starter-go/internal/hash"
+	store "github.com/codecrafters-io/git-starter-go/internal/stor"
+)
+
+// WriteObject stores a loose object and returns its checksum
+func WriteObject(t *testing.T, st *store.FSStore, objectType common.ObjectType, content []byte) []byte {
+	t.Helper()
+	object := common.NewObjectBuffer(objectType, content)
+	checksum, err := object.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := st.ObjectWriter(hash.ChecksumToHex(checksum))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	comp := zlib.NewWriter(file)
+	defer comp.Close()
+	if err := object.Encode(comp); err != nil {
+		t.Fatal(err)
+	}
+	return checksum
+}
+
+// SetupRepo creates a bare repository with a single commit on main
+func SetupRepo(t *testing.T, dir string) (st *store.FSStore, commit []byte) {
+	t.Helper()
+	st, err := store.InitBare(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := WriteObject(t, st, common.OBJ_BLOB, []byte("hello world\n"))
+	tree := WriteObject(t, st, common.OBJ_TREE, append([]byte("100644 hello.txt\x00"), blob...))
+	commit = WriteObject(t, st, common.OBJ_COMMIT, []byte(fmt.Sprintf(
+		"tree %x\nauthor A U Thor <author@example.com> 1700000000 +0000\ncommitter A U Thor <author@example.com> 1700000000 +0000\n\ninitial\n", tree)))
+	if err := st.WriteRef("refs/heads/main", commit, nil); err != nil {
+		t.Fatal(err)
+	}
+	return st, commit
+}
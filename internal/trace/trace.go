@@ -0,0 +1,137 @@
+package trace
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// General traces the commands run
+	General = NewKey("GIT_TRACE")
+	// Packet traces every pkt-line sent and received
+	Packet = NewKey("GIT_TRACE_PACKET")
+	// Curl traces the headers of HTTP requests and responses
+	Curl = NewKey("GIT_TRACE_CURL")
+	// Performance traces the time taken by the phases of a command
+	Performance = NewKey("GIT_TRACE_PERFORMANCE")
+)
+
+// PacketIdentity names the program in packet traces, such as upload-pack when serving
+var PacketIdentity = "git"
+
+// Key is a trace enabled by an environment variable, as in git. The variable is read the
+// first time the key is used:
+//   - empty, 0 or false disable the trace
+//   - 1, 2 or true trace to stderr
+//   - 3 to 9 trace to the file descriptor of that number
+//   - an absolute path appends the trace to that file
+type Key struct {
+	name string
+	once sync.Once
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+func NewKey(name string) *Key {
+	return &Key{
+		name: name,
+	}
+}
+
+// Enabled reports whether the trace is written anywhere
+func (k *Key) Enabled() bool {
+	k.once.Do(k.open)
+	return k.w != nil
+}
+
+// Printf writes a line to the trace, prefixed by the time of day
+func (k *Key) Printf(format string, args ...any) {
+	if !k.Enabled() {
+		return
+	}
+	line := time.Now().Format("15:04:05.000000") + " " + fmt.Sprintf(format, args...) + "\n"
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.w.Write([]byte(line))
+}
+
+func (k *Key) open() {
+	value := os.Getenv(k.name)
+	switch strings.ToLower(value) {
+	case "", "0", "false":
+		return
+	case "1", "2", "true":
+		k.w = os.Stderr
+		return
+	}
+	if fd, err := strconv.Atoi(value); err == nil && fd >= 3 && fd <= 9 {
+		k.w = os.NewFile(uintptr(fd), k.name)
+		return
+	}
+	if !strings.HasPrefix(value, "/") {
+		fmt.Fprintf(os.Stderr, "warning: unknown trace value for '%s': %s\n", k.name, value)
+		fmt.Fprintf(os.Stderr, "         If you want to trace into a file, then please set %s\n", k.name)
+		fmt.Fprintf(os.Stderr, "         to an absolute pathname (starting with /)\n")
+		return
+	}
+	file, err := os.OpenFile(value, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not open '%s' for tracing: %v\n", value, err)
+		return
+	}
+	k.w = file
+}
+
+// Command traces a command run with its arguments
+func Command(kind string, args []string) {
+	if !General.Enabled() {
+		return
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	General.Printf("trace: %s: %s", kind, strings.Join(quoted, " "))
+}
+
+// PacketLine traces a pkt-line without its trailing LF, flush is traced as 0000. Bytes that
+// are not printable are written as octal escapes.
+func PacketLine(write bool, data []byte, flush bool) {
+	if !Packet.Enabled() {
+		return
+	}
+	direction := '<'
+	if write {
+		direction = '>'
+	}
+	var line strings.Builder
+	if flush {
+		line.WriteString("0000")
+	}
+	for _, b := range bytes.TrimSuffix(data, []byte("\n")) {
+		if (b >= 0x20 && b < 0x7f) || b == '\t' {
+			line.WriteByte(b)
+		} else {
+			fmt.Fprintf(&line, "\\%o", b)
+		}
+	}
+	Packet.Printf("packet: %12s%c %s", PacketIdentity, direction, line.String())
+}
+
+// Measure returns a function that traces the time elapsed since Measure was called.
+// Used as defer trace.Measure("phase")().
+func Measure(name string) func() {
+	if !Performance.Enabled() {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		Performance.Printf("performance: %.9f s: %s", time.Since(start).Seconds(), name)
+	}
+}
@@ -0,0 +1,62 @@
+package trace
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyWritesToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	t.Setenv("GIT_TRACE_TEST", path)
+	key := NewKey("GIT_TRACE_TEST")
+	if !key.Enabled() {
+		t.Fatalf("expected the key to be enabled by an absolute path")
+	}
+	key.Printf("hello %s", "world")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), " hello world\n") {
+		t.Fatalf("expected: %q\tactual: %q", "<time> hello world\n", data)
+	}
+}
+
+func TestKeyDisabled(t *testing.T) {
+	for _, value := range []string{"", "0", "false", "relative/path"} {
+		t.Setenv("GIT_TRACE_TEST", value)
+		if NewKey("GIT_TRACE_TEST").Enabled() {
+			t.Fatalf("expected %q to disable the trace", value)
+		}
+	}
+}
+
+func TestPacketLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trace")
+	t.Setenv("GIT_TRACE_PACKET", path)
+	packet := Packet
+	Packet = NewKey("GIT_TRACE_PACKET")
+	defer func() { Packet = packet }()
+
+	PacketLine(true, []byte("want abc\x00side-band\n"), false)
+	PacketLine(false, nil, true)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	expected := []string{
+		"packet:          git> want abc\\0side-band",
+		"packet:          git< 0000",
+	}
+	if len(lines) != len(expected) {
+		t.Fatalf("expected: %d lines\tactual: %q", len(expected), lines)
+	}
+	for i, line := range lines {
+		if _, rest, _ := strings.Cut(line, " "); rest != expected[i] {
+			t.Fatalf("expected: %q\tactual: %q", expected[i], rest)
+		}
+	}
+}